@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/whatsfordinner/fstagger/internal/db"
+	"github.com/whatsfordinner/fstagger/internal/output"
+)
+
+var (
+	errNoTags = errors.New("no tags found")
+
+	findTagCmd = &cobra.Command{
+		Use:   "find-tag WORDS...",
+		Short: "Find tags by their names, descriptions and notes",
+		Long: `Lists the tags whose name or description, or a note about why a file has
+them, contain any of WORDS, best match first, each with a snippet of the text
+it matched and the matching words in square brackets. Words match the start
+of words, ignoring case and punctuation, so "tax" matches "taxes".
+
+Tags are ranked with SQLite's full-text search when fstagger is built with the
+sqlite_fts5 tag, which is opt in, and by how many of WORDS they match, counting
+their names most, otherwise, in which case a warning says so. A search with no
+results exits non-zero.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: withDB(runFindTag),
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(findTagCmd)
+}
+
+func runFindTag(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	warnWithoutFullText(cmd, tagDB)
+
+	matches, err := tagDB.FindTags(cmd.Context(), strings.Join(args, " "))
+	if err != nil {
+		return err
+	}
+
+	renderer, err := newRenderer(cmd)
+	if err != nil {
+		return err
+	}
+
+	if err := output.RenderAll(renderer, matches); err != nil {
+		return err
+	}
+
+	if err := renderer.Close(); err != nil {
+		return err
+	}
+
+	if len(matches) == 0 {
+		return errNoTags
+	}
+
+	return nil
+}
+
+// warnWithoutFullText tells the user when a search falls back to matching words
+// in Go because fstagger was built without FTS5.
+func warnWithoutFullText(cmd *cobra.Command, tagDB *db.TagDB) {
+	if !tagDB.FullText() {
+		fmt.Fprintln(
+			cmd.ErrOrStderr(),
+			"Searching without the full-text index since fstagger was built without FTS5, "+
+				"build it with -tags sqlite_fts5 to rank results",
+		)
+	}
+}
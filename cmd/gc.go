@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/whatsfordinner/fstagger/internal/db"
+)
+
+var (
+	gcCmd = &cobra.Command{
+		Use:   "gc",
+		Short: "Remove tags from files once they've expired",
+		Long: `Removes every tag attached with "fstagger tag add --ttl" whose time is up.
+Expired tags are already hidden from searches and listings, gc deletes them
+for good and lists what it removed.`,
+		Args: cobra.NoArgs,
+		RunE: withDB(runGC),
+	}
+)
+
+// expiredLink is a link removed by gc, shown with its file's path and its
+// tag's name.
+type expiredLink struct {
+	Path    string    `json:"path"`
+	Tag     string    `json:"tag"`
+	Expires time.Time `json:"expires"`
+}
+
+func (e expiredLink) String() string {
+	return fmt.Sprintf("%s\t%s\t%s", e.Path, e.Tag, e.Expires.Local().Format(time.RFC3339))
+}
+
+func init() {
+	rootCmd.AddCommand(gcCmd)
+}
+
+func runGC(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	renderer, err := newRenderer(cmd)
+	if err != nil {
+		return err
+	}
+
+	expired, err := tagDB.DeleteExpiredLinks(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	for _, link := range expired {
+		file, err := tagDB.GetFileById(cmd.Context(), link.File)
+		if err != nil {
+			return err
+		}
+
+		tag, err := tagDB.GetTagById(cmd.Context(), link.Tag)
+		if err != nil {
+			return err
+		}
+
+		if err := renderer.Render(expiredLink{
+			Path:    file.Path,
+			Tag:     tag.String(),
+			Expires: link.Expires,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return renderer.Close()
+}
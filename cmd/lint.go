@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/whatsfordinner/fstagger/internal/db"
+	"github.com/whatsfordinner/fstagger/internal/output"
+	"github.com/whatsfordinner/fstagger/internal/policy"
+)
+
+const (
+	defaultPolicyFile = ".fstagger-policy.yml"
+)
+
+var (
+	lintCmd = &cobra.Command{
+		Use:   "lint [POLICY_FILE]",
+		Short: "Check the tagged files against a policy file",
+		Long: fmt.Sprintf(`Checks every file in the database against the policies in POLICY_FILE, or
+%s in the current directory if it isn't given. A policy
+narrows the files it applies to with under, a directory, and where, a query,
+and then makes one rule about them:
+
+	policies:
+	  - name: one-client
+	    description: every project file belongs to exactly one client
+	    under: /projects
+	    count:
+	      tags: client:*
+	      min: 1
+	      max: 1
+	  - name: draft-or-published
+	    forbid: draft and published
+	  - name: published-is-reviewed
+	    where: published
+	    require: reviewed
+
+require and forbid are queries with the same syntax as "fstagger search" and
+count takes a min, a max or both for the tags matching a pattern where *
+matches anything. A relative under is relative to the policy file.
+
+Every violation is listed with the file's path, the policy and what's wrong,
+and the command exits non-zero if there are any. Use --output json for CI.`,
+			defaultPolicyFile,
+		),
+		Args: cobra.MaximumNArgs(1),
+		RunE: withDB(runLint),
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(lintCmd)
+}
+
+func runLint(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	path := defaultPolicyFile
+	if len(args) > 0 {
+		path = args[0]
+	}
+
+	policyFile, err := policy.Load(path)
+	if err != nil {
+		return err
+	}
+
+	renderer, err := newRenderer(cmd)
+	if err != nil {
+		return err
+	}
+
+	violations, err := policyFile.Check(cmd.Context(), tagDB)
+	if err != nil {
+		return err
+	}
+
+	if err := output.RenderAll(renderer, violations); err != nil {
+		return err
+	}
+
+	if err := renderer.Close(); err != nil {
+		return err
+	}
+
+	if len(violations) > 0 {
+		return fmt.Errorf("%d policy violations", len(violations))
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/whatsfordinner/fstagger/internal/db"
+	"github.com/whatsfordinner/fstagger/internal/files"
+	"github.com/whatsfordinner/fstagger/internal/history"
+	"github.com/whatsfordinner/fstagger/internal/output"
+	"github.com/whatsfordinner/fstagger/internal/tags"
+)
+
+var (
+	logCmd = &cobra.Command{
+		Use:   "log [FILE|TAG]",
+		Short: "Show who changed which files and tags and when",
+		Long: `Lists every change made to the database, oldest first, with when it was
+made, who made it and on which machine. Adding files, creating, updating and
+deleting tags and tagging files are all recorded and the history can't be
+edited.
+
+Given a FILE only the changes to that file are listed and given a TAG only the
+changes to that tag are. An argument is a FILE if it's tracked or exists on
+disk and a TAG otherwise, including tags which have since been deleted.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: withDB(runLog),
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(logCmd)
+}
+
+func runLog(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	renderer, err := newRenderer(cmd)
+	if err != nil {
+		return err
+	}
+
+	var entries []history.Entry
+	if len(args) == 0 {
+		entries, err = tagDB.GetHistory(cmd.Context())
+	} else {
+		entries, err = historyFor(cmd, tagDB, args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := output.RenderAll(renderer, entries); err != nil {
+		return err
+	}
+
+	return renderer.Close()
+}
+
+// historyFor returns the history of the file or tag called name.
+func historyFor(cmd *cobra.Command, tagDB *db.TagDB, name string) ([]history.Entry, error) {
+	path, err := filepath.Abs(name)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := tagDB.GetFileByPath(cmd.Context(), path)
+	if err == nil {
+		return tagDB.GetHistoryForFile(cmd.Context(), file)
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		return tagDB.GetHistoryForFile(cmd.Context(), files.File{Path: path})
+	}
+
+	tag, err := tagDB.GetTagByName(cmd.Context(), name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return tagDB.GetHistoryForTag(cmd.Context(), tags.Parse(name))
+	} else if err != nil {
+		return nil, err
+	}
+
+	return tagDB.GetHistoryForTag(cmd.Context(), tag)
+}
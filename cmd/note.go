@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/whatsfordinner/fstagger/internal/db"
+	"github.com/whatsfordinner/fstagger/internal/files"
+	"github.com/whatsfordinner/fstagger/internal/notes"
+)
+
+var (
+	errNoNotes = errors.New("no notes found")
+
+	noteCmd = &cobra.Command{
+		Use:   "note",
+		Short: "Write, list and search notes on files and their tags",
+		Long: `A note is free text about a file, like "final cut approved by client", or
+about why a file has one of its tags, like "tagged legal because of clause 4".
+A file has at most one note of its own and one for each of its tags, and a
+note about a tag goes when the file loses the tag.`,
+	}
+
+	noteTag    string
+	noteSetCmd = &cobra.Command{
+		Use:   "set FILE TEXT...",
+		Short: "Write the note on a file or on one of its tags",
+		Long: `Writes TEXT, joined with spaces, as the note on FILE, replacing the note it
+already has. With --tag the note is about why FILE has that tag instead and
+FILE has to have it.`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: withDB(runNoteSet),
+	}
+
+	noteRemoveCmd = &cobra.Command{
+		Use:     "remove FILE",
+		Aliases: []string{"rm"},
+		Short:   "Remove the note from a file or from one of its tags",
+		Args:    cobra.ExactArgs(1),
+		RunE:    withDB(runNoteRemove),
+	}
+
+	noteListCmd = &cobra.Command{
+		Use:   "list FILE",
+		Short: "List the notes on a file and on its tags",
+		Args:  cobra.ExactArgs(1),
+		RunE:  withDB(runNoteList),
+	}
+
+	noteSearchCmd = &cobra.Command{
+		Use:   "search TEXT...",
+		Short: "List the notes containing some text",
+		Long: `Lists every note containing TEXT, joined with spaces, ignoring case, along
+with the file and tag it's on. A search with no results exits non-zero.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: withDB(runNoteSearch),
+	}
+)
+
+// noteEntry is a note shown with its file's path and, if it's about a tag,
+// the tag's name.
+type noteEntry struct {
+	Path string `json:"path"`
+	Tag  string `json:"tag,omitempty"`
+	Text string `json:"text"`
+}
+
+func (n noteEntry) String() string {
+	fields := []string{n.Path}
+	if n.Tag != "" {
+		fields = append(fields, n.Tag)
+	}
+
+	return strings.Join(append(fields, n.Text), "\t")
+}
+
+func init() {
+	for _, command := range []*cobra.Command{noteSetCmd, noteRemoveCmd} {
+		command.Flags().StringVar(&noteTag, "tag", "", "the tag on FILE the note is about")
+	}
+
+	noteCmd.AddCommand(noteSetCmd)
+	noteCmd.AddCommand(noteRemoveCmd)
+	noteCmd.AddCommand(noteListCmd)
+	noteCmd.AddCommand(noteSearchCmd)
+
+	rootCmd.AddCommand(noteCmd)
+}
+
+// noteFor builds the note on the file at path, or on its --tag, from text.
+func noteFor(cmd *cobra.Command, tagDB *db.TagDB, path string, text string) (notes.Note, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return notes.Note{}, err
+	}
+
+	file, err := tagDB.GetFileByPath(cmd.Context(), absPath)
+	if err != nil {
+		return notes.Note{}, err
+	}
+
+	note := notes.Note{File: file.Id, Text: text}
+	if noteTag != "" {
+		tag, err := tagDB.GetTagByName(cmd.Context(), noteTag)
+		if err != nil {
+			return notes.Note{}, err
+		}
+		note.Tag = tag.Id
+	}
+
+	return note, nil
+}
+
+func runNoteSet(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	note, err := noteFor(cmd, tagDB, args[0], strings.Join(args[1:], " "))
+	if err != nil {
+		return err
+	}
+
+	added, err := tagDB.SetNotes(cmd.Context(), []notes.Note{note})
+	if err != nil {
+		return err
+	}
+
+	return renderNotes(cmd, tagDB, added)
+}
+
+func runNoteRemove(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	note, err := noteFor(cmd, tagDB, args[0], "")
+	if err != nil {
+		return err
+	}
+
+	return tagDB.DeleteNotes(cmd.Context(), []notes.Note{note})
+}
+
+func runNoteList(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	path, err := filepath.Abs(args[0])
+	if err != nil {
+		return err
+	}
+
+	file, err := tagDB.GetFileByPath(cmd.Context(), path)
+	if err != nil {
+		return err
+	}
+
+	fileNotes, err := tagDB.GetNotesForFile(cmd.Context(), file)
+	if err != nil {
+		return err
+	}
+
+	return renderNotes(cmd, tagDB, fileNotes)
+}
+
+func runNoteSearch(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	found, err := tagDB.SearchNotes(cmd.Context(), strings.Join(args, " "))
+	if err != nil {
+		return err
+	}
+
+	if err := renderNotes(cmd, tagDB, found); err != nil {
+		return err
+	}
+
+	if len(found) == 0 {
+		return errNoNotes
+	}
+
+	return nil
+}
+
+// renderNotes renders notes with the paths of their files and the names of
+// their tags.
+func renderNotes(cmd *cobra.Command, tagDB *db.TagDB, toRender []notes.Note) error {
+	renderer, err := newRenderer(cmd)
+	if err != nil {
+		return err
+	}
+
+	paths := map[int]files.File{}
+	for _, note := range toRender {
+		file, ok := paths[note.File]
+		if !ok {
+			file, err = tagDB.GetFileById(cmd.Context(), note.File)
+			if err != nil {
+				return err
+			}
+			paths[note.File] = file
+		}
+
+		entry := noteEntry{Path: file.Path, Text: note.Text}
+		if note.Tag != 0 {
+			tag, err := tagDB.GetTagById(cmd.Context(), note.Tag)
+			if err != nil {
+				return err
+			}
+			entry.Tag = tag.String()
+		}
+
+		if err := renderer.Render(entry); err != nil {
+			return err
+		}
+	}
+
+	return renderer.Close()
+}
@@ -1,15 +1,32 @@
 package cmd
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
 	"github.com/spf13/cobra"
+	"github.com/whatsfordinner/fstagger/internal/db"
+	"github.com/whatsfordinner/fstagger/internal/history"
+	"github.com/whatsfordinner/fstagger/internal/output"
+)
+
+const (
+	dbPathEnv     = "FSTAGGER_DB"
+	defaultDBFile = ".fstagger.db"
 )
 
 var (
+	dbPath       string
+	outputFormat string
+
 	rootCmd = &cobra.Command{
 		Use:   "fstagger",
 		Short: "Tag files on your filesystem and search using them",
 		Long: `fstagger is a CLI for adding one-or-more arbitrary tags to files.
 These tags can be used to search for files by tag.`,
+		SilenceUsage: true,
 	}
 )
 
@@ -18,5 +35,76 @@ func Execute() error {
 }
 
 func init() {
+	rootCmd.PersistentFlags().StringVar(
+		&dbPath,
+		"db",
+		defaultDBPath(),
+		"path to the fstagger database (defaults to $"+dbPathEnv+" or ~/"+defaultDBFile+")",
+	)
+
+	formats := []string{}
+	for _, format := range output.Formats {
+		formats = append(formats, string(format))
+	}
+	rootCmd.PersistentFlags().StringVarP(
+		&outputFormat,
+		"output",
+		"o",
+		string(output.Text),
+		"output format: one of "+strings.Join(formats, ", ")+" (use template=TEMPLATE for a Go template)",
+	)
+
 	rootCmd.AddCommand(tagCmd)
+	rootCmd.AddCommand(tagsCmd)
+	rootCmd.AddCommand(searchCmd)
+}
+
+// defaultDBPath works out where the database lives when --db isn't set. The
+// environment variable wins over the dotfile in the user's home directory.
+func defaultDBPath() string {
+	if path := os.Getenv(dbPathEnv); path != "" {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return defaultDBFile
+	}
+
+	return filepath.Join(home, defaultDBFile)
+}
+
+// withDB is the closure described in ADR-005. It opens and migrates the DB
+// before running the wrapped command and closes it afterwards so that commands
+// only need to worry about using the DAO they're handed. Everything the command
+// changes is journaled as one operation so that it can be undone as a unit.
+func withDB(
+	run func(cmd *cobra.Command, args []string, tagDB *db.TagDB) error,
+) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		tagDB := db.New(
+			db.WithConnectionString(dbPath),
+			db.WithActor(history.CurrentActor()),
+		)
+		if err := tagDB.Init(cmd.Context()); err != nil {
+			return err
+		}
+		defer tagDB.Close(cmd.Context())
+
+		if err := tagDB.BeginOperation(cmd.Context()); err != nil {
+			return err
+		}
+
+		// a command which fails part way through has still changed things and
+		// those changes should be undoable too
+		runErr := run(cmd, args, tagDB)
+
+		return errors.Join(runErr, tagDB.EndOperation(cmd.Context()))
+	}
+}
+
+// newRenderer creates the renderer every command uses to write its results in
+// the format chosen with --output.
+func newRenderer(cmd *cobra.Command) (*output.Renderer, error) {
+	return output.New(cmd.OutOrStdout(), outputFormat)
 }
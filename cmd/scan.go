@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"database/sql"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/whatsfordinner/fstagger/internal/content"
+	"github.com/whatsfordinner/fstagger/internal/db"
+	"github.com/whatsfordinner/fstagger/internal/files"
+)
+
+var (
+	scanContent     bool
+	scanDropContent bool
+	scanCmd         = &cobra.Command{
+		Use:   "scan [PATH...]",
+		Short: "Check tracked files for changes and keep their text up to date",
+		Long: `Hashes every tracked file, or the tracked files at or below each PATH, and
+records the new hash of any file whose contents have changed. Files which have
+gone missing are listed but stay tracked.
+
+Searching files by what they say is opt-in. --content extracts the text of the
+scanned files which are plain text (.txt, .md), HTML (.html, .htm) or PDFs with
+a text layer so that "fstagger search --mentions" can find them, and every
+scan after that extracts the text of those files again whenever they change.
+--drop-content forgets the text of the scanned files so they're no longer
+found by what they say. Text drawn with a font which has its own encoding,
+like the CID fonts with Identity-H encoding many PDF tools embed, is indexed
+as garbled text and won't be found.
+
+Every file which changed, went missing or had its text extracted is listed.`,
+		RunE: withDB(runScan),
+	}
+)
+
+// scannedFile is what a scan found out about a file.
+type scannedFile struct {
+	Path      string `json:"path"`
+	Changed   bool   `json:"changed"`
+	Missing   bool   `json:"missing"`
+	Extracted bool   `json:"extracted"`
+}
+
+func (s scannedFile) String() string {
+	fields := []string{s.Path}
+	if s.Changed {
+		fields = append(fields, "changed")
+	}
+	if s.Missing {
+		fields = append(fields, "missing")
+	}
+	if s.Extracted {
+		fields = append(fields, "extracted")
+	}
+
+	return strings.Join(fields, "\t")
+}
+
+func init() {
+	scanCmd.Flags().BoolVar(&scanContent, "content", false, "extract the text of the scanned files so they can be searched")
+	scanCmd.Flags().BoolVar(&scanDropContent, "drop-content", false, "forget the text of the scanned files")
+	scanCmd.MarkFlagsMutuallyExclusive("content", "drop-content")
+
+	rootCmd.AddCommand(scanCmd)
+}
+
+func runScan(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	targets, err := scanTargets(cmd, tagDB, args)
+	if err != nil {
+		return err
+	}
+
+	renderer, err := newRenderer(cmd)
+	if err != nil {
+		return err
+	}
+
+	changed := []files.File{}
+	extracted := []content.Content{}
+	dropped := []files.File{}
+	extractErrs := []error{}
+	for _, file := range targets {
+		scanned := scannedFile{Path: file.Path}
+
+		current, err := files.FromPath(file.Path)
+		if errors.Is(err, fs.ErrNotExist) {
+			scanned.Missing = true
+			if err := renderer.Render(scanned); err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if current.Hash != file.Hash {
+			scanned.Changed = true
+			changed = append(changed, files.File{Id: file.Id, Hash: current.Hash})
+		}
+
+		existing, err := tagDB.GetContentsForFile(cmd.Context(), file)
+		hasContent := err == nil
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		switch {
+		case scanDropContent:
+			if hasContent {
+				dropped = append(dropped, file)
+			}
+		case hasContent && existing.Hash == current.Hash:
+		case hasContent || (scanContent && content.Supported(file.Path)):
+			text, err := content.Extract(file.Path)
+			if err != nil {
+				extractErrs = append(extractErrs, err)
+				break
+			}
+			scanned.Extracted = true
+			extracted = append(extracted, content.Content{File: file.Id, Hash: current.Hash, Text: text})
+		}
+
+		if scanned.Changed || scanned.Extracted {
+			if err := renderer.Render(scanned); err != nil {
+				return err
+			}
+		}
+	}
+
+	// a file whose hash couldn't be updated, like one which now has the same
+	// contents as another tracked file, keeps the text it had so that the
+	// text still matches its hash
+	_, updateErr := tagDB.UpdateFileHashes(cmd.Context(), changed)
+	failed := map[int]bool{}
+	batchErr := &db.BatchError{}
+	if errors.As(updateErr, &batchErr) {
+		for i := range batchErr.Errors {
+			failed[changed[i].Id] = true
+		}
+	} else if updateErr != nil {
+		return updateErr
+	}
+
+	setContents := []content.Content{}
+	for _, c := range extracted {
+		if !failed[c.File] {
+			setContents = append(setContents, c)
+		}
+	}
+
+	if err := tagDB.SetContents(cmd.Context(), setContents); err != nil {
+		return err
+	}
+
+	deleteFiles := []files.File{}
+	for _, file := range dropped {
+		if !failed[file.Id] {
+			deleteFiles = append(deleteFiles, file)
+		}
+	}
+
+	if err := tagDB.DeleteContents(cmd.Context(), deleteFiles); err != nil {
+		return err
+	}
+
+	if err := renderer.Close(); err != nil {
+		return err
+	}
+
+	return errors.Join(append([]error{updateErr}, extractErrs...)...)
+}
+
+// scanTargets returns the tracked files to scan: every one if no paths are
+// given, otherwise the file at each path or the files below it if it's a
+// directory.
+func scanTargets(cmd *cobra.Command, tagDB *db.TagDB, paths []string) ([]files.File, error) {
+	if len(paths) == 0 {
+		return tagDB.GetFiles(cmd.Context())
+	}
+
+	ret := []files.File{}
+	for _, arg := range paths {
+		path, err := filepath.Abs(arg)
+		if err != nil {
+			return nil, err
+		}
+
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			below, err := filesBelow(cmd, tagDB, path)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, below...)
+			continue
+		}
+
+		file, err := tagDB.GetFileByPath(cmd.Context(), path)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, file)
+	}
+
+	return ret, nil
+}
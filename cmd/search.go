@@ -1 +1,211 @@
 package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/whatsfordinner/fstagger/internal/db"
+	"github.com/whatsfordinner/fstagger/internal/files"
+	"github.com/whatsfordinner/fstagger/internal/output"
+	"github.com/whatsfordinner/fstagger/internal/query"
+	"github.com/whatsfordinner/fstagger/internal/values"
+)
+
+var (
+	errNoResults = errors.New("no files found")
+
+	searchTaggedSince string
+	searchAsOf        string
+	searchMentions    string
+	searchCmd         = &cobra.Command{
+		Use:   "search [QUERY...]",
+		Short: "List the files which match a query of tags",
+		Long: `Lists every file which matches QUERY. The arguments are joined with spaces
+and parsed as a query where tags can be combined with and, or and not and
+grouped with parentheses:
+
+	fstagger search 'photos and not (reviewed or "to delete")'
+
+Tags written next to each other are joined with and, so "fstagger search food
+dessert" lists files with both tags. A tag containing spaces, parentheses or
+one of the keywords has to be wrapped in double quotes. Smart tags can be used
+like any other tag.
+
+Tags which take values can be compared with =, !=, <, <=, > and >=:
+
+	fstagger search 'rating>=4 and due<2025-07-01'
+
+A comparison only matches files with the tag.
+
+--tagged-since YYYY-MM-DD only lists files which had a tag attached on or
+after that day, in local time, and can be used with or without a QUERY:
+
+	fstagger search --tagged-since 2025-01-01 photos
+
+--as-of TIMESTAMP lists the files which matched QUERY at that time instead of
+now, using the tags files had then, so it needs a QUERY. TIMESTAMP is either
+YYYY-MM-DD, meaning the start of that day in local time, or an RFC 3339 time:
+
+	fstagger search --as-of 2025-03-07T17:00:00+11:00 release
+
+--mentions TEXT only lists files whose text contains every word of TEXT, for
+files whose text has been extracted with "fstagger scan --content". Words
+match the start of words, ignoring case and punctuation, and it can be used
+with or without a QUERY. Without the full-text index of a build with the
+sqlite_fts5 tag a warning says that the text is searched the slow way:
+
+	fstagger search --mentions indemnity contract
+
+A search with no results exits non-zero.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 && !cmd.Flags().Changed("tagged-since") && !cmd.Flags().Changed("mentions") {
+				return errors.New("requires a query, --tagged-since, --mentions or a combination")
+			}
+
+			if len(args) == 0 && cmd.Flags().Changed("as-of") {
+				return errors.New("--as-of requires a query")
+			}
+
+			return nil
+		},
+		RunE: withDB(runSearch),
+	}
+)
+
+func init() {
+	searchCmd.Flags().StringVar(
+		&searchTaggedSince,
+		"tagged-since",
+		"",
+		"only list files tagged on or after this date, as YYYY-MM-DD",
+	)
+	searchCmd.Flags().StringVar(
+		&searchAsOf,
+		"as-of",
+		"",
+		"search the tags files had at this time, as YYYY-MM-DD or RFC 3339",
+	)
+	searchCmd.Flags().StringVar(
+		&searchMentions,
+		"mentions",
+		"",
+		"only list files whose text contains every word of this",
+	)
+	searchCmd.MarkFlagsMutuallyExclusive("tagged-since", "as-of")
+}
+
+func runSearch(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	renderer, err := newRenderer(cmd)
+	if err != nil {
+		return err
+	}
+
+	var results []files.File
+	filtered := len(args) > 0
+	if len(args) > 0 {
+		expr, err := query.Parse(strings.Join(args, " "))
+		if err != nil {
+			return err
+		}
+
+		if cmd.Flags().Changed("as-of") {
+			asOf, err := parseAsOf(searchAsOf)
+			if err != nil {
+				return err
+			}
+
+			results, err = tagDB.GetFilesByQueryAsOf(cmd.Context(), expr, asOf)
+			if err != nil {
+				return err
+			}
+		} else {
+			results, err = tagDB.GetFilesByQuery(cmd.Context(), expr)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if cmd.Flags().Changed("tagged-since") {
+		since, err := time.ParseInLocation(values.DateLayout, searchTaggedSince, time.Local)
+		if err != nil {
+			return fmt.Errorf("invalid date for --tagged-since, expected YYYY-MM-DD: %s", searchTaggedSince)
+		}
+
+		tagged, err := tagDB.GetFilesTaggedSince(cmd.Context(), since)
+		if err != nil {
+			return err
+		}
+
+		if filtered {
+			results = intersectFiles(results, tagged)
+		} else {
+			results = tagged
+		}
+		filtered = true
+	}
+
+	if cmd.Flags().Changed("mentions") {
+		warnWithoutFullText(cmd, tagDB)
+
+		mentioning, err := tagDB.GetFilesMentioning(cmd.Context(), searchMentions)
+		if err != nil {
+			return err
+		}
+
+		if filtered {
+			results = intersectFiles(results, mentioning)
+		} else {
+			results = mentioning
+		}
+	}
+
+	if err := output.RenderAll(renderer, results); err != nil {
+		return err
+	}
+
+	if err := renderer.Close(); err != nil {
+		return err
+	}
+
+	if len(results) == 0 {
+		return errNoResults
+	}
+
+	return nil
+}
+
+// intersectFiles returns the files in a which are also in b, in the order of a.
+func intersectFiles(a []files.File, b []files.File) []files.File {
+	inB := map[int]bool{}
+	for _, file := range b {
+		inB[file.Id] = true
+	}
+
+	ret := []files.File{}
+	for _, file := range a {
+		if inB[file.Id] {
+			ret = append(ret, file)
+		}
+	}
+
+	return ret
+}
+
+// parseAsOf parses the time given to --as-of, which is either a date in local
+// time or an RFC 3339 time.
+func parseAsOf(value string) (time.Time, error) {
+	if asOf, err := time.ParseInLocation(values.DateLayout, value, time.Local); err == nil {
+		return asOf, nil
+	}
+
+	asOf, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time for --as-of, expected YYYY-MM-DD or RFC 3339: %s", value)
+	}
+
+	return asOf, nil
+}
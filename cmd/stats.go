@@ -0,0 +1,273 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/whatsfordinner/fstagger/internal/assoc"
+	"github.com/whatsfordinner/fstagger/internal/db"
+	"github.com/whatsfordinner/fstagger/internal/output"
+)
+
+var (
+	errNoPairs = errors.New("no tags appear together")
+	errNoRules = errors.New("no rules found")
+
+	statsCmd = &cobra.Command{
+		Use:   "stats",
+		Short: "Analyse how tags are used together",
+	}
+
+	statsCooccurMinFiles int
+	statsCooccurCmd      = &cobra.Command{
+		Use:   "cooccur [TAG...]",
+		Short: "Show which tags are attached to the same files",
+		Long: `Lists every pair of tags attached to at least --min-files of the same files,
+which is every cell of the co-occurrence matrix that isn't empty. Each pair
+shows how many files have both tags, its lift, which is how many times more
+often the tags appear together than they would if they were unrelated, and its
+Jaccard index, the share of the files with either tag that have both. Pairs
+with the highest lift come first.
+
+Providing TAG only lists the pairs which include any of the tags. Every file
+with at least one tag is counted but links made by implications aren't, since
+they'd only show the implications again.`,
+		RunE: withDB(runStatsCooccur),
+	}
+
+	statsRulesMinSupport    float64
+	statsRulesMinConfidence float64
+	statsRulesMaxSize       int
+	statsRulesCmd           = &cobra.Command{
+		Use:   "rules [TAG...]",
+		Short: "Find combinations of tags which imply another tag",
+		Long: `Finds rules like "files tagged invoices and client:acme are also tagged
+clients" from the tags already attached to files. A rule's support is the
+share of tagged files with every tag in it, its confidence is the share of the
+files with the tags on the left that also have the tag on the right and its
+lift is how many times more likely the tag on the right is on those files than
+on any file.
+
+Rules are drawn from sets of up to --max-size tags with at least
+--min-support, and on at least two files since one file doesn't make a
+pattern, and only rules with at least --min-confidence are listed, most
+confident first. A rule with a single tag on the left and a confidence close
+to 1 is a candidate for "fstagger tags imply add".
+
+Providing TAG only lists the rules which include any of the tags. Links made
+by implications aren't counted, since they'd only find the implications again.`,
+		RunE: withDB(runStatsRules),
+	}
+)
+
+// tagPair is two tags attached to the same files and how they're related.
+type tagPair struct {
+	A       string  `json:"a"`
+	B       string  `json:"b"`
+	Files   int     `json:"files"`
+	FilesA  int     `json:"files_a"`
+	FilesB  int     `json:"files_b"`
+	Lift    float64 `json:"lift"`
+	Jaccard float64 `json:"jaccard"`
+}
+
+func (p tagPair) String() string {
+	return fmt.Sprintf(
+		"%s\t%s\t%d files\tlift %.2f\tjaccard %.2f",
+		p.A,
+		p.B,
+		p.Files,
+		p.Lift,
+		p.Jaccard,
+	)
+}
+
+// tagRule is a set of tags whose files mostly have another tag.
+type tagRule struct {
+	If         []string `json:"if"`
+	Then       string   `json:"then"`
+	Files      int      `json:"files"`
+	Support    float64  `json:"support"`
+	Confidence float64  `json:"confidence"`
+	Lift       float64  `json:"lift"`
+}
+
+func (r tagRule) String() string {
+	return fmt.Sprintf(
+		"%s => %s\t%d files\tsupport %.2f\tconfidence %.2f\tlift %.2f",
+		strings.Join(r.If, ", "),
+		r.Then,
+		r.Files,
+		r.Support,
+		r.Confidence,
+		r.Lift,
+	)
+}
+
+func init() {
+	statsCooccurCmd.Flags().IntVar(
+		&statsCooccurMinFiles,
+		"min-files",
+		1,
+		"fewest files a pair of tags has to share to be listed",
+	)
+
+	statsRulesCmd.Flags().Float64Var(
+		&statsRulesMinSupport,
+		"min-support",
+		assoc.DefaultMinSupport,
+		"lowest share of tagged files a rule has to be drawn from",
+	)
+	statsRulesCmd.Flags().Float64Var(
+		&statsRulesMinConfidence,
+		"min-confidence",
+		assoc.DefaultMinConfidence,
+		"lowest share of files with the tags on the left which have the tag on the right",
+	)
+	statsRulesCmd.Flags().IntVar(
+		&statsRulesMaxSize,
+		"max-size",
+		assoc.DefaultMaxSize,
+		"most tags in a rule, counting both sides",
+	)
+
+	statsCmd.AddCommand(statsCooccurCmd)
+	statsCmd.AddCommand(statsRulesCmd)
+	rootCmd.AddCommand(statsCmd)
+}
+
+func runStatsCooccur(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	transactions, names, only, err := tagTransactions(cmd, tagDB, args)
+	if err != nil {
+		return err
+	}
+
+	pairs := []tagPair{}
+	for _, pair := range assoc.Cooccurrence(transactions, statsCooccurMinFiles) {
+		if len(only) > 0 && !only[pair.A] && !only[pair.B] {
+			continue
+		}
+
+		pairs = append(pairs, tagPair{
+			A:       names[pair.A],
+			B:       names[pair.B],
+			Files:   pair.Files,
+			FilesA:  pair.FilesA,
+			FilesB:  pair.FilesB,
+			Lift:    pair.Lift,
+			Jaccard: pair.Jaccard,
+		})
+	}
+
+	renderer, err := newRenderer(cmd)
+	if err != nil {
+		return err
+	}
+
+	if err := output.RenderAll(renderer, pairs); err != nil {
+		return err
+	}
+
+	if err := renderer.Close(); err != nil {
+		return err
+	}
+
+	if len(pairs) == 0 {
+		return errNoPairs
+	}
+
+	return nil
+}
+
+func runStatsRules(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	if statsRulesMinSupport < 0 || statsRulesMinSupport > 1 {
+		return fmt.Errorf("minimum support must be between 0 and 1: %g", statsRulesMinSupport)
+	}
+
+	if statsRulesMinConfidence < 0 || statsRulesMinConfidence > 1 {
+		return fmt.Errorf("minimum confidence must be between 0 and 1: %g", statsRulesMinConfidence)
+	}
+
+	if statsRulesMaxSize < 2 {
+		return fmt.Errorf("a rule needs at least 2 tags: %d", statsRulesMaxSize)
+	}
+
+	transactions, names, only, err := tagTransactions(cmd, tagDB, args)
+	if err != nil {
+		return err
+	}
+
+	rules := []tagRule{}
+	for _, rule := range assoc.Rules(transactions, statsRulesMinSupport, statsRulesMinConfidence, statsRulesMaxSize) {
+		if len(only) > 0 && !only[rule.Then] && !slices.ContainsFunc(rule.If, func(tag int) bool { return only[tag] }) {
+			continue
+		}
+
+		antecedent := []string{}
+		for _, tag := range rule.If {
+			antecedent = append(antecedent, names[tag])
+		}
+
+		rules = append(rules, tagRule{
+			If:         antecedent,
+			Then:       names[rule.Then],
+			Files:      rule.Files,
+			Support:    rule.Support,
+			Confidence: rule.Confidence,
+			Lift:       rule.Lift,
+		})
+	}
+
+	renderer, err := newRenderer(cmd)
+	if err != nil {
+		return err
+	}
+
+	if err := output.RenderAll(renderer, rules); err != nil {
+		return err
+	}
+
+	if err := renderer.Close(); err != nil {
+		return err
+	}
+
+	if len(rules) == 0 {
+		return errNoRules
+	}
+
+	return nil
+}
+
+// tagTransactions returns the IDs of the tags on every tagged file, the name of
+// every tag by ID and the IDs of the named tags, if any, to narrow the results
+// down to.
+func tagTransactions(cmd *cobra.Command, tagDB *db.TagDB, names []string) ([][]int, map[int]string, map[int]bool, error) {
+	only := map[int]bool{}
+	for _, name := range names {
+		tag, err := tagDB.GetTagByName(cmd.Context(), name)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		only[tag.Id] = true
+	}
+
+	allTags, err := tagDB.GetTags(cmd.Context())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	tagNames := map[int]string{}
+	for _, tag := range allTags {
+		tagNames[tag.Id] = tag.String()
+	}
+
+	allLinks, err := tagDB.GetLinks(cmd.Context())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return assoc.Transactions(allLinks), tagNames, only, nil
+}
@@ -0,0 +1,486 @@
+package cmd
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/whatsfordinner/fstagger/internal/classify"
+	"github.com/whatsfordinner/fstagger/internal/db"
+	"github.com/whatsfordinner/fstagger/internal/output"
+	"github.com/whatsfordinner/fstagger/internal/suggest"
+	"github.com/whatsfordinner/fstagger/internal/tags"
+)
+
+const defaultSuggestLimit = 10
+
+var (
+	errNoSuggestions = errors.New("no tags to suggest")
+
+	suggestLimit       int
+	suggestMaxDistance int
+	suggestModel       bool
+	suggestTrain       bool
+	suggestHoldout     float64
+	suggestThreshold   float64
+	suggestCmd         = &cobra.Command{
+		Use:   "suggest FILE",
+		Short: "Suggest tags for a file from what its path says or from a trained model",
+		Long: `Suggests existing tags for FILE by matching them against the words in its
+path, best first. Directory and file names are split into words at
+punctuation, changes of case and between letters and digits, so
+~/clients/acme/2024/invoice-042.pdf suggests tags like acme, 2024 and
+invoices. Names are compared ignoring case, accents and word endings like
+tag lint does, and match exactly, as a prefix or within --max-distance edits
+for typos. Aliases are matched along with tag names and the tags FILE already
+has aren't suggested.
+
+Matches in the file's name score higher than matches in the directories above
+it, and the further up a directory is the less it counts. The part of the
+path under your home directory is all that's used. FILE doesn't have to be
+tracked yet. A file without any suggestions exits non-zero.
+
+"fstagger tag add --interactive" offers the same suggestions to pick from.
+
+--model suggests tags learned from the files which are already tagged instead.
+The model has to be trained first with --model --train, which learns how the
+words in tagged files' paths, their extensions, sizes and MIME types go with
+their tags, and again whenever the tags have changed enough to matter. Each
+tag predicted with at least --threshold probability is suggested, most likely
+first.
+
+Training holds out a share of the tagged files, --holdout, trains on the rest
+and reports the precision, the share of tags predicted for the held out files
+which they really have, and the recall, the share of their tags which were
+predicted, or says that no files were held out if there are too few or
+--holdout is 0. The model that's kept is then trained on every tagged file:
+
+	fstagger suggest --model --train
+	fstagger suggest --model ~/clients/acme/2024/invoice-042.pdf`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if suggestTrain {
+				if !suggestModel {
+					return errors.New("--train only works with --model")
+				}
+				return cobra.NoArgs(cmd, args)
+			}
+
+			return cobra.ExactArgs(1)(cmd, args)
+		},
+		RunE: withDB(runSuggest),
+	}
+)
+
+// suggestedTag is a tag suggested for a file with its score and why it was
+// suggested.
+type suggestedTag struct {
+	Tag    string  `json:"tag"`
+	Score  float64 `json:"score"`
+	Reason string  `json:"reason"`
+}
+
+func (s suggestedTag) String() string {
+	return fmt.Sprintf("%s\t%.2f\t%s", s.Tag, s.Score, s.Reason)
+}
+
+// modelReport is how well a newly trained model did on the files held out
+// from training it. Precision and recall mean nothing if none were held out.
+type modelReport struct {
+	Files     int     `json:"files"`
+	HeldOut   int     `json:"held_out"`
+	Threshold float64 `json:"threshold"`
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+}
+
+func (r modelReport) String() string {
+	if r.HeldOut == 0 {
+		return fmt.Sprintf("%d files\tno files held out", r.Files)
+	}
+
+	return fmt.Sprintf(
+		"%d files\t%d held out\tprecision %.2f\trecall %.2f",
+		r.Files,
+		r.HeldOut,
+		r.Precision,
+		r.Recall,
+	)
+}
+
+func init() {
+	suggestCmd.Flags().IntVar(
+		&suggestLimit,
+		"limit",
+		defaultSuggestLimit,
+		"maximum number of tags to suggest",
+	)
+	suggestCmd.Flags().IntVar(
+		&suggestMaxDistance,
+		"max-distance",
+		suggest.DefaultMaxDistance,
+		"maximum edit distance between a tag name and a word in the path",
+	)
+	suggestCmd.Flags().BoolVar(
+		&suggestModel,
+		"model",
+		false,
+		"suggest tags learned from the files which are already tagged",
+	)
+	suggestCmd.Flags().BoolVar(
+		&suggestTrain,
+		"train",
+		false,
+		"train the model from the files which are already tagged, with --model",
+	)
+	suggestCmd.Flags().Float64Var(
+		&suggestHoldout,
+		"holdout",
+		classify.DefaultHoldout,
+		"share of tagged files held out to measure a newly trained model with",
+	)
+	suggestCmd.Flags().Float64Var(
+		&suggestThreshold,
+		"threshold",
+		classify.DefaultThreshold,
+		"lowest probability of a tag the model suggests",
+	)
+
+	rootCmd.AddCommand(suggestCmd)
+}
+
+func runSuggest(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	if suggestTrain {
+		return runSuggestTrain(cmd, tagDB)
+	}
+
+	var suggestions []suggestedTag
+	var err error
+	if suggestModel {
+		suggestions, err = predictTags(cmd, tagDB, args[0], suggestLimit, suggestThreshold)
+	} else {
+		suggestions, err = suggestTags(cmd, tagDB, args[0], suggestLimit, suggestMaxDistance)
+	}
+	if err != nil {
+		return err
+	}
+
+	renderer, err := newRenderer(cmd)
+	if err != nil {
+		return err
+	}
+
+	if err := output.RenderAll(renderer, suggestions); err != nil {
+		return err
+	}
+
+	if err := renderer.Close(); err != nil {
+		return err
+	}
+
+	if len(suggestions) == 0 {
+		return errNoSuggestions
+	}
+
+	return nil
+}
+
+// suggestTags returns up to limit tags suggested for the file at path from
+// the words in its path, leaving out the tags the file already has if it's
+// tracked.
+func suggestTags(cmd *cobra.Command, tagDB *db.TagDB, path string, limit int, maxDistance int) ([]suggestedTag, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	allTags, err := tagDB.GetTags(cmd.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	allAliases, err := tagDB.GetAliases(cmd.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	counts, err := tagDB.GetTagFileCounts(cmd.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := existingTags(cmd, tagDB, absPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// tags in a hierarchy are matched by the last part of their name, so
+	// clients/acme is suggested by acme
+	byId := map[int]tags.Tag{}
+	candidates := []suggest.Candidate{}
+	for _, tag := range allTags {
+		byId[tag.Id] = tag
+		if !existing[tag.Id] {
+			candidates = append(candidates, suggest.Candidate{Name: tag.Leaf(), Tag: tag, Files: counts[tag.Id]})
+		}
+	}
+	for _, alias := range allAliases {
+		if tag, ok := byId[alias.Tag]; ok && !existing[alias.Tag] {
+			candidates = append(candidates, suggest.Candidate{
+				Name:  tags.Tag{Name: alias.Name}.Leaf(),
+				Tag:   tag,
+				Files: counts[tag.Id],
+			})
+		}
+	}
+
+	ret := []suggestedTag{}
+	for _, suggestion := range suggest.Suggest(homeRelative(absPath), candidates, maxDistance) {
+		if len(ret) == limit {
+			break
+		}
+
+		ret = append(ret, suggestedTag{
+			Tag:    suggestion.Tag.String(),
+			Score:  suggestion.Score,
+			Reason: fmt.Sprintf("%s match on %q", suggestion.Match, suggestion.Token),
+		})
+	}
+
+	return ret, nil
+}
+
+// predictTags returns up to limit tags the stored model predicts for the file
+// at path with at least threshold probability, leaving out the tags the file
+// already has if it's tracked.
+func predictTags(cmd *cobra.Command, tagDB *db.TagDB, path string, limit int, threshold float64) ([]suggestedTag, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	model, err := tagDB.GetModel(cmd.Context())
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("no model has been trained, run \"fstagger suggest --model --train\"")
+	} else if err != nil {
+		return nil, err
+	}
+
+	size, mimeType, err := classify.Sniff(absPath)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := existingTags(cmd, tagDB, absPath)
+	if err != nil {
+		return nil, err
+	}
+
+	allTags, err := tagDB.GetTags(cmd.Context())
+	if err != nil {
+		return nil, err
+	}
+	byId := map[int]tags.Tag{}
+	for _, tag := range allTags {
+		byId[tag.Id] = tag
+	}
+
+	ret := []suggestedTag{}
+	for _, prediction := range model.Predict(classify.Features(homeRelative(absPath), size, mimeType)) {
+		if len(ret) == limit || prediction.Probability < threshold {
+			break
+		}
+
+		tag, ok := byId[prediction.Tag]
+		if !ok || existing[prediction.Tag] {
+			continue
+		}
+
+		ret = append(ret, suggestedTag{
+			Tag:    tag.String(),
+			Score:  math.Round(prediction.Probability*100) / 100,
+			Reason: "predicted by model",
+		})
+	}
+
+	return ret, nil
+}
+
+// runSuggestTrain trains a model on every tagged file, reporting how well it
+// does on a held out share of them first, and stores it.
+func runSuggestTrain(cmd *cobra.Command, tagDB *db.TagDB) error {
+	if suggestHoldout < 0 || suggestHoldout >= 1 {
+		return fmt.Errorf("holdout must be at least 0 and less than 1: %g", suggestHoldout)
+	}
+
+	examples, err := trainingExamples(cmd, tagDB)
+	if err != nil {
+		return err
+	}
+
+	evaluation := classify.Evaluate(examples, suggestHoldout, suggestThreshold)
+
+	if err := tagDB.SaveModel(cmd.Context(), classify.Train(examples)); err != nil {
+		return err
+	}
+
+	renderer, err := newRenderer(cmd)
+	if err != nil {
+		return err
+	}
+
+	if err := renderer.Render(modelReport{
+		Files:     len(examples),
+		HeldOut:   evaluation.Tested,
+		Threshold: evaluation.Threshold,
+		Precision: evaluation.Precision,
+		Recall:    evaluation.Recall,
+	}); err != nil {
+		return err
+	}
+
+	return renderer.Close()
+}
+
+// trainingExamples describes every tracked file which has at least one tag. A
+// file which can't be read is described by its path alone.
+func trainingExamples(cmd *cobra.Command, tagDB *db.TagDB) ([]classify.Example, error) {
+	trackedFiles, err := tagDB.GetFiles(cmd.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	allLinks, err := tagDB.GetLinks(cmd.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	fileTags := map[int][]int{}
+	for _, link := range allLinks {
+		fileTags[link.File] = append(fileTags[link.File], link.Tag)
+	}
+
+	ret := []classify.Example{}
+	for _, file := range trackedFiles {
+		if len(fileTags[file.Id]) == 0 {
+			continue
+		}
+
+		size, mimeType, err := classify.Sniff(file.Path)
+		if err != nil {
+			size, mimeType = -1, ""
+		}
+
+		ret = append(ret, classify.Example{
+			Path:     file.Path,
+			Features: classify.Features(homeRelative(file.Path), size, mimeType),
+			Tags:     fileTags[file.Id],
+		})
+	}
+
+	return ret, nil
+}
+
+// existingTags returns the IDs of the tags the file at path has, which is none
+// if it isn't tracked.
+func existingTags(cmd *cobra.Command, tagDB *db.TagDB, path string) (map[int]bool, error) {
+	ret := map[int]bool{}
+	file, err := tagDB.GetFileByPath(cmd.Context(), path)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ret, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	fileTags, err := tagDB.GetTagsForFile(cmd.Context(), file)
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range fileTags {
+		ret[tag.Id] = true
+	}
+
+	return ret, nil
+}
+
+// askSuggestedTags lists suggested tags and asks which of them to use. It
+// returns the names of the chosen tags, which is none if there aren't any
+// suggestions or the input ends.
+func askSuggestedTags(w io.Writer, r *bufio.Reader, suggestions []suggestedTag) ([]string, error) {
+	if len(suggestions) == 0 {
+		fmt.Fprintln(w, "No tags to suggest")
+		return []string{}, nil
+	}
+
+	fmt.Fprintln(w, "Suggested tags:")
+	for i, suggestion := range suggestions {
+		fmt.Fprintf(w, "  %d) %s (%s)\n", i+1, suggestion.Tag, suggestion.Reason)
+	}
+
+	for {
+		fmt.Fprint(w, "Add which? Numbers separated by spaces, [a]ll or [N]one: ")
+		answer, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		answer = strings.ToLower(strings.TrimSpace(answer))
+
+		switch answer {
+		case "", "n", "none":
+			return []string{}, nil
+		case "a", "all":
+			ret := []string{}
+			for _, suggestion := range suggestions {
+				ret = append(ret, suggestion.Tag)
+			}
+			return ret, nil
+		default:
+			if ret, ok := pickSuggestions(answer, suggestions); ok {
+				return ret, nil
+			}
+		}
+
+		if err == io.EOF {
+			return []string{}, nil
+		}
+		fmt.Fprintf(w, "Didn't understand %q\n", answer)
+	}
+}
+
+// pickSuggestions returns the names of the suggestions numbered in answer and
+// false if any of it isn't the number of a suggestion.
+func pickSuggestions(answer string, suggestions []suggestedTag) ([]string, bool) {
+	ret := []string{}
+	for _, field := range strings.FieldsFunc(answer, func(r rune) bool { return r == ' ' || r == ',' }) {
+		choice, err := strconv.Atoi(field)
+		if err != nil || choice < 1 || choice > len(suggestions) {
+			return nil, false
+		}
+		ret = append(ret, suggestions[choice-1].Tag)
+	}
+
+	return ret, true
+}
+
+// homeRelative returns path relative to the user's home directory if it's
+// below it, since the directories above home say nothing about the file.
+func homeRelative(path string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+
+	rel, err := filepath.Rel(home, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path
+	}
+
+	return rel
+}
@@ -1,8 +1,27 @@
 package cmd
 
 import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
 	"github.com/spf13/cobra"
-	//"github.com/whatsfordinner/fstagger/internal/tags"
+	"github.com/whatsfordinner/fstagger/internal/db"
+	"github.com/whatsfordinner/fstagger/internal/files"
+	"github.com/whatsfordinner/fstagger/internal/importer"
+	"github.com/whatsfordinner/fstagger/internal/links"
+	"github.com/whatsfordinner/fstagger/internal/output"
+	"github.com/whatsfordinner/fstagger/internal/smarttags"
+	"github.com/whatsfordinner/fstagger/internal/suggest"
+	"github.com/whatsfordinner/fstagger/internal/tags"
+	"github.com/whatsfordinner/fstagger/internal/values"
 )
 
 var (
@@ -11,7 +30,650 @@ var (
 		Short: "Create, list and remove tags attached to files",
 	}
 
-	tagAddCmd    = &cobra.Command{}
-	tagListCmd   = &cobra.Command{}
-	tagRemoveCmd = &cobra.Command{}
+	tagAddTTL         string
+	tagAddSource      string
+	tagAddConfidence  string
+	tagAddInteractive bool
+	tagAddCmd         = &cobra.Command{
+		Use:   "add FILE [TAG...]",
+		Short: "Attach tags to a file, tracking the file if it's new",
+		Long: `Attaches every TAG to FILE, creating tags which don't exist yet. A tag which
+takes values can be given one, like rating=4, but only once it exists and has
+a type, otherwise nothing is changed.
+
+With --ttl the tags are only attached for a while, like 7d for a week, and
+then hidden from searches until "fstagger gc" removes them. Adding a tag the
+file already has with --ttl moves when it expires.
+
+Tags attached with fstagger tag add are made by hand unless --source names the
+tool which made them, like rule:camera, optionally with --confidence saying how
+sure it was as a fraction or a percentage, like 0.8 or 80%. Only a person or
+the same source can change a tag a file already has, so re-running a tool never
+undoes what a person decided.
+
+--interactive lists the tags "fstagger suggest" would suggest for FILE and asks
+which of them to attach along with any TAG given. Without --interactive at
+least one TAG is needed.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if tagAddInteractive {
+				return cobra.MinimumNArgs(1)(cmd, args)
+			}
+
+			return cobra.MinimumNArgs(2)(cmd, args)
+		},
+		RunE: withDB(runTagAdd),
+	}
+
+	tagListAsOf string
+	tagListCmd  = &cobra.Command{
+		Use:   "list FILE",
+		Short: "List the tags attached to a file",
+		Long: `Lists the tags attached to a file along with any smart tags it matches. Tags
+with a value on the file are shown as name=value, tags which expire are shown
+with when they expire and tags which weren't attached by hand are shown with
+where they came from.
+
+With --as-of TIMESTAMP the tags the file had at that time are listed instead,
+including tags which have since been deleted. TIMESTAMP is either YYYY-MM-DD,
+meaning the start of that day in local time, or an RFC 3339 time.`,
+		Args: cobra.ExactArgs(1),
+		RunE: withDB(runTagList),
+	}
+
+	tagRemoveSource string
+	tagRemoveCmd    = &cobra.Command{
+		Use:     "remove PATH TAG...",
+		Aliases: []string{"rm"},
+		Short:   "Detach tags from a file or every file in a directory",
+		Long: `Detaches every TAG from the file at PATH. If PATH is a directory the tags are
+detached from every tracked file below it which has them. Removing a tag a
+file doesn't have is an error.
+
+With --source SOURCE every tag attached by SOURCE is detached instead, like
+every tag a rule got wrong. SOURCE may contain * wildcards, so implied:*
+matches every tag attached by an implication rule. Tags attached before
+fstagger recorded sources count as attached by hand, including ones attached
+by implication rules. PATH and TAG are optional and narrow it down to the
+files at or below PATH and to those tags:
+
+	fstagger tag remove --source rule:camera ~/Pictures
+
+Lists every tag it removed. If it removed the wrong ones "fstagger undo" puts
+them all back.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if cmd.Flags().Changed("source") {
+				return nil
+			}
+
+			return cobra.MinimumNArgs(2)(cmd, args)
+		},
+		RunE: withDB(runTagRemove),
+	}
+
+	tagImportBatchSize int
+	tagImportSource    string
+	tagImportCmd       = &cobra.Command{
+		Use:   "import [FILE|-]",
+		Short: "Bulk tag files from TSV or NDJSON lines",
+		Long: `Reads tag assignments one per line from FILE, or from stdin if FILE is -.
+Each line is either a path and a comma separated tag list separated by a tab:
+
+	/path/to/file	tag1,tag2
+
+or an NDJSON object:
+
+	{"path": "/path/to/file", "tags": ["tag1", "tag2"]}
+
+A tag which takes values can be given one, like rating=4. An NDJSON object can
+also name the tool which made its tags and how sure it was:
+
+	{"path": "/path/to/file", "tags": ["cat"], "source": "rule:pets", "confidence": 0.8}
+
+Tags from lines which don't name a source are attached by "import", or by
+--source if it's given. Only a person or the same source can change a tag a
+file already has, so re-importing never undoes what a person decided.
+
+Every line gets a report of ok or error. The command exits non-zero if any
+line failed.`,
+		Args: cobra.ExactArgs(1),
+		RunE: withDB(runTagImport),
+	}
 )
+
+func init() {
+	tagAddCmd.Flags().StringVar(
+		&tagAddTTL,
+		"ttl",
+		"",
+		"remove the tags after this long, like 12h, 7d or 2w",
+	)
+	tagAddCmd.Flags().StringVar(
+		&tagAddSource,
+		"source",
+		"",
+		"what attached the tags, like rule:camera, instead of a person",
+	)
+	tagAddCmd.Flags().StringVar(
+		&tagAddConfidence,
+		"confidence",
+		"",
+		"how sure the source is, like 0.8 or 80%",
+	)
+	tagAddCmd.Flags().BoolVarP(
+		&tagAddInteractive,
+		"interactive",
+		"i",
+		false,
+		"pick tags to attach from the tags suggested for the file",
+	)
+
+	tagListCmd.Flags().StringVar(
+		&tagListAsOf,
+		"as-of",
+		"",
+		"list the tags the file had at this time, as YYYY-MM-DD or RFC 3339",
+	)
+
+	tagImportCmd.Flags().IntVar(
+		&tagImportBatchSize,
+		"batch-size",
+		importer.DefaultBatchSize,
+		"number of lines written to the database per transaction",
+	)
+	tagImportCmd.Flags().StringVar(
+		&tagImportSource,
+		"source",
+		links.SourceImport,
+		"what attached the tags on lines which don't say",
+	)
+
+	tagRemoveCmd.Flags().StringVar(
+		&tagRemoveSource,
+		"source",
+		"",
+		"remove every tag attached by this source, which may contain * wildcards",
+	)
+
+	tagCmd.AddCommand(tagAddCmd)
+	tagCmd.AddCommand(tagListCmd)
+	tagCmd.AddCommand(tagRemoveCmd)
+	tagCmd.AddCommand(tagImportCmd)
+}
+
+// fileTag is a tag attached to a file along with its value on the file, when
+// it expires and what attached it.
+type fileTag struct {
+	tags.Tag
+	Value      string    `json:"value,omitempty"`
+	Expires    time.Time `json:"expires,omitzero"`
+	Source     string    `json:"source,omitempty"`
+	Confidence *float64  `json:"confidence,omitempty"`
+}
+
+func (t fileTag) String() string {
+	ret := t.Tag.String()
+	if t.Value != "" {
+		ret += "=" + t.Value
+	}
+
+	if !t.Expires.IsZero() {
+		ret += "\texpires " + t.Expires.Local().Format(time.RFC3339)
+	}
+
+	return ret + describeSource(t.Source, t.Confidence)
+}
+
+// describeSource describes what attached a tag, or nothing if it was attached
+// by hand.
+func describeSource(source string, confidence *float64) string {
+	ret := ""
+	if source != "" {
+		ret += "\tfrom " + source
+	}
+
+	if confidence != nil {
+		ret += fmt.Sprintf("\tconfidence %g", *confidence)
+	}
+
+	return ret
+}
+
+func runTagAdd(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	var expires time.Time
+	if tagAddTTL != "" {
+		ttl, err := links.ParseTTL(tagAddTTL)
+		if err != nil {
+			return err
+		}
+		expires = time.Now().Add(ttl)
+	}
+
+	var confidence *float64
+	if tagAddConfidence != "" {
+		parsed, err := links.ParseConfidence(tagAddConfidence)
+		if err != nil {
+			return err
+		}
+		confidence = &parsed
+	}
+
+	if tagAddInteractive {
+		suggestions, err := suggestTags(cmd, tagDB, args[0], defaultSuggestLimit, suggest.DefaultMaxDistance)
+		if err != nil {
+			return err
+		}
+
+		chosen, err := askSuggestedTags(cmd.ErrOrStderr(), bufio.NewReader(cmd.InOrStdin()), suggestions)
+		if err != nil {
+			return err
+		}
+		args = append(args, chosen...)
+
+		if len(args) == 1 {
+			fmt.Fprintln(cmd.ErrOrStderr(), "No tags to add")
+			return nil
+		}
+	}
+
+	// a tag which doesn't exist yet has no type so it can't take a value, and
+	// it's rejected before the file or any tags are added so none are left
+	// behind
+	for _, arg := range args[1:] {
+		name, value, _ := values.Split(arg)
+		if value == "" {
+			continue
+		}
+
+		if _, err := tagDB.GetTagByName(cmd.Context(), name); errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf(
+				"tag %s doesn't exist so it can't take a value, "+
+					"create it with \"fstagger tags create\" and declare its type with \"fstagger tags type set\"",
+				tags.Parse(name),
+			)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	file, err := trackFile(cmd, tagDB, args[0])
+	if err != nil {
+		return err
+	}
+
+	// tags are added one at a time so each value stays with its tag even if
+	// two arguments resolve to the same tag
+	newLinks := []links.Link{}
+	for _, arg := range args[1:] {
+		name, value, _ := values.Split(arg)
+		added, err := tagDB.AddTags(cmd.Context(), []tags.Tag{tags.Parse(name)})
+		if err != nil {
+			return err
+		}
+
+		newLinks = append(newLinks, links.Link{
+			File:       file.Id,
+			Tag:        added[0].Id,
+			Value:      value,
+			Expires:    expires,
+			Source:     tagAddSource,
+			Confidence: confidence,
+		})
+	}
+
+	_, linkErr := tagDB.AddLinks(cmd.Context(), newLinks)
+
+	if err := renderFileTags(cmd, tagDB, file, time.Time{}); err != nil {
+		return err
+	}
+
+	return linkErr
+}
+
+// trackFile returns the tracked file at path, adding it to the database first
+// if it isn't tracked yet.
+func trackFile(cmd *cobra.Command, tagDB *db.TagDB, path string) (files.File, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return files.File{}, err
+	}
+
+	file, err := tagDB.GetFileByPath(cmd.Context(), absPath)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return file, err
+	}
+
+	file, err = files.FromPath(absPath)
+	if err != nil {
+		return files.File{}, err
+	}
+
+	added, err := tagDB.AddFiles(cmd.Context(), []files.File{file})
+	if err != nil {
+		return files.File{}, err
+	}
+
+	return added[0], nil
+}
+
+func runTagList(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	path, err := filepath.Abs(args[0])
+	if err != nil {
+		return err
+	}
+
+	var asOf time.Time
+	if cmd.Flags().Changed("as-of") {
+		asOf, err = parseAsOf(tagListAsOf)
+		if err != nil {
+			return err
+		}
+	}
+
+	file, err := tagDB.GetFileByPath(cmd.Context(), path)
+	if err != nil {
+		return err
+	}
+
+	return renderFileTags(cmd, tagDB, file, asOf)
+}
+
+// renderFileTags renders the tags attached to a file and the smart tags it
+// matches, ordered by namespace and name. They're the file's tags at asOf
+// unless it's zero, in which case they're the file's tags now.
+func renderFileTags(cmd *cobra.Command, tagDB *db.TagDB, file files.File, asOf time.Time) error {
+	renderer, err := newRenderer(cmd)
+	if err != nil {
+		return err
+	}
+
+	var (
+		attachedTags []tags.Tag
+		fileLinks    []links.Link
+		smartTags    []smarttags.SmartTag
+	)
+	if asOf.IsZero() {
+		attachedTags, err = tagDB.GetTagsForFile(cmd.Context(), file)
+		if err == nil {
+			fileLinks, err = tagDB.GetLinksForFile(cmd.Context(), file)
+		}
+		if err == nil {
+			smartTags, err = tagDB.GetSmartTagsForFile(cmd.Context(), file)
+		}
+	} else {
+		attachedTags, err = tagDB.GetTagsForFileAsOf(cmd.Context(), file, asOf)
+		if err == nil {
+			fileLinks, err = tagDB.GetLinksForFileAsOf(cmd.Context(), file, asOf)
+		}
+		if err == nil {
+			smartTags, err = tagDB.GetSmartTagsForFileAsOf(cmd.Context(), file, asOf)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	fileLinksByTag := map[int]links.Link{}
+	for _, link := range fileLinks {
+		fileLinksByTag[link.Tag] = link
+	}
+
+	fileTags := []fileTag{}
+	for _, tag := range attachedTags {
+		link := fileLinksByTag[tag.Id]
+		fileTags = append(fileTags, fileTag{
+			Tag:        tag,
+			Value:      link.Value,
+			Expires:    link.Expires,
+			Source:     link.Source,
+			Confidence: link.Confidence,
+		})
+	}
+
+	// smart tags the file matches are listed as though they were attached
+	for _, smartTag := range smartTags {
+		fileTags = append(fileTags, fileTag{Tag: tags.Tag{
+			Namespace:   smartTag.Namespace,
+			Name:        smartTag.Name,
+			Description: smartTag.Description,
+		}})
+	}
+
+	sort.SliceStable(fileTags, func(i, j int) bool {
+		if fileTags[i].Namespace != fileTags[j].Namespace {
+			return fileTags[i].Namespace < fileTags[j].Namespace
+		}
+		return fileTags[i].Name < fileTags[j].Name
+	})
+
+	if err := output.RenderAll(renderer, fileTags); err != nil {
+		return err
+	}
+
+	return renderer.Close()
+}
+
+// removedLink is a tag removed from a file, shown with the file's path and the
+// tag's name.
+type removedLink struct {
+	Path string `json:"path"`
+	Tag  string `json:"tag"`
+}
+
+func (r removedLink) String() string {
+	return fmt.Sprintf("%s\t%s", r.Path, r.Tag)
+}
+
+func runTagRemove(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	if cmd.Flags().Changed("source") {
+		return runTagRemoveBySource(cmd, args, tagDB)
+	}
+
+	targets, isDir, err := filesAt(cmd, tagDB, args[0])
+	if err != nil {
+		return err
+	}
+
+	deleteLinks := []links.Link{}
+	removed := []removedLink{}
+	for _, name := range args[1:] {
+		tag, err := tagDB.GetTagByName(cmd.Context(), name)
+		if err != nil {
+			return err
+		}
+
+		// a file named directly must have the tag but a directory only loses
+		// the tag from the files below it which have it
+		candidates := targets
+		if isDir {
+			candidates, err = filesWithTag(cmd, tagDB, targets, tag)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, file := range candidates {
+			deleteLinks = append(deleteLinks, links.Link{File: file.Id, Tag: tag.Id})
+			removed = append(removed, removedLink{Path: file.Path, Tag: tag.String()})
+		}
+	}
+
+	return removeLinks(cmd, tagDB, deleteLinks, removed)
+}
+
+// runTagRemoveBySource removes every link made by a source, narrowed down to
+// the files at or below the path and the tags given as arguments, if any.
+func runTagRemoveBySource(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	deleteLinks, described, err := linksBySource(cmd, tagDB, tagRemoveSource, args)
+	if err != nil {
+		return err
+	}
+
+	removed := []removedLink{}
+	for _, link := range described {
+		removed = append(removed, removedLink{Path: link.Path, Tag: link.Tag})
+	}
+
+	return removeLinks(cmd, tagDB, deleteLinks, removed)
+}
+
+// removeLinks removes links from their files and renders the ones which were
+// removed, which are described by the matching element of removed.
+func removeLinks(cmd *cobra.Command, tagDB *db.TagDB, deleteLinks []links.Link, removed []removedLink) error {
+	renderer, err := newRenderer(cmd)
+	if err != nil {
+		return err
+	}
+
+	deleteErr := tagDB.DeleteLinks(cmd.Context(), deleteLinks)
+
+	batchErr := &db.BatchError{}
+	for i, link := range removed {
+		if errors.As(deleteErr, &batchErr) && batchErr.Errors[i] != nil {
+			continue
+		}
+
+		if err := renderer.Render(link); err != nil {
+			return err
+		}
+	}
+
+	if err := renderer.Close(); err != nil {
+		return err
+	}
+
+	return deleteErr
+}
+
+// filesAt returns the tracked file at path, or every tracked file below it if
+// it's a directory, and whether it's a directory.
+func filesAt(cmd *cobra.Command, tagDB *db.TagDB, arg string) ([]files.File, bool, error) {
+	path, err := filepath.Abs(arg)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		below, err := filesBelow(cmd, tagDB, path)
+		return below, true, err
+	}
+
+	file, err := tagDB.GetFileByPath(cmd.Context(), path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return []files.File{file}, false, nil
+}
+
+// filesBelow returns every tracked file below the directory at path.
+func filesBelow(cmd *cobra.Command, tagDB *db.TagDB, path string) ([]files.File, error) {
+	tracked, err := tagDB.GetFiles(cmd.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []files.File{}
+	prefix := strings.TrimSuffix(path, string(filepath.Separator)) + string(filepath.Separator)
+	for _, file := range tracked {
+		if strings.HasPrefix(file.Path, prefix) {
+			ret = append(ret, file)
+		}
+	}
+
+	return ret, nil
+}
+
+// filesWithTag returns the files in candidates which have tag.
+func filesWithTag(cmd *cobra.Command, tagDB *db.TagDB, candidates []files.File, tag tags.Tag) ([]files.File, error) {
+	tagLinks, err := tagDB.GetLinksForTag(cmd.Context(), tag)
+	if err != nil {
+		return nil, err
+	}
+
+	tagged := map[int]bool{}
+	for _, link := range tagLinks {
+		tagged[link.File] = true
+	}
+
+	ret := []files.File{}
+	for _, file := range candidates {
+		if tagged[file.Id] {
+			ret = append(ret, file)
+		}
+	}
+
+	return ret, nil
+}
+
+// importReport is the outcome of importing a single line.
+type importReport struct {
+	Line   int      `json:"line"`
+	Status string   `json:"status"`
+	Path   string   `json:"path"`
+	Tags   []string `json:"tags"`
+	Error  string   `json:"error,omitempty"`
+}
+
+func (r importReport) String() string {
+	detail := strings.Join(r.Tags, ",")
+	if r.Error != "" {
+		detail = strings.ReplaceAll(r.Error, "\n", "; ")
+	}
+
+	return fmt.Sprintf("%d\t%s\t%s\t%s", r.Line, r.Status, r.Path, detail)
+}
+
+func runTagImport(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	var input io.Reader = cmd.InOrStdin()
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		input = f
+	}
+
+	renderer, err := newRenderer(cmd)
+	if err != nil {
+		return err
+	}
+
+	failures := 0
+	var renderErr error
+	report := func(result importer.Result) {
+		line := importReport{
+			Line:   result.Line,
+			Status: "ok",
+			Path:   result.Path,
+			Tags:   result.Tags,
+		}
+		if result.Err != nil {
+			failures++
+			line.Status = "error"
+			line.Error = result.Err.Error()
+		}
+		if err := renderer.Render(line); err != nil {
+			renderErr = errors.Join(renderErr, err)
+		}
+	}
+
+	tagImporter := importer.New(
+		tagDB,
+		importer.WithBatchSize(tagImportBatchSize),
+		importer.WithSource(tagImportSource),
+	)
+	if err := tagImporter.Import(cmd.Context(), input, report); err != nil {
+		return err
+	}
+
+	if err := errors.Join(renderErr, renderer.Close()); err != nil {
+		return err
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%d lines failed to import", failures)
+	}
+
+	return nil
+}
@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/whatsfordinner/fstagger/internal/db"
+	"github.com/whatsfordinner/fstagger/internal/files"
+	"github.com/whatsfordinner/fstagger/internal/links"
+	"github.com/whatsfordinner/fstagger/internal/output"
+	"github.com/whatsfordinner/fstagger/internal/tags"
+)
+
+var (
+	tagLinksSource string
+	tagLinksCmd    = &cobra.Command{
+		Use:   "links [PATH [TAG...]]",
+		Short: "List tags attached to files by a person, a rule or an import",
+		Long: `Lists every tag attached to a file along with what attached it, ordered by
+file and then tag. Tags attached by hand have no source and tags attached
+by anything else are shown with its name and how sure it was, if it said.
+
+--source SOURCE only lists the tags attached by SOURCE, like rule:camera,
+import or manual for tags attached by hand. SOURCE may contain * wildcards, so
+implied:* lists every tag attached by an implication rule. Tags attached before
+fstagger recorded sources count as attached by hand, including ones attached
+by implication rules. PATH and TAG narrow it down to the files at or below
+PATH and to those tags:
+
+	fstagger tag links --source 'rule:*' ~/Pictures
+
+"fstagger tag remove --source" removes the same tags.`,
+		RunE: withDB(runTagLinks),
+	}
+)
+
+// sourcedLink is a tag attached to a file along with what attached it, shown
+// with the file's path and the tag's name.
+type sourcedLink struct {
+	Path       string   `json:"path"`
+	Tag        string   `json:"tag"`
+	Value      string   `json:"value,omitempty"`
+	Source     string   `json:"source,omitempty"`
+	Confidence *float64 `json:"confidence,omitempty"`
+}
+
+func (l sourcedLink) String() string {
+	ret := l.Path + "\t" + l.Tag
+	if l.Value != "" {
+		ret += "=" + l.Value
+	}
+
+	return ret + describeSource(l.Source, l.Confidence)
+}
+
+func init() {
+	tagLinksCmd.Flags().StringVar(
+		&tagLinksSource,
+		"source",
+		"*",
+		"only list tags attached by this source, which may contain * wildcards",
+	)
+
+	tagCmd.AddCommand(tagLinksCmd)
+}
+
+func runTagLinks(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	_, described, err := linksBySource(cmd, tagDB, tagLinksSource, args)
+	if err != nil {
+		return err
+	}
+
+	renderer, err := newRenderer(cmd)
+	if err != nil {
+		return err
+	}
+
+	if err := output.RenderAll(renderer, described); err != nil {
+		return err
+	}
+
+	return renderer.Close()
+}
+
+// linksBySource returns every link made by source along with a description of
+// each one. If args has a path only links to the files at or below it are
+// returned and if it has tags after the path only links to those tags are.
+func linksBySource(cmd *cobra.Command, tagDB *db.TagDB, source string, args []string) ([]links.Link, []sourcedLink, error) {
+	sourceLinks, err := tagDB.GetLinksBySource(cmd.Context(), source)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var targets map[int]bool
+	if len(args) > 0 {
+		found, _, err := filesAt(cmd, tagDB, args[0])
+		if err != nil {
+			return nil, nil, err
+		}
+
+		targets = map[int]bool{}
+		for _, file := range found {
+			targets[file.Id] = true
+		}
+	}
+
+	var wanted map[int]bool
+	if len(args) > 1 {
+		wanted = map[int]bool{}
+		for _, name := range args[1:] {
+			tag, err := tagDB.GetTagByName(cmd.Context(), name)
+			if err != nil {
+				return nil, nil, err
+			}
+			wanted[tag.Id] = true
+		}
+	}
+
+	knownFiles := map[int]files.File{}
+	knownTags := map[int]tags.Tag{}
+	retLinks := []links.Link{}
+	described := []sourcedLink{}
+	for _, link := range sourceLinks {
+		if targets != nil && !targets[link.File] {
+			continue
+		}
+
+		if wanted != nil && !wanted[link.Tag] {
+			continue
+		}
+
+		file, ok := knownFiles[link.File]
+		if !ok {
+			file, err = tagDB.GetFileById(cmd.Context(), link.File)
+			if err != nil {
+				return nil, nil, err
+			}
+			knownFiles[link.File] = file
+		}
+
+		tag, ok := knownTags[link.Tag]
+		if !ok {
+			tag, err = tagDB.GetTagById(cmd.Context(), link.Tag)
+			if err != nil {
+				return nil, nil, err
+			}
+			knownTags[link.Tag] = tag
+		}
+
+		retLinks = append(retLinks, link)
+		described = append(described, sourcedLink{
+			Path:       file.Path,
+			Tag:        tag.String(),
+			Value:      link.Value,
+			Source:     link.Source,
+			Confidence: link.Confidence,
+		})
+	}
+
+	return retLinks, described, nil
+}
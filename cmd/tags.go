@@ -0,0 +1,479 @@
+package cmd
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/whatsfordinner/fstagger/internal/db"
+	"github.com/whatsfordinner/fstagger/internal/output"
+	"github.com/whatsfordinner/fstagger/internal/smarttags"
+	"github.com/whatsfordinner/fstagger/internal/tags"
+)
+
+const (
+	tagsSortName   = "name"
+	tagsSortRecent = "recent"
+)
+
+var (
+	tagsCmd = &cobra.Command{
+		Use:   "tags",
+		Short: "Manage the tags fstagger knows about",
+	}
+
+	tagsListCmd = &cobra.Command{
+		Use:   "list [PATTERN]",
+		Short: "List tags and how many files each is attached to",
+		Long: `Lists every tag along with the number of files it's attached to. An
+optional PATTERN filters tags by their name, including the namespace, and
+supports % as a wildcard. --namespace lists only the tags in one namespace,
+and with a PATTERN only the tags in that namespace which match it.
+Smart tags are listed alongside regular tags with the number of files their
+query matches and the query itself.
+
+--sort=recent lists the tags most recently created, changed or attached to a
+file first, along with when that was. Smart tags and tags untouched since
+before fstagger recorded times come last.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: withDB(runTagsList),
+	}
+
+	tagsShowCmd = &cobra.Command{
+		Use:   "show NAME",
+		Short: "Show the details of a tag",
+		Args:  cobra.ExactArgs(1),
+		RunE:  withDB(runTagsShow),
+	}
+
+	tagsListNamespace string
+	tagsListSort      string
+
+	tagsCreateDescription string
+	tagsCreateCmd         = &cobra.Command{
+		Use:   "create NAME",
+		Short: "Create a new tag without attaching it to any files",
+		Args:  cobra.ExactArgs(1),
+		RunE:  withDB(runTagsCreate),
+	}
+
+	tagsDescribeCmd = &cobra.Command{
+		Use:   "describe NAME DESCRIPTION",
+		Short: "Set the description of a tag",
+		Args:  cobra.ExactArgs(2),
+		RunE:  withDB(runTagsDescribe),
+	}
+
+	tagsRenameCmd = &cobra.Command{
+		Use:   "rename OLD NEW",
+		Short: "Rename a tag",
+		Long: `Renames a tag. If a tag called NEW already exists then OLD is merged into
+it, the same as running "fstagger tags merge OLD NEW". If NEW is an alias of
+OLD then the two swap, so OLD becomes an alias of the renamed tag.`,
+		Args: cobra.ExactArgs(2),
+		RunE: withDB(runTagsRename),
+	}
+
+	tagsMergeCmd = &cobra.Command{
+		Use:   "merge SRC... DST",
+		Short: "Merge tags into another tag",
+		Long: `Gives every file tagged with any SRC tag the DST tag instead and then
+deletes the SRC tags. Values move across with the links and are converted to
+DST's type, so the merge fails if DST doesn't take values or a value isn't
+valid for it. The merge either happens completely or not at all.`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: withDB(runTagsMerge),
+	}
+
+	tagsDeleteForce bool
+	tagsDeleteCmd   = &cobra.Command{
+		Use:   "delete NAME...",
+		Short: "Delete tags",
+		Long: `Deletes tags. A tag that's still attached to files won't be deleted
+unless --force is provided, in which case it's removed from those files too.
+The other tags are still deleted when one of them can't be.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: withDB(runTagsDelete),
+	}
+)
+
+// tagUsage is a tag along with the number of files it's attached to and, when
+// sorting by it, when it was last active. Smart tags are shown as a tag with
+// their query.
+type tagUsage struct {
+	tags.Tag
+	Files  int       `json:"files"`
+	Query  string    `json:"query,omitempty"`
+	Active time.Time `json:"active,omitzero"`
+}
+
+func (t tagUsage) String() string {
+	if t.Query != "" {
+		return fmt.Sprintf("%s\t%d\tsmart: %s", t.Tag, t.Files, t.Query)
+	}
+
+	if !t.Active.IsZero() {
+		return fmt.Sprintf("%s\t%d\t%s", t.Tag, t.Files, t.Active.Local().Format(time.RFC3339))
+	}
+
+	return fmt.Sprintf("%s\t%d", t.Tag, t.Files)
+}
+
+// smartTagUsage builds a tagUsage for a smart tag.
+func smartTagUsage(smartTag smarttags.SmartTag, files int) tagUsage {
+	return tagUsage{
+		Tag: tags.Tag{
+			Namespace:   smartTag.Namespace,
+			Name:        smartTag.Name,
+			Description: smartTag.Description,
+		},
+		Files: files,
+		Query: smartTag.Query,
+	}
+}
+
+// tagDetail is the same as a tagUsage but is shown to people as a block of
+// fields rather than a single line.
+type tagDetail tagUsage
+
+func (t tagDetail) String() string {
+	detail := fmt.Sprintf(
+		"name: %s\ndescription: %s\nfiles: %d",
+		t.Tag,
+		t.Description,
+		t.Files,
+	)
+
+	if t.Query != "" {
+		detail += fmt.Sprintf("\nquery: %s", t.Query)
+	}
+
+	return detail
+}
+
+func init() {
+	tagsListCmd.Flags().StringVarP(
+		&tagsListNamespace,
+		"namespace",
+		"n",
+		"",
+		"only list tags in this namespace",
+	)
+
+	tagsListCmd.Flags().StringVar(
+		&tagsListSort,
+		"sort",
+		tagsSortName,
+		"order tags by: one of "+tagsSortName+", "+tagsSortRecent,
+	)
+
+	tagsCreateCmd.Flags().StringVarP(
+		&tagsCreateDescription,
+		"description",
+		"d",
+		"",
+		"description of the new tag",
+	)
+
+	tagsDeleteCmd.Flags().BoolVarP(
+		&tagsDeleteForce,
+		"force",
+		"f",
+		false,
+		"delete tags even if they're attached to files",
+	)
+
+	tagsCmd.AddCommand(tagsListCmd)
+	tagsCmd.AddCommand(tagsShowCmd)
+	tagsCmd.AddCommand(tagsCreateCmd)
+	tagsCmd.AddCommand(tagsDescribeCmd)
+	tagsCmd.AddCommand(tagsRenameCmd)
+	tagsCmd.AddCommand(tagsMergeCmd)
+	tagsCmd.AddCommand(tagsDeleteCmd)
+}
+
+func runTagsList(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	if tagsListSort != tagsSortName && tagsListSort != tagsSortRecent {
+		return fmt.Errorf("unsupported sort order: %s", tagsListSort)
+	}
+
+	renderer, err := newRenderer(cmd)
+	if err != nil {
+		return err
+	}
+
+	var allTags []tags.Tag
+	var allSmartTags []smarttags.SmartTag
+	if len(args) > 0 {
+		allTags, err = tagDB.GetTagsByName(cmd.Context(), args[0])
+		if err == nil {
+			allSmartTags, err = tagDB.GetSmartTagsByName(cmd.Context(), args[0])
+		}
+	} else if cmd.Flags().Changed("namespace") {
+		allTags, err = tagDB.GetTagsByNamespace(cmd.Context(), tagsListNamespace)
+		if err == nil {
+			allSmartTags, err = tagDB.GetSmartTags(cmd.Context())
+		}
+	} else {
+		allTags, err = tagDB.GetTags(cmd.Context())
+		if err == nil {
+			allSmartTags, err = tagDB.GetSmartTags(cmd.Context())
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// a PATTERN can match tags in any namespace so both filters apply
+	if cmd.Flags().Changed("namespace") {
+		inNamespace := []tags.Tag{}
+		for _, tag := range allTags {
+			if tag.Namespace == tagsListNamespace {
+				inNamespace = append(inNamespace, tag)
+			}
+		}
+		allTags = inNamespace
+
+		smartInNamespace := []smarttags.SmartTag{}
+		for _, smartTag := range allSmartTags {
+			if smartTag.Namespace == tagsListNamespace {
+				smartInNamespace = append(smartInNamespace, smartTag)
+			}
+		}
+		allSmartTags = smartInNamespace
+	}
+
+	counts, err := tagDB.GetTagFileCounts(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	smartCounts, err := tagDB.GetSmartTagFileCounts(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	usages := []tagUsage{}
+	for _, tag := range allTags {
+		usages = append(usages, tagUsage{Tag: tag, Files: counts[tag.Id]})
+	}
+
+	for _, smartTag := range allSmartTags {
+		usages = append(usages, smartTagUsage(smartTag, smartCounts[smartTag.Id]))
+	}
+
+	sort.Slice(usages, func(i, j int) bool {
+		return usages[i].Tag.String() < usages[j].Tag.String()
+	})
+
+	if tagsListSort == tagsSortRecent {
+		activity, err := tagDB.GetTagActivity(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		for i, usage := range usages {
+			if usage.Query == "" {
+				usages[i].Active = activity[usage.Id]
+			}
+		}
+
+		sort.SliceStable(usages, func(i, j int) bool {
+			return usages[i].Active.After(usages[j].Active)
+		})
+	}
+
+	if err := output.RenderAll(renderer, usages); err != nil {
+		return err
+	}
+
+	return renderer.Close()
+}
+
+func runTagsShow(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	renderer, err := newRenderer(cmd)
+	if err != nil {
+		return err
+	}
+
+	tag, err := tagDB.GetTagByName(cmd.Context(), args[0])
+	if errors.Is(err, sql.ErrNoRows) {
+		smartTag, smartErr := tagDB.GetSmartTagByName(cmd.Context(), args[0])
+		if smartErr != nil {
+			return err
+		}
+
+		smartCounts, err := tagDB.GetSmartTagFileCounts(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		if err := renderer.Render(tagDetail(smartTagUsage(smartTag, smartCounts[smartTag.Id]))); err != nil {
+			return err
+		}
+
+		return renderer.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	counts, err := tagDB.GetTagFileCounts(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	if err := renderer.Render(tagDetail{Tag: tag, Files: counts[tag.Id]}); err != nil {
+		return err
+	}
+
+	return renderer.Close()
+}
+
+func runTagsCreate(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	renderer, err := newRenderer(cmd)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tagDB.GetTagByName(cmd.Context(), args[0]); err == nil {
+		return fmt.Errorf("tag already exists: %s", args[0])
+	}
+
+	created, err := tagDB.AddTags(cmd.Context(), []tags.Tag{
+		{
+			Namespace:   tags.Parse(args[0]).Namespace,
+			Name:        tags.Parse(args[0]).Name,
+			Description: tagsCreateDescription,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := output.RenderAll(renderer, created); err != nil {
+		return err
+	}
+
+	return renderer.Close()
+}
+
+func runTagsDescribe(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	renderer, err := newRenderer(cmd)
+	if err != nil {
+		return err
+	}
+
+	tag, err := tagDB.GetTagByName(cmd.Context(), args[0])
+	if err != nil {
+		return err
+	}
+	tag.Description = args[1]
+
+	updated, err := tagDB.UpdateTags(cmd.Context(), []tags.Tag{tag})
+	if err != nil {
+		return err
+	}
+
+	if err := output.RenderAll(renderer, updated); err != nil {
+		return err
+	}
+
+	return renderer.Close()
+}
+
+func runTagsRename(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	renderer, err := newRenderer(cmd)
+	if err != nil {
+		return err
+	}
+
+	tag, err := tagDB.GetTagByName(cmd.Context(), args[0])
+	if err != nil {
+		return err
+	}
+
+	// an alias of the tag itself names the same tag, so it's renamed to the
+	// alias rather than merged into itself
+	if existing, err := tagDB.GetTagByName(cmd.Context(), args[1]); err == nil && existing.Id != tag.Id {
+		merged, err := tagDB.MergeTags(cmd.Context(), []tags.Tag{tag}, existing)
+		if err != nil {
+			return err
+		}
+
+		if err := renderer.Render(merged); err != nil {
+			return err
+		}
+
+		return renderer.Close()
+	}
+
+	renamed := tags.Parse(args[1])
+	tag.Namespace = renamed.Namespace
+	tag.Name = renamed.Name
+
+	updated, err := tagDB.UpdateTags(cmd.Context(), []tags.Tag{tag})
+	if err != nil {
+		return err
+	}
+
+	if err := output.RenderAll(renderer, updated); err != nil {
+		return err
+	}
+
+	return renderer.Close()
+}
+
+func runTagsMerge(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	renderer, err := newRenderer(cmd)
+	if err != nil {
+		return err
+	}
+
+	sources := []tags.Tag{}
+	for _, name := range args[:len(args)-1] {
+		source, err := tagDB.GetTagByName(cmd.Context(), name)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, source)
+	}
+
+	target, err := tagDB.GetTagByName(cmd.Context(), args[len(args)-1])
+	if err != nil {
+		return err
+	}
+
+	merged, err := tagDB.MergeTags(cmd.Context(), sources, target)
+	if err != nil {
+		return err
+	}
+
+	if err := renderer.Render(merged); err != nil {
+		return err
+	}
+
+	return renderer.Close()
+}
+
+func runTagsDelete(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	deleteTags := []tags.Tag{}
+	for _, name := range args {
+		tag, err := tagDB.GetTagByName(cmd.Context(), name)
+		if err != nil {
+			return err
+		}
+
+		deleteTags = append(deleteTags, tag)
+	}
+
+	err := tagDB.DeleteTags(cmd.Context(), deleteTags, tagsDeleteForce)
+	if errors.Is(err, db.ErrTagInUse) {
+		return fmt.Errorf("%w\nuse --force to delete tags which are still attached to files", err)
+	}
+
+	return err
+}
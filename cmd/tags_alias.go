@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/whatsfordinner/fstagger/internal/aliases"
+	"github.com/whatsfordinner/fstagger/internal/db"
+	"github.com/whatsfordinner/fstagger/internal/output"
+	"github.com/whatsfordinner/fstagger/internal/tags"
+)
+
+var (
+	tagsAliasCmd = &cobra.Command{
+		Use:   "alias",
+		Short: "Manage alternative names for tags",
+		Long: `An alias is another name for a tag, like img for image. Aliases can be used
+anywhere a tag can: tagging a file with an alias tags it with the alias's tag
+and searching for an alias finds the files tagged with its tag.`,
+	}
+
+	tagsAliasAddCmd = &cobra.Command{
+		Use:   "add ALIAS... TAG",
+		Short: "Add aliases for a tag",
+		Long: `Makes every ALIAS another name for TAG. If a tag called ALIAS already exists
+then it's merged into TAG first, the same as "fstagger tags merge ALIAS TAG",
+so files already tagged with ALIAS keep turning up when searching for it.`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: withDB(runTagsAliasAdd),
+	}
+
+	tagsAliasRemoveCmd = &cobra.Command{
+		Use:     "rm ALIAS...",
+		Aliases: []string{"remove"},
+		Short:   "Remove aliases",
+		Long: `Removes aliases. The tags they point at and the files tagged with those
+tags are untouched.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: withDB(runTagsAliasRemove),
+	}
+
+	tagsAliasListCmd = &cobra.Command{
+		Use:   "list [TAG]",
+		Short: "List aliases and the tags they point at",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  withDB(runTagsAliasList),
+	}
+)
+
+// aliasEntry is an alias along with the name of the tag it points at.
+type aliasEntry struct {
+	Alias string `json:"alias"`
+	Tag   string `json:"tag"`
+}
+
+func (a aliasEntry) String() string {
+	return fmt.Sprintf("%s\t%s", a.Alias, a.Tag)
+}
+
+func init() {
+	tagsAliasCmd.AddCommand(tagsAliasAddCmd)
+	tagsAliasCmd.AddCommand(tagsAliasRemoveCmd)
+	tagsAliasCmd.AddCommand(tagsAliasListCmd)
+
+	tagsCmd.AddCommand(tagsAliasCmd)
+}
+
+// parseAlias builds an Alias from a name as a user would type it, splitting
+// the namespace off the same way as tags.Parse.
+func parseAlias(name string, tagId int) aliases.Alias {
+	parsed := tags.Parse(name)
+	return aliases.Alias{
+		Namespace: parsed.Namespace,
+		Name:      parsed.Name,
+		Tag:       tagId,
+	}
+}
+
+func runTagsAliasAdd(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	renderer, err := newRenderer(cmd)
+	if err != nil {
+		return err
+	}
+
+	target, err := tagDB.GetTagByName(cmd.Context(), args[len(args)-1])
+	if err != nil {
+		return err
+	}
+
+	newAliases := []aliases.Alias{}
+	for _, name := range args[:len(args)-1] {
+		newAliases = append(newAliases, parseAlias(name, target.Id))
+	}
+
+	added, addErr := tagDB.AddAliases(cmd.Context(), newAliases)
+	for _, alias := range added {
+		if err := renderer.Render(aliasEntry{alias.String(), target.String()}); err != nil {
+			return err
+		}
+	}
+
+	if err := renderer.Close(); err != nil {
+		return err
+	}
+
+	return addErr
+}
+
+func runTagsAliasRemove(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	deleteAliases := []aliases.Alias{}
+	for _, name := range args {
+		deleteAliases = append(deleteAliases, parseAlias(name, 0))
+	}
+
+	return tagDB.DeleteAliases(cmd.Context(), deleteAliases)
+}
+
+func runTagsAliasList(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	renderer, err := newRenderer(cmd)
+	if err != nil {
+		return err
+	}
+
+	allAliases, err := tagDB.GetAliases(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	allTags, err := tagDB.GetTags(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	tagNames := map[int]string{}
+	for _, tag := range allTags {
+		tagNames[tag.Id] = tag.String()
+	}
+
+	filter := 0
+	if len(args) > 0 {
+		tag, err := tagDB.GetTagByName(cmd.Context(), args[0])
+		if err != nil {
+			return err
+		}
+		filter = tag.Id
+	}
+
+	entries := []aliasEntry{}
+	for _, alias := range allAliases {
+		if filter != 0 && alias.Tag != filter {
+			continue
+		}
+		entries = append(entries, aliasEntry{alias.String(), tagNames[alias.Tag]})
+	}
+
+	if err := output.RenderAll(renderer, entries); err != nil {
+		return err
+	}
+
+	return renderer.Close()
+}
@@ -0,0 +1,310 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/whatsfordinner/fstagger/internal/db"
+	"github.com/whatsfordinner/fstagger/internal/groups"
+)
+
+var (
+	tagsGroupCmd = &cobra.Command{
+		Use:   "group",
+		Short: "Manage groups of tags, like states a file can only be in one of",
+		Long: `A group is a named set of tags. When a group is exclusive a file may only have
+one of its tags, like status:todo, status:doing and status:done. Tagging a file
+with a second tag from an exclusive group either replaces the tag it already
+has or is rejected, depending on the group's policy.`,
+	}
+
+	tagsGroupCreateDescription string
+	tagsGroupCreateExclusive   bool
+	tagsGroupCreatePolicy      string
+	tagsGroupCreateCmd         = &cobra.Command{
+		Use:   "create NAME [TAG...]",
+		Short: "Create a group, optionally with some tags in it",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  withDB(runTagsGroupCreate),
+	}
+
+	tagsGroupSetCmd = &cobra.Command{
+		Use:   "set NAME",
+		Short: "Change whether a group is exclusive or its policy",
+		Args:  cobra.ExactArgs(1),
+		RunE:  withDB(runTagsGroupSet),
+	}
+
+	tagsGroupDeleteCmd = &cobra.Command{
+		Use:   "delete NAME...",
+		Short: "Delete groups, leaving their tags alone",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  withDB(runTagsGroupDelete),
+	}
+
+	tagsGroupAddCmd = &cobra.Command{
+		Use:   "add GROUP TAG...",
+		Short: "Add tags to a group",
+		Long: `Adds tags to a group. Files which already have more than one tag from an
+exclusive group keep them until they're next tagged from the group.`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: withDB(runTagsGroupAdd),
+	}
+
+	tagsGroupRemoveCmd = &cobra.Command{
+		Use:     "rm GROUP TAG...",
+		Aliases: []string{"remove"},
+		Short:   "Remove tags from a group",
+		Args:    cobra.MinimumNArgs(2),
+		RunE:    withDB(runTagsGroupRemove),
+	}
+
+	tagsGroupListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List groups and their tags",
+		Args:  cobra.NoArgs,
+		RunE:  withDB(runTagsGroupList),
+	}
+)
+
+// groupEntry is a group along with the names of its tags.
+type groupEntry struct {
+	groups.Group
+	Tags []string `json:"tags"`
+}
+
+func (g groupEntry) String() string {
+	kind := "inclusive"
+	if g.Exclusive {
+		kind = fmt.Sprintf("exclusive (%s)", g.Policy)
+	}
+
+	return fmt.Sprintf("%s\t%s\t%s", g.Name, kind, strings.Join(g.Tags, ","))
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{tagsGroupCreateCmd, tagsGroupSetCmd} {
+		cmd.Flags().BoolVarP(
+			&tagsGroupCreateExclusive,
+			"exclusive",
+			"x",
+			false,
+			"only allow a file to have one tag from the group",
+		)
+		cmd.Flags().StringVar(
+			&tagsGroupCreatePolicy,
+			"policy",
+			string(groups.PolicyReject),
+			fmt.Sprintf("what happens when a file is given a second tag from an exclusive group (%s)", policyNames()),
+		)
+		cmd.Flags().StringVarP(
+			&tagsGroupCreateDescription,
+			"description",
+			"d",
+			"",
+			"description of the group",
+		)
+	}
+
+	tagsGroupCmd.AddCommand(tagsGroupCreateCmd)
+	tagsGroupCmd.AddCommand(tagsGroupSetCmd)
+	tagsGroupCmd.AddCommand(tagsGroupDeleteCmd)
+	tagsGroupCmd.AddCommand(tagsGroupAddCmd)
+	tagsGroupCmd.AddCommand(tagsGroupRemoveCmd)
+	tagsGroupCmd.AddCommand(tagsGroupListCmd)
+
+	tagsCmd.AddCommand(tagsGroupCmd)
+}
+
+func policyNames() string {
+	names := []string{}
+	for _, policy := range groups.Policies {
+		names = append(names, string(policy))
+	}
+
+	return strings.Join(names, "|")
+}
+
+// membersFromArgs looks up GROUP TAG... and builds a membership for each tag.
+func membersFromArgs(cmd *cobra.Command, args []string, tagDB *db.TagDB) ([]groups.Member, error) {
+	group, err := tagDB.GetGroupByName(cmd.Context(), args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []groups.Member{}
+	for _, name := range args[1:] {
+		tag, err := tagDB.GetTagByName(cmd.Context(), name)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, groups.Member{Group: group.Id, Tag: tag.Id})
+	}
+
+	return ret, nil
+}
+
+func runTagsGroupCreate(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	policy, err := groups.ParsePolicy(tagsGroupCreatePolicy)
+	if err != nil {
+		return err
+	}
+
+	// every tag is looked up before the group is added so a missing tag
+	// doesn't leave an empty group behind
+	tagIds := []int{}
+	for _, name := range args[1:] {
+		tag, err := tagDB.GetTagByName(cmd.Context(), name)
+		if err != nil {
+			return err
+		}
+
+		if !slices.Contains(tagIds, tag.Id) {
+			tagIds = append(tagIds, tag.Id)
+		}
+	}
+
+	addedGroups, err := tagDB.AddGroups(cmd.Context(), []groups.Group{
+		{
+			Name:        args[0],
+			Description: tagsGroupCreateDescription,
+			Exclusive:   tagsGroupCreateExclusive,
+			Policy:      policy,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(tagIds) > 0 {
+		newMembers := []groups.Member{}
+		for _, tagId := range tagIds {
+			newMembers = append(newMembers, groups.Member{Group: addedGroups[0].Id, Tag: tagId})
+		}
+
+		if _, err := tagDB.AddGroupMembers(cmd.Context(), newMembers); err != nil {
+			return err
+		}
+	}
+
+	return renderGroups(cmd, tagDB, args[0])
+}
+
+func runTagsGroupSet(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	group, err := tagDB.GetGroupByName(cmd.Context(), args[0])
+	if err != nil {
+		return err
+	}
+
+	if cmd.Flags().Changed("exclusive") {
+		group.Exclusive = tagsGroupCreateExclusive
+	}
+
+	if cmd.Flags().Changed("policy") {
+		group.Policy, err = groups.ParsePolicy(tagsGroupCreatePolicy)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cmd.Flags().Changed("description") {
+		group.Description = tagsGroupCreateDescription
+	}
+
+	if _, err := tagDB.UpdateGroups(cmd.Context(), []groups.Group{group}); err != nil {
+		return err
+	}
+
+	return renderGroups(cmd, tagDB, group.Name)
+}
+
+func runTagsGroupDelete(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	deleteGroups := []groups.Group{}
+	for _, name := range args {
+		group, err := tagDB.GetGroupByName(cmd.Context(), name)
+		if err != nil {
+			return err
+		}
+		deleteGroups = append(deleteGroups, group)
+	}
+
+	return tagDB.DeleteGroups(cmd.Context(), deleteGroups)
+}
+
+func runTagsGroupAdd(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	newMembers, err := membersFromArgs(cmd, args, tagDB)
+	if err != nil {
+		return err
+	}
+
+	_, addErr := tagDB.AddGroupMembers(cmd.Context(), newMembers)
+
+	if err := renderGroups(cmd, tagDB, args[0]); err != nil {
+		return err
+	}
+
+	return addErr
+}
+
+func runTagsGroupRemove(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	deleteMembers, err := membersFromArgs(cmd, args, tagDB)
+	if err != nil {
+		return err
+	}
+
+	return tagDB.DeleteGroupMembers(cmd.Context(), deleteMembers)
+}
+
+func runTagsGroupList(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	return renderGroups(cmd, tagDB, "")
+}
+
+// renderGroups renders every group with its tags, or only the named group if
+// name isn't empty.
+func renderGroups(cmd *cobra.Command, tagDB *db.TagDB, name string) error {
+	renderer, err := newRenderer(cmd)
+	if err != nil {
+		return err
+	}
+
+	allGroups, err := tagDB.GetGroups(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	members, err := tagDB.GetGroupMembers(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	allTags, err := tagDB.GetTags(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	names := map[int]string{}
+	for _, tag := range allTags {
+		names[tag.Id] = tag.String()
+	}
+
+	for _, group := range allGroups {
+		if name != "" && group.Name != name {
+			continue
+		}
+
+		entry := groupEntry{Group: group, Tags: []string{}}
+		for _, member := range members {
+			if member.Group == group.Id {
+				entry.Tags = append(entry.Tags, names[member.Tag])
+			}
+		}
+
+		if err := renderer.Render(entry); err != nil {
+			return err
+		}
+	}
+
+	return renderer.Close()
+}
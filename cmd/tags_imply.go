@@ -0,0 +1,245 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/whatsfordinner/fstagger/internal/db"
+	"github.com/whatsfordinner/fstagger/internal/implications"
+	"github.com/whatsfordinner/fstagger/internal/output"
+)
+
+var (
+	tagsImplyCmd = &cobra.Command{
+		Use:   "imply",
+		Short: "Manage rules that tagging a file with one tag also tags it with others",
+		Long: `An implication rule says any file tagged with TAG is also tagged with IMPLIED,
+like invoice implying finance and tax. Rules chain and apply to every tag below
+TAG in the hierarchy. A rule that would make a tag imply itself is rejected.
+
+How rules are applied is a setting of the database, changed with
+"fstagger tags imply mode". When it's materialized, the default, implied tags
+are attached to files when they're tagged and when a rule is added. When it's
+computed nothing is attached and searches find files tagged with anything that
+implies the tags being searched for instead.`,
+	}
+
+	tagsImplyModeCmd = &cobra.Command{
+		Use:   "mode [MODE]",
+		Short: "Show or change how implication rules are applied",
+		Long: `Shows how implication rules are applied to the database or changes it to MODE,
+which is one of materialized or computed. Changing to materialized attaches
+every tag implied by the tags files already have, and fails without changing
+anything if an exclusive group rejects any of them. Changing to computed
+leaves implied tags which are already attached.`,
+		Args:      cobra.MaximumNArgs(1),
+		ValidArgs: implicationModeNames(),
+		RunE:      withDB(runTagsImplyMode),
+	}
+
+	tagsImplyAddCmd = &cobra.Command{
+		Use:   "add TAG IMPLIED...",
+		Short: "Add rules that TAG implies each IMPLIED tag",
+		Args:  cobra.MinimumNArgs(2),
+		RunE:  withDB(runTagsImplyAdd),
+	}
+
+	tagsImplyRemoveCmd = &cobra.Command{
+		Use:     "rm TAG IMPLIED...",
+		Aliases: []string{"remove"},
+		Short:   "Remove rules that TAG implies each IMPLIED tag",
+		Long: `Removes implication rules. Tags which were already attached to files because
+of a rule stay attached.`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: withDB(runTagsImplyRemove),
+	}
+
+	tagsImplyListCmd = &cobra.Command{
+		Use:   "list [TAG]",
+		Short: "List implication rules, optionally only those involving TAG",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  withDB(runTagsImplyList),
+	}
+)
+
+// implicationRule is an implication with the names of its tags.
+type implicationRule struct {
+	Tag     string `json:"tag"`
+	Implied string `json:"implied"`
+}
+
+func (r implicationRule) String() string {
+	return fmt.Sprintf("%s\t%s", r.Tag, r.Implied)
+}
+
+// implicationModeEntry is how implication rules are applied to the database.
+type implicationModeEntry struct {
+	Mode db.ImplicationMode `json:"mode"`
+}
+
+func (m implicationModeEntry) String() string {
+	return string(m.Mode)
+}
+
+func init() {
+	tagsImplyCmd.AddCommand(tagsImplyAddCmd)
+	tagsImplyCmd.AddCommand(tagsImplyRemoveCmd)
+	tagsImplyCmd.AddCommand(tagsImplyListCmd)
+	tagsImplyCmd.AddCommand(tagsImplyModeCmd)
+
+	tagsCmd.AddCommand(tagsImplyCmd)
+}
+
+func implicationModeNames() []string {
+	names := []string{}
+	for _, mode := range db.ImplicationModes {
+		names = append(names, string(mode))
+	}
+
+	return names
+}
+
+// implicationsFromArgs looks up TAG IMPLIED... and builds a rule for each
+// implied tag.
+func implicationsFromArgs(cmd *cobra.Command, args []string, tagDB *db.TagDB) ([]implications.Implication, error) {
+	tag, err := tagDB.GetTagByName(cmd.Context(), args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []implications.Implication{}
+	for _, name := range args[1:] {
+		implied, err := tagDB.GetTagByName(cmd.Context(), name)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, implications.Implication{Tag: tag.Id, Implied: implied.Id})
+	}
+
+	return ret, nil
+}
+
+func runTagsImplyAdd(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	renderer, err := newRenderer(cmd)
+	if err != nil {
+		return err
+	}
+
+	newImplications, err := implicationsFromArgs(cmd, args, tagDB)
+	if err != nil {
+		return err
+	}
+
+	added, addErr := tagDB.AddImplications(cmd.Context(), newImplications)
+
+	rules, err := implicationRules(cmd, tagDB, added)
+	if err != nil {
+		return err
+	}
+
+	if err := output.RenderAll(renderer, rules); err != nil {
+		return err
+	}
+
+	if err := renderer.Close(); err != nil {
+		return err
+	}
+
+	return addErr
+}
+
+func runTagsImplyRemove(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	deleteImplications, err := implicationsFromArgs(cmd, args, tagDB)
+	if err != nil {
+		return err
+	}
+
+	return tagDB.DeleteImplications(cmd.Context(), deleteImplications)
+}
+
+func runTagsImplyList(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	renderer, err := newRenderer(cmd)
+	if err != nil {
+		return err
+	}
+
+	allImplications, err := tagDB.GetImplications(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	if len(args) > 0 {
+		tag, err := tagDB.GetTagByName(cmd.Context(), args[0])
+		if err != nil {
+			return err
+		}
+
+		filtered := []implications.Implication{}
+		for _, implication := range allImplications {
+			if implication.Tag == tag.Id || implication.Implied == tag.Id {
+				filtered = append(filtered, implication)
+			}
+		}
+		allImplications = filtered
+	}
+
+	rules, err := implicationRules(cmd, tagDB, allImplications)
+	if err != nil {
+		return err
+	}
+
+	if err := output.RenderAll(renderer, rules); err != nil {
+		return err
+	}
+
+	return renderer.Close()
+}
+
+func runTagsImplyMode(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	renderer, err := newRenderer(cmd)
+	if err != nil {
+		return err
+	}
+
+	if len(args) > 0 {
+		mode, err := db.ParseImplicationMode(args[0])
+		if err != nil {
+			return err
+		}
+
+		if err := tagDB.SetImplicationMode(cmd.Context(), mode); err != nil {
+			return err
+		}
+	}
+
+	mode, err := tagDB.GetImplicationMode(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	if err := renderer.Render(implicationModeEntry{mode}); err != nil {
+		return err
+	}
+
+	return renderer.Close()
+}
+
+// implicationRules swaps the tag IDs in each implication for the tags' names.
+func implicationRules(cmd *cobra.Command, tagDB *db.TagDB, input []implications.Implication) ([]implicationRule, error) {
+	allTags, err := tagDB.GetTags(cmd.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[int]string{}
+	for _, tag := range allTags {
+		names[tag.Id] = tag.String()
+	}
+
+	ret := []implicationRule{}
+	for _, implication := range input {
+		ret = append(ret, implicationRule{names[implication.Tag], names[implication.Implied]})
+	}
+
+	return ret, nil
+}
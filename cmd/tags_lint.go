@@ -0,0 +1,276 @@
+package cmd
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/whatsfordinner/fstagger/internal/cluster"
+	"github.com/whatsfordinner/fstagger/internal/db"
+	"github.com/whatsfordinner/fstagger/internal/tags"
+)
+
+var (
+	tagsLintMaxDistance int
+	tagsLintInteractive bool
+	tagsLintApply       bool
+	tagsLintCmd         = &cobra.Command{
+		Use:   "lint",
+		Short: "Find tags with similar names and propose merging them",
+		Long: `Groups tags whose names are probably the same thing, like Photo, photos,
+photograph and fotos. Only tags in the same namespace with the same parent are
+grouped, so a tag is never grouped with its own children. Names are compared
+after folding case, removing accents and stemming each word, and are grouped
+if they match, one is a prefix of the other or they're within --max-distance
+edits of each other.
+
+Each group becomes a merge plan into its most used tag. By default the plans
+are only printed and text output is a list of "fstagger tags merge" commands
+which can be edited and run as a script. --interactive asks about each plan
+and lets you pick a different target and --apply merges every plan without
+asking. A plan whose tags were renamed or merged away by an earlier plan is
+skipped, so running it again may find more.`,
+		Args: cobra.NoArgs,
+		RunE: withDB(runTagsLint),
+	}
+)
+
+// mergePlan is a proposal to merge a cluster of similar tags into one.
+type mergePlan struct {
+	Target  string   `json:"target"`
+	Sources []string `json:"sources"`
+	Files   int      `json:"files"`
+}
+
+func (p mergePlan) String() string {
+	args := []string{"fstagger", "tags", "merge"}
+	for _, name := range append(p.Sources, p.Target) {
+		args = append(args, shellQuote(name))
+	}
+
+	return strings.Join(args, " ")
+}
+
+func init() {
+	tagsLintCmd.Flags().IntVar(
+		&tagsLintMaxDistance,
+		"max-distance",
+		cluster.DefaultMaxDistance,
+		"maximum edit distance between two similar tag names",
+	)
+	tagsLintCmd.Flags().BoolVarP(
+		&tagsLintInteractive,
+		"interactive",
+		"i",
+		false,
+		"ask before applying each merge plan",
+	)
+	tagsLintCmd.Flags().BoolVar(
+		&tagsLintApply,
+		"apply",
+		false,
+		"apply every merge plan without asking",
+	)
+	tagsLintCmd.MarkFlagsMutuallyExclusive("interactive", "apply")
+
+	tagsCmd.AddCommand(tagsLintCmd)
+}
+
+func runTagsLint(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	renderer, err := newRenderer(cmd)
+	if err != nil {
+		return err
+	}
+
+	allTags, err := tagDB.GetTags(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	counts, err := tagDB.GetTagFileCounts(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	// tags are only ever clustered with other tags in the same namespace
+	usage := map[string]int{}
+	namespaceUsage := map[string]map[string]int{}
+	for _, tag := range allTags {
+		usage[tag.String()] = counts[tag.Id]
+		if namespaceUsage[tag.Namespace] == nil {
+			namespaceUsage[tag.Namespace] = map[string]int{}
+		}
+		namespaceUsage[tag.Namespace][tag.String()] = counts[tag.Id]
+	}
+
+	clusters := []cluster.Cluster{}
+	for _, namespaceTags := range namespaceUsage {
+		clusters = append(clusters, cluster.Find(namespaceTags, tagsLintMaxDistance)...)
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		if clusters[i].Files != clusters[j].Files {
+			return clusters[i].Files > clusters[j].Files
+		}
+		return clusters[i].Names[0] < clusters[j].Names[0]
+	})
+
+	prompt := bufio.NewReader(cmd.InOrStdin())
+
+	for i, c := range clusters {
+		target := 0
+		if tagsLintInteractive {
+			var ok bool
+			target, ok, err = askMergeTarget(cmd.ErrOrStderr(), prompt, c, usage, i, len(clusters))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		plan := mergePlan{
+			Target: c.Names[target],
+			Files:  c.Files,
+		}
+		for j, name := range c.Names {
+			if j != target {
+				plan.Sources = append(plan.Sources, name)
+			}
+		}
+
+		// merging a tag into one of its own descendants would move the
+		// descendant below itself
+		if isDescendantOfAny(plan.Target, plan.Sources) {
+			continue
+		}
+
+		if tagsLintInteractive || tagsLintApply {
+			sources, target, err := resolveMergePlan(cmd, tagDB, &plan)
+			if err != nil {
+				return err
+			}
+			if len(sources) == 0 {
+				continue
+			}
+
+			if _, err := tagDB.MergeTags(cmd.Context(), sources, target); err != nil {
+				return err
+			}
+		}
+
+		if err := renderer.Render(plan); err != nil {
+			return err
+		}
+	}
+
+	return renderer.Close()
+}
+
+// resolveMergePlan looks the tags in a plan up by name again, since an earlier
+// merge may have renamed or folded them into another tag. Sources which are
+// gone or which are now the target are left out of the plan, and no sources
+// are returned if there's nothing left to merge.
+func resolveMergePlan(cmd *cobra.Command, tagDB *db.TagDB, plan *mergePlan) ([]tags.Tag, tags.Tag, error) {
+	target, err := tagDB.GetTagByName(cmd.Context(), plan.Target)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, tags.Tag{}, nil
+	} else if err != nil {
+		return nil, tags.Tag{}, err
+	}
+
+	sources := []tags.Tag{}
+	names := []string{}
+	for _, name := range plan.Sources {
+		source, err := tagDB.GetTagByName(cmd.Context(), name)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		} else if err != nil {
+			return nil, tags.Tag{}, err
+		}
+
+		if source.Id == target.Id || slices.ContainsFunc(sources, func(t tags.Tag) bool {
+			return t.Id == source.Id
+		}) {
+			continue
+		}
+
+		sources = append(sources, source)
+		names = append(names, name)
+	}
+	plan.Sources = names
+
+	return sources, target, nil
+}
+
+// isDescendantOfAny reports whether the tag named name is below any of the
+// tags named in others in the hierarchy.
+func isDescendantOfAny(name string, others []string) bool {
+	tag := tags.Parse(name)
+	for _, ancestor := range tag.Ancestors() {
+		qualified := tags.Tag{Namespace: tag.Namespace, Name: ancestor}.String()
+		if slices.Contains(others, qualified) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// askMergeTarget describes a cluster and asks which of its names to merge the
+// others into. It returns the index of the chosen name and false if the
+// cluster should be skipped.
+func askMergeTarget(
+	w io.Writer,
+	r *bufio.Reader,
+	c cluster.Cluster,
+	usage map[string]int,
+	index int,
+	total int,
+) (int, bool, error) {
+	fmt.Fprintf(w, "Cluster %d of %d (%d files):\n", index+1, total, c.Files)
+	for i, name := range c.Names {
+		fmt.Fprintf(w, "  %d) %s (%d)\n", i+1, name, usage[name])
+	}
+
+	for {
+		fmt.Fprintf(w, "Merge into %s? [Y]es, [n]o, or the number of another target: ", c.Names[0])
+		answer, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return 0, false, err
+		}
+		answer = strings.ToLower(strings.TrimSpace(answer))
+
+		switch answer {
+		case "", "y", "yes":
+			if err == io.EOF && answer == "" {
+				return 0, false, nil
+			}
+			return 0, true, nil
+		case "n", "no":
+			return 0, false, nil
+		}
+
+		if choice, convErr := strconv.Atoi(answer); convErr == nil && choice >= 1 && choice <= len(c.Names) {
+			return choice - 1, true, nil
+		}
+
+		if err == io.EOF {
+			return 0, false, nil
+		}
+		fmt.Fprintf(w, "Didn't understand %q\n", answer)
+	}
+}
+
+// shellQuote quotes a string so it can be safely used as a single argument in
+// a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/whatsfordinner/fstagger/internal/db"
+	"github.com/whatsfordinner/fstagger/internal/output"
+	"github.com/whatsfordinner/fstagger/internal/smarttags"
+	"github.com/whatsfordinner/fstagger/internal/tags"
+)
+
+var (
+	tagsSmartCmd = &cobra.Command{
+		Use:   "smart",
+		Short: "Manage smart tags whose files are found by a saved query",
+		Long: `A smart tag is a virtual tag defined by a query, like todo-photos defined as
+"photos and not reviewed". It can't be attached to files but it's listed
+alongside regular tags and can be searched for like one, including from the
+query of another smart tag. A smart tag can't refer back to itself.`,
+	}
+
+	tagsSmartDefineDescription string
+	tagsSmartDefineCmd         = &cobra.Command{
+		Use:   "define NAME QUERY...",
+		Short: "Create a smart tag or replace the query of an existing one",
+		Long: `Saves QUERY as the definition of the smart tag NAME. The QUERY arguments are
+joined with spaces and use the same syntax as "fstagger search". If NAME is
+already a smart tag its query is replaced.`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: withDB(runTagsSmartDefine),
+	}
+
+	tagsSmartDeleteCmd = &cobra.Command{
+		Use:   "delete NAME...",
+		Short: "Delete smart tags",
+		Long: `Deletes smart tags. A smart tag used in the query of another smart tag
+can't be deleted until that smart tag is changed or deleted first.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: withDB(runTagsSmartDelete),
+	}
+
+	tagsSmartListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List smart tags and their queries",
+		Args:  cobra.NoArgs,
+		RunE:  withDB(runTagsSmartList),
+	}
+)
+
+func init() {
+	tagsSmartDefineCmd.Flags().StringVarP(
+		&tagsSmartDefineDescription,
+		"description",
+		"d",
+		"",
+		"description of the smart tag",
+	)
+
+	tagsSmartCmd.AddCommand(tagsSmartDefineCmd)
+	tagsSmartCmd.AddCommand(tagsSmartDeleteCmd)
+	tagsSmartCmd.AddCommand(tagsSmartListCmd)
+
+	tagsCmd.AddCommand(tagsSmartCmd)
+}
+
+func runTagsSmartDefine(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	renderer, err := newRenderer(cmd)
+	if err != nil {
+		return err
+	}
+
+	parsed := tags.Parse(args[0])
+	smartTag := smarttags.SmartTag{
+		Namespace:   parsed.Namespace,
+		Name:        parsed.Name,
+		Description: tagsSmartDefineDescription,
+		Query:       strings.Join(args[1:], " "),
+	}
+
+	var defined []smarttags.SmartTag
+	existing, err := tagDB.GetSmartTagByName(cmd.Context(), args[0])
+	switch {
+	case err == nil:
+		smartTag.Id = existing.Id
+		if !cmd.Flags().Changed("description") {
+			smartTag.Description = existing.Description
+		}
+		defined, err = tagDB.UpdateSmartTags(cmd.Context(), []smarttags.SmartTag{smartTag})
+	case errors.Is(err, sql.ErrNoRows):
+		defined, err = tagDB.AddSmartTags(cmd.Context(), []smarttags.SmartTag{smartTag})
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := output.RenderAll(renderer, smartTagDefinitions(defined)); err != nil {
+		return err
+	}
+
+	return renderer.Close()
+}
+
+func runTagsSmartDelete(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	deleteSmartTags := []smarttags.SmartTag{}
+	for _, name := range args {
+		smartTag, err := tagDB.GetSmartTagByName(cmd.Context(), name)
+		if err != nil {
+			return err
+		}
+		deleteSmartTags = append(deleteSmartTags, smartTag)
+	}
+
+	return tagDB.DeleteSmartTags(cmd.Context(), deleteSmartTags)
+}
+
+func runTagsSmartList(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	renderer, err := newRenderer(cmd)
+	if err != nil {
+		return err
+	}
+
+	allSmartTags, err := tagDB.GetSmartTags(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	if err := output.RenderAll(renderer, smartTagDefinitions(allSmartTags)); err != nil {
+		return err
+	}
+
+	return renderer.Close()
+}
+
+// smartTagDefinition is a smart tag shown to people as its name and query.
+type smartTagDefinition smarttags.SmartTag
+
+func (s smartTagDefinition) String() string {
+	return fmt.Sprintf("%s\t%s", smarttags.SmartTag(s), s.Query)
+}
+
+func smartTagDefinitions(input []smarttags.SmartTag) []smartTagDefinition {
+	ret := []smartTagDefinition{}
+	for _, smartTag := range input {
+		ret = append(ret, smartTagDefinition(smartTag))
+	}
+
+	return ret
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/whatsfordinner/fstagger/internal/db"
+	"github.com/whatsfordinner/fstagger/internal/tags"
+)
+
+var (
+	tagsTreeCmd = &cobra.Command{
+		Use:   "tree [NAME]",
+		Short: "Show tags as a hierarchy with file counts",
+		Long: `Shows every tag as a tree built from the / in tag names, so food/dessert/pie
+appears below food/dessert which appears below food. Each tag shows how many
+files are tagged with it or anything below it, which is the number of files
+"fstagger search" finds for that tag. Providing NAME only shows the tree below
+that tag.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: withDB(runTagsTree),
+	}
+)
+
+// treeNode is a single tag in the tag hierarchy. Files counts the distinct files
+// tagged with the tag or any tag below it.
+type treeNode struct {
+	tags.Tag
+	Depth int `json:"depth"`
+	Files int `json:"files"`
+}
+
+func (n treeNode) String() string {
+	name := n.Leaf()
+	if n.Depth == 0 {
+		name = n.Tag.String()
+	}
+
+	return fmt.Sprintf("%s%s (%d)", strings.Repeat("  ", n.Depth), name, n.Files)
+}
+
+func init() {
+	tagsCmd.AddCommand(tagsTreeCmd)
+}
+
+func runTagsTree(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	renderer, err := newRenderer(cmd)
+	if err != nil {
+		return err
+	}
+
+	allTags, err := tagDB.GetTags(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	counts, err := tagDB.GetTagTreeFileCounts(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(allTags, func(i, j int) bool {
+		return allTags[i].String() < allTags[j].String()
+	})
+
+	roots := []tags.Tag{}
+	children := map[int][]tags.Tag{}
+	for _, tag := range allTags {
+		if tag.Parent == 0 {
+			roots = append(roots, tag)
+		} else {
+			children[tag.Parent] = append(children[tag.Parent], tag)
+		}
+	}
+
+	if len(args) > 0 {
+		root, err := tagDB.GetTagByName(cmd.Context(), args[0])
+		if err != nil {
+			return err
+		}
+		roots = []tags.Tag{root}
+	}
+
+	var walk func(tag tags.Tag, depth int) error
+	walk = func(tag tags.Tag, depth int) error {
+		if err := renderer.Render(treeNode{tag, depth, counts[tag.Id]}); err != nil {
+			return err
+		}
+
+		for _, child := range children[tag.Id] {
+			if err := walk(child, depth+1); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	for _, root := range roots {
+		if err := walk(root, 0); err != nil {
+			return err
+		}
+	}
+
+	return renderer.Close()
+}
@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/whatsfordinner/fstagger/internal/db"
+	"github.com/whatsfordinner/fstagger/internal/values"
+)
+
+var (
+	tagsTypeCmd = &cobra.Command{
+		Use:   "type",
+		Short: "Manage the type of value tags take, like rating=4",
+		Long: `A tag with a declared type can carry a value on each file, like rating=4,
+due=2025-06-01 or stage=draft. Values are given when tagging, as name=value,
+and are checked against the tag's type. Searches can compare them with =, !=,
+<, <=, > and >=, like "rating>=4 and due<2025-07-01".`,
+	}
+
+	tagsTypeSetCmd = &cobra.Command{
+		Use:   "set NAME TYPE [OPTION...]",
+		Short: "Declare the type of value a tag takes",
+		Long: fmt.Sprintf(`Declares the type of value the tag NAME takes, replacing any type it already
+had. TYPE is one of %s.
+Dates are written YYYY-MM-DD. An enum lists its OPTIONs in order, which is the
+order they're compared in.
+
+Values already on files are converted to the new type and the type isn't
+changed if any of them can't be.`, typeNames()),
+		Args: cobra.MinimumNArgs(2),
+		RunE: withDB(runTagsTypeSet),
+	}
+
+	tagsTypeRemoveCmd = &cobra.Command{
+		Use:     "rm NAME...",
+		Aliases: []string{"remove"},
+		Short:   "Stop tags taking values, removing their values from files",
+		Args:    cobra.MinimumNArgs(1),
+		RunE:    withDB(runTagsTypeRemove),
+	}
+
+	tagsTypeListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List tags which take values and their types",
+		Args:  cobra.NoArgs,
+		RunE:  withDB(runTagsTypeList),
+	}
+)
+
+// valueTypeEntry is a value type declaration with the name of its tag.
+type valueTypeEntry struct {
+	Tag     string      `json:"tag"`
+	Type    values.Type `json:"type"`
+	Options []string    `json:"options"`
+}
+
+func (v valueTypeEntry) String() string {
+	if len(v.Options) == 0 {
+		return fmt.Sprintf("%s\t%s", v.Tag, v.Type)
+	}
+
+	return fmt.Sprintf("%s\t%s\t%s", v.Tag, v.Type, strings.Join(v.Options, ","))
+}
+
+func init() {
+	tagsTypeCmd.AddCommand(tagsTypeSetCmd)
+	tagsTypeCmd.AddCommand(tagsTypeRemoveCmd)
+	tagsTypeCmd.AddCommand(tagsTypeListCmd)
+
+	tagsCmd.AddCommand(tagsTypeCmd)
+}
+
+func typeNames() string {
+	names := []string{}
+	for _, t := range values.Types {
+		names = append(names, string(t))
+	}
+
+	return strings.Join(names, ", ")
+}
+
+func runTagsTypeSet(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	tag, err := tagDB.GetTagByName(cmd.Context(), args[0])
+	if err != nil {
+		return err
+	}
+
+	valueType, err := values.ParseType(args[1])
+	if err != nil {
+		return err
+	}
+
+	if _, err := tagDB.SetValueTypes(cmd.Context(), []values.Declaration{
+		{Tag: tag.Id, Type: valueType, Options: args[2:]},
+	}); err != nil {
+		return err
+	}
+
+	return renderValueTypes(cmd, tagDB, tag.Id)
+}
+
+func runTagsTypeRemove(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	declarations := []values.Declaration{}
+	for _, name := range args {
+		tag, err := tagDB.GetTagByName(cmd.Context(), name)
+		if err != nil {
+			return err
+		}
+		declarations = append(declarations, values.Declaration{Tag: tag.Id})
+	}
+
+	return tagDB.DeleteValueTypes(cmd.Context(), declarations)
+}
+
+func runTagsTypeList(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	return renderValueTypes(cmd, tagDB, 0)
+}
+
+// renderValueTypes renders every value type declaration, or only the one for
+// the provided tag ID if it isn't 0.
+func renderValueTypes(cmd *cobra.Command, tagDB *db.TagDB, tagId int) error {
+	renderer, err := newRenderer(cmd)
+	if err != nil {
+		return err
+	}
+
+	declarations, err := tagDB.GetValueTypes(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	allTags, err := tagDB.GetTags(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	names := map[int]string{}
+	for _, tag := range allTags {
+		names[tag.Id] = tag.String()
+	}
+
+	for _, declaration := range declarations {
+		if tagId != 0 && declaration.Tag != tagId {
+			continue
+		}
+
+		if err := renderer.Render(valueTypeEntry{
+			Tag:     names[declaration.Tag],
+			Type:    declaration.Type,
+			Options: declaration.Options,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return renderer.Close()
+}
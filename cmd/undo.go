@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/spf13/cobra"
+	"github.com/whatsfordinner/fstagger/internal/db"
+	"github.com/whatsfordinner/fstagger/internal/history"
+	"github.com/whatsfordinner/fstagger/internal/output"
+)
+
+var (
+	undoList bool
+	undoCmd  = &cobra.Command{
+		Use:   "undo",
+		Short: "Reverse the last command which changed something",
+		Long: `Reverses every change made by the most recent fstagger command which changed
+the database and hasn't been undone yet, like a "fstagger tag remove" run on
+the wrong directory. Running undo again reverses the command before that.
+
+The command is reversed completely or not at all and is listed once it has
+been. It can be made again with "fstagger redo" until another command changes
+something. Removing expired tags with "fstagger gc" can't be undone.
+
+With --list the commands which can be undone are listed, most recent first,
+followed by the commands which can be redone.`,
+		Args: cobra.NoArgs,
+		RunE: withDB(runUndo),
+	}
+
+	redoCmd = &cobra.Command{
+		Use:   "redo",
+		Short: "Make the changes of the last undone command again",
+		Long: `Makes the changes of the command most recently reversed with "fstagger undo"
+again and lists it. Commands can be redone in the opposite order to the one
+they were undone in until another command changes something, at which point
+they can't be redone any more.`,
+		Args: cobra.NoArgs,
+		RunE: withDB(runRedo),
+	}
+)
+
+func init() {
+	undoCmd.Flags().BoolVar(
+		&undoList,
+		"list",
+		false,
+		"list the commands which can be undone and redone instead of undoing one",
+	)
+
+	rootCmd.AddCommand(undoCmd)
+	rootCmd.AddCommand(redoCmd)
+}
+
+func runUndo(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	if undoList {
+		renderer, err := newRenderer(cmd)
+		if err != nil {
+			return err
+		}
+
+		operations, err := tagDB.GetOperations(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		if err := output.RenderAll(renderer, operations); err != nil {
+			return err
+		}
+
+		return renderer.Close()
+	}
+
+	return renderOperation(cmd, tagDB.Undo)
+}
+
+func runRedo(cmd *cobra.Command, args []string, tagDB *db.TagDB) error {
+	return renderOperation(cmd, tagDB.Redo)
+}
+
+// renderOperation undoes or redoes an operation with replay and renders it.
+func renderOperation(
+	cmd *cobra.Command,
+	replay func(ctx context.Context) (history.Operation, error),
+) error {
+	renderer, err := newRenderer(cmd)
+	if err != nil {
+		return err
+	}
+
+	operation, err := replay(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	if err := renderer.Render(operation); err != nil {
+		return err
+	}
+
+	return renderer.Close()
+}
@@ -0,0 +1,17 @@
+package aliases
+
+import "github.com/whatsfordinner/fstagger/internal/tags"
+
+// Alias is another name for a tag. Wherever a tag's name is accepted its
+// aliases are accepted too and resolve to the tag itself, so files are only
+// ever linked to the canonical tag.
+type Alias struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Tag       int    `json:"tag"`
+}
+
+// String returns the alias qualified with its namespace the same way as a tag.
+func (a Alias) String() string {
+	return tags.Tag{Namespace: a.Namespace, Name: a.Name}.String()
+}
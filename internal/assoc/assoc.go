@@ -0,0 +1,299 @@
+// Package assoc measures which tags are attached to the same files and finds
+// rules like "files tagged invoices and acme are also tagged clients".
+//
+// Each file is a transaction holding the set of its tags. Pairs of tags are
+// scored by how many files have both, their lift, which is how much more often
+// they appear together than they would if they were unrelated, and their
+// Jaccard index, which is the share of files with either tag that have both.
+// Rules are found the apriori way: sets of tags on enough files are grown a
+// tag at a time from smaller sets which are on enough files, since adding a
+// tag never puts a set on more files, and every frequent set is split into
+// rules which name one of its tags as the one the others imply.
+package assoc
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/whatsfordinner/fstagger/internal/links"
+)
+
+const (
+	DefaultMinSupport    = 0.01
+	DefaultMinConfidence = 0.8
+	DefaultMaxSize       = 3
+	// minRuleFiles is the fewest files a rule can be drawn from whatever the
+	// minimum support is, since a single file doesn't make a pattern.
+	minRuleFiles = 2
+)
+
+// Pair is two tags, by ID with A below B, and how they're related. Files is
+// how many files have both and FilesA and FilesB how many have each.
+type Pair struct {
+	A       int     `json:"a"`
+	B       int     `json:"b"`
+	Files   int     `json:"files"`
+	FilesA  int     `json:"files_a"`
+	FilesB  int     `json:"files_b"`
+	Lift    float64 `json:"lift"`
+	Jaccard float64 `json:"jaccard"`
+}
+
+// Rule is a set of tags, If, whose files mostly have another tag, Then.
+// Support is the share of all files with every tag in the rule, Confidence is
+// the share of files with the If tags that also have the Then tag and Lift is
+// how much more likely the Then tag is on those files than on any file.
+type Rule struct {
+	If         []int   `json:"if"`
+	Then       int     `json:"then"`
+	Files      int     `json:"files"`
+	Support    float64 `json:"support"`
+	Confidence float64 `json:"confidence"`
+	Lift       float64 `json:"lift"`
+}
+
+// Transactions groups links into the IDs of the tags on each file, in order of
+// file and then tag ID. Links made by implications are left out since they'd
+// only find the implications again.
+func Transactions(allLinks []links.Link) [][]int {
+	byFile := map[int]map[int]bool{}
+	for _, link := range allLinks {
+		if strings.HasPrefix(link.Source, links.SourceImplied) {
+			continue
+		}
+		if byFile[link.File] == nil {
+			byFile[link.File] = map[int]bool{}
+		}
+		byFile[link.File][link.Tag] = true
+	}
+
+	fileIds := []int{}
+	for fileId := range byFile {
+		fileIds = append(fileIds, fileId)
+	}
+	sort.Ints(fileIds)
+
+	ret := [][]int{}
+	for _, fileId := range fileIds {
+		ret = append(ret, sortedSet(byFile[fileId]))
+	}
+
+	return ret
+}
+
+// Cooccurrence returns every pair of tags which are both on at least minFiles
+// files, the highest lift first.
+func Cooccurrence(transactions [][]int, minFiles int) []Pair {
+	single := map[int]int{}
+	both := map[[2]int]int{}
+	for _, transaction := range transactions {
+		for i, a := range transaction {
+			single[a]++
+			for _, b := range transaction[i+1:] {
+				both[[2]int{a, b}]++
+			}
+		}
+	}
+
+	total := float64(len(transactions))
+	ret := []Pair{}
+	for pair, files := range both {
+		if files < max(minFiles, 1) {
+			continue
+		}
+
+		filesA, filesB := single[pair[0]], single[pair[1]]
+		ret = append(ret, Pair{
+			A:       pair[0],
+			B:       pair[1],
+			Files:   files,
+			FilesA:  filesA,
+			FilesB:  filesB,
+			Lift:    float64(files) * total / float64(filesA*filesB),
+			Jaccard: float64(files) / float64(filesA+filesB-files),
+		})
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Lift != ret[j].Lift {
+			return ret[i].Lift > ret[j].Lift
+		}
+		if ret[i].Files != ret[j].Files {
+			return ret[i].Files > ret[j].Files
+		}
+		if ret[i].A != ret[j].A {
+			return ret[i].A < ret[j].A
+		}
+		return ret[i].B < ret[j].B
+	})
+
+	return ret
+}
+
+// Rules returns every rule drawn from sets of up to maxSize tags which are on
+// at least minSupport of the files, and at least two of them, where the Then
+// tag is on at least minConfidence of the files with the If tags. Rules are
+// ordered most confident first, then by lift and then by support.
+func Rules(transactions [][]int, minSupport float64, minConfidence float64, maxSize int) []Rule {
+	total := len(transactions)
+	// the tolerance keeps 0.01 of 300 files at 3 rather than rounding it up
+	minFiles := max(minRuleFiles, int(math.Ceil(minSupport*float64(total)-1e-9)))
+
+	single := map[int]int{}
+	for _, transaction := range transactions {
+		for _, tag := range transaction {
+			single[tag]++
+		}
+	}
+
+	counts := map[string]int{}
+	frequent := [][]int{}
+	for tag, count := range single {
+		counts[key([]int{tag})] = count
+		if count >= minFiles {
+			frequent = append(frequent, []int{tag})
+		}
+	}
+
+	sets := [][]int{}
+	for size := 2; size <= maxSize && len(frequent) > 1; size++ {
+		candidates := grow(frequent, counts, minFiles)
+
+		found := map[string]int{}
+		for _, transaction := range transactions {
+			if len(transaction) < size {
+				continue
+			}
+
+			has := map[int]bool{}
+			for _, tag := range transaction {
+				has[tag] = true
+			}
+
+			for _, candidate := range candidates {
+				if containsAll(has, candidate) {
+					found[key(candidate)]++
+				}
+			}
+		}
+
+		frequent = [][]int{}
+		for _, candidate := range candidates {
+			if count := found[key(candidate)]; count >= minFiles {
+				counts[key(candidate)] = count
+				frequent = append(frequent, candidate)
+				sets = append(sets, candidate)
+			}
+		}
+	}
+
+	ret := []Rule{}
+	for _, set := range sets {
+		files := counts[key(set)]
+		for i, then := range set {
+			antecedent := append(append([]int{}, set[:i]...), set[i+1:]...)
+			antecedentFiles := counts[key(antecedent)]
+			confidence := float64(files) / float64(antecedentFiles)
+			if confidence < minConfidence {
+				continue
+			}
+
+			ret = append(ret, Rule{
+				If:         antecedent,
+				Then:       then,
+				Files:      files,
+				Support:    float64(files) / float64(total),
+				Confidence: confidence,
+				Lift:       float64(files*total) / float64(antecedentFiles*counts[key([]int{then})]),
+			})
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Confidence != ret[j].Confidence {
+			return ret[i].Confidence > ret[j].Confidence
+		}
+		if ret[i].Lift != ret[j].Lift {
+			return ret[i].Lift > ret[j].Lift
+		}
+		if ret[i].Support != ret[j].Support {
+			return ret[i].Support > ret[j].Support
+		}
+		if key(ret[i].If) != key(ret[j].If) {
+			return key(ret[i].If) < key(ret[j].If)
+		}
+		return ret[i].Then < ret[j].Then
+	})
+
+	return ret
+}
+
+// grow returns every set one tag larger than the frequent sets, which all have
+// the same size and are sorted, by joining pairs which differ only in their
+// last tag. A set is only kept if every subset one tag smaller is on at least
+// minFiles files, since otherwise it can't be either.
+func grow(frequent [][]int, counts map[string]int, minFiles int) [][]int {
+	sort.Slice(frequent, func(i, j int) bool {
+		return key(frequent[i]) < key(frequent[j])
+	})
+
+	ret := [][]int{}
+	for i, a := range frequent {
+		for _, b := range frequent[i+1:] {
+			prefix := len(a) - 1
+			if key(a[:prefix]) != key(b[:prefix]) {
+				continue
+			}
+
+			candidate := append(append([]int{}, a...), b[prefix])
+			sort.Ints(candidate)
+
+			ok := true
+			for skip := range candidate {
+				subset := append(append([]int{}, candidate[:skip]...), candidate[skip+1:]...)
+				if counts[key(subset)] < minFiles {
+					ok = false
+					break
+				}
+			}
+
+			if ok {
+				ret = append(ret, candidate)
+			}
+		}
+	}
+
+	return ret
+}
+
+func containsAll(has map[int]bool, tags []int) bool {
+	for _, tag := range tags {
+		if !has[tag] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func sortedSet(set map[int]bool) []int {
+	ret := []int{}
+	for item := range set {
+		ret = append(ret, item)
+	}
+	sort.Ints(ret)
+
+	return ret
+}
+
+// key identifies a sorted set of tag IDs so it can be counted in a map.
+func key(tags []int) string {
+	parts := make([]string, len(tags))
+	for i, tag := range tags {
+		parts[i] = strconv.Itoa(tag)
+	}
+
+	return strings.Join(parts, ",")
+}
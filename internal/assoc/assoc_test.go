@@ -0,0 +1,149 @@
+package assoc
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/whatsfordinner/fstagger/internal/links"
+)
+
+// testTransactions has tag 1 on most files, 2 mostly alongside it, 3 only
+// alongside both and 4 mostly on its own.
+var testTransactions = [][]int{
+	{1, 2, 3},
+	{1, 2, 3},
+	{1, 2},
+	{1, 4},
+	{4},
+}
+
+func TestTransactions(t *testing.T) {
+	res := Transactions([]links.Link{
+		{File: 2, Tag: 3},
+		{File: 1, Tag: 2},
+		{File: 1, Tag: 1},
+		{File: 2, Tag: 1, Source: links.SourceImplied + "cat"},
+		{File: 3, Tag: 1, Source: links.SourceImplied + "cat"},
+		{File: 2, Tag: 2, Source: links.SourceImport},
+	})
+
+	expect := [][]int{{1, 2}, {2, 3}}
+	if !reflect.DeepEqual(res, expect) {
+		t.Fatalf("Result did not match expectation\nResult: %+v\nExpected: %+v", res, expect)
+	}
+}
+
+func TestCooccurrence(t *testing.T) {
+	testMap := map[string]struct {
+		minFiles int
+		expect   []Pair
+	}{
+		"every pair": {
+			0,
+			[]Pair{
+				{A: 2, B: 3, Files: 2, FilesA: 3, FilesB: 2, Lift: 5.0 / 3, Jaccard: 2.0 / 3},
+				{A: 1, B: 2, Files: 3, FilesA: 4, FilesB: 3, Lift: 1.25, Jaccard: 0.75},
+				{A: 1, B: 3, Files: 2, FilesA: 4, FilesB: 2, Lift: 1.25, Jaccard: 0.5},
+				{A: 1, B: 4, Files: 1, FilesA: 4, FilesB: 2, Lift: 0.625, Jaccard: 0.2},
+			},
+		},
+		"pairs on enough files": {
+			3,
+			[]Pair{
+				{A: 1, B: 2, Files: 3, FilesA: 4, FilesB: 3, Lift: 1.25, Jaccard: 0.75},
+			},
+		},
+		"no pairs": {
+			4,
+			[]Pair{},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			res := Cooccurrence(testTransactions, testData.minFiles)
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestRules(t *testing.T) {
+	testMap := map[string]struct {
+		minSupport    float64
+		minConfidence float64
+		maxSize       int
+		expect        []Rule
+	}{
+		"sets of up to three tags": {
+			0,
+			0.8,
+			3,
+			[]Rule{
+				{If: []int{1, 3}, Then: 2, Files: 2, Support: 0.4, Confidence: 1, Lift: 5.0 / 3},
+				{If: []int{3}, Then: 2, Files: 2, Support: 0.4, Confidence: 1, Lift: 5.0 / 3},
+				{If: []int{2}, Then: 1, Files: 3, Support: 0.6, Confidence: 1, Lift: 1.25},
+				{If: []int{2, 3}, Then: 1, Files: 2, Support: 0.4, Confidence: 1, Lift: 1.25},
+				{If: []int{3}, Then: 1, Files: 2, Support: 0.4, Confidence: 1, Lift: 1.25},
+			},
+		},
+		"pairs only": {
+			0,
+			0.8,
+			2,
+			[]Rule{
+				{If: []int{3}, Then: 2, Files: 2, Support: 0.4, Confidence: 1, Lift: 5.0 / 3},
+				{If: []int{2}, Then: 1, Files: 3, Support: 0.6, Confidence: 1, Lift: 1.25},
+				{If: []int{3}, Then: 1, Files: 2, Support: 0.4, Confidence: 1, Lift: 1.25},
+			},
+		},
+		"minimum support": {
+			0.5,
+			0.8,
+			3,
+			[]Rule{
+				{If: []int{2}, Then: 1, Files: 3, Support: 0.6, Confidence: 1, Lift: 1.25},
+			},
+		},
+		"minimum confidence": {
+			0.5,
+			0.7,
+			3,
+			[]Rule{
+				{If: []int{2}, Then: 1, Files: 3, Support: 0.6, Confidence: 1, Lift: 1.25},
+				{If: []int{1}, Then: 2, Files: 3, Support: 0.6, Confidence: 0.75, Lift: 1.25},
+			},
+		},
+		"single files don't make rules": {
+			0,
+			0,
+			2,
+			[]Rule{
+				{If: []int{3}, Then: 2, Files: 2, Support: 0.4, Confidence: 1, Lift: 5.0 / 3},
+				{If: []int{2}, Then: 1, Files: 3, Support: 0.6, Confidence: 1, Lift: 1.25},
+				{If: []int{3}, Then: 1, Files: 2, Support: 0.4, Confidence: 1, Lift: 1.25},
+				{If: []int{1}, Then: 2, Files: 3, Support: 0.6, Confidence: 0.75, Lift: 1.25},
+				{If: []int{2}, Then: 3, Files: 2, Support: 0.4, Confidence: 2.0 / 3, Lift: 5.0 / 3},
+				{If: []int{1}, Then: 3, Files: 2, Support: 0.4, Confidence: 0.5, Lift: 1.25},
+			},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			res := Rules(testTransactions, testData.minSupport, testData.minConfidence, testData.maxSize)
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
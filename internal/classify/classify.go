@@ -0,0 +1,288 @@
+// Package classify learns which tags files get from the files which are
+// already tagged, so it can predict tags for files which aren't.
+//
+// Files are described by features: the words in their path, their extension,
+// their size rounded to a power of ten and their MIME type. Each tag gets its
+// own naive Bayes classifier which weighs up how much more often each of a
+// file's features turns up on files with the tag than on files without it, so
+// a file can be given any number of tags. A Model only holds counts, which
+// makes it cheap to store and to train again from scratch.
+package classify
+
+import (
+	"hash/fnv"
+	"math"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/whatsfordinner/fstagger/internal/suggest"
+)
+
+const (
+	DefaultThreshold = 0.5
+	DefaultHoldout   = 0.2
+	// sniffLength is how much of a file is read to detect its MIME type,
+	// which is all http.DetectContentType looks at.
+	sniffLength = 512
+)
+
+// Example is a file a model is trained or evaluated on: its path, its
+// features and the IDs of the tags it has.
+type Example struct {
+	Path     string
+	Features []string
+	Tags     []int
+}
+
+// Prediction is a tag predicted for a file and the probability that the file
+// should have it.
+type Prediction struct {
+	Tag         int     `json:"tag"`
+	Probability float64 `json:"probability"`
+}
+
+// Evaluation is how well a model trained on some examples predicted the tags
+// of the rest. Precision is the share of predicted tags the files really had
+// and Recall is the share of the files' tags which were predicted, both
+// counted over every held out file and tag.
+type Evaluation struct {
+	Trained   int     `json:"trained"`
+	Tested    int     `json:"tested"`
+	Threshold float64 `json:"threshold"`
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+}
+
+// Model is the number of examples a model was trained on, how many of them
+// had each tag and each feature and how many with each tag had each feature.
+type Model struct {
+	Documents int
+	Tags      map[int]int
+	Features  map[string]int
+	Counts    map[int]map[string]int
+
+	// totals are the number of features counted for each tag and overall,
+	// worked out the first time they're needed
+	totals map[int]int
+	total  int
+}
+
+// Features describes a file as the words in its path, its extension, its
+// size and its MIME type. The path should be the one the file is predicted
+// for, usually relative to the home directory. A size below zero or an empty
+// MIME type is left out.
+func Features(path string, size int64, mimeType string) []string {
+	seen := map[string]bool{}
+	ret := []string{}
+	add := func(feature string) {
+		if !seen[feature] {
+			seen[feature] = true
+			ret = append(ret, feature)
+		}
+	}
+
+	for _, token := range suggest.Tokens(path) {
+		if !strings.Contains(token.Key(), " ") {
+			add("word:" + token.Key())
+		}
+	}
+
+	if extension := strings.ToLower(filepath.Ext(path)); extension != "" {
+		add("ext:" + extension)
+	}
+
+	if size >= 0 {
+		add("size:" + sizeBucket(size))
+	}
+
+	if mimeType != "" {
+		add("mime:" + mimeType)
+	}
+
+	return ret
+}
+
+// sizeBucket rounds a size down to a power of ten, so 4096 bytes is 1e3.
+func sizeBucket(size int64) string {
+	if size == 0 {
+		return "0"
+	}
+
+	return "1e" + strconv.Itoa(int(math.Log10(float64(size))))
+}
+
+// Sniff returns the size and MIME type of the file at path, detecting the type
+// from its first few bytes and falling back to its extension if they don't
+// say.
+func Sniff(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return -1, "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return -1, "", err
+	}
+
+	head := make([]byte, sniffLength)
+	n, _ := f.Read(head)
+
+	mimeType, _, _ := strings.Cut(http.DetectContentType(head[:n]), ";")
+	if mimeType == "application/octet-stream" || mimeType == "text/plain" {
+		if byExtension, _, _ := strings.Cut(mime.TypeByExtension(filepath.Ext(path)), ";"); byExtension != "" {
+			mimeType = byExtension
+		}
+	}
+
+	return info.Size(), mimeType, nil
+}
+
+// Train counts the features and tags of every example into a new model.
+func Train(examples []Example) *Model {
+	model := &Model{
+		Tags:     map[int]int{},
+		Features: map[string]int{},
+		Counts:   map[int]map[string]int{},
+	}
+
+	for _, example := range examples {
+		model.Documents++
+		for _, feature := range example.Features {
+			model.Features[feature]++
+		}
+
+		for _, tag := range example.Tags {
+			model.Tags[tag]++
+			if model.Counts[tag] == nil {
+				model.Counts[tag] = map[string]int{}
+			}
+			for _, feature := range example.Features {
+				model.Counts[tag][feature]++
+			}
+		}
+	}
+
+	return model
+}
+
+// Predict returns every tag the model knows with the probability that a file
+// with features should have it, most likely first. A file without any
+// features the model has seen gets no predictions rather than the most
+// common tags.
+func (m *Model) Predict(features []string) []Prediction {
+	// features the model has never seen say nothing either way
+	known := []string{}
+	for _, feature := range features {
+		if m.Features[feature] > 0 {
+			known = append(known, feature)
+		}
+	}
+
+	if len(known) == 0 {
+		return []Prediction{}
+	}
+
+	if m.totals == nil {
+		m.totals = map[int]int{}
+		for tag, counts := range m.Counts {
+			for _, count := range counts {
+				m.totals[tag] += count
+			}
+		}
+		for _, count := range m.Features {
+			m.total += count
+		}
+	}
+
+	vocabulary := float64(len(m.Features))
+	ret := []Prediction{}
+	for tag, documents := range m.Tags {
+		tagTotal := m.totals[tag]
+
+		// the log odds of the file having the tag start from how common the
+		// tag is and every feature moves them by how much more likely it is
+		// on files with the tag than on files without it, with add-one
+		// smoothing so a feature never rules a tag in or out on its own
+		odds := math.Log(float64(documents)+1) - math.Log(float64(m.Documents-documents)+1)
+		for _, feature := range known {
+			with := float64(m.Counts[tag][feature])
+			without := float64(m.Features[feature]) - with
+			odds += math.Log((with+1)/(float64(tagTotal)+vocabulary)) -
+				math.Log((without+1)/(float64(m.total-tagTotal)+vocabulary))
+		}
+
+		ret = append(ret, Prediction{Tag: tag, Probability: 1 / (1 + math.Exp(-odds))})
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Probability != ret[j].Probability {
+			return ret[i].Probability > ret[j].Probability
+		}
+		return ret[i].Tag < ret[j].Tag
+	})
+
+	return ret
+}
+
+// Split divides examples into ones to train on and a share of holdout to test
+// on. Examples are assigned by a hash of their path so the same files are held
+// out every time.
+func Split(examples []Example, holdout float64) ([]Example, []Example) {
+	train := []Example{}
+	test := []Example{}
+	for _, example := range examples {
+		h := fnv.New32a()
+		h.Write([]byte(example.Path))
+		if float64(h.Sum32())/math.MaxUint32 < holdout {
+			test = append(test, example)
+		} else {
+			train = append(train, example)
+		}
+	}
+
+	return train, test
+}
+
+// Evaluate trains a model on the examples which aren't held out and measures
+// how well it predicts the tags of the ones which are, counting tags predicted
+// with at least threshold probability.
+func Evaluate(examples []Example, holdout float64, threshold float64) Evaluation {
+	train, test := Split(examples, holdout)
+	model := Train(train)
+
+	ret := Evaluation{Trained: len(train), Tested: len(test), Threshold: threshold}
+	truePositives, predicted, actual := 0, 0, 0
+	for _, example := range test {
+		has := map[int]bool{}
+		for _, tag := range example.Tags {
+			has[tag] = true
+		}
+		actual += len(has)
+
+		for _, prediction := range model.Predict(example.Features) {
+			if prediction.Probability < threshold {
+				break
+			}
+			predicted++
+			if has[prediction.Tag] {
+				truePositives++
+			}
+		}
+	}
+
+	if predicted > 0 {
+		ret.Precision = float64(truePositives) / float64(predicted)
+	}
+	if actual > 0 {
+		ret.Recall = float64(truePositives) / float64(actual)
+	}
+
+	return ret
+}
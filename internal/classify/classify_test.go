@@ -0,0 +1,197 @@
+package classify
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFeatures(t *testing.T) {
+	testMap := map[string]struct {
+		path     string
+		size     int64
+		mimeType string
+		expect   []string
+	}{
+		"every feature": {
+			"clients/acme/invoice-042.PDF",
+			4096,
+			"application/pdf",
+			[]string{
+				"word:invoic",
+				"word:042",
+				"word:acm",
+				"word:client",
+				"word:pdf",
+				"ext:.pdf",
+				"size:1e3",
+				"mime:application/pdf",
+			},
+		},
+		"empty file without a type": {
+			"notes",
+			0,
+			"",
+			[]string{"word:note", "size:0"},
+		},
+		"unknown size": {
+			"photos/photo.jpg",
+			-1,
+			"",
+			[]string{"word:photo", "word:jpg", "ext:.jpg"},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			res := Features(testData.path, testData.size, testData.mimeType)
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestSniff(t *testing.T) {
+	dir := t.TempDir()
+	testMap := map[string]struct {
+		name       string
+		content    string
+		expectSize int64
+		expectType string
+	}{
+		"detected from contents":  {"page", "<html><body>hi</body></html>", 28, "text/html"},
+		"detected from extension": {"notes.md", "# Notes", 7, "text/markdown"},
+		"unknown":                 {"blob", "\x00\x01\x02", 3, "application/octet-stream"},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			path := filepath.Join(dir, testData.name)
+			if err := os.WriteFile(path, []byte(testData.content), 0o644); err != nil {
+				t.Fatalf("Unable to create test file: %s", err.Error())
+			}
+
+			size, mimeType, err := Sniff(path)
+			if err != nil {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if size != testData.expectSize || mimeType != testData.expectType {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %d %s\nExpected: %d %s",
+					size,
+					mimeType,
+					testData.expectSize,
+					testData.expectType,
+				)
+			}
+		})
+	}
+}
+
+// examples are files where invoices and receipts live in their own
+// directories and photos are told apart by their type.
+func examples() []Example {
+	return []Example{
+		{Path: "a", Features: []string{"word:invoic", "word:acm", "ext:.pdf"}, Tags: []int{1, 2}},
+		{Path: "b", Features: []string{"word:invoic", "word:globex", "ext:.pdf"}, Tags: []int{1}},
+		{Path: "c", Features: []string{"word:invoic", "word:acm", "ext:.pdf"}, Tags: []int{1, 2}},
+		{Path: "h", Features: []string{"word:invoic", "word:initech", "ext:.pdf"}, Tags: []int{1}},
+		{Path: "i", Features: []string{"word:invoic", "word:globex", "ext:.pdf"}, Tags: []int{1}},
+		{Path: "d", Features: []string{"word:receipt", "ext:.pdf"}, Tags: []int{3}},
+		{Path: "e", Features: []string{"word:receipt", "word:acm", "ext:.pdf"}, Tags: []int{2, 3}},
+		{Path: "f", Features: []string{"word:holiday", "ext:.jpg", "mime:image/jpeg"}, Tags: []int{4}},
+		{Path: "g", Features: []string{"word:beach", "ext:.jpg", "mime:image/jpeg"}, Tags: []int{4}},
+	}
+}
+
+func TestTrain(t *testing.T) {
+	model := Train(examples()[:2])
+
+	expect := &Model{
+		Documents: 2,
+		Tags:      map[int]int{1: 2, 2: 1},
+		Features:  map[string]int{"word:invoic": 2, "word:acm": 1, "word:globex": 1, "ext:.pdf": 2},
+		Counts: map[int]map[string]int{
+			1: {"word:invoic": 2, "word:acm": 1, "word:globex": 1, "ext:.pdf": 2},
+			2: {"word:invoic": 1, "word:acm": 1, "ext:.pdf": 1},
+		},
+	}
+	if !reflect.DeepEqual(model, expect) {
+		t.Fatalf("Result did not match expectation\nResult: %+v\nExpected: %+v", model, expect)
+	}
+}
+
+func TestPredict(t *testing.T) {
+	model := Train(examples())
+
+	testMap := map[string]struct {
+		input  []string
+		expect []int
+	}{
+		"an invoice":                 {[]string{"word:invoic", "word:hooli", "ext:.pdf"}, []int{1}},
+		"an invoice for a client":    {[]string{"word:invoic", "word:acm", "ext:.pdf"}, []int{1, 2}},
+		"a photo":                    {[]string{"word:dog", "ext:.jpg", "mime:image/jpeg"}, []int{4}},
+		"nothing the model has seen": {[]string{"word:zebra"}, []int{}},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			res := []int{}
+			for _, prediction := range model.Predict(testData.input) {
+				if prediction.Probability >= DefaultThreshold {
+					res = append(res, prediction.Tag)
+				}
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+	many := []Example{}
+	for i := range 1000 {
+		many = append(many, Example{Path: fmt.Sprintf("/files/%d.txt", i)})
+	}
+
+	train, test := Split(many, 0.2)
+	if len(train)+len(test) != len(many) {
+		t.Fatalf("Expected every example to be split but got %d and %d", len(train), len(test))
+	}
+
+	if len(test) < 150 || len(test) > 250 {
+		t.Fatalf("Expected about a fifth of the examples to be held out but got %d", len(test))
+	}
+
+	again, _ := Split(many, 0.2)
+	if !reflect.DeepEqual(train, again) {
+		t.Fatalf("Expected the same examples to be held out every time")
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	res := Evaluate(examples(), 0, DefaultThreshold)
+	expect := Evaluation{Trained: 9, Tested: 0, Threshold: DefaultThreshold}
+	if !reflect.DeepEqual(res, expect) {
+		t.Fatalf("Result did not match expectation\nResult: %+v\nExpected: %+v", res, expect)
+	}
+
+	res = Evaluate(examples(), 1, DefaultThreshold)
+	if res.Trained != 0 || res.Tested != 9 || res.Precision != 0 || res.Recall != 0 {
+		t.Fatalf("Expected a model trained on nothing to predict nothing but got: %+v", res)
+	}
+}
@@ -0,0 +1,202 @@
+// Package cluster finds groups of tag names which probably mean the same thing,
+// like Photo, photos, photograph and fotos, so they can be merged.
+//
+// Names are compared by their key: the name with its case folded, accents and
+// other combining marks removed and each word stemmed. Two names are in the
+// same cluster if their keys are equal, if one key is a prefix of the other or
+// if the keys are within a small edit distance of each other. Clustering is
+// transitive so a cluster can contain names which aren't similar to each other
+// directly. It's a heuristic intended to produce suggestions for a person to
+// review, not to merge tags automatically.
+//
+// Only tags with the same parent in the hierarchy are compared, and then only
+// by the last segment of their names, so a tag is never clustered with its own
+// ancestors or descendants.
+package cluster
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/whatsfordinner/fstagger/internal/tags"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	DefaultMaxDistance = 2
+	// minFuzzyLength is the shortest key that's compared by edit distance.
+	// Below this almost every short tag is a couple of edits from another.
+	minFuzzyLength = 4
+	// minPrefixLength is the shortest key that's allowed to match as a prefix
+	// of another key.
+	minPrefixLength = 5
+)
+
+// Cluster is a group of similar tag names.
+type Cluster struct {
+	// Names are ordered by usage, most used first, so the first name is the
+	// natural target for a merge.
+	Names []string `json:"names"`
+	// Files is the total usage of every name in the cluster.
+	Files int `json:"files"`
+}
+
+// Key normalises a tag name for comparison.
+func Key(name string) string {
+	stripMarks := transform.Chain(
+		norm.NFKD,
+		runes.Remove(runes.In(unicode.Mn)),
+		norm.NFC,
+	)
+	stripped, _, err := transform.String(stripMarks, name)
+	if err != nil {
+		stripped = name
+	}
+	folded := cases.Fold().String(stripped)
+
+	words := strings.FieldsFunc(folded, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for i, word := range words {
+		words[i] = Stem(word)
+	}
+
+	return strings.Join(words, " ")
+}
+
+// Distance is the Levenshtein distance between a and b counted in runes.
+func Distance(a, b string) int {
+	ra := []rune(a)
+	rb := []rune(b)
+
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(
+				previous[j]+1,
+				current[j-1]+1,
+				previous[j-1]+cost,
+			)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(rb)]
+}
+
+// Similar reports whether two keys belong in the same cluster.
+func Similar(a, b string, maxDistance int) bool {
+	if a == b {
+		return true
+	}
+
+	shorter, longer := a, b
+	if len([]rune(shorter)) > len([]rune(longer)) {
+		shorter, longer = longer, shorter
+	}
+	length := len([]rune(shorter))
+
+	if length >= minPrefixLength && strings.HasPrefix(longer, shorter) {
+		return true
+	}
+
+	return length >= minFuzzyLength && Distance(a, b) <= maxDistance
+}
+
+// Find clusters the provided tag names, given as a map of name to the number of
+// files using it. Only clusters with more than one name are returned. They're
+// ordered by total usage, most used first.
+func Find(usage map[string]int, maxDistance int) []Cluster {
+	names := []string{}
+	for name := range usage {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	keys := make([]string, len(names))
+	siblings := make([]string, len(names))
+	for i, name := range names {
+		siblings[i], keys[i] = split(name)
+	}
+
+	parents := make([]int, len(names))
+	for i := range parents {
+		parents[i] = i
+	}
+	var root func(int) int
+	root = func(i int) int {
+		if parents[i] != i {
+			parents[i] = root(parents[i])
+		}
+		return parents[i]
+	}
+
+	for i := range names {
+		for j := i + 1; j < len(names); j++ {
+			if siblings[i] != siblings[j] || root(i) == root(j) {
+				continue
+			}
+			if Similar(keys[i], keys[j], maxDistance) {
+				parents[root(j)] = root(i)
+			}
+		}
+	}
+
+	grouped := map[int]*Cluster{}
+	for i, name := range names {
+		r := root(i)
+		if grouped[r] == nil {
+			grouped[r] = &Cluster{}
+		}
+		grouped[r].Names = append(grouped[r].Names, name)
+		grouped[r].Files += usage[name]
+	}
+
+	ret := []Cluster{}
+	for _, cluster := range grouped {
+		if len(cluster.Names) < 2 {
+			continue
+		}
+
+		sort.SliceStable(cluster.Names, func(i, j int) bool {
+			return usage[cluster.Names[i]] > usage[cluster.Names[j]]
+		})
+		ret = append(ret, *cluster)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Files != ret[j].Files {
+			return ret[i].Files > ret[j].Files
+		}
+		return ret[i].Names[0] < ret[j].Names[0]
+	})
+
+	return ret
+}
+
+// split returns the name of a tag's parent, or an empty string for a tag at
+// the top of the hierarchy, and the key of the rest of its name.
+func split(name string) (string, string) {
+	tag := tags.Parse(name)
+	ancestors := tag.Ancestors()
+	if len(ancestors) == 0 {
+		return "", Key(name)
+	}
+
+	parent := tags.Tag{Namespace: tag.Namespace, Name: ancestors[len(ancestors)-1]}
+	return parent.String(), Key(tag.Leaf())
+}
@@ -0,0 +1,189 @@
+package cluster
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStem(t *testing.T) {
+	testMap := map[string]struct {
+		input  string
+		expect string
+	}{
+		"short word":          {"is", "is"},
+		"plural":              {"cats", "cat"},
+		"sses":                {"caresses", "caress"},
+		"ies":                 {"ponies", "poni"},
+		"eed":                 {"agreed", "agre"},
+		"ed":                  {"plastered", "plaster"},
+		"ing":                 {"motoring", "motor"},
+		"ing without vowel":   {"sing", "sing"},
+		"double consonant":    {"hopping", "hop"},
+		"restored e":          {"filing", "file"},
+		"terminal y":          {"happy", "happi"},
+		"double suffix":       {"relational", "relat"},
+		"many suffixes":       {"generalization", "gener"},
+		"photos":              {"photos", "photo"},
+		"photograph":          {"photograph", "photograph"},
+		"ll":                  {"controll", "control"},
+		"ion after t":         {"adoption", "adopt"},
+		"ness":                {"goodness", "good"},
+		"already stemmed":     {"photo", "photo"},
+		"word of three ies":   {"ies", "i"},
+		"non-latin unchanged": {"фото", "фото"},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			res := Stem(testData.input)
+			if res != testData.expect {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %s\nExpected: %s",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestKey(t *testing.T) {
+	testMap := map[string]struct {
+		input  string
+		expect string
+	}{
+		"case is folded":         {"Photos", "photo"},
+		"accents are removed":    {"Café", "cafe"},
+		"separators are unified": {"Tax_Returns", "tax return"},
+		"emoji are dropped":      {"🍕 pizza", "pizza"},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			res := Key(testData.input)
+			if res != testData.expect {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %s\nExpected: %s",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestDistance(t *testing.T) {
+	testMap := map[string]struct {
+		a      string
+		b      string
+		expect int
+	}{
+		"equal":        {"photo", "photo", 0},
+		"empty":        {"", "photo", 5},
+		"substitution": {"photo", "phota", 1},
+		"mixed":        {"foto", "photo", 2},
+		"runes":        {"café", "cafe", 1},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			res := Distance(testData.a, testData.b)
+			if res != testData.expect {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %d\nExpected: %d",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestFind(t *testing.T) {
+	testMap := map[string]struct {
+		input  map[string]int
+		expect []Cluster
+	}{
+		"no tags": {
+			map[string]int{},
+			[]Cluster{},
+		},
+		"nothing similar": {
+			map[string]int{"food": 3, "music": 2},
+			[]Cluster{},
+		},
+		"photo variants": {
+			map[string]int{
+				"Photo":      1,
+				"photos":     12,
+				"photograph": 2,
+				"fotos":      4,
+				"music":      7,
+			},
+			[]Cluster{
+				{
+					Names: []string{"photos", "fotos", "photograph", "Photo"},
+					Files: 19,
+				},
+			},
+		},
+		"short tags aren't fuzzy matched": {
+			map[string]int{"tax": 1, "tag": 1, "Tag": 3},
+			[]Cluster{
+				{
+					Names: []string{"Tag", "tag"},
+					Files: 4,
+				},
+			},
+		},
+		"parents aren't clustered with their children": {
+			map[string]int{
+				"photo":      5,
+				"photograph": 2,
+				"photo/raw":  3,
+				"photo/raws": 1,
+			},
+			[]Cluster{
+				{
+					Names: []string{"photo", "photograph"},
+					Files: 7,
+				},
+				{
+					Names: []string{"photo/raw", "photo/raws"},
+					Files: 4,
+				},
+			},
+		},
+		"clusters are ordered by usage": {
+			map[string]int{
+				"invoice":  1,
+				"invoices": 1,
+				"recipe":   5,
+				"recipes":  2,
+			},
+			[]Cluster{
+				{
+					Names: []string{"recipe", "recipes"},
+					Files: 7,
+				},
+				{
+					Names: []string{"invoice", "invoices"},
+					Files: 2,
+				},
+			},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			res := Find(testData.input, DefaultMaxDistance)
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
@@ -0,0 +1,287 @@
+package cluster
+
+// Stem reduces an English word to its stem using the Porter stemming algorithm
+// (M.F. Porter, 1980, "An algorithm for suffix stripping"). It expects a lower
+// case word; anything else is stemmed on a best effort basis. Words of one or
+// two letters are returned unchanged.
+func Stem(word string) string {
+	b := []rune(word)
+	if len(b) <= 2 {
+		return word
+	}
+
+	s := &stemmer{b: b, k: len(b) - 1}
+	s.step1ab()
+	if s.k > 0 {
+		s.step1c()
+		s.step2()
+		s.step3()
+		s.step4()
+		s.step5()
+	}
+
+	return string(s.b[:s.k+1])
+}
+
+// stemmer holds the word being stemmed. b[0:k+1] is the current word and j is
+// the end of the stem once a suffix has been matched by ends.
+type stemmer struct {
+	b []rune
+	k int
+	j int
+}
+
+// cons reports whether b[i] is a consonant. Y is a consonant at the start of a
+// word or after a vowel.
+func (s *stemmer) cons(i int) bool {
+	switch s.b[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		if i == 0 {
+			return true
+		}
+		return !s.cons(i - 1)
+	}
+
+	return true
+}
+
+// m measures the number of vowel-consonant sequences in b[0:j+1]. Writing c for
+// a consonant sequence and v for a vowel sequence every word is [c](vc){m}[v].
+func (s *stemmer) m() int {
+	n := 0
+	i := 0
+	for {
+		if i > s.j {
+			return n
+		}
+		if !s.cons(i) {
+			break
+		}
+		i++
+	}
+	i++
+
+	for {
+		for {
+			if i > s.j {
+				return n
+			}
+			if s.cons(i) {
+				break
+			}
+			i++
+		}
+		i++
+		n++
+
+		for {
+			if i > s.j {
+				return n
+			}
+			if !s.cons(i) {
+				break
+			}
+			i++
+		}
+		i++
+	}
+}
+
+// vowelInStem reports whether b[0:j+1] contains a vowel.
+func (s *stemmer) vowelInStem() bool {
+	for i := 0; i <= s.j; i++ {
+		if !s.cons(i) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// doublec reports whether b[j-1:j+1] is a double consonant.
+func (s *stemmer) doublec(j int) bool {
+	if j < 1 || s.b[j] != s.b[j-1] {
+		return false
+	}
+
+	return s.cons(j)
+}
+
+// cvc reports whether b[i-2:i+1] is consonant-vowel-consonant and the last
+// consonant isn't w, x or y. It's used to restore an e in words like hop(e).
+func (s *stemmer) cvc(i int) bool {
+	if i < 2 || !s.cons(i) || s.cons(i-1) || !s.cons(i-2) {
+		return false
+	}
+
+	switch s.b[i] {
+	case 'w', 'x', 'y':
+		return false
+	}
+
+	return true
+}
+
+// ends reports whether the word ends with suffix and if so sets j to the end
+// of the stem before it.
+func (s *stemmer) ends(suffix string) bool {
+	r := []rune(suffix)
+	if len(r) > s.k+1 {
+		return false
+	}
+
+	if string(s.b[s.k-len(r)+1:s.k+1]) != suffix {
+		return false
+	}
+
+	s.j = s.k - len(r)
+	return true
+}
+
+// setto replaces everything after j with replacement.
+func (s *stemmer) setto(replacement string) {
+	s.b = append(s.b[:s.j+1:s.j+1], []rune(replacement)...)
+	s.k = len(s.b) - 1
+}
+
+// r replaces the matched suffix if the stem has a measure greater than zero.
+func (s *stemmer) r(replacement string) {
+	if s.m() > 0 {
+		s.setto(replacement)
+	}
+}
+
+// step1ab removes plurals, -ed and -ing.
+func (s *stemmer) step1ab() {
+	if s.b[s.k] == 's' {
+		if s.ends("sses") {
+			s.k -= 2
+		} else if s.ends("ies") {
+			s.setto("i")
+		} else if s.b[s.k-1] != 's' {
+			s.k--
+		}
+	}
+
+	if s.ends("eed") {
+		if s.m() > 0 {
+			s.k--
+		}
+	} else if (s.ends("ed") || s.ends("ing")) && s.vowelInStem() {
+		s.k = s.j
+		if s.ends("at") {
+			s.setto("ate")
+		} else if s.ends("bl") {
+			s.setto("ble")
+		} else if s.ends("iz") {
+			s.setto("ize")
+		} else if s.doublec(s.k) {
+			s.k--
+			switch s.b[s.k] {
+			case 'l', 's', 'z':
+				s.k++
+			}
+		} else if s.m() == 1 && s.cvc(s.k) {
+			s.setto("e")
+		}
+	}
+}
+
+// step1c turns a terminal y into i when there's another vowel in the stem.
+func (s *stemmer) step1c() {
+	if s.ends("y") && s.vowelInStem() {
+		s.b = append(s.b[:s.k:s.k], 'i')
+	}
+}
+
+// step2 maps double suffixes to single ones, e.g. -ization to -ize.
+func (s *stemmer) step2() {
+	rules := map[rune][][2]string{
+		'a': {{"ational", "ate"}, {"tional", "tion"}},
+		'c': {{"enci", "ence"}, {"anci", "ance"}},
+		'e': {{"izer", "ize"}},
+		'l': {{"bli", "ble"}, {"alli", "al"}, {"entli", "ent"}, {"eli", "e"}, {"ousli", "ous"}},
+		'o': {{"ization", "ize"}, {"ation", "ate"}, {"ator", "ate"}},
+		's': {{"alism", "al"}, {"iveness", "ive"}, {"fulness", "ful"}, {"ousness", "ous"}},
+		't': {{"aliti", "al"}, {"iviti", "ive"}, {"biliti", "ble"}},
+		'g': {{"logi", "log"}},
+	}
+
+	for _, rule := range rules[s.b[s.k-1]] {
+		if s.ends(rule[0]) {
+			s.r(rule[1])
+			return
+		}
+	}
+}
+
+// step3 handles -ic-, -full, -ness and similar.
+func (s *stemmer) step3() {
+	rules := map[rune][][2]string{
+		'e': {{"icate", "ic"}, {"ative", ""}, {"alize", "al"}},
+		'i': {{"iciti", "ic"}},
+		'l': {{"ical", "ic"}, {"ful", ""}},
+		's': {{"ness", ""}},
+	}
+
+	for _, rule := range rules[s.b[s.k]] {
+		if s.ends(rule[0]) {
+			s.r(rule[1])
+			return
+		}
+	}
+}
+
+// step4 removes -ant, -ence and similar when the stem is long enough.
+func (s *stemmer) step4() {
+	suffixes := map[rune][]string{
+		'a': {"al"},
+		'c': {"ance", "ence"},
+		'e': {"er"},
+		'i': {"ic"},
+		'l': {"able", "ible"},
+		'n': {"ant", "ement", "ment", "ent"},
+		's': {"ism"},
+		't': {"ate", "iti"},
+		'u': {"ous"},
+		'v': {"ive"},
+		'z': {"ize"},
+	}
+
+	matched := false
+	if s.b[s.k-1] == 'o' {
+		if s.ends("ion") && s.j >= 0 && (s.b[s.j] == 's' || s.b[s.j] == 't') {
+			matched = true
+		} else if s.ends("ou") {
+			matched = true
+		}
+	}
+
+	for _, suffix := range suffixes[s.b[s.k-1]] {
+		if s.ends(suffix) {
+			matched = true
+			break
+		}
+	}
+
+	if matched && s.m() > 1 {
+		s.k = s.j
+	}
+}
+
+// step5 removes a final -e and turns -ll into -l when the stem is long enough.
+func (s *stemmer) step5() {
+	s.j = s.k
+	if s.b[s.k] == 'e' {
+		a := s.m()
+		if a > 1 || a == 1 && !s.cvc(s.k-1) {
+			s.k--
+		}
+	}
+
+	if s.b[s.k] == 'l' && s.doublec(s.k) && s.m() > 1 {
+		s.k--
+	}
+}
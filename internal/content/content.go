@@ -0,0 +1,131 @@
+// Package content extracts the text of documents so that files can be searched
+// for by what they say as well as by how they're tagged. Plain text, Markdown,
+// HTML and the text layer of PDFs are supported and are recognised by their
+// extension.
+package content
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// MaxSize is the largest file, in bytes, text is extracted from.
+const MaxSize = 64 << 20
+
+// ErrUnsupported is returned from Extract for a file whose type text can't be
+// extracted from.
+var ErrUnsupported = errors.New("can't extract text from this type of file")
+
+// Content is the text extracted from a file along with the hash the file had
+// when it was extracted, so it can be extracted again once the file changes.
+type Content struct {
+	File int    `json:"file"`
+	Hash string `json:"hash"`
+	Text string `json:"text"`
+}
+
+// extractors are the functions which extract text from each type of file, by
+// extension.
+var extractors = map[string]func([]byte) (string, error){
+	".txt":      extractPlain,
+	".text":     extractPlain,
+	".md":       extractPlain,
+	".markdown": extractPlain,
+	".html":     extractHTML,
+	".htm":      extractHTML,
+	".pdf":      extractPDF,
+}
+
+// Supported reports whether text can be extracted from the file at path,
+// going by its extension.
+func Supported(path string) bool {
+	_, ok := extractors[strings.ToLower(filepath.Ext(path))]
+	return ok
+}
+
+// Extract returns the text of the file at path. It returns ErrUnsupported if
+// the file isn't one of the supported types and an error if it's larger than
+// MaxSize.
+func Extract(path string) (string, error) {
+	extract, ok := extractors[strings.ToLower(filepath.Ext(path))]
+	if !ok {
+		return "", fmt.Errorf("%s: %w", path, ErrUnsupported)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, MaxSize+1))
+	if err != nil {
+		return "", err
+	}
+
+	if len(data) > MaxSize {
+		return "", fmt.Errorf("%s is larger than %d bytes", path, MaxSize)
+	}
+
+	text, err := extract(data)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+
+	return text, nil
+}
+
+// extractPlain returns text as it is. Markdown is read as plain text since
+// its markup is punctuation which searches ignore anyway.
+func extractPlain(data []byte) (string, error) {
+	return strings.ToValidUTF8(string(data), " "), nil
+}
+
+// extractHTML returns the text of an HTML document without its markup,
+// scripts or styles.
+func extractHTML(data []byte) (string, error) {
+	tokenizer := html.NewTokenizer(bytes.NewReader(data))
+	text := strings.Builder{}
+	hidden := 0
+
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if err := tokenizer.Err(); !errors.Is(err, io.EOF) {
+				return "", err
+			}
+			return strings.Join(strings.Fields(text.String()), " "), nil
+		case html.StartTagToken:
+			if name, _ := tokenizer.TagName(); isHidden(name) {
+				hidden++
+			}
+			text.WriteString(" ")
+		case html.EndTagToken:
+			if name, _ := tokenizer.TagName(); isHidden(name) && hidden > 0 {
+				hidden--
+			}
+			text.WriteString(" ")
+		case html.TextToken:
+			if hidden == 0 {
+				text.Write(tokenizer.Text())
+			}
+		}
+	}
+}
+
+// isHidden reports whether an HTML element's text isn't shown to readers.
+func isHidden(name []byte) bool {
+	switch string(name) {
+	case "script", "style", "template", "noscript":
+		return true
+	}
+
+	return false
+}
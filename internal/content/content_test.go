@@ -0,0 +1,163 @@
+package content
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// pdf builds a minimal PDF with one page whose content stream is content,
+// compressed with FlateDecode if compress is set.
+func pdf(t *testing.T, content string, compress bool) string {
+	t.Helper()
+
+	stream := []byte(content)
+	filter := ""
+	if compress {
+		buf := &bytes.Buffer{}
+		w := zlib.NewWriter(buf)
+		if _, err := w.Write(stream); err != nil {
+			t.Fatalf("Failed to compress stream: %s", err)
+		}
+		w.Close()
+		stream = buf.Bytes()
+		filter = " /Filter /FlateDecode"
+	}
+
+	return fmt.Sprintf(`%%PDF-1.4
+1 0 obj
+<< /Type /Catalog /Pages 2 0 R >>
+endobj
+2 0 obj
+<< /Type /Pages /Kids [3 0 R] /Count 1 >>
+endobj
+3 0 obj
+<< /Type /Page /Parent 2 0 R /Resources << /Font << /F1 5 0 R >> >> /Contents 4 0 R >>
+endobj
+4 0 obj
+<< /Length %d%s >>
+stream
+%s
+endstream
+endobj
+5 0 obj
+<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>
+endobj
+%%%%EOF
+`, len(stream), filter, stream)
+}
+
+func TestExtract(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr bool
+		name      string
+		content   string
+		expect    string
+	}{
+		"plain text": {
+			false,
+			"contract.txt",
+			"The supplier shall indemnify the buyer.\n",
+			"The supplier shall indemnify the buyer.\n",
+		},
+		"markdown": {
+			false,
+			"README.MD",
+			"# Indemnity\n\n*Clause 4*",
+			"# Indemnity\n\n*Clause 4*",
+		},
+		"html": {
+			false,
+			"page.html",
+			`<html><head><title>Terms</title><style>p { color: red; }</style></head>
+<body><p>Fish &amp; chips</p><script>alert("hidden")</script><p>indemnity</p></body></html>`,
+			"Terms Fish & chips indemnity",
+		},
+		"pdf": {
+			false,
+			"contract.pdf",
+			pdf(t, "BT /F1 12 Tf 72 712 Td (Indemnity \\(clause 4\\)) Tj T* (applies) Tj ET", false),
+			"Indemnity (clause 4) applies",
+		},
+		"compressed pdf": {
+			false,
+			"contract.pdf",
+			pdf(t, "BT /F1 12 Tf 72 712 Td [(Inde) 20 (mnity) -400 (clause)] TJ ET", true),
+			"Indemnity clause",
+		},
+		"pdf with utf-16 and hex strings": {
+			false,
+			"contract.pdf",
+			pdf(t, "BT /F1 12 Tf <FEFF00E9007400E9> Tj 0 -14 Td <7A6F6F> Tj ET", false),
+			"été zoo",
+		},
+		"not a pdf": {
+			true,
+			"contract.pdf",
+			"indemnity",
+			"",
+		},
+		"unsupported type": {
+			true,
+			"photo.jpg",
+			"indemnity",
+			"",
+		},
+	}
+
+	for name, test := range testMap {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), test.name)
+			if err := os.WriteFile(path, []byte(test.content), 0o644); err != nil {
+				t.Fatalf("Failed to write test file: %s", err)
+			}
+
+			result, err := Extract(path)
+
+			if err != nil && !test.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err)
+			}
+
+			if err == nil && test.shouldErr {
+				t.Fatalf("Expected error but didn't get one")
+			}
+
+			if result != test.expect {
+				t.Fatalf("Result did not match expectation\nResult: %q\nExpected: %q", result, test.expect)
+			}
+		})
+	}
+}
+
+func TestPDFStreamsLimit(t *testing.T) {
+	content := bytes.Repeat([]byte("(a) Tj "), MaxSize/7+1)
+	data := []byte(pdf(t, string(content), true) + pdf(t, string(content), true))
+
+	decoded := 0
+	for _, stream := range pdfStreams(data) {
+		decoded += len(stream)
+	}
+
+	if decoded > MaxSize {
+		t.Fatalf("Expected at most %d bytes to be decoded but got %d", MaxSize, decoded)
+	}
+}
+
+func TestExtractUnsupported(t *testing.T) {
+	_, err := Extract(filepath.Join(t.TempDir(), "photo.jpg"))
+	if !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("Expected ErrUnsupported but got: %v", err)
+	}
+
+	if Supported("photo.jpg") {
+		t.Fatalf("Expected photo.jpg not to be supported")
+	}
+
+	if !Supported("NOTES.Markdown") {
+		t.Fatalf("Expected NOTES.Markdown to be supported")
+	}
+}
@@ -0,0 +1,330 @@
+package content
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// streamStart matches the end of a stream's dictionary and the stream keyword
+// which starts its data.
+var streamStart = regexp.MustCompile(`>>\s*stream\r?\n`)
+
+// extractPDF returns the text drawn by a PDF's content streams. Only streams
+// which are uncompressed or compressed with FlateDecode are read and strings
+// are decoded as UTF-16 if they say so and Latin-1 otherwise, so the text of
+// a font with its own encoding, like a CID font with Identity-H, comes out
+// garbled or not at all. Scanned
+// documents without a text layer have no text.
+func extractPDF(data []byte) (string, error) {
+	if !bytes.HasPrefix(data, []byte("%PDF-")) {
+		return "", errors.New("not a PDF")
+	}
+
+	text := strings.Builder{}
+	for _, stream := range pdfStreams(data) {
+		text.WriteString(pdfText(stream))
+	}
+
+	return strings.Join(strings.Fields(text.String()), " "), nil
+}
+
+// pdfStreams returns the decoded data of every stream in a PDF which could be
+// a page's content. Streams with a type or subtype, like fonts, images and
+// object streams, are skipped along with any using a filter other than
+// FlateDecode. Compressed streams share MaxSize bytes of decoded data between
+// them and are cut short once it runs out, so that a small PDF can't inflate
+// into more than a large one could hold.
+func pdfStreams(data []byte) [][]byte {
+	ret := [][]byte{}
+	remaining := int64(MaxSize)
+	for _, match := range streamStart.FindAllIndex(data, -1) {
+		dictionary := pdfDictionary(data, match[0])
+
+		if bytes.Contains(dictionary, []byte("/Type")) ||
+			bytes.Contains(dictionary, []byte("/Subtype")) ||
+			bytes.Contains(dictionary, []byte("/Length1")) {
+			continue
+		}
+
+		end := bytes.Index(data[match[1]:], []byte("endstream"))
+		if end == -1 {
+			continue
+		}
+		stream := data[match[1] : match[1]+end]
+
+		if bytes.Contains(dictionary, []byte("/Filter")) {
+			if !bytes.Contains(dictionary, []byte("/FlateDecode")) ||
+				bytes.Contains(dictionary, []byte("/DecodeParms")) ||
+				remaining == 0 {
+				continue
+			}
+
+			reader, err := zlib.NewReader(bytes.NewReader(stream))
+			if err != nil {
+				continue
+			}
+
+			// a stream cut short by a bad length still has text worth keeping
+			stream, _ = io.ReadAll(io.LimitReader(reader, remaining))
+			reader.Close()
+			remaining -= int64(len(stream))
+		}
+
+		ret = append(ret, stream)
+	}
+
+	return ret
+}
+
+// pdfDictionary returns the dictionary ending with the >> at end.
+func pdfDictionary(data []byte, end int) []byte {
+	depth := 0
+	for i := end + 1; i > 0; i-- {
+		switch {
+		case data[i] == '>' && data[i-1] == '>':
+			depth++
+			i--
+		case data[i] == '<' && data[i-1] == '<':
+			depth--
+			i--
+			if depth == 0 {
+				return data[i : end+2]
+			}
+		}
+	}
+
+	return nil
+}
+
+// pdfText returns the text shown by the text operators in a content stream.
+// Moving to a new line, and a large enough gap between the parts of a TJ
+// array, become spaces so that words drawn separately stay apart.
+func pdfText(stream []byte) string {
+	text := strings.Builder{}
+	shown := []string{}
+	inArray := false
+
+	for i := 0; i < len(stream); {
+		c := stream[i]
+		switch {
+		case isPDFSpace(c):
+			i++
+		case c == '%':
+			for i < len(stream) && stream[i] != '\n' && stream[i] != '\r' {
+				i++
+			}
+		case c == '(':
+			var s []byte
+			s, i = pdfLiteralString(stream, i)
+			shown = append(shown, decodePDFString(s))
+		case c == '<' && i+1 < len(stream) && stream[i+1] == '<':
+			i += 2
+		case c == '<':
+			var s []byte
+			s, i = pdfHexString(stream, i)
+			shown = append(shown, decodePDFString(s))
+		case c == '[':
+			inArray = true
+			i++
+		case c == ']':
+			inArray = false
+			i++
+		case c == '/' || c == '>' || c == '{' || c == '}' || c == ')':
+			i++
+			for i < len(stream) && isPDFRegular(stream[i]) {
+				i++
+			}
+		default:
+			start := i
+			for i < len(stream) && isPDFRegular(stream[i]) {
+				i++
+			}
+			if i == start {
+				i++
+				continue
+			}
+			word := string(stream[start:i])
+
+			if number, err := strconv.ParseFloat(word, 64); err == nil {
+				// TJ adjustments are in thousandths of the font size and
+				// anything wider than a narrow space is a gap between words
+				if inArray && number < -200 {
+					shown = append(shown, " ")
+				}
+				continue
+			}
+
+			switch word {
+			case "Tj", "TJ":
+				text.WriteString(strings.Join(shown, ""))
+			case "'", `"`:
+				text.WriteString(" " + strings.Join(shown, ""))
+			case "Td", "TD", "Tm", "T*", "ET":
+				text.WriteString(" ")
+			case "BI":
+				// inline images are binary and end at the first EI on its own
+				end := bytes.Index(stream[i:], []byte("EI"))
+				for end != -1 && i+end+2 < len(stream) && !isPDFSpace(stream[i+end+2]) {
+					next := bytes.Index(stream[i+end+2:], []byte("EI"))
+					if next == -1 {
+						end = -1
+						break
+					}
+					end += next + 2
+				}
+				if end == -1 {
+					i = len(stream)
+				} else {
+					i += end + 2
+				}
+			}
+			shown = shown[:0]
+		}
+	}
+
+	return text.String()
+}
+
+// pdfLiteralString returns the bytes of the literal string starting at the (
+// at start, with its escapes undone, and the index just after it.
+func pdfLiteralString(stream []byte, start int) ([]byte, int) {
+	ret := []byte{}
+	depth := 0
+
+	i := start
+	for i < len(stream) {
+		c := stream[i]
+		i++
+
+		switch c {
+		case '(':
+			depth++
+			if depth == 1 {
+				continue
+			}
+		case ')':
+			depth--
+			if depth == 0 {
+				return ret, i
+			}
+		case '\\':
+			if i >= len(stream) {
+				return ret, i
+			}
+			escaped := stream[i]
+			i++
+
+			switch escaped {
+			case 'n':
+				ret = append(ret, '\n')
+			case 'r':
+				ret = append(ret, '\r')
+			case 't':
+				ret = append(ret, '\t')
+			case 'b':
+				ret = append(ret, '\b')
+			case 'f':
+				ret = append(ret, '\f')
+			case '\r':
+				// a backslash at the end of a line continues the string
+				if i < len(stream) && stream[i] == '\n' {
+					i++
+				}
+			case '\n':
+			default:
+				if escaped >= '0' && escaped <= '7' {
+					value := int(escaped - '0')
+					for digits := 1; digits < 3 && i < len(stream) && stream[i] >= '0' && stream[i] <= '7'; digits++ {
+						value = value*8 + int(stream[i]-'0')
+						i++
+					}
+					ret = append(ret, byte(value))
+				} else {
+					ret = append(ret, escaped)
+				}
+			}
+			continue
+		}
+
+		ret = append(ret, c)
+	}
+
+	return ret, i
+}
+
+// pdfHexString returns the bytes of the hex string starting at the < at start
+// and the index just after it.
+func pdfHexString(stream []byte, start int) ([]byte, int) {
+	digits := []byte{}
+
+	i := start + 1
+	for i < len(stream) && stream[i] != '>' {
+		if isHexDigit(stream[i]) {
+			digits = append(digits, stream[i])
+		}
+		i++
+	}
+
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+
+	ret := []byte{}
+	for j := 0; j < len(digits); j += 2 {
+		value, _ := strconv.ParseUint(string(digits[j:j+2]), 16, 8)
+		ret = append(ret, byte(value))
+	}
+
+	return ret, i + 1
+}
+
+// decodePDFString decodes a string which starts with a UTF-16 byte order mark
+// as UTF-16 and anything else as Latin-1, which is close enough to the
+// encodings PDFs use for text, dropping control characters.
+func decodePDFString(s []byte) string {
+	runes := []rune{}
+	if len(s) >= 2 && s[0] == 0xfe && s[1] == 0xff {
+		units := []uint16{}
+		for i := 2; i+1 < len(s); i += 2 {
+			units = append(units, uint16(s[i])<<8|uint16(s[i+1]))
+		}
+		runes = utf16.Decode(units)
+	} else {
+		for _, b := range s {
+			runes = append(runes, rune(b))
+		}
+	}
+
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, string(runes))
+}
+
+func isPDFSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n', '\f', 0:
+		return true
+	}
+
+	return false
+}
+
+// isPDFRegular reports whether a byte can be part of a name, number or
+// operator.
+func isPDFRegular(c byte) bool {
+	return !isPDFSpace(c) && !strings.ContainsRune("()<>[]{}/%", rune(c))
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
@@ -0,0 +1,276 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/whatsfordinner/fstagger/internal/aliases"
+	"github.com/whatsfordinner/fstagger/internal/tags"
+
+	"github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// querier is satisfied by both *sql.DB and *sql.Tx so lookups can be shared
+// between plain reads and transactions.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// AddAliases takes a slice of aliases and adds them to the datastore. If a tag
+// already exists with the same name as a new alias then that tag is merged
+// into the alias's tag first, the same as MergeTags, so that its files keep
+// turning up under the name they were tagged with. Each alias is added or not
+// independently of the others.
+func (tagDB *TagDB) AddAliases(ctx context.Context, newAliases []aliases.Alias) ([]aliases.Alias, error) {
+	const (
+		targetString = "SELECT id, namespace, name FROM tags WHERE id = ?"
+		searchString = "SELECT id, namespace, name FROM tags WHERE namespace = ? AND name = ?"
+		insertString = "INSERT INTO tagaliases(namespace, name, tagid) VALUES(?, ?, ?)"
+	)
+
+	ctx, span := tracer.Start(ctx, "AddAliases")
+	defer span.End()
+
+	addedAliases := []aliases.Alias{}
+
+	tx, err := tagDB.client.BeginTx(ctx, nil)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return []aliases.Alias{}, err
+	}
+	txErrors := &BatchError{}
+
+	addAlias := func(alias aliases.Alias) error {
+		target := tags.Tag{}
+		row := tx.QueryRowContext(ctx, targetString, alias.Tag)
+		if err := row.Scan(&target.Id, &target.Namespace, &target.Name); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				err = fmt.Errorf("tag does not exist with id: %d: %w", alias.Tag, err)
+			}
+			return err
+		}
+
+		if target.Namespace == alias.Namespace && target.Name == alias.Name {
+			return fmt.Errorf("can't make %s an alias of itself", alias)
+		}
+
+		if smart, err := isSmartTag(ctx, tx, tags.Tag{Namespace: alias.Namespace, Name: alias.Name}); err != nil {
+			return err
+		} else if smart {
+			return fmt.Errorf("can't add alias %s because a smart tag has that name", alias)
+		}
+
+		existing := tags.Tag{}
+		row = tx.QueryRowContext(ctx, searchString, alias.Namespace, alias.Name)
+		err := row.Scan(&existing.Id, &existing.Namespace, &existing.Name)
+		switch {
+		case err == nil:
+			span.AddEvent(fmt.Sprintf("merging existing tag %s into %s", existing, target))
+			if err := tagDB.moveDescendants(ctx, tx, existing, target, true); err != nil {
+				return err
+			}
+
+			if err := tagDB.foldTag(ctx, tx, existing.Id, target.Id); err != nil {
+				return err
+			}
+
+			if err := tagDB.materializeImplications(
+				ctx,
+				tx,
+				"SELECT fileid, tagid, 0 FROM livefiletags WHERE tagid = ?",
+				target.Id,
+			); err != nil {
+				return err
+			}
+		case !errors.Is(err, sql.ErrNoRows):
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, insertString, alias.Namespace, alias.Name, alias.Tag); err != nil {
+			if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.Code == sqlite3.ErrConstraint {
+				return fmt.Errorf("alias already exists: %s", alias)
+			}
+			return err
+		}
+
+		return nil
+	}
+
+	for i, alias := range newAliases {
+		span.AddEvent(fmt.Sprintf("adding alias %s for tag ID %d", alias, alias.Tag))
+
+		// merging an existing tag touches several rows so each alias is added
+		// inside a savepoint which can be discarded without losing the others
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT add_alias"); err != nil {
+			txErrors.add(i, err)
+			continue
+		}
+
+		err := addAlias(alias)
+		if err != nil {
+			if _, rollbackErr := tx.ExecContext(ctx, "ROLLBACK TO add_alias"); rollbackErr != nil {
+				err = errors.Join(err, rollbackErr)
+			}
+		}
+
+		if _, releaseErr := tx.ExecContext(ctx, "RELEASE add_alias"); releaseErr != nil {
+			err = errors.Join(err, releaseErr)
+		}
+
+		if err != nil {
+			txErrors.add(i, err)
+			continue
+		}
+
+		addedAliases = append(addedAliases, alias)
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			err = errors.Join(err, rollbackErr)
+		}
+		span.SetStatus(codes.Error, err.Error())
+		return []aliases.Alias{}, err
+	}
+
+	if len(txErrors.Errors) > 0 {
+		span.SetStatus(codes.Error, "encountered DB errors during transaction")
+	} else {
+		span.SetStatus(codes.Ok, "")
+	}
+
+	return addedAliases, txErrors.errOrNil()
+}
+
+// DeleteAliases takes a slice of aliases and removes them from the datastore.
+// Aliases are matched by namespace and name and the tag they point at is left
+// untouched.
+func (tagDB *TagDB) DeleteAliases(ctx context.Context, deleteAliases []aliases.Alias) error {
+	const (
+		deleteString = "DELETE FROM tagaliases WHERE namespace = ? AND name = ?"
+	)
+
+	ctx, span := tracer.Start(ctx, "DeleteAliases")
+	defer span.End()
+
+	tx, err := tagDB.client.BeginTx(ctx, nil)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	txErrors := &BatchError{}
+
+	for i, alias := range deleteAliases {
+		res, err := tx.ExecContext(ctx, deleteString, alias.Namespace, alias.Name)
+		if err != nil {
+			txErrors.add(i, err)
+			continue
+		}
+
+		if deleted, err := res.RowsAffected(); err == nil && deleted == 0 {
+			txErrors.add(i, fmt.Errorf("alias does not exist: %s", alias))
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			err = errors.Join(err, rollbackErr)
+		}
+		span.SetStatus(codes.Error, "encountered error finalising transaction")
+		return err
+	}
+
+	if len(txErrors.Errors) > 0 {
+		span.SetStatus(codes.Error, "encountered DB errors during transaction")
+	} else {
+		span.SetStatus(codes.Ok, "")
+	}
+
+	return txErrors.errOrNil()
+}
+
+// GetAliases returns every alias ordered by namespace and name.
+func (tagDB *TagDB) GetAliases(ctx context.Context) ([]aliases.Alias, error) {
+	const (
+		searchString = "SELECT namespace, name, tagid FROM tagaliases ORDER BY namespace, name"
+	)
+
+	ctx, span := tracer.Start(ctx, "GetAliases")
+	defer span.End()
+
+	rows, err := tagDB.client.QueryContext(ctx, searchString)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := []aliases.Alias{}
+
+	for rows.Next() {
+		alias := aliases.Alias{}
+		if err := rows.Scan(&alias.Namespace, &alias.Name, &alias.Tag); err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+		ret = append(ret, alias)
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+// ResolveAliases takes a slice of tags and replaces every tag whose namespace
+// and name are an alias with the tag the alias points at. Tags which aren't
+// aliases are returned unchanged, whether or not they exist, so the output
+// lines up with the input.
+func (tagDB *TagDB) ResolveAliases(ctx context.Context, inputTags []tags.Tag) ([]tags.Tag, error) {
+	ctx, span := tracer.Start(ctx, "ResolveAliases")
+	defer span.End()
+
+	ret := []tags.Tag{}
+
+	for _, tag := range inputTags {
+		resolved, _, err := resolveAlias(ctx, tagDB.client, tag)
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+		ret = append(ret, resolved)
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+// resolveAlias returns the tag an alias points at and true if the provided
+// tag's namespace and name are an alias. Otherwise it returns the provided
+// tag and false.
+func resolveAlias(ctx context.Context, q querier, tag tags.Tag) (tags.Tag, bool, error) {
+	const (
+		searchString = `SELECT t.id, t.namespace, t.name, t.description, COALESCE(t.parent, 0)
+			FROM tagaliases a JOIN tags t ON t.id = a.tagid
+			WHERE a.namespace = ? AND a.name = ?`
+	)
+
+	resolved := tags.Tag{}
+	err := q.QueryRowContext(ctx, searchString, tag.Namespace, tag.Name).Scan(
+		&resolved.Id,
+		&resolved.Namespace,
+		&resolved.Name,
+		&resolved.Description,
+		&resolved.Parent,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return tag, false, nil
+	}
+	if err != nil {
+		return tag, false, err
+	}
+
+	return resolved, true, nil
+}
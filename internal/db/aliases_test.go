@@ -0,0 +1,418 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/whatsfordinner/fstagger/internal/aliases"
+	"github.com/whatsfordinner/fstagger/internal/files"
+	"github.com/whatsfordinner/fstagger/internal/tags"
+)
+
+func TestTagDBAddAliases(t *testing.T) {
+	fixtureAliases := []aliases.Alias{
+		{Name: "doc", Tag: 3},
+		{Name: "img", Tag: 1},
+	}
+
+	testMap := map[string]struct {
+		shouldErr     bool
+		input         []aliases.Alias
+		expect        []aliases.Alias
+		expectAliases []aliases.Alias
+		expectTree    map[string]string
+	}{
+		"new alias": {
+			false,
+			[]aliases.Alias{{Name: "pic", Tag: 1}},
+			[]aliases.Alias{{Name: "pic", Tag: 1}},
+			[]aliases.Alias{
+				{Name: "doc", Tag: 3},
+				{Name: "img", Tag: 1},
+				{Name: "pic", Tag: 1},
+			},
+			map[string]string{
+				"image":       "",
+				"picture":     "",
+				"picture/cat": "picture",
+				"document":    "",
+			},
+		},
+		"alias for an existing tag": {
+			false,
+			[]aliases.Alias{{Name: "picture", Tag: 1}},
+			[]aliases.Alias{{Name: "picture", Tag: 1}},
+			[]aliases.Alias{
+				{Name: "doc", Tag: 3},
+				{Name: "img", Tag: 1},
+				{Name: "picture", Tag: 1},
+			},
+			map[string]string{
+				"image":     "",
+				"image/cat": "image",
+				"document":  "",
+			},
+		},
+		"alias already exists": {
+			true,
+			[]aliases.Alias{{Name: "img", Tag: 3}},
+			[]aliases.Alias{},
+			fixtureAliases,
+			map[string]string{
+				"image":       "",
+				"picture":     "",
+				"picture/cat": "picture",
+				"document":    "",
+			},
+		},
+		"alias of itself": {
+			true,
+			[]aliases.Alias{{Name: "image", Tag: 1}},
+			[]aliases.Alias{},
+			fixtureAliases,
+			map[string]string{
+				"image":       "",
+				"picture":     "",
+				"picture/cat": "picture",
+				"document":    "",
+			},
+		},
+		"tag doesn't exist": {
+			true,
+			[]aliases.Alias{{Name: "pic", Tag: 9}},
+			[]aliases.Alias{},
+			fixtureAliases,
+			map[string]string{
+				"image":       "",
+				"picture":     "",
+				"picture/cat": "picture",
+				"document":    "",
+			},
+		},
+		"some valid aliases": {
+			true,
+			[]aliases.Alias{{Name: "pic", Tag: 1}, {Name: "doc", Tag: 1}},
+			[]aliases.Alias{{Name: "pic", Tag: 1}},
+			[]aliases.Alias{
+				{Name: "doc", Tag: 3},
+				{Name: "img", Tag: 1},
+				{Name: "pic", Tag: 1},
+			},
+			map[string]string{
+				"image":       "",
+				"picture":     "",
+				"picture/cat": "picture",
+				"document":    "",
+			},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/aliases.yml"})
+			defer teardown()
+
+			res, err := testDB.AddAliases(context.Background(), testData.input)
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+
+			dbAliases, err := testDB.GetAliases(context.Background())
+			if err != nil {
+				t.Fatalf("Error retrieving aliases: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(dbAliases, testData.expectAliases) {
+				t.Fatalf(
+					"DB did not match expectation\nResult: %+v\nExpected: %+v",
+					dbAliases,
+					testData.expectAliases,
+				)
+			}
+
+			tree := tagTree(t, testDB)
+			if !reflect.DeepEqual(tree, testData.expectTree) {
+				t.Fatalf(
+					"DB did not match expectation\nResult: %+v\nExpected: %+v",
+					tree,
+					testData.expectTree,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBAddAliasesMigratesLinks(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/aliases.yml"})
+	defer teardown()
+
+	if _, err := testDB.AddAliases(
+		context.Background(),
+		[]aliases.Alias{{Name: "picture", Tag: 1}},
+	); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	res, err := testDB.GetFilesByTags(context.Background(), []string{"image"})
+	if err != nil {
+		t.Fatalf("Error searching for files: %s", err.Error())
+	}
+
+	expect := []files.File{
+		{Id: 2, Path: "/path/to/bar", Hash: "barhash"},
+		{Id: 3, Path: "/path/to/baz", Hash: "bazhash"},
+		{Id: 1, Path: "/path/to/foo", Hash: "foohash"},
+	}
+
+	if !reflect.DeepEqual(res, expect) {
+		t.Fatalf(
+			"Result did not match expectation\nResult: %+v\nExpected: %+v",
+			res,
+			expect,
+		)
+	}
+}
+
+func TestTagDBDeleteAliases(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr     bool
+		input         []aliases.Alias
+		expectAliases []aliases.Alias
+	}{
+		"existing alias": {
+			false,
+			[]aliases.Alias{{Name: "img"}},
+			[]aliases.Alias{{Name: "doc", Tag: 3}},
+		},
+		"alias doesn't exist": {
+			true,
+			[]aliases.Alias{{Name: "pic"}, {Name: "doc"}},
+			[]aliases.Alias{{Name: "img", Tag: 1}},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/aliases.yml"})
+			defer teardown()
+
+			err := testDB.DeleteAliases(context.Background(), testData.input)
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			dbAliases, err := testDB.GetAliases(context.Background())
+			if err != nil {
+				t.Fatalf("Error retrieving aliases: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(dbAliases, testData.expectAliases) {
+				t.Fatalf(
+					"DB did not match expectation\nResult: %+v\nExpected: %+v",
+					dbAliases,
+					testData.expectAliases,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBResolveAliases(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/aliases.yml"})
+	defer teardown()
+
+	res, err := testDB.ResolveAliases(context.Background(), []tags.Tag{
+		{Name: "img"},
+		{Name: "image"},
+		{Name: "new", Description: "a new tag"},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	expect := []tags.Tag{
+		{Id: 1, Name: "image", Description: "an image"},
+		{Name: "image"},
+		{Name: "new", Description: "a new tag"},
+	}
+
+	if !reflect.DeepEqual(res, expect) {
+		t.Fatalf(
+			"Result did not match expectation\nResult: %+v\nExpected: %+v",
+			res,
+			expect,
+		)
+	}
+}
+
+func TestTagDBAddTagsAliases(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/aliases.yml"})
+	defer teardown()
+
+	res, err := testDB.AddTags(context.Background(), []tags.Tag{
+		{Name: "img"},
+		{Name: "image"},
+		{Name: "new"},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	expect := []tags.Tag{
+		{Id: 1, Name: "image", Description: "an image"},
+		{Id: 5, Name: "new"},
+	}
+
+	if !reflect.DeepEqual(res, expect) {
+		t.Fatalf(
+			"Result did not match expectation\nResult: %+v\nExpected: %+v",
+			res,
+			expect,
+		)
+	}
+}
+
+func TestTagDBGetTagByNameAliases(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/aliases.yml"})
+	defer teardown()
+
+	res, err := testDB.GetTagByName(context.Background(), "doc")
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	expect := tags.Tag{Id: 3, Name: "document", Description: "a document"}
+	if !reflect.DeepEqual(res, expect) {
+		t.Fatalf(
+			"Result did not match expectation\nResult: %+v\nExpected: %+v",
+			res,
+			expect,
+		)
+	}
+}
+
+func TestTagDBUpdateTagsAliases(t *testing.T) {
+	testMap := map[string]struct {
+		input         tags.Tag
+		expectErr     string
+		expectTag     tags.Tag
+		expectAliases []aliases.Alias
+	}{
+		"another tag's alias": {
+			tags.Tag{Id: 2, Name: "img", Description: "a picture"},
+			"can't rename tag picture to img which is an alias of tag image",
+			tags.Tag{Id: 2, Name: "picture", Description: "a picture"},
+			[]aliases.Alias{{Name: "doc", Tag: 3}, {Name: "img", Tag: 1}},
+		},
+		"its own alias": {
+			tags.Tag{Id: 1, Name: "img", Description: "an image"},
+			"",
+			tags.Tag{Id: 1, Name: "img", Description: "an image"},
+			[]aliases.Alias{{Name: "doc", Tag: 3}, {Name: "image", Tag: 1}},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/aliases.yml"})
+			defer teardown()
+
+			_, err := testDB.UpdateTags(context.Background(), []tags.Tag{testData.input})
+			if testData.expectErr == "" && err != nil {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if testData.expectErr != "" && (err == nil || err.Error() != testData.expectErr) {
+				t.Fatalf("Expected error %q but got: %v", testData.expectErr, err)
+			}
+
+			tag, err := testDB.GetTagById(context.Background(), testData.input.Id)
+			if err != nil {
+				t.Fatalf("Error retrieving tag: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(tag, testData.expectTag) {
+				t.Fatalf(
+					"Tag did not match expectation\nResult: %+v\nExpected: %+v",
+					tag,
+					testData.expectTag,
+				)
+			}
+
+			dbAliases, err := testDB.GetAliases(context.Background())
+			if err != nil {
+				t.Fatalf("Error retrieving aliases: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(dbAliases, testData.expectAliases) {
+				t.Fatalf(
+					"Aliases did not match expectation\nResult: %+v\nExpected: %+v",
+					dbAliases,
+					testData.expectAliases,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBGetFilesByTagsAliases(t *testing.T) {
+	foo := files.File{Id: 1, Path: "/path/to/foo", Hash: "foohash"}
+	baz := files.File{Id: 3, Path: "/path/to/baz", Hash: "bazhash"}
+
+	testMap := map[string]struct {
+		input  []string
+		expect []files.File
+	}{
+		"alias": {
+			[]string{"img"},
+			[]files.File{foo},
+		},
+		"alias and tag": {
+			[]string{"doc", "picture/cat"},
+			[]files.File{baz},
+		},
+		"wildcard matching an alias and its tag": {
+			[]string{"im*"},
+			[]files.File{foo},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/aliases.yml"})
+			defer teardown()
+
+			res, err := testDB.GetFilesByTags(context.Background(), testData.input)
+			if err != nil {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/whatsfordinner/fstagger/internal/files"
+	"github.com/whatsfordinner/fstagger/internal/links"
+	"github.com/whatsfordinner/fstagger/internal/query"
+	"github.com/whatsfordinner/fstagger/internal/smarttags"
+	"github.com/whatsfordinner/fstagger/internal/tags"
+
+	"go.opentelemetry.io/otel/codes"
+)
+
+// asOfTable is the temporary table beginAsOf reconstructs the links into.
+const asOfTable = "asoffiletags"
+
+// beginAsOf starts a transaction holding a temporary table of every link as it
+// was at asOf, with the same columns as livefiletags that queries use. The
+// links are rebuilt from the most recent snapshot taken at or before asOf with
+// every change logged after it up to asOf applied on top, so only the changes
+// since the snapshot are read. Links which had expired by asOf are left out.
+// The log doesn't record where links came from so they have no source.
+// The table is dropped when the transaction is rolled back.
+func (tagDB *TagDB) beginAsOf(ctx context.Context, asOf time.Time) (*sql.Tx, error) {
+	const (
+		createString = `CREATE TEMP TABLE ` + asOfTable + `(
+			fileid INTEGER NOT NULL,
+			tagid INTEGER NOT NULL,
+			value,
+			expires INTEGER,
+			source TEXT NOT NULL DEFAULT '',
+			confidence REAL,
+			PRIMARY KEY(fileid, tagid)
+		) WITHOUT ROWID`
+		rebuildString = `INSERT INTO ` + asOfTable + `(fileid, tagid, value, expires)
+			WITH snapshot AS (
+				SELECT id, logid FROM linksnapshots WHERE at <= ?1 ORDER BY id DESC LIMIT 1
+			), latest AS (
+				SELECT MAX(id) AS id FROM linklog
+				WHERE id > COALESCE((SELECT logid FROM snapshot), 0) AND at <= ?1
+				GROUP BY fileid, tagid
+			), changed AS (
+				SELECT l.fileid, l.tagid, l.value, l.expires, l.removed
+				FROM linklog l JOIN latest USING (id)
+			)
+			SELECT fileid, tagid, value, expires FROM (
+				SELECT s.fileid, s.tagid, s.value, s.expires FROM linksnapshotrows s
+				WHERE s.snapshotid = (SELECT id FROM snapshot)
+				AND NOT EXISTS (SELECT 1 FROM changed c WHERE c.fileid = s.fileid AND c.tagid = s.tagid)
+				UNION ALL
+				SELECT fileid, tagid, value, expires FROM changed WHERE NOT removed
+			)
+			WHERE expires IS NULL OR expires > ?1`
+	)
+
+	tx, err := tagDB.client.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, statement := range []struct {
+		query string
+		args  []any
+	}{
+		{createString, nil},
+		{rebuildString, []any{asOf.Unix()}},
+	} {
+		if _, err := tx.ExecContext(ctx, statement.query, statement.args...); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				err = errors.Join(err, rollbackErr)
+			}
+			return nil, err
+		}
+	}
+
+	return tx, nil
+}
+
+// GetFilesByQueryAsOf returns every file which matched a query expression at
+// asOf, ordered by path. It matches the same way as GetFilesByQuery but against
+// the links files had at the time. Tags, aliases, smart tags and implications
+// are used as they are now, so a tag which has since been deleted can't be
+// searched for.
+func (tagDB *TagDB) GetFilesByQueryAsOf(ctx context.Context, expr query.Expr, asOf time.Time) ([]files.File, error) {
+	ctx, span := tracer.Start(ctx, "GetFilesByQueryAsOf")
+	defer span.End()
+
+	tx, err := tagDB.beginAsOf(ctx, asOf)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	compiler, err := tagDB.newQueryCompiler(ctx, tx)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	compiler.links = asOfTable
+
+	ret, err := getFilesByQuery(ctx, tx, compiler, expr)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+// GetTagsForFileAsOf returns every tag attached to the provided file at asOf,
+// ordered by namespace and name. A tag which has since been deleted is named
+// the way the history last recorded it. Only the file's ID is used for the
+// search.
+func (tagDB *TagDB) GetTagsForFileAsOf(ctx context.Context, file files.File, asOf time.Time) ([]tags.Tag, error) {
+	const (
+		searchString = `SELECT ft.tagid, t.namespace, t.name, COALESCE(t.description, ''), COALESCE(t.parent, 0),
+				(SELECT h.tag FROM history h WHERE h.tagid = ft.tagid AND h.tag IS NOT NULL ORDER BY h.id DESC LIMIT 1)
+			FROM ` + asOfTable + ` ft LEFT JOIN tags t ON t.id = ft.tagid
+			WHERE ft.fileid = ?`
+	)
+
+	ctx, span := tracer.Start(ctx, "GetTagsForFileAsOf")
+	defer span.End()
+
+	tx, err := tagDB.beginAsOf(ctx, asOf)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, searchString, file.Id)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := []tags.Tag{}
+	for rows.Next() {
+		tag := tags.Tag{}
+		var namespace, name, recorded sql.NullString
+		if err := rows.Scan(&tag.Id, &namespace, &name, &tag.Description, &tag.Parent, &recorded); err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+
+		if name.Valid {
+			tag.Namespace, tag.Name = namespace.String, name.String
+		} else {
+			deleted := tags.Parse(recorded.String)
+			tag.Namespace, tag.Name = deleted.Namespace, deleted.Name
+		}
+
+		ret = append(ret, tag)
+	}
+
+	if err := rows.Err(); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Namespace != ret[j].Namespace {
+			return ret[i].Namespace < ret[j].Namespace
+		}
+		return ret[i].Name < ret[j].Name
+	})
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+// GetLinksForFileAsOf returns every link on a file at asOf, including their
+// values but not their sources, ordered by tag ID. Only the file's ID is used
+// for the search.
+func (tagDB *TagDB) GetLinksForFileAsOf(ctx context.Context, targetFile files.File, asOf time.Time) ([]links.Link, error) {
+	const (
+		searchString = "SELECT " + linkColumns + " FROM " + asOfTable + " WHERE fileid = ? ORDER BY tagid"
+	)
+
+	ctx, span := tracer.Start(ctx, "GetLinksForFileAsOf")
+	defer span.End()
+
+	tx, err := tagDB.beginAsOf(ctx, asOf)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, searchString, targetFile.Id)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	ret, err := scanLinks(rows)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+// GetSmartTagsForFileAsOf returns every smart tag the provided file matched at
+// asOf, ordered by namespace and name. Smart tags' queries are used as they
+// are now. Only the file's ID is used for the search.
+func (tagDB *TagDB) GetSmartTagsForFileAsOf(ctx context.Context, file files.File, asOf time.Time) ([]smarttags.SmartTag, error) {
+	ctx, span := tracer.Start(ctx, "GetSmartTagsForFileAsOf")
+	defer span.End()
+
+	tx, err := tagDB.beginAsOf(ctx, asOf)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	compiler, err := tagDB.newQueryCompiler(ctx, tx)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	compiler.links = asOfTable
+
+	ret, err := getSmartTagsForFile(ctx, tx, compiler, file)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
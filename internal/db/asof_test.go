@@ -0,0 +1,193 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/whatsfordinner/fstagger/internal/files"
+	"github.com/whatsfordinner/fstagger/internal/links"
+	"github.com/whatsfordinner/fstagger/internal/query"
+)
+
+func TestTagDBGetFilesByQueryAsOf(t *testing.T) {
+	testMap := map[string]struct {
+		query  string
+		asOf   int64
+		expect []string
+	}{
+		"before anything was tagged": {"release", 500, []string{}},
+		"after the first release":    {"release", 1500, []string{"/path/to/foo"}},
+		"after the release moved":    {"release", 3200, []string{"/path/to/bar"}},
+		"after the release expired":  {"release", 4000, []string{}},
+		"combining tags":             {"release and draft", 2200, []string{"/path/to/foo"}},
+		"negating tags":              {"draft and not release", 2200, []string{"/path/to/bar"}},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/asof.yml"})
+			defer teardown()
+
+			expr, err := query.Parse(testData.query)
+			if err != nil {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			res, err := testDB.GetFilesByQueryAsOf(context.Background(), expr, time.Unix(testData.asOf, 0))
+			if err != nil {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(filePaths(res), testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					filePaths(res),
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBGetTagsForFileAsOf(t *testing.T) {
+	testMap := map[string]struct {
+		asOf   int64
+		expect []string
+	}{
+		"before anything was tagged":   {500, []string{}},
+		"with a tag since deleted":     {1500, []string{"old", "release"}},
+		"with every tag":               {2200, []string{"draft", "old", "release"}},
+		"after the tag was deleted":    {2600, []string{"draft", "release"}},
+		"after the release was pulled": {3200, []string{"draft"}},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/asof.yml"})
+			defer teardown()
+
+			res, err := testDB.GetTagsForFileAsOf(context.Background(), files.File{Id: 1}, time.Unix(testData.asOf, 0))
+			if err != nil {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			names := []string{}
+			for _, tag := range res {
+				names = append(names, tag.String())
+			}
+
+			if !reflect.DeepEqual(names, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					names,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBGetLinksForFileAsOf(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/asof.yml"})
+	defer teardown()
+
+	res, err := testDB.GetLinksForFileAsOf(context.Background(), files.File{Id: 1}, time.Unix(2200, 0))
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	expect := []links.Link{{File: 1, Tag: 1}, {File: 1, Tag: 2, Value: "3"}, {File: 1, Tag: 3}}
+	if !reflect.DeepEqual(res, expect) {
+		t.Fatalf("Result did not match expectation\nResult: %+v\nExpected: %+v", res, expect)
+	}
+}
+
+// TestTagDBAsOfSnapshots checks that a snapshot is taken once enough links
+// have changed and that the links can be rebuilt from it without the changes
+// logged before it.
+func TestTagDBAsOfSnapshots(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/get_tags_no_tags.yml"})
+	defer teardown()
+
+	ctx := context.Background()
+	if _, err := testDB.client.ExecContext(ctx, `
+		INSERT INTO tags(id, name, description) VALUES (1, 'bulk', '');
+		WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 1200)
+		INSERT INTO files(id, path, hash) SELECT i, '/path/to/' || i, 'hash' || i FROM n;
+		INSERT INTO filetags(fileid, tagid) SELECT id, 1 FROM files;
+		DELETE FROM filetags WHERE fileid > 2;
+	`); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	var snapshots int
+	if err := testDB.client.QueryRowContext(ctx, "SELECT COUNT(*) FROM linksnapshots").Scan(&snapshots); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	if snapshots != 2 {
+		t.Fatalf("Result did not match expectation\nResult: %+v\nExpected: %+v", snapshots, 2)
+	}
+
+	if _, err := testDB.client.ExecContext(
+		ctx,
+		"DELETE FROM linklog WHERE id <= (SELECT MAX(logid) FROM linksnapshots)",
+	); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	expr, err := query.Parse("bulk")
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	res, err := testDB.GetFilesByQueryAsOf(ctx, expr, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	expect := []string{"/path/to/1", "/path/to/2"}
+	if !reflect.DeepEqual(filePaths(res), expect) {
+		t.Fatalf("Result did not match expectation\nResult: %+v\nExpected: %+v", filePaths(res), expect)
+	}
+}
+
+// TestTagDBAsOfSnapshotInterval checks that snapshots get further apart as the
+// number of links grows, so each snapshot waits for at least as many changes
+// as the last one copied.
+func TestTagDBAsOfSnapshotInterval(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/get_tags_no_tags.yml"})
+	defer teardown()
+
+	ctx := context.Background()
+	if _, err := testDB.client.ExecContext(ctx, `
+		INSERT INTO tags(id, name, description) VALUES (1, 'bulk', '');
+		WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 3500)
+		INSERT INTO files(id, path, hash) SELECT i, '/path/to/' || i, 'hash' || i FROM n;
+		INSERT INTO filetags(fileid, tagid) SELECT id, 1 FROM files;
+	`); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	rows, err := testDB.client.QueryContext(ctx, "SELECT logid, links FROM linksnapshots ORDER BY id")
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+	defer rows.Close()
+
+	res := [][2]int{}
+	for rows.Next() {
+		var snapshot [2]int
+		if err := rows.Scan(&snapshot[0], &snapshot[1]); err != nil {
+			t.Fatalf("Expected no error but got: %s", err.Error())
+		}
+		res = append(res, snapshot)
+	}
+
+	expect := [][2]int{{1000, 1000}, {2000, 2000}}
+	if !reflect.DeepEqual(res, expect) {
+		t.Fatalf("Result did not match expectation\nResult: %+v\nExpected: %+v", res, expect)
+	}
+}
@@ -0,0 +1,175 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/whatsfordinner/fstagger/internal/classify"
+
+	"go.opentelemetry.io/otel/codes"
+)
+
+// SaveModel replaces the stored classifier with model. Counts for tags which
+// have been deleted since the model was trained are left out. The model is
+// derived from the links so it isn't recorded in the history or journaled.
+func (tagDB *TagDB) SaveModel(ctx context.Context, model *classify.Model) error {
+	const (
+		insertString  = "INSERT INTO classifier(id, documents, trained) VALUES(1, ?, unixepoch())"
+		tagString     = "INSERT INTO classifiertags(tagid, documents) SELECT id, ? FROM tags WHERE id = ?"
+		featureString = "INSERT INTO classifierfeatures(feature, documents) VALUES(?, ?)"
+		countString   = "INSERT INTO classifiercounts(tagid, feature, documents) VALUES(?, ?, ?)"
+	)
+
+	ctx, span := tracer.Start(ctx, "SaveModel")
+	defer span.End()
+
+	tx, err := tagDB.client.BeginTx(ctx, nil)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	save := func() error {
+		// counts go with their tags
+		for _, table := range []string{"classifiertags", "classifierfeatures", "classifier"} {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM "+table); err != nil {
+				return err
+			}
+		}
+
+		if _, err := tx.ExecContext(ctx, insertString, model.Documents); err != nil {
+			return err
+		}
+
+		for feature, documents := range model.Features {
+			if _, err := tx.ExecContext(ctx, featureString, feature, documents); err != nil {
+				return err
+			}
+		}
+
+		for tag, documents := range model.Tags {
+			res, err := tx.ExecContext(ctx, tagString, documents, tag)
+			if err != nil {
+				return err
+			}
+
+			if inserted, err := res.RowsAffected(); err != nil {
+				return err
+			} else if inserted == 0 {
+				continue
+			}
+
+			for feature, count := range model.Counts[tag] {
+				if _, err := tx.ExecContext(ctx, countString, tag, feature, count); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
+	if err := save(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			err = errors.Join(err, rollbackErr)
+		}
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			err = errors.Join(err, rollbackErr)
+		}
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return nil
+}
+
+// GetModel returns the stored classifier. If a model has never been trained
+// the error wraps sql.ErrNoRows.
+func (tagDB *TagDB) GetModel(ctx context.Context) (*classify.Model, error) {
+	const (
+		modelString   = "SELECT documents FROM classifier WHERE id = 1"
+		tagString     = "SELECT tagid, documents FROM classifiertags"
+		featureString = "SELECT feature, documents FROM classifierfeatures"
+		countString   = "SELECT tagid, feature, documents FROM classifiercounts"
+	)
+
+	ctx, span := tracer.Start(ctx, "GetModel")
+	defer span.End()
+
+	model := &classify.Model{
+		Tags:     map[int]int{},
+		Features: map[string]int{},
+		Counts:   map[int]map[string]int{},
+	}
+
+	if err := tagDB.client.QueryRowContext(ctx, modelString).Scan(&model.Documents); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = fmt.Errorf("no model has been trained: %w", err)
+		}
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	load := func(query string, scan func(*sql.Rows) error) error {
+		rows, err := tagDB.client.QueryContext(ctx, query)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			if err := scan(rows); err != nil {
+				return err
+			}
+		}
+
+		return rows.Err()
+	}
+
+	err := load(tagString, func(rows *sql.Rows) error {
+		var tag, documents int
+		if err := rows.Scan(&tag, &documents); err != nil {
+			return err
+		}
+		model.Tags[tag] = documents
+		model.Counts[tag] = map[string]int{}
+		return nil
+	})
+	if err == nil {
+		err = load(featureString, func(rows *sql.Rows) error {
+			var feature string
+			var documents int
+			if err := rows.Scan(&feature, &documents); err != nil {
+				return err
+			}
+			model.Features[feature] = documents
+			return nil
+		})
+	}
+	if err == nil {
+		err = load(countString, func(rows *sql.Rows) error {
+			var tag, documents int
+			var feature string
+			if err := rows.Scan(&tag, &feature, &documents); err != nil {
+				return err
+			}
+			model.Counts[tag][feature] = documents
+			return nil
+		})
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return model, nil
+}
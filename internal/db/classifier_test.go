@@ -0,0 +1,63 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/whatsfordinner/fstagger/internal/classify"
+	"github.com/whatsfordinner/fstagger/internal/tags"
+)
+
+func TestTagDBSaveModel(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/provenance.yml"})
+	defer teardown()
+
+	if _, err := testDB.GetModel(context.Background()); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("Expected sql.ErrNoRows before a model is trained but got: %v", err)
+	}
+
+	first := classify.Train([]classify.Example{
+		{Path: "a", Features: []string{"word:cat"}, Tags: []int{1}},
+	})
+	if err := testDB.SaveModel(context.Background(), first); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	// saving a model replaces the last one and leaves out tags which don't
+	// exist anymore
+	second := classify.Train([]classify.Example{
+		{Path: "a", Features: []string{"word:dog", "ext:.jpg"}, Tags: []int{2, 3}},
+		{Path: "b", Features: []string{"ext:.jpg"}, Tags: []int{3, 99}},
+	})
+	if err := testDB.SaveModel(context.Background(), second); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	if err := testDB.DeleteTags(context.Background(), []tags.Tag{{Id: 2}}, true); err != nil {
+		t.Fatalf("Unable to delete tag: %s", err.Error())
+	}
+
+	res, err := testDB.GetModel(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	expect := &classify.Model{
+		Documents: 2,
+		Tags:      map[int]int{3: 2},
+		Features:  map[string]int{"word:dog": 1, "ext:.jpg": 2},
+		Counts: map[int]map[string]int{
+			3: {"word:dog": 1, "ext:.jpg": 2},
+		},
+	}
+	if !reflect.DeepEqual(res, expect) {
+		t.Fatalf(
+			"Result did not match expectation\nResult: %+v\nExpected: %+v",
+			res,
+			expect,
+		)
+	}
+}
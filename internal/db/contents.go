@@ -0,0 +1,251 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/whatsfordinner/fstagger/internal/content"
+	"github.com/whatsfordinner/fstagger/internal/files"
+
+	"go.opentelemetry.io/otel/codes"
+)
+
+// SetContents takes a slice of contents and writes each one as the text of its
+// file, replacing the text the file already has if there is one. A file which
+// isn't tracked is an error wrapping sql.ErrNoRows. Contents are extracted
+// from files rather than written by people so they aren't recorded in the
+// history or journaled. Each content is written or not independently of the
+// others.
+func (tagDB *TagDB) SetContents(ctx context.Context, newContents []content.Content) error {
+	const (
+		fileString   = "SELECT 1 FROM files WHERE id = ?"
+		upsertString = `INSERT INTO filecontents(fileid, hash, text, extracted) VALUES(?, ?, ?, unixepoch())
+			ON CONFLICT(fileid) DO UPDATE SET hash = excluded.hash, text = excluded.text, extracted = excluded.extracted`
+	)
+
+	ctx, span := tracer.Start(ctx, "SetContents")
+	defer span.End()
+
+	tx, err := tagDB.client.BeginTx(ctx, nil)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	txErrors := &BatchError{}
+
+	for i, newContent := range newContents {
+		span.AddEvent(fmt.Sprintf("setting contents of file ID %d", newContent.File))
+
+		var exists int
+		if err := tx.QueryRowContext(ctx, fileString, newContent.File).Scan(&exists); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				err = fmt.Errorf("file does not exist with id: %d: %w", newContent.File, err)
+			}
+			txErrors.add(i, err)
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, upsertString, newContent.File, newContent.Hash, newContent.Text); err != nil {
+			txErrors.add(i, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			err = errors.Join(err, rollbackErr)
+		}
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	if len(txErrors.Errors) > 0 {
+		span.SetStatus(codes.Error, "encountered DB errors during transaction")
+	} else {
+		span.SetStatus(codes.Ok, "")
+	}
+
+	return txErrors.errOrNil()
+}
+
+// DeleteContents takes a slice of files and removes their text so they're no
+// longer found by what they say. Only the file's ID is used. A file without
+// any text is an error wrapping sql.ErrNoRows.
+func (tagDB *TagDB) DeleteContents(ctx context.Context, deleteFiles []files.File) error {
+	const (
+		deleteString = "DELETE FROM filecontents WHERE fileid = ?"
+	)
+
+	ctx, span := tracer.Start(ctx, "DeleteContents")
+	defer span.End()
+
+	tx, err := tagDB.client.BeginTx(ctx, nil)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	txErrors := &BatchError{}
+
+	for i, file := range deleteFiles {
+		span.AddEvent(fmt.Sprintf("removing contents of file ID %d", file.Id))
+
+		res, err := tx.ExecContext(ctx, deleteString, file.Id)
+		if err != nil {
+			txErrors.add(i, err)
+			continue
+		}
+
+		if deleted, err := res.RowsAffected(); err != nil {
+			txErrors.add(i, err)
+		} else if deleted == 0 {
+			txErrors.add(i, fmt.Errorf("no contents for file ID %d: %w", file.Id, sql.ErrNoRows))
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			err = errors.Join(err, rollbackErr)
+		}
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	if len(txErrors.Errors) > 0 {
+		span.SetStatus(codes.Error, "encountered DB errors during transaction")
+	} else {
+		span.SetStatus(codes.Ok, "")
+	}
+
+	return txErrors.errOrNil()
+}
+
+// GetContentsForFile returns the text extracted from a file and the hash it
+// was extracted from. A file without any text is an error wrapping
+// sql.ErrNoRows. Only the file's ID is used for the search.
+func (tagDB *TagDB) GetContentsForFile(ctx context.Context, file files.File) (content.Content, error) {
+	const (
+		searchString = "SELECT fileid, hash, text FROM filecontents WHERE fileid = ?"
+	)
+
+	ctx, span := tracer.Start(ctx, "GetContentsForFile")
+	defer span.End()
+
+	ret := content.Content{}
+	if err := tagDB.client.QueryRowContext(ctx, searchString, file.Id).Scan(&ret.File, &ret.Hash, &ret.Text); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = fmt.Errorf("no contents for file ID %d: %w", file.Id, err)
+		}
+		span.SetStatus(codes.Error, err.Error())
+		return content.Content{}, err
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+// GetFilesMentioning returns every file whose text contains all of the words
+// in search, ordered by path. Words match the start of words in the text,
+// ignoring case and punctuation, the same way as FindTags. With FTS5 the
+// search uses the index, otherwise files are narrowed down with LIKE and then
+// checked word by word.
+func (tagDB *TagDB) GetFilesMentioning(ctx context.Context, search string) ([]files.File, error) {
+	ctx, span := tracer.Start(ctx, "GetFilesMentioning")
+	defer span.End()
+
+	words := textWords(search)
+	if len(words) == 0 {
+		span.SetStatus(codes.Ok, "")
+		return []files.File{}, nil
+	}
+
+	var ret []files.File
+	var err error
+	if tagDB.fullText {
+		ret, err = tagDB.getFilesMentioningFullText(ctx, words)
+	} else {
+		ret, err = tagDB.getFilesMentioningLike(ctx, words)
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+func (tagDB *TagDB) getFilesMentioningFullText(ctx context.Context, words []string) ([]files.File, error) {
+	const (
+		searchString = `SELECT f.id, f.path, f.hash
+			FROM contentsearch JOIN files f ON f.id = contentsearch.rowid
+			WHERE contentsearch MATCH ?
+			ORDER BY f.path`
+	)
+
+	terms := []string{}
+	for _, word := range words {
+		terms = append(terms, `"`+word+`"*`)
+	}
+
+	rows, err := tagDB.client.QueryContext(ctx, searchString, strings.Join(terms, " AND "))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := []files.File{}
+	for rows.Next() {
+		file := files.File{}
+		if err := rows.Scan(&file.Id, &file.Path, &file.Hash); err != nil {
+			return nil, err
+		}
+		ret = append(ret, file)
+	}
+
+	return ret, rows.Err()
+}
+
+func (tagDB *TagDB) getFilesMentioningLike(ctx context.Context, words []string) ([]files.File, error) {
+	const (
+		searchString = `SELECT f.id, f.path, f.hash, c.text
+			FROM filecontents c JOIN files f ON f.id = c.fileid
+			WHERE %s
+			ORDER BY f.path`
+	)
+
+	conditions := []string{}
+	args := []any{}
+	for _, word := range words {
+		conditions = append(conditions, `c.text LIKE ? ESCAPE '\'`)
+		args = append(args, containsPattern(word))
+	}
+
+	rows, err := tagDB.client.QueryContext(
+		ctx,
+		fmt.Sprintf(searchString, strings.Join(conditions, " AND ")),
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := []files.File{}
+	for rows.Next() {
+		file := files.File{}
+		var text string
+		if err := rows.Scan(&file.Id, &file.Path, &file.Hash, &text); err != nil {
+			return nil, err
+		}
+
+		// LIKE finds the words anywhere, including in the middle of other
+		// words, so the candidates are checked the same way FTS5 would
+		if countMatches(text, words) == len(words) {
+			ret = append(ret, file)
+		}
+	}
+
+	return ret, rows.Err()
+}
@@ -0,0 +1,177 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/whatsfordinner/fstagger/internal/content"
+	"github.com/whatsfordinner/fstagger/internal/files"
+)
+
+func TestTagDBSetContents(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr bool
+		input     []content.Content
+		search    string
+		expect    []string
+	}{
+		"adding contents": {
+			false,
+			[]content.Content{{File: 3, Hash: "photohash", Text: "EXIF: taken at the beach"}},
+			"beach",
+			[]string{"/path/to/photo.jpg"},
+		},
+		"replacing contents": {
+			false,
+			[]content.Content{{File: 1, Hash: "newhash", Text: "The buyer bears all risk."}},
+			"indemnify",
+			[]string{},
+		},
+		"contents for a file that doesn't exist": {
+			true,
+			[]content.Content{{File: 4, Hash: "nope", Text: "beach"}, {File: 3, Hash: "photohash", Text: "beach"}},
+			"beach",
+			[]string{"/path/to/photo.jpg"},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/contents.yml"})
+			defer teardown()
+
+			err := testDB.SetContents(context.Background(), testData.input)
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			res, err := testDB.GetFilesMentioning(context.Background(), testData.search)
+			if err != nil {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(filePaths(res), testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					filePaths(res),
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBDeleteContents(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr bool
+		input     []files.File
+		expect    []string
+	}{
+		"removing contents": {
+			false,
+			[]files.File{{Id: 1}},
+			[]string{"/path/to/invoice.html"},
+		},
+		"removing contents a file doesn't have": {
+			true,
+			[]files.File{{Id: 3}, {Id: 2}},
+			[]string{"/path/to/contract.pdf"},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/contents.yml"})
+			defer teardown()
+
+			err := testDB.DeleteContents(context.Background(), testData.input)
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			res, err := testDB.GetFilesMentioning(context.Background(), "buyer")
+			if err != nil {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(filePaths(res), testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					filePaths(res),
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBGetContentsForFile(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/contents.yml"})
+	defer teardown()
+
+	res, err := testDB.GetContentsForFile(context.Background(), files.File{Id: 1})
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	expect := content.Content{
+		File: 1,
+		Hash: "contracthash",
+		Text: "The supplier shall indemnify the buyer against all claims.",
+	}
+	if !reflect.DeepEqual(res, expect) {
+		t.Fatalf("Result did not match expectation\nResult: %+v\nExpected: %+v", res, expect)
+	}
+
+	if _, err := testDB.GetContentsForFile(context.Background(), files.File{Id: 3}); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("Expected sql.ErrNoRows but got: %v", err)
+	}
+}
+
+func TestTagDBGetFilesMentioning(t *testing.T) {
+	testMap := map[string]struct {
+		input  string
+		expect []string
+	}{
+		"one word":                  {"buyer", []string{"/path/to/contract.pdf", "/path/to/invoice.html"}},
+		"every word has to match":   {"indemnify buyer", []string{"/path/to/contract.pdf"}},
+		"words match prefixes":      {"INDEMN", []string{"/path/to/contract.pdf", "/path/to/invoice.html"}},
+		"punctuation is ignored":    {"invoice: #42", []string{"/path/to/invoice.html"}},
+		"words don't match infixes": {"laim", []string{}},
+		"no words":                  {"!!", []string{}},
+		"no matches":                {"warranty", []string{}},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/contents.yml"})
+			defer teardown()
+
+			res, err := testDB.GetFilesMentioning(context.Background(), testData.input)
+			if err != nil {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(filePaths(res), testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					filePaths(res),
+					testData.expect,
+				)
+			}
+		})
+	}
+}
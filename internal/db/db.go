@@ -3,12 +3,15 @@ package db
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"embed"
 	"fmt"
 	"io/fs"
 
+	"github.com/whatsfordinner/fstagger/internal/history"
+
 	"github.com/XSAM/otelsql"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 	"github.com/pressly/goose/v3"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/codes"
@@ -33,6 +36,11 @@ type TagDB struct {
 	connectionString string
 	migrationsFS     fs.FS
 	migrationsDir    string
+	implications     ImplicationMode
+	actor            history.Actor
+	operation        int
+	migrated         bool
+	fullText         bool
 }
 
 func New(options ...func(*TagDB)) *TagDB {
@@ -40,6 +48,7 @@ func New(options ...func(*TagDB)) *TagDB {
 		connectionString: defaultConnectionString,
 		migrationsFS:     defaultMigrationsFS,
 		migrationsDir:    defaultMigrationsDir,
+		implications:     ImplicationsMaterialized,
 	}
 	for _, o := range options {
 		o(tagDB)
@@ -84,14 +93,10 @@ func (tagDB *TagDB) Init(ctx context.Context) error {
 	ctx, span := tracer.Start(ctx, "Init")
 	defer span.End()
 
-	db, err := otelsql.Open("sqlite3", tagDB.connectionString)
-	if err != nil {
-		span.SetStatus(
-			codes.Error,
-			fmt.Sprintf("unable to open sqlite3 file at %s", tagDB.connectionString),
-		)
-		return err
-	}
+	db := otelsql.OpenDB(connector{
+		driver:           &sqlite3.SQLiteDriver{ConnectHook: tagDB.connect},
+		connectionString: tagDB.connectionString,
+	})
 	span.AddEvent(
 		fmt.Sprintf("unable to open sqlite3 file at %s", tagDB.connectionString),
 	)
@@ -119,10 +124,52 @@ func (tagDB *TagDB) Init(ctx context.Context) error {
 		return err
 	}
 
+	if err := tagDB.initJournal(ctx); err != nil {
+		tagDB.Close(ctx)
+		span.SetStatus(
+			codes.Error,
+			err.Error(),
+		)
+		return err
+	}
+
+	if err := tagDB.initFullText(ctx); err != nil {
+		tagDB.Close(ctx)
+		span.SetStatus(
+			codes.Error,
+			err.Error(),
+		)
+		return err
+	}
+
+	if err := tagDB.loadImplicationMode(ctx); err != nil {
+		tagDB.Close(ctx)
+		span.SetStatus(
+			codes.Error,
+			err.Error(),
+		)
+		return err
+	}
+
 	span.SetStatus(codes.Ok, "")
 	return nil
 }
 
+// connector opens connections to the DB with a driver of the TagDB's own, so
+// that the TagDB can prepare each connection as it's opened.
+type connector struct {
+	driver           *sqlite3.SQLiteDriver
+	connectionString string
+}
+
+func (c connector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.connectionString)
+}
+
+func (c connector) Driver() driver.Driver {
+	return c.driver
+}
+
 // Close will clean up the connection to the DB if one's been established
 func (tagDB *TagDB) Close(ctx context.Context) {
 	ctx, span := tracer.Start(ctx, "Close")
@@ -2,9 +2,17 @@ package db
 
 import (
 	"context"
+	"database/sql"
+	"path/filepath"
+	"reflect"
 	"testing"
 
+	"github.com/whatsfordinner/fstagger/internal/files"
+	"github.com/whatsfordinner/fstagger/internal/tags"
+
 	"github.com/go-testfixtures/testfixtures/v3"
+	"github.com/pressly/goose/v3"
+	"go.opentelemetry.io/otel"
 )
 
 // TestTagDBInit does NOT test production functionality of migrations. It only
@@ -117,3 +125,137 @@ func setupDB(t *testing.T, fixtureFiles []string) (*TagDB, func()) {
 		testDB.Close(context.Background())
 	}
 }
+
+// TestMigrationTagNamespaces checks that tags created before namespaces existed
+// are split into a namespace and a name without losing any links.
+func TestMigrationTagNamespaces(t *testing.T) {
+	client, err := sql.Open(
+		"sqlite3",
+		filepath.Join(t.TempDir(), "test.db")+"?_foreign_keys=true",
+	)
+	if err != nil {
+		t.Fatalf("Unable to open test DB: %s", err.Error())
+	}
+	defer client.Close()
+
+	goose.SetBaseFS(defaultMigrationsFS)
+	goose.SetLogger(goose.NopLogger())
+	if err := goose.SetDialect("sqlite3"); err != nil {
+		t.Fatalf("Unable to set dialect: %s", err.Error())
+	}
+
+	if err := goose.UpTo(client, defaultMigrationsDir, 1); err != nil {
+		t.Fatalf("Unable to migrate to base schema: %s", err.Error())
+	}
+
+	if _, err := client.Exec(`
+		INSERT INTO files(id, path, hash) VALUES (1, '/path/to/foo', 'foohash');
+		INSERT INTO tags(id, name, description) VALUES
+			(1, 'project:apollo', 'a project'),
+			(2, 'plain', 'no namespace'),
+			(3, ':odd', 'leading colon'),
+			(4, 'time:12:30', 'two colons');
+		INSERT INTO filetags(fileid, tagid) VALUES (1, 1), (1, 2), (1, 3), (1, 4);
+	`); err != nil {
+		t.Fatalf("Unable to insert test data: %s", err.Error())
+	}
+
+	if err := goose.Up(client, defaultMigrationsDir); err != nil {
+		t.Fatalf("Unable to run migrations: %s", err.Error())
+	}
+
+	testDB := &TagDB{client: client}
+	tracer = otel.GetTracerProvider().Tracer(name)
+
+	res, err := testDB.GetTagsForFile(context.Background(), files.File{Id: 1})
+	if err != nil {
+		t.Fatalf("Error retrieving tags: %s", err.Error())
+	}
+
+	expect := []tags.Tag{
+		{Id: 3, Name: ":odd", Description: "leading colon"},
+		{Id: 2, Name: "plain", Description: "no namespace"},
+		{Id: 1, Namespace: "project", Name: "apollo", Description: "a project"},
+		{Id: 4, Namespace: "time", Name: "12:30", Description: "two colons"},
+	}
+
+	if !reflect.DeepEqual(res, expect) {
+		t.Fatalf(
+			"Result did not match expectation\nResult: %+v\nExpected: %+v",
+			res,
+			expect,
+		)
+	}
+}
+
+// TestMigrationTagHierarchy checks that tags with hierarchical names are given
+// parents, including any ancestors that didn't already exist, when the parent
+// column is added.
+func TestMigrationTagHierarchy(t *testing.T) {
+	client, err := sql.Open(
+		"sqlite3",
+		filepath.Join(t.TempDir(), "test.db")+"?_foreign_keys=true",
+	)
+	if err != nil {
+		t.Fatalf("Unable to open test DB: %s", err.Error())
+	}
+	defer client.Close()
+
+	goose.SetBaseFS(defaultMigrationsFS)
+	goose.SetLogger(goose.NopLogger())
+	if err := goose.SetDialect("sqlite3"); err != nil {
+		t.Fatalf("Unable to set dialect: %s", err.Error())
+	}
+
+	if err := goose.UpTo(client, defaultMigrationsDir, 2); err != nil {
+		t.Fatalf("Unable to migrate to namespaced schema: %s", err.Error())
+	}
+
+	if _, err := client.Exec(`
+		INSERT INTO files(id, path, hash) VALUES (1, '/path/to/foo', 'foohash');
+		INSERT INTO tags(id, namespace, name, description) VALUES
+			(1, '', 'food/dessert/pie', 'a pie'),
+			(2, '', 'food', 'things to eat'),
+			(3, 'project', 'web/api', 'an api'),
+			(4, '', 'odd//name', 'empty segment'),
+			(5, '', '/odd', 'leading slash');
+		INSERT INTO filetags(fileid, tagid) VALUES (1, 1), (1, 3);
+	`); err != nil {
+		t.Fatalf("Unable to insert test data: %s", err.Error())
+	}
+
+	if err := goose.Up(client, defaultMigrationsDir); err != nil {
+		t.Fatalf("Unable to run migrations: %s", err.Error())
+	}
+
+	testDB := &TagDB{client: client}
+	tracer = otel.GetTracerProvider().Tracer(name)
+
+	tree := tagTree(t, testDB)
+	expect := map[string]string{
+		"food":             "",
+		"food/dessert":     "food",
+		"food/dessert/pie": "food/dessert",
+		"project:web":      "",
+		"project:web/api":  "project:web",
+		"odd//name":        "",
+		"/odd":             "",
+	}
+
+	if !reflect.DeepEqual(tree, expect) {
+		t.Fatalf(
+			"Result did not match expectation\nResult: %+v\nExpected: %+v",
+			tree,
+			expect,
+		)
+	}
+
+	res, err := testDB.GetTagsForFile(context.Background(), files.File{Id: 1})
+	if err != nil {
+		t.Fatalf("Error retrieving tags: %s", err.Error())
+	}
+
+	if len(res) != 2 {
+		t.Fatalf("Expected 2 tags on file but got: %+v", res)
+	}
+}
@@ -1,3 +1,84 @@
 package db
 
-import ()
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrFileExists is wrapped by errors returned from AddFiles when a file
+	// collides with one already being tracked.
+	ErrFileExists = errors.New("file already tracked")
+	// ErrLinkExists is wrapped by errors returned from AddLinks when a file
+	// already has the tag being added to it.
+	ErrLinkExists = errors.New("file already has tag")
+	// ErrExclusiveGroup is wrapped by errors returned from AddLinks when a
+	// file already has another tag from an exclusive group whose policy is to
+	// reject new links.
+	ErrExclusiveGroup = errors.New("file already has a tag from an exclusive group")
+	// ErrTagInUse is wrapped by errors returned from DeleteTags when a tag is
+	// still attached to files and the deletion isn't forced.
+	ErrTagInUse = errors.New("tag is still attached to files")
+	// ErrNothingToUndo is returned from Undo when no operation has been done
+	// since the last one was undone, or nothing has been done at all.
+	ErrNothingToUndo = errors.New("nothing to undo")
+	// ErrNothingToRedo is returned from Redo when no operation has been undone
+	// since new work was last done.
+	ErrNothingToRedo = errors.New("nothing to redo")
+)
+
+// BatchError is the custom error described in ADR-006. Every batch operation
+// attempts to process each element of its input and records the error for any
+// element that fails, keyed by that element's index in the input slice. This
+// lets callers report failures against the input that caused them.
+type BatchError struct {
+	Errors map[int]error
+}
+
+func (e *BatchError) Error() string {
+	messages := []string{}
+	for _, i := range e.indices() {
+		messages = append(messages, e.Errors[i].Error())
+	}
+
+	return strings.Join(messages, "\n")
+}
+
+// Unwrap exposes the individual errors so that errors.Is and errors.As can
+// match against any of them.
+func (e *BatchError) Unwrap() []error {
+	ret := []error{}
+	for _, i := range e.indices() {
+		ret = append(ret, e.Errors[i])
+	}
+
+	return ret
+}
+
+func (e *BatchError) indices() []int {
+	ret := []int{}
+	for i := range e.Errors {
+		ret = append(ret, i)
+	}
+	sort.Ints(ret)
+
+	return ret
+}
+
+func (e *BatchError) add(index int, err error) {
+	if e.Errors == nil {
+		e.Errors = map[int]error{}
+	}
+	e.Errors[index] = errors.Join(e.Errors[index], err)
+}
+
+// errOrNil returns the BatchError if any errors were recorded and an untyped
+// nil otherwise so that callers can keep comparing the result against nil.
+func (e *BatchError) errOrNil() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+
+	return e
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestBatchError(t *testing.T) {
+	testMap := map[string]struct {
+		input       map[int]error
+		shouldErr   bool
+		expectError string
+		expectIs    error
+	}{
+		"no errors": {
+			map[int]error{},
+			false,
+			"",
+			nil,
+		},
+		"one error": {
+			map[int]error{
+				3: ErrLinkExists,
+			},
+			true,
+			"file already has tag",
+			ErrLinkExists,
+		},
+		"errors are ordered by index": {
+			map[int]error{
+				5: errors.New("second"),
+				1: ErrFileExists,
+			},
+			true,
+			"file already tracked\nsecond",
+			ErrFileExists,
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			batchErr := &BatchError{}
+			for i, err := range testData.input {
+				batchErr.add(i, err)
+			}
+			err := batchErr.errOrNil()
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if err == nil {
+				return
+			}
+
+			if err.Error() != testData.expectError {
+				t.Fatalf(
+					"Expected error: %s but got: %s",
+					testData.expectError,
+					err.Error(),
+				)
+			}
+
+			if !errors.Is(err, testData.expectIs) {
+				t.Fatalf("Expected error to wrap: %s", testData.expectIs.Error())
+			}
+		})
+	}
+}
@@ -2,11 +2,16 @@ package db
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/whatsfordinner/fstagger/internal/files"
+	"github.com/whatsfordinner/fstagger/internal/history"
+	"github.com/whatsfordinner/fstagger/internal/query"
+	"github.com/whatsfordinner/fstagger/internal/tags"
 
 	"github.com/mattn/go-sqlite3"
 	"go.opentelemetry.io/otel/codes"
@@ -21,7 +26,7 @@ import (
 // and it's impossible to know which was the intended one to keep.
 func (tagDB *TagDB) AddFiles(ctx context.Context, newFiles []files.File) ([]files.File, error) {
 	const (
-		insertString     = "INSERT INTO files(path, hash) VALUES (?, ?) RETURNING id"
+		insertString     = "INSERT INTO files(path, hash, created, updated) VALUES (?, ?, unixepoch(), unixepoch()) RETURNING id"
 		searchPathString = "SELECT id, path, hash FROM files WHERE path = ?"
 		searchHashString = "SELECT id, path, hash FROM files WHERE hash = ?"
 	)
@@ -35,9 +40,9 @@ func (tagDB *TagDB) AddFiles(ctx context.Context, newFiles []files.File) ([]file
 	if err != nil {
 		return []files.File{}, err
 	}
-	txErrors := []error{}
+	txErrors := &BatchError{}
 
-	for _, newFile := range newFiles {
+	for i, newFile := range newFiles {
 		row := tx.QueryRowContext(ctx, insertString, newFile.Path, newFile.Hash)
 		var fileId int64
 		err := row.Scan(&fileId)
@@ -47,35 +52,52 @@ func (tagDB *TagDB) AddFiles(ctx context.Context, newFiles []files.File) ([]file
 					var collidingFile files.File
 					if strings.Contains(sqliteErr.Error(), "path") {
 						row := tx.QueryRowContext(ctx, searchPathString, newFile.Path)
-						if err := row.Scan(&collidingFile); err != nil {
-							txErrors = append(txErrors, err)
+						if err := row.Scan(
+							&collidingFile.Id,
+							&collidingFile.Path,
+							&collidingFile.Hash,
+						); err != nil {
+							txErrors.add(i, err)
 							continue
 						}
 						collisionErr := fmt.Errorf(
 							"file at path %s already being tracked: %w",
 							collidingFile.Path,
-							sqliteErr,
+							ErrFileExists,
 						)
-						txErrors = append(txErrors, collisionErr)
+						txErrors.add(i, collisionErr)
+						continue
 					} else if strings.Contains(sqliteErr.Error(), "hash") {
 						row := tx.QueryRowContext(ctx, searchHashString, newFile.Hash)
-						if err := row.Scan(&collidingFile); err != nil {
-							txErrors = append(txErrors, err)
+						if err := row.Scan(
+							&collidingFile.Id,
+							&collidingFile.Path,
+							&collidingFile.Hash,
+						); err != nil {
+							txErrors.add(i, err)
 							continue
 						}
 						collisionErr := fmt.Errorf(
-							"file with hash %s already being tracked: %w",
+							"file with hash %s already being tracked at path %s: %w",
 							collidingFile.Hash,
-							sqliteErr,
+							collidingFile.Path,
+							ErrFileExists,
 						)
-						txErrors = append(txErrors, collisionErr)
+						txErrors.add(i, collisionErr)
+						continue
 					}
 				}
 			}
-			txErrors = append(txErrors, err)
+			txErrors.add(i, err)
 			continue
 		}
 		newFile.Id = int(fileId)
+
+		if err := tagDB.recordHistory(ctx, tx, history.FileAdded, newFile.Id, 0, ""); err != nil {
+			txErrors.add(i, err)
+			continue
+		}
+
 		addedFiles = append(addedFiles, newFile)
 	}
 
@@ -87,7 +109,84 @@ func (tagDB *TagDB) AddFiles(ctx context.Context, newFiles []files.File) ([]file
 	}
 
 	span.SetStatus(codes.Ok, "")
-	return addedFiles, errors.Join(txErrors...)
+	return addedFiles, txErrors.errOrNil()
+}
+
+// UpdateFileHashes takes a slice of files and writes each one's hash, for
+// when a file's contents have changed since it was tracked. Only the ID and
+// hash are used. A file which isn't tracked is an error wrapping
+// sql.ErrNoRows and a hash which another tracked file already has is an error
+// wrapping ErrFileExists. Each file is updated or not independently of the
+// others.
+func (tagDB *TagDB) UpdateFileHashes(ctx context.Context, updateFiles []files.File) ([]files.File, error) {
+	const (
+		updateString = "UPDATE files SET hash = ?, updated = unixepoch() WHERE id = ? RETURNING path"
+		searchString = "SELECT path FROM files WHERE hash = ?"
+	)
+
+	ctx, span := tracer.Start(ctx, "UpdateFileHashes")
+	defer span.End()
+
+	tx, err := tagDB.client.BeginTx(ctx, nil)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return []files.File{}, err
+	}
+	txErrors := &BatchError{}
+
+	updateFile := func(file files.File) (files.File, error) {
+		if err := tx.QueryRowContext(ctx, updateString, file.Hash, file.Id).Scan(&file.Path); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return files.File{}, fmt.Errorf("file does not exist with id: %d: %w", file.Id, err)
+			}
+
+			if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+				var collidingPath string
+				if err := tx.QueryRowContext(ctx, searchString, file.Hash).Scan(&collidingPath); err != nil {
+					return files.File{}, err
+				}
+				return files.File{}, fmt.Errorf(
+					"file with hash %s already being tracked at path %s: %w",
+					file.Hash,
+					collidingPath,
+					ErrFileExists,
+				)
+			}
+
+			return files.File{}, err
+		}
+
+		return file, tagDB.recordHistory(ctx, tx, history.FileChanged, file.Id, 0, "")
+	}
+
+	updatedFiles := []files.File{}
+	for i, file := range updateFiles {
+		span.AddEvent(fmt.Sprintf("updating hash of file ID %d", file.Id))
+
+		updated, err := updateFile(file)
+		if err != nil {
+			txErrors.add(i, err)
+			continue
+		}
+
+		updatedFiles = append(updatedFiles, updated)
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			err = errors.Join(err, rollbackErr)
+		}
+		span.SetStatus(codes.Error, err.Error())
+		return []files.File{}, err
+	}
+
+	if len(txErrors.Errors) > 0 {
+		span.SetStatus(codes.Error, "encountered DB errors during transaction")
+	} else {
+		span.SetStatus(codes.Ok, "")
+	}
+
+	return updatedFiles, txErrors.errOrNil()
 }
 
 func (tagDB *TagDB) GetFileById(ctx context.Context, search int) (files.File, error) {
@@ -102,3 +201,174 @@ func (tagDB *TagDB) GetFileById(ctx context.Context, search int) (files.File, er
 
 	return ret, nil
 }
+
+// GetFiles returns every file being tracked, ordered by path.
+func (tagDB *TagDB) GetFiles(ctx context.Context) ([]files.File, error) {
+	const (
+		searchString = "SELECT id, path, hash FROM files ORDER BY path"
+	)
+
+	ctx, span := tracer.Start(ctx, "GetFiles")
+	defer span.End()
+
+	rows, err := tagDB.client.QueryContext(ctx, searchString)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := []files.File{}
+	for rows.Next() {
+		file := files.File{}
+		if err := rows.Scan(&file.Id, &file.Path, &file.Hash); err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+		ret = append(ret, file)
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+// GetFilesTaggedSince returns every file which had a tag attached at or after
+// since, ordered by path. Expired links don't count and neither do links made
+// before timestamps were recorded.
+func (tagDB *TagDB) GetFilesTaggedSince(ctx context.Context, since time.Time) ([]files.File, error) {
+	const (
+		searchString = `SELECT f.id, f.path, f.hash FROM files f
+			WHERE EXISTS (SELECT 1 FROM livefiletags ft WHERE ft.fileid = f.id AND ft.created >= ?)
+			ORDER BY f.path`
+	)
+
+	ctx, span := tracer.Start(ctx, "GetFilesTaggedSince")
+	defer span.End()
+
+	rows, err := tagDB.client.QueryContext(ctx, searchString, since.Unix())
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := []files.File{}
+	for rows.Next() {
+		file := files.File{}
+		if err := rows.Scan(&file.Id, &file.Path, &file.Hash); err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+		ret = append(ret, file)
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+// GetFileByPath returns the file being tracked at the provided path or an error
+// wrapping sql.ErrNoRows if no file is tracked there. Paths are stored as
+// absolute paths so the search should be absolute too.
+func (tagDB *TagDB) GetFileByPath(ctx context.Context, search string) (files.File, error) {
+	const (
+		searchString = "SELECT id, path, hash FROM files WHERE path = ?"
+	)
+
+	ctx, span := tracer.Start(ctx, "GetFileByPath")
+	defer span.End()
+
+	ret := files.File{}
+	row := tagDB.client.QueryRowContext(ctx, searchString, search)
+	if err := row.Scan(&ret.Id, &ret.Path, &ret.Hash); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			span.SetStatus(codes.Error, "file not found")
+			return ret, fmt.Errorf("file at path %s is not being tracked: %w", search, err)
+		}
+
+		span.SetStatus(codes.Error, err.Error())
+		return ret, err
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+// GetFilesByTags returns every file which has all of the provided tags, ordered
+// by path. Each search term is a tag name qualified with its namespace, as parsed
+// by tags.Parse, and either part may use * as a wildcard: project:* matches any
+// tag in the project namespace and *:acme matches acme in any namespace, including
+// none. Aliases match the same as the tag they point at. A file tagged with a
+// tag below a search term in the hierarchy also matches, so food finds files
+// tagged food/dessert/pie. When implications are computed a file tagged with a
+// tag that implies a search term matches too. A search term which is the name
+// of a smart tag matches the files its query matches.
+// Searching with no tags returns no files rather than every file.
+func (tagDB *TagDB) GetFilesByTags(ctx context.Context, tagNames []string) ([]files.File, error) {
+	ctx, span := tracer.Start(ctx, "GetFilesByTags")
+	defer span.End()
+
+	if len(tagNames) == 0 {
+		span.SetStatus(codes.Ok, "")
+		return []files.File{}, nil
+	}
+
+	terms := []query.Expr{}
+	for _, name := range tagNames {
+		terms = append(terms, query.Term{Tag: name})
+	}
+
+	ret, err := tagDB.GetFilesByQuery(ctx, query.And{Exprs: terms})
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+// tagCondition builds a condition on the tags table, aliased as t, which matches
+// every tag described by a search term. The namespace and name are each matched
+// exactly unless they contain a * wildcard.
+func tagCondition(term string) (string, []any) {
+	parsed := tags.Parse(term)
+	condition := fmt.Sprintf(
+		"%s AND %s",
+		globCondition("t.namespace", parsed.Namespace),
+		globCondition("t.name", parsed.Name),
+	)
+
+	return condition, []any{globPattern(parsed.Namespace), globPattern(parsed.Name)}
+}
+
+func globCondition(column string, pattern string) string {
+	if strings.Contains(pattern, "*") {
+		return column + " GLOB ?"
+	}
+
+	return column + " = ?"
+}
+
+// globPattern escapes everything GLOB treats specially except for *.
+func globPattern(pattern string) string {
+	if !strings.Contains(pattern, "*") {
+		return pattern
+	}
+
+	return strings.NewReplacer("[", "[[]", "?", "[?]").Replace(pattern)
+}
+
+// filePath returns the path of a file for use in errors, or a description of
+// its ID if the file can't be found.
+func filePath(ctx context.Context, q querier, fileId int) string {
+	const (
+		searchString = "SELECT path FROM files WHERE id = ?"
+	)
+
+	var path string
+	if err := q.QueryRowContext(ctx, searchString, fileId).Scan(&path); err != nil {
+		return fmt.Sprintf("file ID %d", fileId)
+	}
+
+	return path
+}
@@ -2,8 +2,11 @@ package db
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/whatsfordinner/fstagger/internal/files"
 )
@@ -134,3 +137,383 @@ func TestTagDBAddFiles(t *testing.T) {
 		})
 	}
 }
+
+func TestTagDBUpdateFileHashes(t *testing.T) {
+	testMap := map[string]struct {
+		input     []files.File
+		expectErr []error
+		expect    []files.File
+	}{
+		"changing a hash": {
+			[]files.File{{Id: 1, Hash: "newhash"}},
+			[]error{},
+			[]files.File{{Id: 1, Path: "/path/to/contract.pdf", Hash: "newhash"}},
+		},
+		"a file that doesn't exist": {
+			[]files.File{{Id: 4, Hash: "newhash"}, {Id: 3, Hash: "newphotohash"}},
+			[]error{sql.ErrNoRows},
+			[]files.File{{Id: 3, Path: "/path/to/photo.jpg", Hash: "newphotohash"}},
+		},
+		"a hash another file has": {
+			[]files.File{{Id: 1, Hash: "invoicehash"}},
+			[]error{ErrFileExists},
+			[]files.File{},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/contents.yml"})
+			defer teardown()
+
+			res, err := testDB.UpdateFileHashes(context.Background(), testData.input)
+
+			if len(testData.expectErr) == 0 && err != nil {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			for _, expectErr := range testData.expectErr {
+				if !errors.Is(err, expectErr) {
+					t.Fatalf("Expected error wrapping %s but got: %v", expectErr, err)
+				}
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+
+			for _, file := range res {
+				stored, err := testDB.GetFileById(context.Background(), file.Id)
+				if err != nil {
+					t.Fatalf("Expected no error but got: %s", err.Error())
+				}
+
+				if stored.Hash != file.Hash {
+					t.Fatalf("Expected hash %s to be stored but got %s", file.Hash, stored.Hash)
+				}
+			}
+		})
+	}
+}
+
+func TestTagDBGetFileByPath(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr bool
+		input     string
+		expect    files.File
+	}{
+		"file exists": {
+			false,
+			"/path/to/foo",
+			files.File{
+				Id:   1,
+				Path: "/path/to/foo",
+				Hash: "foohash",
+			},
+		},
+		"file doesn't exist": {
+			true,
+			"/path/to/bar",
+			files.File{},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/get_file_by_path.yml"})
+			defer teardown()
+
+			res, err := testDB.GetFileByPath(context.Background(), testData.input)
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBGetFiles(t *testing.T) {
+	testMap := map[string]struct {
+		fixtures []string
+		expect   []string
+	}{
+		"many files": {
+			[]string{"fixtures/values.yml"},
+			[]string{"/path/to/bar", "/path/to/baz", "/path/to/foo", "/path/to/qux"},
+		},
+		"no files": {
+			[]string{"fixtures/get_tags_no_tags.yml"},
+			[]string{},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, testData.fixtures)
+			defer teardown()
+
+			res, err := testDB.GetFiles(context.Background())
+			if err != nil {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(filePaths(res), testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					filePaths(res),
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBGetFilesTaggedSince(t *testing.T) {
+	testMap := map[string]struct {
+		input  time.Time
+		expect []string
+	}{
+		"every timed link":          {time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), []string{"/path/to/bar", "/path/to/foo"}},
+		"some links":                {time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), []string{"/path/to/bar"}},
+		"only expired links":        {time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC), []string{}},
+		"untimed links never match": {time.Unix(0, 0), []string{"/path/to/bar", "/path/to/foo"}},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/timestamps.yml"})
+			defer teardown()
+
+			res, err := testDB.GetFilesTaggedSince(context.Background(), testData.input)
+			if err != nil {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(filePaths(res), testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					filePaths(res),
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBGetFilesByTags(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr bool
+		input     []string
+		expect    []files.File
+	}{
+		"no tags": {
+			false,
+			[]string{},
+			[]files.File{},
+		},
+		"tag with no files": {
+			false,
+			[]string{"pies"},
+			[]files.File{},
+		},
+		"tag that doesn't exist": {
+			false,
+			[]string{"qux"},
+			[]files.File{},
+		},
+		"one tag": {
+			false,
+			[]string{"food"},
+			[]files.File{
+				{
+					Id:   2,
+					Path: "/path/to/bar",
+					Hash: "barhash",
+				},
+				{
+					Id:   1,
+					Path: "/path/to/foo",
+					Hash: "foohash",
+				},
+			},
+		},
+		"multiple tags are a logical and": {
+			false,
+			[]string{"food", "dessert"},
+			[]files.File{
+				{
+					Id:   1,
+					Path: "/path/to/foo",
+					Hash: "foohash",
+				},
+			},
+		},
+		"repeated tags": {
+			false,
+			[]string{"dessert", "dessert"},
+			[]files.File{
+				{
+					Id:   1,
+					Path: "/path/to/foo",
+					Hash: "foohash",
+				},
+			},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/get_files_by_tags.yml"})
+			defer teardown()
+
+			res, err := testDB.GetFilesByTags(context.Background(), testData.input)
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBGetFilesByTagsNamespaced(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr bool
+		input     []string
+		expect    []files.File
+	}{
+		"exact namespaced tag": {
+			false,
+			[]string{"client:acme"},
+			[]files.File{
+				{
+					Id:   1,
+					Path: "/path/to/foo",
+					Hash: "foohash",
+				},
+			},
+		},
+		"tag without a namespace": {
+			false,
+			[]string{"acme"},
+			[]files.File{
+				{
+					Id:   3,
+					Path: "/path/to/baz",
+					Hash: "bazhash",
+				},
+			},
+		},
+		"namespace wildcard": {
+			false,
+			[]string{"project:*"},
+			[]files.File{
+				{
+					Id:   2,
+					Path: "/path/to/bar",
+					Hash: "barhash",
+				},
+				{
+					Id:   1,
+					Path: "/path/to/foo",
+					Hash: "foohash",
+				},
+			},
+		},
+		"any namespace": {
+			false,
+			[]string{"*:acme"},
+			[]files.File{
+				{
+					Id:   3,
+					Path: "/path/to/baz",
+					Hash: "bazhash",
+				},
+				{
+					Id:   1,
+					Path: "/path/to/foo",
+					Hash: "foohash",
+				},
+			},
+		},
+		"partial wildcard": {
+			false,
+			[]string{"project:gem*"},
+			[]files.File{
+				{
+					Id:   2,
+					Path: "/path/to/bar",
+					Hash: "barhash",
+				},
+			},
+		},
+		"wildcard and exact tag": {
+			false,
+			[]string{"project:*", "client:acme"},
+			[]files.File{
+				{
+					Id:   1,
+					Path: "/path/to/foo",
+					Hash: "foohash",
+				},
+			},
+		},
+		"glob characters are escaped": {
+			false,
+			[]string{"project:?pollo*"},
+			[]files.File{},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/namespaces.yml"})
+			defer teardown()
+
+			res, err := testDB.GetFilesByTags(context.Background(), testData.input)
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
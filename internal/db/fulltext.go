@@ -0,0 +1,409 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/whatsfordinner/fstagger/internal/tags"
+
+	"go.opentelemetry.io/otel/codes"
+)
+
+const (
+	// snippetWords is the most words a snippet is cut down to.
+	snippetWords = 12
+
+	// fullTextString creates the FTS5 indexes over tags, notes and file
+	// contents along with the triggers which keep them in sync. The indexes
+	// read their text from the tables they index so they only hold the index
+	// itself.
+	fullTextString = `CREATE VIRTUAL TABLE IF NOT EXISTS tagsearch
+			USING fts5(namespace, name, description, content='tags', content_rowid='id');
+		CREATE VIRTUAL TABLE IF NOT EXISTS notesearch
+			USING fts5(text, content='notes', content_rowid='id');
+		CREATE VIRTUAL TABLE IF NOT EXISTS contentsearch
+			USING fts5(text, content='filecontents', content_rowid='fileid');
+		CREATE TRIGGER IF NOT EXISTS tags_search_insert AFTER INSERT ON tags
+		BEGIN
+			INSERT INTO tagsearch(rowid, namespace, name, description)
+			VALUES(NEW.id, NEW.namespace, NEW.name, NEW.description);
+		END;
+		CREATE TRIGGER IF NOT EXISTS tags_search_update AFTER UPDATE ON tags
+		BEGIN
+			INSERT INTO tagsearch(tagsearch, rowid, namespace, name, description)
+			VALUES('delete', OLD.id, OLD.namespace, OLD.name, OLD.description);
+			INSERT INTO tagsearch(rowid, namespace, name, description)
+			VALUES(NEW.id, NEW.namespace, NEW.name, NEW.description);
+		END;
+		CREATE TRIGGER IF NOT EXISTS tags_search_delete AFTER DELETE ON tags
+		BEGIN
+			INSERT INTO tagsearch(tagsearch, rowid, namespace, name, description)
+			VALUES('delete', OLD.id, OLD.namespace, OLD.name, OLD.description);
+		END;
+		CREATE TRIGGER IF NOT EXISTS notes_search_insert AFTER INSERT ON notes
+		BEGIN
+			INSERT INTO notesearch(rowid, text) VALUES(NEW.id, NEW.text);
+		END;
+		CREATE TRIGGER IF NOT EXISTS notes_search_update AFTER UPDATE ON notes
+		BEGIN
+			INSERT INTO notesearch(notesearch, rowid, text) VALUES('delete', OLD.id, OLD.text);
+			INSERT INTO notesearch(rowid, text) VALUES(NEW.id, NEW.text);
+		END;
+		CREATE TRIGGER IF NOT EXISTS notes_search_delete AFTER DELETE ON notes
+		BEGIN
+			INSERT INTO notesearch(notesearch, rowid, text) VALUES('delete', OLD.id, OLD.text);
+		END;
+		CREATE TRIGGER IF NOT EXISTS filecontents_search_insert AFTER INSERT ON filecontents
+		BEGIN
+			INSERT INTO contentsearch(rowid, text) VALUES(NEW.fileid, NEW.text);
+		END;
+		CREATE TRIGGER IF NOT EXISTS filecontents_search_update AFTER UPDATE ON filecontents
+		BEGIN
+			INSERT INTO contentsearch(contentsearch, rowid, text) VALUES('delete', OLD.fileid, OLD.text);
+			INSERT INTO contentsearch(rowid, text) VALUES(NEW.fileid, NEW.text);
+		END;
+		CREATE TRIGGER IF NOT EXISTS filecontents_search_delete AFTER DELETE ON filecontents
+		BEGIN
+			INSERT INTO contentsearch(contentsearch, rowid, text) VALUES('delete', OLD.fileid, OLD.text);
+		END;`
+)
+
+// fullTextTriggers are the triggers created by fullTextString.
+var fullTextTriggers = []string{
+	"tags_search_insert",
+	"tags_search_update",
+	"tags_search_delete",
+	"notes_search_insert",
+	"notes_search_update",
+	"notes_search_delete",
+	"filecontents_search_insert",
+	"filecontents_search_update",
+	"filecontents_search_delete",
+}
+
+// initFullText sets up the full-text indexes if the SQLite fstagger was built
+// with has FTS5, which go-sqlite3 only includes with the sqlite_fts5 build tag.
+// Without FTS5 the triggers are dropped, if a build with FTS5 created them,
+// so that tags, notes and file contents can still be written, and searches
+// fall back to matching with LIKE. The indexes are rebuilt whenever their
+// triggers have to be created since they'll have missed any changes made
+// without them. A schema without the tables they index, like the one
+// TestTagDBInit migrates to, gets the fallback.
+func (tagDB *TagDB) initFullText(ctx context.Context) error {
+	const (
+		availableString = `SELECT sqlite_compileoption_used('ENABLE_FTS5')
+			AND (SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name IN ('tags', 'notes', 'filecontents')) = 3`
+		triggersString = "SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name IN (%s)"
+		dropString     = "DROP TRIGGER IF EXISTS %s"
+		rebuildString  = `INSERT INTO tagsearch(tagsearch) VALUES('rebuild');
+			INSERT INTO notesearch(notesearch) VALUES('rebuild');
+			INSERT INTO contentsearch(contentsearch) VALUES('rebuild')`
+	)
+
+	if err := tagDB.client.QueryRowContext(ctx, availableString).Scan(&tagDB.fullText); err != nil {
+		return err
+	}
+
+	if !tagDB.fullText {
+		for _, trigger := range fullTextTriggers {
+			if _, err := tagDB.client.ExecContext(ctx, fmt.Sprintf(dropString, trigger)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	names := []any{}
+	for _, trigger := range fullTextTriggers {
+		names = append(names, trigger)
+	}
+
+	var existing int
+	if err := tagDB.client.QueryRowContext(
+		ctx,
+		fmt.Sprintf(triggersString, strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", ")),
+		names...,
+	).Scan(&existing); err != nil {
+		return err
+	}
+
+	if existing == len(fullTextTriggers) {
+		return nil
+	}
+
+	if _, err := tagDB.client.ExecContext(ctx, fullTextString); err != nil {
+		return err
+	}
+
+	_, err := tagDB.client.ExecContext(ctx, rebuildString)
+
+	return err
+}
+
+// FullText reports whether searches use the FTS5 indexes, which they only do
+// when fstagger is built with the sqlite_fts5 tag, or fall back to matching in
+// Go.
+func (tagDB *TagDB) FullText() bool {
+	return tagDB.fullText
+}
+
+// FindTags returns the tags whose name or description, or a note about why a
+// file has them, match the words in search, best match first. A tag matches
+// if any of the words do and matches better the more words it matches and if
+// they're in its name. Each tag comes with a snippet of the text it matched.
+// Words match the start of words in the text, ignoring case and punctuation.
+// With FTS5 tags are ranked with BM25, otherwise they're ranked by how many of
+// the words they match.
+func (tagDB *TagDB) FindTags(ctx context.Context, search string) ([]tags.Match, error) {
+	ctx, span := tracer.Start(ctx, "FindTags")
+	defer span.End()
+
+	words := textWords(search)
+	if len(words) == 0 {
+		span.SetStatus(codes.Ok, "")
+		return []tags.Match{}, nil
+	}
+
+	var ret []tags.Match
+	var err error
+	if tagDB.fullText {
+		ret, err = tagDB.findTagsFullText(ctx, words)
+	} else {
+		ret, err = tagDB.findTagsLike(ctx, words)
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+func (tagDB *TagDB) findTagsFullText(ctx context.Context, words []string) ([]tags.Match, error) {
+	const (
+		searchString = `SELECT t.id, t.namespace, t.name, COALESCE(t.description, ''), COALESCE(t.parent, 0),
+				snippet(tagsearch, -1, '[', ']', '...', %[1]d) AS snippet,
+				bm25(tagsearch, 1.0, 10.0, 1.0) AS rank
+			FROM tagsearch JOIN tags t ON t.id = tagsearch.rowid
+			WHERE tagsearch MATCH ?1
+			UNION ALL
+			SELECT t.id, t.namespace, t.name, COALESCE(t.description, ''), COALESCE(t.parent, 0),
+				snippet(notesearch, 0, '[', ']', '...', %[1]d) AS snippet,
+				bm25(notesearch) AS rank
+			FROM notesearch
+			JOIN notes n ON n.id = notesearch.rowid
+			JOIN livefiletags ft ON ft.fileid = n.fileid AND ft.tagid = n.tagid
+			JOIN tags t ON t.id = n.tagid
+			WHERE notesearch MATCH ?1
+			ORDER BY rank, namespace, name`
+	)
+
+	// words are only letters and digits but they're quoted anyway so that
+	// keywords like OR aren't read as FTS5 query syntax
+	terms := []string{}
+	for _, word := range words {
+		terms = append(terms, `"`+word+`"*`)
+	}
+
+	rows, err := tagDB.client.QueryContext(
+		ctx,
+		fmt.Sprintf(searchString, snippetWords),
+		strings.Join(terms, " OR "),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := []tags.Match{}
+	found := map[int]bool{}
+	for rows.Next() {
+		match := tags.Match{}
+		var rank float64
+		if err := rows.Scan(
+			&match.Id,
+			&match.Namespace,
+			&match.Name,
+			&match.Description,
+			&match.Parent,
+			&match.Snippet,
+			&rank,
+		); err != nil {
+			return nil, err
+		}
+
+		// a tag can match on its own text and on several notes but it's only
+		// returned for its best match
+		if found[match.Id] {
+			continue
+		}
+		found[match.Id] = true
+
+		ret = append(ret, match)
+	}
+
+	return ret, rows.Err()
+}
+
+func (tagDB *TagDB) findTagsLike(ctx context.Context, words []string) ([]tags.Match, error) {
+	const (
+		tagsString = `SELECT id, namespace, name, COALESCE(description, ''), COALESCE(parent, 0)
+			FROM tags`
+		notesString = `SELECT n.tagid, n.text FROM notes n
+			JOIN livefiletags ft ON ft.fileid = n.fileid AND ft.tagid = n.tagid
+			ORDER BY n.id`
+	)
+
+	type scored struct {
+		tags.Match
+		score int
+	}
+
+	rows, err := tagDB.client.QueryContext(ctx, tagsString)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := map[int]*scored{}
+	for rows.Next() {
+		tag := tags.Tag{}
+		if err := rows.Scan(&tag.Id, &tag.Namespace, &tag.Name, &tag.Description, &tag.Parent); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		candidate := &scored{Match: tags.Match{Tag: tag}}
+		if nameScore := countMatches(tag.String(), words); nameScore > 0 {
+			candidate.score = 10 * nameScore
+			candidate.Snippet = snippet(tag.String(), words)
+		}
+
+		if descriptionScore := countMatches(tag.Description, words); descriptionScore > 0 {
+			candidate.score += descriptionScore
+			candidate.Snippet = snippet(tag.Description, words)
+		}
+
+		candidates[tag.Id] = candidate
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tagDB.client.QueryContext(ctx, notesString)
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var tagId int
+		var text string
+		if err := rows.Scan(&tagId, &text); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		candidate, ok := candidates[tagId]
+		if !ok {
+			continue
+		}
+
+		if noteScore := countMatches(text, words); noteScore > candidate.score {
+			candidate.score = noteScore
+			candidate.Snippet = snippet(text, words)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	matched := []*scored{}
+	for _, candidate := range candidates {
+		if candidate.score > 0 {
+			matched = append(matched, candidate)
+		}
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		if matched[i].score != matched[j].score {
+			return matched[i].score > matched[j].score
+		}
+		if matched[i].Namespace != matched[j].Namespace {
+			return matched[i].Namespace < matched[j].Namespace
+		}
+		return matched[i].Name < matched[j].Name
+	})
+
+	ret := []tags.Match{}
+	for _, candidate := range matched {
+		ret = append(ret, candidate.Match)
+	}
+
+	return ret, nil
+}
+
+// textWords splits text into lower case words of letters and digits, the same
+// way FTS5's default tokenizer does.
+func textWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// countMatches returns how many of words are the start of a word in text.
+func countMatches(text string, words []string) int {
+	textWords := textWords(text)
+
+	ret := 0
+	for _, word := range words {
+		for _, textWord := range textWords {
+			if strings.HasPrefix(textWord, word) {
+				ret++
+				break
+			}
+		}
+	}
+
+	return ret
+}
+
+// snippet cuts text down to the snippetWords words around the first one
+// matching any of words, wraps every word matching one of words in square
+// brackets and marks where it was cut with an ellipsis, the same way as FTS5's
+// snippet function.
+func snippet(text string, words []string) string {
+	fields := strings.Fields(text)
+
+	first := -1
+	for i, field := range fields {
+		if countMatches(field, words) > 0 {
+			if first == -1 {
+				first = i
+			}
+			fields[i] = "[" + field + "]"
+		}
+	}
+
+	if len(fields) <= snippetWords {
+		return strings.Join(fields, " ")
+	}
+
+	start := max(0, min(first-snippetWords/4, len(fields)-snippetWords))
+	end := start + snippetWords
+
+	ret := strings.Join(fields[start:end], " ")
+	if start > 0 {
+		ret = "..." + ret
+	}
+	if end < len(fields) {
+		ret += "..."
+	}
+
+	return ret
+}
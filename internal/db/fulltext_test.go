@@ -0,0 +1,165 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/whatsfordinner/fstagger/internal/notes"
+	"github.com/whatsfordinner/fstagger/internal/tags"
+)
+
+func TestTagDBFindTags(t *testing.T) {
+	testMap := map[string]struct {
+		input          string
+		expectTags     []string
+		expectSnippets map[string]string
+	}{
+		"matching descriptions": {
+			"lawyer",
+			[]string{"legal"},
+			map[string]string{"legal": "Needs a [lawyer]"},
+		},
+		"ignoring case": {
+			"PROOF",
+			[]string{"receipts"},
+			map[string]string{"receipts": "[Proof] of purchase"},
+		},
+		"matching notes about links": {
+			"indemnity",
+			[]string{"legal"},
+			map[string]string{"legal": "tagged because of the [indemnity] clause"},
+		},
+		"ranking names above descriptions": {
+			"tax",
+			[]string{"tax", "finance:bas"},
+			map[string]string{},
+		},
+		"matching nothing":      {"astronomy", []string{}, map[string]string{}},
+		"searching for nothing": {"  ", []string{}, map[string]string{}},
+		"searching with query syntax": {
+			`"lawyer OR`,
+			[]string{"legal"},
+			map[string]string{},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/fulltext.yml"})
+			defer teardown()
+
+			res, err := testDB.FindTags(context.Background(), testData.input)
+			if err != nil {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			names := []string{}
+			for _, match := range res {
+				names = append(names, match.Tag.String())
+
+				if expect, ok := testData.expectSnippets[match.Tag.String()]; ok && match.Snippet != expect {
+					t.Fatalf(
+						"Result did not match expectation\nResult: %+v\nExpected: %+v",
+						match.Snippet,
+						expect,
+					)
+				}
+			}
+
+			if !reflect.DeepEqual(names, testData.expectTags) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					names,
+					testData.expectTags,
+				)
+			}
+		})
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	testMap := map[string]struct {
+		input  string
+		words  []string
+		expect string
+	}{
+		"short text": {
+			"Needs a lawyer",
+			[]string{"law"},
+			"Needs a [lawyer]",
+		},
+		"cut at the end": {
+			"one two three four five six seven eight nine ten eleven twelve thirteen",
+			[]string{"two"},
+			"one [two] three four five six seven eight nine ten eleven twelve...",
+		},
+		"cut at both ends": {
+			"one two three four five six seven eight nine ten eleven twelve thirteen fourteen fifteen sixteen",
+			[]string{"seven"},
+			"...four five six [seven] eight nine ten eleven twelve thirteen fourteen fifteen...",
+		},
+		"cut at the start": {
+			"one two three four five six seven eight nine ten eleven twelve thirteen",
+			[]string{"thirteen"},
+			"...two three four five six seven eight nine ten eleven twelve [thirteen]",
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			res := snippet(testData.input, testData.words)
+
+			if res != testData.expect {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+// TestTagDBFindTagsAfterChanges checks that tags are found by what they say
+// now rather than by what they said when they were written.
+func TestTagDBFindTagsAfterChanges(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/fulltext.yml"})
+	defer teardown()
+
+	ctx := context.Background()
+	if _, err := testDB.UpdateTags(ctx, []tags.Tag{
+		{Id: 3, Name: "receipts", Description: "Kept for the tax return"},
+	}); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	if err := testDB.DeleteTags(ctx, []tags.Tag{{Id: 2}}, true); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	if _, err := testDB.SetNotes(ctx, []notes.Note{{File: 1, Tag: 4, Text: "see clause 4"}}); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	for search, expect := range map[string][]string{
+		"tax":       {"receipts", "finance:bas"},
+		"proof":     {},
+		"indemnity": {},
+		"clause":    {"legal"},
+	} {
+		res, err := testDB.FindTags(ctx, search)
+		if err != nil {
+			t.Fatalf("Expected no error but got: %s", err.Error())
+		}
+
+		names := []string{}
+		for _, match := range res {
+			names = append(names, match.Tag.String())
+		}
+
+		if !reflect.DeepEqual(names, expect) {
+			t.Fatalf("Result did not match expectation\nResult: %+v\nExpected: %+v", names, expect)
+		}
+	}
+}
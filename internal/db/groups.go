@@ -0,0 +1,515 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/whatsfordinner/fstagger/internal/groups"
+	"github.com/whatsfordinner/fstagger/internal/history"
+	"github.com/whatsfordinner/fstagger/internal/links"
+	"github.com/whatsfordinner/fstagger/internal/tags"
+
+	"github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel/codes"
+)
+
+const groupColumns = "id, name, COALESCE(description, ''), exclusive, policy"
+
+// AddGroups takes a slice of groups and adds them to the datastore. It ignores
+// any IDs in the input slice and the output slice is the added groups with
+// their IDs. A group without a policy is given groups.PolicyReject.
+func (tagDB *TagDB) AddGroups(ctx context.Context, newGroups []groups.Group) ([]groups.Group, error) {
+	const (
+		insertString = "INSERT INTO taggroups(name, description, exclusive, policy) VALUES(?, ?, ?, ?) RETURNING id"
+	)
+
+	ctx, span := tracer.Start(ctx, "AddGroups")
+	defer span.End()
+
+	addedGroups := []groups.Group{}
+
+	tx, err := tagDB.client.BeginTx(ctx, nil)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return []groups.Group{}, err
+	}
+	txErrors := &BatchError{}
+
+	for i, group := range newGroups {
+		span.AddEvent(fmt.Sprintf("adding group: %s", group))
+
+		if group.Policy == "" {
+			group.Policy = groups.PolicyReject
+		}
+
+		if err := validateGroup(group); err != nil {
+			txErrors.add(i, err)
+			continue
+		}
+
+		row := tx.QueryRowContext(
+			ctx,
+			insertString,
+			group.Name,
+			group.Description,
+			group.Exclusive,
+			group.Policy,
+		)
+		if err := row.Scan(&group.Id); err != nil {
+			if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+				err = fmt.Errorf("group already exists: %s", group)
+			}
+			txErrors.add(i, err)
+			continue
+		}
+
+		addedGroups = append(addedGroups, group)
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			err = errors.Join(err, rollbackErr)
+		}
+		span.SetStatus(codes.Error, err.Error())
+		return []groups.Group{}, err
+	}
+
+	if len(txErrors.Errors) > 0 {
+		span.SetStatus(codes.Error, "encountered DB errors during transaction")
+	} else {
+		span.SetStatus(codes.Ok, "")
+	}
+
+	return addedGroups, txErrors.errOrNil()
+}
+
+// UpdateGroups takes a slice of groups and updates the groups with matching
+// IDs. Making a group exclusive doesn't change the tags of files which
+// already have more than one of its tags.
+func (tagDB *TagDB) UpdateGroups(ctx context.Context, updateGroups []groups.Group) ([]groups.Group, error) {
+	const (
+		updateString = "UPDATE taggroups SET name = ?, description = ?, exclusive = ?, policy = ? WHERE id = ?"
+	)
+
+	ctx, span := tracer.Start(ctx, "UpdateGroups")
+	defer span.End()
+
+	updatedGroups := []groups.Group{}
+
+	tx, err := tagDB.client.BeginTx(ctx, nil)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return []groups.Group{}, err
+	}
+	txErrors := &BatchError{}
+
+	for i, group := range updateGroups {
+		span.AddEvent(fmt.Sprintf("updating group ID %d", group.Id))
+
+		if err := validateGroup(group); err != nil {
+			txErrors.add(i, err)
+			continue
+		}
+
+		res, err := tx.ExecContext(
+			ctx,
+			updateString,
+			group.Name,
+			group.Description,
+			group.Exclusive,
+			group.Policy,
+			group.Id,
+		)
+		if err != nil {
+			if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+				err = fmt.Errorf("group already exists: %s", group)
+			}
+			txErrors.add(i, err)
+			continue
+		}
+
+		if updated, err := res.RowsAffected(); err == nil && updated == 0 {
+			txErrors.add(i, fmt.Errorf("group does not exist with id: %d", group.Id))
+			continue
+		}
+
+		updatedGroups = append(updatedGroups, group)
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			err = errors.Join(err, rollbackErr)
+		}
+		span.SetStatus(codes.Error, err.Error())
+		return []groups.Group{}, err
+	}
+
+	if len(txErrors.Errors) > 0 {
+		span.SetStatus(codes.Error, "encountered DB errors during transaction")
+	} else {
+		span.SetStatus(codes.Ok, "")
+	}
+
+	return updatedGroups, txErrors.errOrNil()
+}
+
+func validateGroup(group groups.Group) error {
+	if group.Name == "" {
+		return errors.New("group must have a name")
+	}
+
+	if _, err := groups.ParsePolicy(string(group.Policy)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteGroups takes a slice of groups and removes them from the datastore.
+// The tags in a group are untouched.
+func (tagDB *TagDB) DeleteGroups(ctx context.Context, deleteGroups []groups.Group) error {
+	const (
+		deleteString = "DELETE FROM taggroups WHERE id = ?"
+	)
+
+	ctx, span := tracer.Start(ctx, "DeleteGroups")
+	defer span.End()
+
+	tx, err := tagDB.client.BeginTx(ctx, nil)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	txErrors := &BatchError{}
+
+	for i, group := range deleteGroups {
+		res, err := tx.ExecContext(ctx, deleteString, group.Id)
+		if err != nil {
+			txErrors.add(i, err)
+			continue
+		}
+
+		if deleted, err := res.RowsAffected(); err == nil && deleted == 0 {
+			txErrors.add(i, fmt.Errorf("group does not exist with id: %d", group.Id))
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			err = errors.Join(err, rollbackErr)
+		}
+		span.SetStatus(codes.Error, "encountered error finalising transaction")
+		return err
+	}
+
+	if len(txErrors.Errors) > 0 {
+		span.SetStatus(codes.Error, "encountered DB errors during transaction")
+	} else {
+		span.SetStatus(codes.Ok, "")
+	}
+
+	return txErrors.errOrNil()
+}
+
+// GetGroups returns every group ordered by name.
+func (tagDB *TagDB) GetGroups(ctx context.Context) ([]groups.Group, error) {
+	const (
+		searchString = "SELECT " + groupColumns + " FROM taggroups ORDER BY name"
+	)
+
+	ctx, span := tracer.Start(ctx, "GetGroups")
+	defer span.End()
+
+	rows, err := tagDB.client.QueryContext(ctx, searchString)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := []groups.Group{}
+
+	for rows.Next() {
+		group := groups.Group{}
+		if err := rows.Scan(
+			&group.Id,
+			&group.Name,
+			&group.Description,
+			&group.Exclusive,
+			&group.Policy,
+		); err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+		ret = append(ret, group)
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+// GetGroupByName returns the group with the provided name or an error wrapping
+// sql.ErrNoRows if there isn't one.
+func (tagDB *TagDB) GetGroupByName(ctx context.Context, search string) (groups.Group, error) {
+	const (
+		searchString = "SELECT " + groupColumns + " FROM taggroups WHERE name = ?"
+	)
+
+	ctx, span := tracer.Start(ctx, "GetGroupByName")
+	defer span.End()
+
+	ret := groups.Group{}
+	row := tagDB.client.QueryRowContext(ctx, searchString, search)
+	if err := row.Scan(&ret.Id, &ret.Name, &ret.Description, &ret.Exclusive, &ret.Policy); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			span.SetStatus(codes.Error, "group not found")
+			return ret, fmt.Errorf("group does not exist with name: %s: %w", search, err)
+		}
+
+		span.SetStatus(codes.Error, err.Error())
+		return ret, err
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+// AddGroupMembers takes a slice of memberships and adds them to the datastore.
+// Files which already have more than one tag from an exclusive group keep
+// them.
+func (tagDB *TagDB) AddGroupMembers(ctx context.Context, newMembers []groups.Member) ([]groups.Member, error) {
+	const (
+		insertString = "INSERT INTO taggroupmembers(groupid, tagid) VALUES(?, ?)"
+	)
+
+	ctx, span := tracer.Start(ctx, "AddGroupMembers")
+	defer span.End()
+
+	addedMembers := []groups.Member{}
+
+	tx, err := tagDB.client.BeginTx(ctx, nil)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return []groups.Member{}, err
+	}
+	txErrors := &BatchError{}
+
+	for i, member := range newMembers {
+		span.AddEvent(fmt.Sprintf("adding tag ID %d to group ID %d", member.Tag, member.Group))
+
+		if _, err := tx.ExecContext(ctx, insertString, member.Group, member.Tag); err != nil {
+			if sqliteErr, ok := err.(sqlite3.Error); ok {
+				switch sqliteErr.ExtendedCode {
+				case sqlite3.ErrConstraintPrimaryKey:
+					err = fmt.Errorf(
+						"tag %s is already in group %s",
+						tagName(ctx, tx, member.Tag),
+						groupName(ctx, tx, member.Group),
+					)
+				case sqlite3.ErrConstraintForeignKey:
+					err = fmt.Errorf(
+						"group does not exist with id: %d or tag does not exist with id: %d",
+						member.Group,
+						member.Tag,
+					)
+				}
+			}
+			txErrors.add(i, err)
+			continue
+		}
+
+		addedMembers = append(addedMembers, member)
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			err = errors.Join(err, rollbackErr)
+		}
+		span.SetStatus(codes.Error, err.Error())
+		return []groups.Member{}, err
+	}
+
+	if len(txErrors.Errors) > 0 {
+		span.SetStatus(codes.Error, "encountered DB errors during transaction")
+	} else {
+		span.SetStatus(codes.Ok, "")
+	}
+
+	return addedMembers, txErrors.errOrNil()
+}
+
+// DeleteGroupMembers takes a slice of memberships and removes them from the
+// datastore.
+func (tagDB *TagDB) DeleteGroupMembers(ctx context.Context, deleteMembers []groups.Member) error {
+	const (
+		deleteString = "DELETE FROM taggroupmembers WHERE groupid = ? AND tagid = ?"
+	)
+
+	ctx, span := tracer.Start(ctx, "DeleteGroupMembers")
+	defer span.End()
+
+	tx, err := tagDB.client.BeginTx(ctx, nil)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	txErrors := &BatchError{}
+
+	for i, member := range deleteMembers {
+		res, err := tx.ExecContext(ctx, deleteString, member.Group, member.Tag)
+		if err != nil {
+			txErrors.add(i, err)
+			continue
+		}
+
+		if deleted, err := res.RowsAffected(); err == nil && deleted == 0 {
+			txErrors.add(i, fmt.Errorf(
+				"tag %s isn't in group %s",
+				tagName(ctx, tx, member.Tag),
+				groupName(ctx, tx, member.Group),
+			))
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			err = errors.Join(err, rollbackErr)
+		}
+		span.SetStatus(codes.Error, "encountered error finalising transaction")
+		return err
+	}
+
+	if len(txErrors.Errors) > 0 {
+		span.SetStatus(codes.Error, "encountered DB errors during transaction")
+	} else {
+		span.SetStatus(codes.Ok, "")
+	}
+
+	return txErrors.errOrNil()
+}
+
+// GetGroupMembers returns every membership ordered by group ID and then tag
+// ID.
+func (tagDB *TagDB) GetGroupMembers(ctx context.Context) ([]groups.Member, error) {
+	const (
+		searchString = "SELECT groupid, tagid FROM taggroupmembers ORDER BY groupid, tagid"
+	)
+
+	ctx, span := tracer.Start(ctx, "GetGroupMembers")
+	defer span.End()
+
+	rows, err := tagDB.client.QueryContext(ctx, searchString)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := []groups.Member{}
+
+	for rows.Next() {
+		member := groups.Member{}
+		if err := rows.Scan(&member.Group, &member.Tag); err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+		ret = append(ret, member)
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+// enforceExclusiveGroups makes room for a new link in every exclusive group
+// its tag belongs to. Other tags from a group with groups.PolicyReplace are
+// removed from the file and other tags from a group with groups.PolicyReject
+// cause an error wrapping ErrExclusiveGroup, as do manual links to other tags
+// from any group when the new link isn't manual.
+func (tagDB *TagDB) enforceExclusiveGroups(ctx context.Context, tx *sql.Tx, link links.Link) error {
+	const (
+		siblingString = `SELECT g.name, g.policy, t.id, t.namespace, t.name, ft.source
+			FROM taggroupmembers m
+			JOIN taggroups g ON g.id = m.groupid AND g.exclusive = 1
+			JOIN taggroupmembers s ON s.groupid = g.id AND s.tagid != m.tagid
+			JOIN livefiletags ft ON ft.tagid = s.tagid AND ft.fileid = ?
+			JOIN tags t ON t.id = s.tagid
+			WHERE m.tagid = ?
+			ORDER BY g.name, t.namespace, t.name`
+		deleteString = "DELETE FROM filetags WHERE fileid = ? AND tagid = ?"
+	)
+
+	type sibling struct {
+		group  string
+		policy groups.Policy
+		tag    tags.Tag
+		source string
+	}
+
+	rows, err := tx.QueryContext(ctx, siblingString, link.File, link.Tag)
+	if err != nil {
+		return err
+	}
+
+	siblings := []sibling{}
+	for rows.Next() {
+		s := sibling{}
+		if err := rows.Scan(&s.group, &s.policy, &s.tag.Id, &s.tag.Namespace, &s.tag.Name, &s.source); err != nil {
+			rows.Close()
+			return err
+		}
+		siblings = append(siblings, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rejected := []string{}
+	for _, s := range siblings {
+		// only a person can replace what a person decided
+		manual := s.source == links.SourceManual && link.SourceOrManual() != links.SourceManual
+		if s.policy == groups.PolicyReject || manual {
+			rejected = append(rejected, fmt.Sprintf("%s from group %s", s.tag, s.group))
+		}
+	}
+
+	if len(rejected) > 0 {
+		return fmt.Errorf(
+			"%s already has %s: %w",
+			filePath(ctx, tx, link.File),
+			strings.Join(rejected, " and "),
+			ErrExclusiveGroup,
+		)
+	}
+
+	for _, s := range siblings {
+		detail := "replaced in exclusive group " + s.group
+		if err := tagDB.recordHistory(ctx, tx, history.LinkRemoved, link.File, s.tag.Id, detail); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, deleteString, link.File, s.tag.Id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// groupName returns the name of a group for use in errors, or a description of
+// its ID if the group can't be found.
+func groupName(ctx context.Context, q querier, groupId int) string {
+	const (
+		searchString = "SELECT name FROM taggroups WHERE id = ?"
+	)
+
+	var name string
+	if err := q.QueryRowContext(ctx, searchString, groupId).Scan(&name); err != nil {
+		return fmt.Sprintf("group ID %d", groupId)
+	}
+
+	return name
+}
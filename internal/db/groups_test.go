@@ -0,0 +1,581 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/whatsfordinner/fstagger/internal/groups"
+	"github.com/whatsfordinner/fstagger/internal/implications"
+	"github.com/whatsfordinner/fstagger/internal/links"
+	"github.com/whatsfordinner/fstagger/internal/tags"
+)
+
+func TestTagDBAddLinksExclusiveGroups(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr    bool
+		input        []links.Link
+		expectFile   int
+		expectTags   []string
+		expectReject bool
+	}{
+		"replace policy removes the other tag": {
+			false,
+			[]links.Link{{File: 1, Tag: 2}},
+			1,
+			[]string{"priority:low", "status:doing"},
+			false,
+		},
+		"reject policy refuses the link": {
+			true,
+			[]links.Link{{File: 1, Tag: 5}},
+			1,
+			[]string{"priority:low", "status:todo"},
+			true,
+		},
+		"groups which aren't exclusive allow both": {
+			false,
+			[]links.Link{{File: 2, Tag: 6}},
+			2,
+			[]string{"urgent", "priority:high"},
+			false,
+		},
+		"later links in a batch replace earlier ones": {
+			false,
+			[]links.Link{{File: 3, Tag: 1}, {File: 3, Tag: 3}},
+			3,
+			[]string{"status:done"},
+			false,
+		},
+		"a rejected link leaves the rest of the batch": {
+			true,
+			[]links.Link{{File: 1, Tag: 5}, {File: 1, Tag: 3}},
+			1,
+			[]string{"priority:low", "status:done"},
+			true,
+		},
+		"an existing link isn't replaced by itself": {
+			true,
+			[]links.Link{{File: 1, Tag: 1}},
+			1,
+			[]string{"priority:low", "status:todo"},
+			false,
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/groups.yml"})
+			defer teardown()
+
+			_, err := testDB.AddLinks(context.Background(), testData.input)
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if errors.Is(err, ErrExclusiveGroup) != testData.expectReject {
+				t.Fatalf("Expected rejection to be %t but got error: %v", testData.expectReject, err)
+			}
+
+			res := tagNamesForFile(t, testDB, testData.expectFile)
+			if !reflect.DeepEqual(res, testData.expectTags) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expectTags,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBAddLinksImpliedExclusiveGroups(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr    bool
+		rule         implications.Implication
+		setup        []links.Link
+		input        []links.Link
+		expectFile   int
+		expectTags   []string
+		expectReject bool
+	}{
+		"reject policy refuses the implied link and the link implying it": {
+			true,
+			implications.Implication{Tag: 6, Implied: 5},
+			[]links.Link{},
+			[]links.Link{{File: 1, Tag: 6}},
+			1,
+			[]string{"priority:low", "status:todo"},
+			true,
+		},
+		"an implied link can't replace a manual link": {
+			true,
+			implications.Implication{Tag: 6, Implied: 3},
+			[]links.Link{},
+			[]links.Link{{File: 1, Tag: 6}},
+			1,
+			[]string{"priority:low", "status:todo"},
+			true,
+		},
+		"replace policy removes a link that isn't manual": {
+			false,
+			implications.Implication{Tag: 6, Implied: 3},
+			[]links.Link{{File: 3, Tag: 1, Source: links.SourceImport}},
+			[]links.Link{{File: 3, Tag: 6}},
+			3,
+			[]string{"urgent", "status:done"},
+			false,
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/groups.yml"})
+			defer teardown()
+
+			if _, err := testDB.AddImplications(
+				context.Background(),
+				[]implications.Implication{testData.rule},
+			); err != nil {
+				t.Fatalf("Unable to add implication: %s", err.Error())
+			}
+
+			if _, err := testDB.AddLinks(context.Background(), testData.setup); err != nil {
+				t.Fatalf("Unable to add links: %s", err.Error())
+			}
+
+			_, err := testDB.AddLinks(context.Background(), testData.input)
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if errors.Is(err, ErrExclusiveGroup) != testData.expectReject {
+				t.Fatalf("Expected rejection to be %t but got error: %v", testData.expectReject, err)
+			}
+
+			res := tagNamesForFile(t, testDB, testData.expectFile)
+			if !reflect.DeepEqual(res, testData.expectTags) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expectTags,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBAddImplicationsExclusiveGroups(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/groups.yml"})
+	defer teardown()
+
+	if _, err := testDB.AddLinks(context.Background(), []links.Link{{File: 1, Tag: 6}}); err != nil {
+		t.Fatalf("Unable to add link: %s", err.Error())
+	}
+
+	// file 1 already has priority:low so the rule's link would be rejected
+	_, err := testDB.AddImplications(
+		context.Background(),
+		[]implications.Implication{{Tag: 6, Implied: 5}},
+	)
+	if !errors.Is(err, ErrExclusiveGroup) {
+		t.Fatalf("Expected rejection but got error: %v", err)
+	}
+
+	res, err := testDB.GetImplications(context.Background())
+	if err != nil {
+		t.Fatalf("Error retrieving implications: %s", err.Error())
+	}
+
+	if len(res) != 0 {
+		t.Fatalf("Result did not match expectation\nResult: %+v\nExpected: %+v", res, []implications.Implication{})
+	}
+
+	tagNames := tagNamesForFile(t, testDB, 1)
+	expect := []string{"urgent", "priority:low", "status:todo"}
+	if !reflect.DeepEqual(tagNames, expect) {
+		t.Fatalf("Result did not match expectation\nResult: %+v\nExpected: %+v", tagNames, expect)
+	}
+}
+
+func TestTagDBAddGroups(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr bool
+		input     []groups.Group
+		expect    []groups.Group
+	}{
+		"new group": {
+			false,
+			[]groups.Group{{Name: "size", Exclusive: true, Policy: groups.PolicyReplace}},
+			[]groups.Group{{Id: 4, Name: "size", Exclusive: true, Policy: groups.PolicyReplace}},
+		},
+		"policy defaults to reject": {
+			false,
+			[]groups.Group{{Name: "size"}},
+			[]groups.Group{{Id: 4, Name: "size", Policy: groups.PolicyReject}},
+		},
+		"group already exists": {
+			true,
+			[]groups.Group{{Name: "status"}},
+			[]groups.Group{},
+		},
+		"unknown policy": {
+			true,
+			[]groups.Group{{Name: "size", Policy: "ignore"}},
+			[]groups.Group{},
+		},
+		"no name": {
+			true,
+			[]groups.Group{{Policy: groups.PolicyReject}},
+			[]groups.Group{},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/groups.yml"})
+			defer teardown()
+
+			res, err := testDB.AddGroups(context.Background(), testData.input)
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBUpdateGroups(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr bool
+		input     groups.Group
+		expect    groups.Group
+	}{
+		"make a group exclusive": {
+			false,
+			groups.Group{Id: 3, Name: "flags", Exclusive: true, Policy: groups.PolicyReplace},
+			groups.Group{Id: 3, Name: "flags", Exclusive: true, Policy: groups.PolicyReplace},
+		},
+		"rename to an existing group": {
+			true,
+			groups.Group{Id: 3, Name: "status", Policy: groups.PolicyReject},
+			groups.Group{Id: 3, Name: "flags", Description: "anything worth noticing", Policy: groups.PolicyReject},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/groups.yml"})
+			defer teardown()
+
+			_, err := testDB.UpdateGroups(context.Background(), []groups.Group{testData.input})
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			res, err := testDB.GetGroupByName(context.Background(), testData.expect.Name)
+			if err != nil {
+				t.Fatalf("Error retrieving group: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBGroupMembers(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr bool
+		add       []groups.Member
+		remove    []groups.Member
+		expect    []groups.Member
+	}{
+		"add a member": {
+			false,
+			[]groups.Member{{Group: 3, Tag: 1}},
+			[]groups.Member{},
+			[]groups.Member{{Group: 1, Tag: 1}, {Group: 1, Tag: 2}, {Group: 1, Tag: 3}, {Group: 2, Tag: 4}, {Group: 2, Tag: 5}, {Group: 3, Tag: 1}, {Group: 3, Tag: 5}, {Group: 3, Tag: 6}},
+		},
+		"add an existing member": {
+			true,
+			[]groups.Member{{Group: 1, Tag: 1}},
+			[]groups.Member{},
+			[]groups.Member{{Group: 1, Tag: 1}, {Group: 1, Tag: 2}, {Group: 1, Tag: 3}, {Group: 2, Tag: 4}, {Group: 2, Tag: 5}, {Group: 3, Tag: 5}, {Group: 3, Tag: 6}},
+		},
+		"add a tag that doesn't exist": {
+			true,
+			[]groups.Member{{Group: 1, Tag: 10}},
+			[]groups.Member{},
+			[]groups.Member{{Group: 1, Tag: 1}, {Group: 1, Tag: 2}, {Group: 1, Tag: 3}, {Group: 2, Tag: 4}, {Group: 2, Tag: 5}, {Group: 3, Tag: 5}, {Group: 3, Tag: 6}},
+		},
+		"remove a member": {
+			false,
+			[]groups.Member{},
+			[]groups.Member{{Group: 3, Tag: 5}},
+			[]groups.Member{{Group: 1, Tag: 1}, {Group: 1, Tag: 2}, {Group: 1, Tag: 3}, {Group: 2, Tag: 4}, {Group: 2, Tag: 5}, {Group: 3, Tag: 6}},
+		},
+		"remove a tag that isn't a member": {
+			true,
+			[]groups.Member{},
+			[]groups.Member{{Group: 3, Tag: 1}},
+			[]groups.Member{{Group: 1, Tag: 1}, {Group: 1, Tag: 2}, {Group: 1, Tag: 3}, {Group: 2, Tag: 4}, {Group: 2, Tag: 5}, {Group: 3, Tag: 5}, {Group: 3, Tag: 6}},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/groups.yml"})
+			defer teardown()
+
+			_, addErr := testDB.AddGroupMembers(context.Background(), testData.add)
+			removeErr := testDB.DeleteGroupMembers(context.Background(), testData.remove)
+			err := errors.Join(addErr, removeErr)
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			res, err := testDB.GetGroupMembers(context.Background())
+			if err != nil {
+				t.Fatalf("Error retrieving members: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+// TestTagDBGroupErrors checks that errors name the tags, groups and files
+// involved rather than their IDs.
+func TestTagDBGroupErrors(t *testing.T) {
+	testMap := map[string]struct {
+		do     func(testDB *TagDB) error
+		expect string
+	}{
+		"existing member": {
+			func(testDB *TagDB) error {
+				_, err := testDB.AddGroupMembers(context.Background(), []groups.Member{{Group: 1, Tag: 1}})
+				return err
+			},
+			"tag status:todo is already in group status",
+		},
+		"missing member": {
+			func(testDB *TagDB) error {
+				return testDB.DeleteGroupMembers(context.Background(), []groups.Member{{Group: 3, Tag: 1}})
+			},
+			"tag status:todo isn't in group flags",
+		},
+		"rejected merge": {
+			func(testDB *TagDB) error {
+				if _, err := testDB.AddLinks(context.Background(), []links.Link{{File: 1, Tag: 6}}); err != nil {
+					return err
+				}
+
+				_, err := testDB.MergeTags(context.Background(), []tags.Tag{{Id: 6}}, tags.Tag{Id: 5})
+				return err
+			},
+			"can't merge tag urgent into tag priority:high: " +
+				"/path/to/foo already has priority:low from group priority: " + ErrExclusiveGroup.Error(),
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/groups.yml"})
+			defer teardown()
+
+			if err := testData.do(testDB); err == nil || err.Error() != testData.expect {
+				t.Fatalf("Result did not match expectation\nResult: %v\nExpected: %s", err, testData.expect)
+			}
+		})
+	}
+}
+
+func TestTagDBDeleteGroups(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/groups.yml"})
+	defer teardown()
+
+	if err := testDB.DeleteGroups(context.Background(), []groups.Group{{Id: 1}}); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	members, err := testDB.GetGroupMembers(context.Background())
+	if err != nil {
+		t.Fatalf("Error retrieving members: %s", err.Error())
+	}
+
+	expect := []groups.Member{{Group: 2, Tag: 4}, {Group: 2, Tag: 5}, {Group: 3, Tag: 5}, {Group: 3, Tag: 6}}
+	if !reflect.DeepEqual(members, expect) {
+		t.Fatalf(
+			"Result did not match expectation\nResult: %+v\nExpected: %+v",
+			members,
+			expect,
+		)
+	}
+
+	if _, err := testDB.GetTagById(context.Background(), 1); err != nil {
+		t.Fatalf("Expected tags to be kept but got: %s", err.Error())
+	}
+
+	if err := testDB.DeleteGroups(context.Background(), []groups.Group{{Id: 10}}); err == nil {
+		t.Fatal("Expected error but got no error")
+	}
+}
+
+func TestTagDBMergeTagsGroupMembers(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/groups.yml"})
+	defer teardown()
+
+	if _, err := testDB.MergeTags(
+		context.Background(),
+		[]tags.Tag{{Id: 6}},
+		tags.Tag{Id: 3},
+	); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	members, err := testDB.GetGroupMembers(context.Background())
+	if err != nil {
+		t.Fatalf("Error retrieving members: %s", err.Error())
+	}
+
+	expect := []groups.Member{{Group: 1, Tag: 1}, {Group: 1, Tag: 2}, {Group: 1, Tag: 3}, {Group: 2, Tag: 4}, {Group: 2, Tag: 5}, {Group: 3, Tag: 3}, {Group: 3, Tag: 5}}
+	if !reflect.DeepEqual(members, expect) {
+		t.Fatalf(
+			"Result did not match expectation\nResult: %+v\nExpected: %+v",
+			members,
+			expect,
+		)
+	}
+}
+
+func TestTagDBMergeTagsExclusiveGroups(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr    bool
+		setup        []links.Link
+		source       int
+		target       int
+		expectFile   int
+		expectTags   []string
+		expectReject bool
+	}{
+		"replace policy removes the other tag": {
+			false,
+			[]links.Link{{File: 1, Tag: 6}},
+			6,
+			3,
+			1,
+			[]string{"priority:low", "status:done"},
+			false,
+		},
+		"reject policy refuses the merge": {
+			true,
+			[]links.Link{{File: 1, Tag: 6}},
+			6,
+			5,
+			1,
+			[]string{"urgent", "priority:low", "status:todo"},
+			true,
+		},
+		"merging members of different groups checks both groups": {
+			true,
+			[]links.Link{{File: 3, Tag: 2}, {File: 3, Tag: 4}},
+			2,
+			5,
+			3,
+			[]string{"priority:low", "status:doing"},
+			true,
+		},
+		"groups which aren't exclusive allow both": {
+			false,
+			[]links.Link{{File: 2, Tag: 3}},
+			3,
+			6,
+			2,
+			[]string{"urgent", "priority:high"},
+			false,
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/groups.yml"})
+			defer teardown()
+
+			if _, err := testDB.AddLinks(context.Background(), testData.setup); err != nil {
+				t.Fatalf("Unable to add links: %s", err.Error())
+			}
+
+			_, err := testDB.MergeTags(
+				context.Background(),
+				[]tags.Tag{{Id: testData.source}},
+				tags.Tag{Id: testData.target},
+			)
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if errors.Is(err, ErrExclusiveGroup) != testData.expectReject {
+				t.Fatalf("Expected rejection to be %t but got error: %v", testData.expectReject, err)
+			}
+
+			res := tagNamesForFile(t, testDB, testData.expectFile)
+			if !reflect.DeepEqual(res, testData.expectTags) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expectTags,
+				)
+			}
+		})
+	}
+}
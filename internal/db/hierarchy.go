@@ -0,0 +1,169 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/whatsfordinner/fstagger/internal/history"
+	"github.com/whatsfordinner/fstagger/internal/tags"
+)
+
+// descendantsString wraps a condition on a tag aliased as t so that it matches
+// the IDs of every tag that satisfies the condition, or has an alias that does,
+// along with every tag below those in the hierarchy. The condition is used
+// twice so its arguments need to be provided twice. The second verb adds extra
+// recursive steps, like following implication rules, and may be empty.
+const descendantsString = `WITH RECURSIVE matched(id) AS (
+		SELECT t.id FROM tags t WHERE %[1]s
+		UNION
+		SELECT t.tagid FROM tagaliases t WHERE %[1]s
+		UNION
+		SELECT c.id FROM tags c JOIN matched m ON c.parent = m.id
+		%[2]s
+	)
+	SELECT id FROM matched`
+
+// ensureAncestors creates every tag above the provided tag in the hierarchy
+// that doesn't already exist and returns the ID of its parent, or 0 if it's
+// at the top of the hierarchy. Ancestors are created without a description and
+// each one created is recorded in the history.
+func (tagDB *TagDB) ensureAncestors(ctx context.Context, tx *sql.Tx, tag tags.Tag) (int, error) {
+	const (
+		searchString = "SELECT id FROM tags WHERE namespace = ? AND name = ?"
+		insertString = `INSERT INTO tags(namespace, name, description, parent, created, updated)
+			VALUES(?, ?, '', NULLIF(?, 0), unixepoch(), unixepoch()) RETURNING id`
+	)
+
+	parentId := 0
+	for _, name := range tag.Ancestors() {
+		var ancestorId int
+		err := tx.QueryRowContext(ctx, searchString, tag.Namespace, name).Scan(&ancestorId)
+		if errors.Is(err, sql.ErrNoRows) {
+			err = tx.QueryRowContext(ctx, insertString, tag.Namespace, name, parentId).Scan(&ancestorId)
+			if err == nil {
+				err = tagDB.recordHistory(ctx, tx, history.TagAdded, 0, ancestorId, "ancestor of "+tag.String())
+			}
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		parentId = ancestorId
+	}
+
+	return parentId, nil
+}
+
+// adoptChildren points any tags one level below the provided tag in the
+// hierarchy at it if they don't already have a parent.
+func adoptChildren(ctx context.Context, tx *sql.Tx, tag tags.Tag) error {
+	const (
+		adoptString = `UPDATE tags SET parent = ?, updated = unixepoch()
+			WHERE parent IS NULL
+				AND namespace = ?
+				AND length(name) > length(?)
+				AND substr(name, 1, length(?)) = ?
+				AND instr(substr(name, length(?) + 1), '/') = 0`
+	)
+
+	prefix := tag.Name + tags.HierarchySeparator
+	_, err := tx.ExecContext(ctx, adoptString, tag.Id, tag.Namespace, prefix, prefix, prefix, prefix)
+
+	return err
+}
+
+// moveDescendants moves every tag below from in the hierarchy to the same
+// position below to, e.g. food/dessert/pie becomes meal/dessert/pie when food
+// is moved to meal, recording each rename in the history. If a moved tag
+// collides with an existing tag then it's merged into the existing tag when
+// merge is true and it's an error otherwise.
+func (tagDB *TagDB) moveDescendants(ctx context.Context, tx *sql.Tx, from tags.Tag, to tags.Tag, merge bool) error {
+	const (
+		listString = `SELECT id, name FROM tags
+			WHERE namespace = ? AND substr(name, 1, length(?)) = ?
+			ORDER BY length(name)`
+		searchString = "SELECT id FROM tags WHERE namespace = ? AND name = ?"
+		moveString   = "UPDATE tags SET namespace = ?, name = ?, parent = NULLIF(?, 0), updated = unixepoch() WHERE id = ?"
+	)
+
+	prefix := from.Name + tags.HierarchySeparator
+	if from.Namespace == to.Namespace && strings.HasPrefix(to.Name, prefix) {
+		return fmt.Errorf("can't move tag %s below itself", from)
+	}
+
+	rows, err := tx.QueryContext(ctx, listString, from.Namespace, prefix, prefix)
+	if err != nil {
+		return err
+	}
+
+	descendants := []tags.Tag{}
+	for rows.Next() {
+		descendant := tags.Tag{Namespace: from.Namespace}
+		if err := rows.Scan(&descendant.Id, &descendant.Name); err != nil {
+			rows.Close()
+			return err
+		}
+		descendants = append(descendants, descendant)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// shorter names are moved first so a tag's new parent always exists by
+	// the time the tag itself is moved
+	for _, descendant := range descendants {
+		moved := tags.Tag{
+			Id:        descendant.Id,
+			Namespace: to.Namespace,
+			Name:      to.Name + strings.TrimPrefix(descendant.Name, from.Name),
+		}
+
+		var existingId int
+		err := tx.QueryRowContext(ctx, searchString, moved.Namespace, moved.Name).Scan(&existingId)
+		switch {
+		case err == nil:
+			if !merge {
+				return fmt.Errorf("tag already exists: %s", moved)
+			}
+
+			if err := tagDB.foldTag(ctx, tx, descendant.Id, existingId); err != nil {
+				return err
+			}
+		case errors.Is(err, sql.ErrNoRows):
+			parentId, err := tagDB.ensureAncestors(ctx, tx, moved)
+			if err != nil {
+				return err
+			}
+
+			if _, err := tx.ExecContext(
+				ctx,
+				moveString,
+				moved.Namespace,
+				moved.Name,
+				parentId,
+				moved.Id,
+			); err != nil {
+				return err
+			}
+
+			if err := tagDB.recordHistory(
+				ctx,
+				tx,
+				history.TagUpdated,
+				0,
+				moved.Id,
+				describeTagUpdate(descendant, moved),
+			); err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
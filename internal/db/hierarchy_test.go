@@ -0,0 +1,482 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/whatsfordinner/fstagger/internal/files"
+	"github.com/whatsfordinner/fstagger/internal/tags"
+)
+
+// tagTree returns every tag in the DB mapped to the name of its parent, or to
+// an empty string if it's at the top of the hierarchy.
+func tagTree(t *testing.T, testDB *TagDB) map[string]string {
+	allTags, err := testDB.GetTags(context.Background())
+	if err != nil {
+		t.Fatalf("Error retrieving tags: %s", err.Error())
+	}
+
+	names := map[int]string{}
+	for _, tag := range allTags {
+		names[tag.Id] = tag.String()
+	}
+
+	ret := map[string]string{}
+	for _, tag := range allTags {
+		ret[tag.String()] = names[tag.Parent]
+	}
+
+	return ret
+}
+
+func TestTagDBAddTagsHierarchy(t *testing.T) {
+	testMap := map[string]struct {
+		input      []tags.Tag
+		expect     []tags.Tag
+		expectTree map[string]string
+	}{
+		"child of an existing tag": {
+			[]tags.Tag{{Name: "food/snack"}},
+			[]tags.Tag{{Id: 9, Name: "food/snack", Parent: 1}},
+			map[string]string{
+				"food":             "",
+				"food/dessert":     "food",
+				"food/dessert/pie": "food/dessert",
+				"food/main":        "food",
+				"food/snack":       "food",
+				"drink":            "",
+				"meal":             "",
+				"meal/dessert":     "meal",
+				"snack/main":       "",
+			},
+		},
+		"missing ancestors are created": {
+			[]tags.Tag{{Name: "drink/tea/green"}},
+			[]tags.Tag{{Id: 10, Name: "drink/tea/green", Parent: 9}},
+			map[string]string{
+				"food":             "",
+				"food/dessert":     "food",
+				"food/dessert/pie": "food/dessert",
+				"food/main":        "food",
+				"drink":            "",
+				"drink/tea":        "drink",
+				"drink/tea/green":  "drink/tea",
+				"meal":             "",
+				"meal/dessert":     "meal",
+				"snack/main":       "",
+			},
+		},
+		"orphans are adopted": {
+			[]tags.Tag{{Name: "snack"}},
+			[]tags.Tag{{Id: 9, Name: "snack"}},
+			map[string]string{
+				"food":             "",
+				"food/dessert":     "food",
+				"food/dessert/pie": "food/dessert",
+				"food/main":        "food",
+				"drink":            "",
+				"meal":             "",
+				"meal/dessert":     "meal",
+				"snack":            "",
+				"snack/main":       "snack",
+			},
+		},
+		"names with empty segments aren't a hierarchy": {
+			[]tags.Tag{{Name: "drink//tea"}},
+			[]tags.Tag{{Id: 9, Name: "drink//tea"}},
+			map[string]string{
+				"food":             "",
+				"food/dessert":     "food",
+				"food/dessert/pie": "food/dessert",
+				"food/main":        "food",
+				"drink":            "",
+				"drink//tea":       "",
+				"meal":             "",
+				"meal/dessert":     "meal",
+				"snack/main":       "",
+			},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/hierarchy.yml"})
+			defer teardown()
+
+			res, err := testDB.AddTags(context.Background(), testData.input)
+			if err != nil {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+
+			tree := tagTree(t, testDB)
+			if !reflect.DeepEqual(tree, testData.expectTree) {
+				t.Fatalf(
+					"DB did not match expectation\nResult: %+v\nExpected: %+v",
+					tree,
+					testData.expectTree,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBUpdateTagsHierarchy(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr  bool
+		input      []tags.Tag
+		expect     []tags.Tag
+		expectTree map[string]string
+	}{
+		"description only": {
+			false,
+			[]tags.Tag{{Id: 2, Name: "food/dessert", Description: "pudding"}},
+			[]tags.Tag{{Id: 2, Name: "food/dessert", Description: "pudding", Parent: 1}},
+			map[string]string{
+				"food":             "",
+				"food/dessert":     "food",
+				"food/dessert/pie": "food/dessert",
+				"food/main":        "food",
+				"drink":            "",
+				"meal":             "",
+				"meal/dessert":     "meal",
+				"snack/main":       "",
+			},
+		},
+		"rename moves descendants": {
+			false,
+			[]tags.Tag{{Id: 2, Name: "treats"}},
+			[]tags.Tag{{Id: 2, Name: "treats"}},
+			map[string]string{
+				"food":         "",
+				"treats":       "",
+				"treats/pie":   "treats",
+				"food/main":    "food",
+				"drink":        "",
+				"meal":         "",
+				"meal/dessert": "meal",
+				"snack/main":   "",
+			},
+		},
+		"rename below another tag": {
+			false,
+			[]tags.Tag{{Id: 2, Name: "drink/sweet"}},
+			[]tags.Tag{{Id: 2, Name: "drink/sweet", Parent: 5}},
+			map[string]string{
+				"food":            "",
+				"drink/sweet":     "drink",
+				"drink/sweet/pie": "drink/sweet",
+				"food/main":       "food",
+				"drink":           "",
+				"meal":            "",
+				"meal/dessert":    "meal",
+				"snack/main":      "",
+			},
+		},
+		"rename adopts orphans": {
+			false,
+			[]tags.Tag{{Id: 5, Name: "snack"}},
+			[]tags.Tag{{Id: 5, Name: "snack"}},
+			map[string]string{
+				"food":             "",
+				"food/dessert":     "food",
+				"food/dessert/pie": "food/dessert",
+				"food/main":        "food",
+				"snack":            "",
+				"meal":             "",
+				"meal/dessert":     "meal",
+				"snack/main":       "snack",
+			},
+		},
+		"rename below itself": {
+			true,
+			[]tags.Tag{{Id: 1, Name: "food/food"}},
+			[]tags.Tag{},
+			map[string]string{
+				"food":             "",
+				"food/dessert":     "food",
+				"food/dessert/pie": "food/dessert",
+				"food/main":        "food",
+				"drink":            "",
+				"meal":             "",
+				"meal/dessert":     "meal",
+				"snack/main":       "",
+			},
+		},
+		"descendant collides with an existing tag": {
+			true,
+			[]tags.Tag{{Id: 1, Name: "snack"}, {Id: 5, Name: "beverage"}},
+			[]tags.Tag{{Id: 5, Name: "beverage"}},
+			map[string]string{
+				"food":             "",
+				"food/dessert":     "food",
+				"food/dessert/pie": "food/dessert",
+				"food/main":        "food",
+				"beverage":         "",
+				"meal":             "",
+				"meal/dessert":     "meal",
+				"snack/main":       "",
+			},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/hierarchy.yml"})
+			defer teardown()
+
+			res, err := testDB.UpdateTags(context.Background(), testData.input)
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+
+			tree := tagTree(t, testDB)
+			if !reflect.DeepEqual(tree, testData.expectTree) {
+				t.Fatalf(
+					"DB did not match expectation\nResult: %+v\nExpected: %+v",
+					tree,
+					testData.expectTree,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBMergeTagsHierarchy(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr    bool
+		inputSources []tags.Tag
+		inputTarget  tags.Tag
+		expectTree   map[string]string
+		expectCounts map[int]int
+	}{
+		"descendants move to the target": {
+			false,
+			[]tags.Tag{{Id: 2}},
+			tags.Tag{Id: 7},
+			map[string]string{
+				"food":             "",
+				"meal/dessert/pie": "meal/dessert",
+				"food/main":        "food",
+				"drink":            "",
+				"meal":             "",
+				"meal/dessert":     "meal",
+				"snack/main":       "",
+			},
+			map[int]int{3: 1, 4: 1, 5: 1, 7: 2},
+		},
+		"colliding descendants are merged": {
+			false,
+			[]tags.Tag{{Id: 1}},
+			tags.Tag{Id: 6},
+			map[string]string{
+				"meal/dessert/pie": "meal/dessert",
+				"meal/main":        "meal",
+				"drink":            "",
+				"meal":             "",
+				"meal/dessert":     "meal",
+				"snack/main":       "",
+			},
+			map[int]int{3: 1, 4: 1, 5: 1, 7: 2},
+		},
+		"merging into a descendant": {
+			true,
+			[]tags.Tag{{Id: 1}},
+			tags.Tag{Id: 2},
+			map[string]string{
+				"food":             "",
+				"food/dessert":     "food",
+				"food/dessert/pie": "food/dessert",
+				"food/main":        "food",
+				"drink":            "",
+				"meal":             "",
+				"meal/dessert":     "meal",
+				"snack/main":       "",
+			},
+			map[int]int{2: 1, 3: 1, 4: 1, 5: 1, 7: 1},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/hierarchy.yml"})
+			defer teardown()
+
+			_, err := testDB.MergeTags(
+				context.Background(),
+				testData.inputSources,
+				testData.inputTarget,
+			)
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			tree := tagTree(t, testDB)
+			if !reflect.DeepEqual(tree, testData.expectTree) {
+				t.Fatalf(
+					"DB did not match expectation\nResult: %+v\nExpected: %+v",
+					tree,
+					testData.expectTree,
+				)
+			}
+
+			counts, err := testDB.GetTagFileCounts(context.Background())
+			if err != nil {
+				t.Fatalf("Error retrieving tag counts: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(counts, testData.expectCounts) {
+				t.Fatalf(
+					"DB did not match expectation\nResult: %+v\nExpected: %+v",
+					counts,
+					testData.expectCounts,
+				)
+			}
+		})
+	}
+}
+
+// TestTagDBMergeTagsAfterParentMerge checks that merging a parent renames or
+// folds its descendants, so a later merge of a child has to find it by its new
+// name, which is how "fstagger tags lint" applies one plan after another.
+func TestTagDBMergeTagsAfterParentMerge(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/hierarchy.yml"})
+	defer teardown()
+
+	ctx := context.Background()
+	if _, err := testDB.MergeTags(ctx, []tags.Tag{{Id: 1}}, tags.Tag{Id: 6}); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	if _, err := testDB.GetTagByName(ctx, "food/dessert"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("Expected a folded child to be gone but got: %v", err)
+	}
+
+	source, err := testDB.GetTagByName(ctx, "meal/main")
+	if err != nil {
+		t.Fatalf("Expected a moved child to be found by its new name but got: %s", err.Error())
+	}
+
+	target, err := testDB.GetTagByName(ctx, "meal/dessert")
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	if _, err := testDB.MergeTags(ctx, []tags.Tag{source}, target); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	expect := map[string]string{
+		"meal/dessert/pie": "meal/dessert",
+		"drink":            "",
+		"meal":             "",
+		"meal/dessert":     "meal",
+		"snack/main":       "",
+	}
+	if tree := tagTree(t, testDB); !reflect.DeepEqual(tree, expect) {
+		t.Fatalf("DB did not match expectation\nResult: %+v\nExpected: %+v", tree, expect)
+	}
+}
+
+func TestTagDBGetFilesByTagsHierarchy(t *testing.T) {
+	foo := files.File{Id: 1, Path: "/path/to/foo", Hash: "foohash"}
+	bar := files.File{Id: 2, Path: "/path/to/bar", Hash: "barhash"}
+	baz := files.File{Id: 3, Path: "/path/to/baz", Hash: "bazhash"}
+
+	testMap := map[string]struct {
+		input  []string
+		expect []files.File
+	}{
+		"top of the hierarchy": {
+			[]string{"food"},
+			[]files.File{bar, baz, foo},
+		},
+		"middle of the hierarchy": {
+			[]string{"food/dessert"},
+			[]files.File{baz, foo},
+		},
+		"bottom of the hierarchy": {
+			[]string{"food/dessert/pie"},
+			[]files.File{foo},
+		},
+		"leaf name alone": {
+			[]string{"dessert"},
+			[]files.File{},
+		},
+		"wildcard below a tag": {
+			[]string{"food/*"},
+			[]files.File{bar, baz, foo},
+		},
+		"multiple hierarchies": {
+			[]string{"food", "meal"},
+			[]files.File{bar},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/hierarchy.yml"})
+			defer teardown()
+
+			res, err := testDB.GetFilesByTags(context.Background(), testData.input)
+			if err != nil {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBGetTagTreeFileCounts(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/hierarchy.yml"})
+	defer teardown()
+
+	res, err := testDB.GetTagTreeFileCounts(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	expect := map[int]int{1: 3, 2: 2, 3: 1, 4: 1, 5: 1, 6: 1, 7: 1}
+	if !reflect.DeepEqual(res, expect) {
+		t.Fatalf(
+			"Result did not match expectation\nResult: %+v\nExpected: %+v",
+			res,
+			expect,
+		)
+	}
+}
@@ -0,0 +1,193 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/whatsfordinner/fstagger/internal/files"
+	"github.com/whatsfordinner/fstagger/internal/history"
+	"github.com/whatsfordinner/fstagger/internal/tags"
+
+	"go.opentelemetry.io/otel/codes"
+)
+
+const (
+	historyColumns = `id, at, user, host, command, action, COALESCE(fileid, 0), COALESCE(path, ''),
+		COALESCE(tagid, 0), COALESCE(tag, ''), detail`
+)
+
+// WithActor sets who the changes made through the TagDB are recorded against
+// in its history. Without it changes are recorded against nobody.
+func WithActor(actor history.Actor) func(*TagDB) {
+	return func(t *TagDB) {
+		t.actor = actor
+	}
+}
+
+// recordHistory appends an entry for a change to a file, a tag or a link to
+// the history as part of tx. A file or tag ID of 0 means the change wasn't to
+// one. The file and tag have to exist when it's called so their path and name
+// can be copied into the entry.
+func (tagDB *TagDB) recordHistory(
+	ctx context.Context,
+	tx *sql.Tx,
+	action history.Action,
+	fileId int,
+	tagId int,
+	detail string,
+) error {
+	const (
+		recordString = `INSERT INTO history(at, user, host, command, action, fileid, path, tagid, tag, detail)
+			VALUES(unixepoch(), ?, ?, ?, ?,
+				NULLIF(?, 0), (SELECT path FROM files WHERE id = ?),
+				NULLIF(?, 0), (SELECT CASE WHEN namespace = '' THEN name ELSE namespace || ':' || name END
+					FROM tags WHERE id = ?),
+				?)`
+	)
+
+	_, err := tx.ExecContext(
+		ctx,
+		recordString,
+		tagDB.actor.User,
+		tagDB.actor.Host,
+		tagDB.actor.Command,
+		action,
+		fileId,
+		fileId,
+		tagId,
+		tagId,
+		detail,
+	)
+
+	return err
+}
+
+// recordTagRemoved appends an entry to the history for every file which is
+// about to lose a tag. Expired links aren't recorded since they were already
+// gone as far as anyone could tell.
+func (tagDB *TagDB) recordTagRemoved(ctx context.Context, tx *sql.Tx, tagId int, detail string) error {
+	const (
+		recordString = `INSERT INTO history(at, user, host, command, action, fileid, path, tagid, tag, detail)
+			SELECT unixepoch(), ?, ?, ?, ?, ft.fileid, f.path, ft.tagid,
+				CASE WHEN t.namespace = '' THEN t.name ELSE t.namespace || ':' || t.name END, ?
+			FROM livefiletags ft
+			JOIN files f ON f.id = ft.fileid
+			JOIN tags t ON t.id = ft.tagid
+			WHERE ft.tagid = ?
+			ORDER BY f.path`
+	)
+
+	_, err := tx.ExecContext(
+		ctx,
+		recordString,
+		tagDB.actor.User,
+		tagDB.actor.Host,
+		tagDB.actor.Command,
+		history.LinkRemoved,
+		detail,
+		tagId,
+	)
+
+	return err
+}
+
+// GetHistory returns every entry in the history, oldest first.
+func (tagDB *TagDB) GetHistory(ctx context.Context) ([]history.Entry, error) {
+	ctx, span := tracer.Start(ctx, "GetHistory")
+	defer span.End()
+
+	ret, err := getHistory(ctx, tagDB.client, "1")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+// GetHistoryForFile returns every entry in the history about a file, oldest
+// first. Entries are matched by the file's ID and by the ID of any file which
+// has been recorded at its path, so the history of a file is found even if it
+// was tracked under another ID before.
+func (tagDB *TagDB) GetHistoryForFile(ctx context.Context, file files.File) ([]history.Entry, error) {
+	ctx, span := tracer.Start(ctx, "GetHistoryForFile")
+	defer span.End()
+
+	ret, err := getHistory(
+		ctx,
+		tagDB.client,
+		"fileid = ? OR fileid IN (SELECT fileid FROM history WHERE path = ?)",
+		file.Id,
+		file.Path,
+	)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+// GetHistoryForTag returns every entry in the history about a tag, oldest
+// first. Entries are matched by the tag's ID and by the ID of any tag which has
+// been recorded with its name, so a deleted tag's history can be found by name
+// including from before it was renamed.
+func (tagDB *TagDB) GetHistoryForTag(ctx context.Context, tag tags.Tag) ([]history.Entry, error) {
+	ctx, span := tracer.Start(ctx, "GetHistoryForTag")
+	defer span.End()
+
+	ret, err := getHistory(
+		ctx,
+		tagDB.client,
+		"tagid = ? OR tagid IN (SELECT tagid FROM history WHERE tag = ?)",
+		tag.Id,
+		tag.String(),
+	)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+func getHistory(ctx context.Context, q querier, condition string, args ...any) ([]history.Entry, error) {
+	rows, err := q.QueryContext(
+		ctx,
+		"SELECT "+historyColumns+" FROM history WHERE "+condition+" ORDER BY id",
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := []history.Entry{}
+	for rows.Next() {
+		entry := history.Entry{}
+		var at int64
+		if err := rows.Scan(
+			&entry.Id,
+			&at,
+			&entry.User,
+			&entry.Host,
+			&entry.Command,
+			&entry.Action,
+			&entry.File,
+			&entry.Path,
+			&entry.Tag,
+			&entry.TagName,
+			&entry.Detail,
+		); err != nil {
+			return nil, err
+		}
+		entry.Time = time.Unix(at, 0).UTC()
+		ret = append(ret, entry)
+	}
+
+	return ret, rows.Err()
+}
@@ -0,0 +1,331 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/whatsfordinner/fstagger/internal/files"
+	"github.com/whatsfordinner/fstagger/internal/history"
+	"github.com/whatsfordinner/fstagger/internal/links"
+	"github.com/whatsfordinner/fstagger/internal/tags"
+)
+
+// historySummary is the part of a history entry which doesn't depend on when
+// the test ran.
+type historySummary struct {
+	action  history.Action
+	path    string
+	tagName string
+	detail  string
+}
+
+func summariseHistory(t *testing.T, entries []history.Entry, actor history.Actor) []historySummary {
+	ret := []historySummary{}
+	for _, entry := range entries {
+		if entry.Actor != actor {
+			t.Fatalf("Expected entry by %+v but got: %+v", actor, entry.Actor)
+		}
+		ret = append(ret, historySummary{entry.Action, entry.Path, entry.TagName, entry.Detail})
+	}
+
+	return ret
+}
+
+func TestTagDBHistory(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/get_tags_no_tags.yml"})
+	defer teardown()
+
+	actor := history.Actor{User: "alice", Host: "laptop", Command: "fstagger tag add foo inbox"}
+	testDB.actor = actor
+	ctx := context.Background()
+	expires := time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	addedFiles, err := testDB.AddFiles(ctx, []files.File{
+		{Path: "/path/to/foo", Hash: "foohash"},
+		{Path: "/path/to/bar", Hash: "barhash"},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	addedTags, err := testDB.AddTags(ctx, []tags.Tag{{Name: "inbox"}, {Name: "draft"}})
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	if _, err := testDB.AddLinks(ctx, []links.Link{
+		{File: addedFiles[0].Id, Tag: addedTags[0].Id},
+		{File: addedFiles[1].Id, Tag: addedTags[0].Id},
+		{File: addedFiles[0].Id, Tag: addedTags[1].Id},
+		{File: addedFiles[0].Id, Tag: addedTags[1].Id, Expires: expires},
+	}); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	renamed := addedTags[0]
+	renamed.Name = "todo"
+	renamed.Description = "to look at"
+	if _, err := testDB.UpdateTags(ctx, []tags.Tag{renamed}); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	if err := testDB.DeleteTags(ctx, []tags.Tag{renamed}, true); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	testMap := map[string]struct {
+		get    func() ([]history.Entry, error)
+		expect []historySummary
+	}{
+		"everything": {
+			func() ([]history.Entry, error) { return testDB.GetHistory(ctx) },
+			[]historySummary{
+				{history.FileAdded, "/path/to/foo", "", ""},
+				{history.FileAdded, "/path/to/bar", "", ""},
+				{history.TagAdded, "", "inbox", ""},
+				{history.TagAdded, "", "draft", ""},
+				{history.LinkAdded, "/path/to/foo", "inbox", ""},
+				{history.LinkAdded, "/path/to/bar", "inbox", ""},
+				{history.LinkAdded, "/path/to/foo", "draft", ""},
+				{history.LinkUpdated, "/path/to/foo", "draft", "expires 2100-01-01T00:00:00Z"},
+				{history.TagUpdated, "", "todo", `renamed from inbox, description changed from "" to "to look at"`},
+				{history.LinkRemoved, "/path/to/bar", "todo", "tag deleted"},
+				{history.LinkRemoved, "/path/to/foo", "todo", "tag deleted"},
+				{history.TagDeleted, "", "todo", ""},
+			},
+		},
+		"for a file": {
+			func() ([]history.Entry, error) { return testDB.GetHistoryForFile(ctx, addedFiles[1]) },
+			[]historySummary{
+				{history.FileAdded, "/path/to/bar", "", ""},
+				{history.LinkAdded, "/path/to/bar", "inbox", ""},
+				{history.LinkRemoved, "/path/to/bar", "todo", "tag deleted"},
+			},
+		},
+		"for a deleted tag by name": {
+			func() ([]history.Entry, error) { return testDB.GetHistoryForTag(ctx, tags.Tag{Name: "todo"}) },
+			[]historySummary{
+				{history.TagAdded, "", "inbox", ""},
+				{history.LinkAdded, "/path/to/foo", "inbox", ""},
+				{history.LinkAdded, "/path/to/bar", "inbox", ""},
+				{history.TagUpdated, "", "todo", `renamed from inbox, description changed from "" to "to look at"`},
+				{history.LinkRemoved, "/path/to/bar", "todo", "tag deleted"},
+				{history.LinkRemoved, "/path/to/foo", "todo", "tag deleted"},
+				{history.TagDeleted, "", "todo", ""},
+			},
+		},
+		"for a tag by ID": {
+			func() ([]history.Entry, error) { return testDB.GetHistoryForTag(ctx, addedTags[1]) },
+			[]historySummary{
+				{history.TagAdded, "", "draft", ""},
+				{history.LinkAdded, "/path/to/foo", "draft", ""},
+				{history.LinkUpdated, "/path/to/foo", "draft", "expires 2100-01-01T00:00:00Z"},
+			},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			res, err := testData.get()
+			if err != nil {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if summary := summariseHistory(t, res, actor); !reflect.DeepEqual(summary, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					summary,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBHistoryImpliedLinks(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/implications.yml"})
+	defer teardown()
+
+	if _, err := testDB.AddLinks(context.Background(), []links.Link{{File: 2, Tag: 1}}); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	res, err := testDB.GetHistoryForFile(context.Background(), files.File{Id: 2, Path: "/path/to/bar"})
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	expect := []historySummary{
+		{history.LinkAdded, "/path/to/bar", "invoice", ""},
+		{history.LinkAdded, "/path/to/bar", "finance", "implied by invoice"},
+		{history.LinkAdded, "/path/to/bar", "tax", "implied by invoice"},
+		{history.LinkAdded, "/path/to/bar", "money", "implied by finance"},
+	}
+	if summary := summariseHistory(t, res, history.Actor{}); !reflect.DeepEqual(summary, expect) {
+		t.Fatalf("Result did not match expectation\nResult: %+v\nExpected: %+v", summary, expect)
+	}
+}
+
+func TestTagDBHistoryExpiredLinks(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/expiry.yml"})
+	defer teardown()
+
+	if _, err := testDB.DeleteExpiredLinks(context.Background()); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	res, err := testDB.GetHistory(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	expect := []historySummary{{history.LinkRemoved, "/path/to/foo", "inbox", "expired"}}
+	if summary := summariseHistory(t, res, history.Actor{}); !reflect.DeepEqual(summary, expect) {
+		t.Fatalf("Result did not match expectation\nResult: %+v\nExpected: %+v", summary, expect)
+	}
+}
+
+func TestTagDBHistoryAppendOnly(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/get_tags_no_tags.yml"})
+	defer teardown()
+
+	if _, err := testDB.AddTags(context.Background(), []tags.Tag{{Name: "inbox"}}); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	for _, statement := range []string{"UPDATE history SET user = 'mallory'", "DELETE FROM history"} {
+		if _, err := testDB.client.Exec(statement); err == nil {
+			t.Fatalf("Expected error from %q but got no error", statement)
+		}
+	}
+}
+
+func TestTagDBHistoryMerge(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/hierarchy.yml"})
+	defer teardown()
+
+	ctx := context.Background()
+	if _, err := testDB.MergeTags(ctx, []tags.Tag{{Id: 1}}, tags.Tag{Id: 6}); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	testMap := map[string]struct {
+		get    func() ([]history.Entry, error)
+		expect []historySummary
+	}{
+		"for a file with a folded tag": {
+			func() ([]history.Entry, error) {
+				return testDB.GetHistoryForFile(ctx, files.File{Id: 3, Path: "/path/to/baz"})
+			},
+			[]historySummary{
+				{history.LinkRemoved, "/path/to/baz", "food/dessert", "merged into meal/dessert"},
+				{history.LinkAdded, "/path/to/baz", "meal/dessert", "merged from food/dessert"},
+			},
+		},
+		"for a moved tag": {
+			func() ([]history.Entry, error) { return testDB.GetHistoryForTag(ctx, tags.Tag{Id: 4}) },
+			[]historySummary{{history.TagUpdated, "", "meal/main", "renamed from food/main"}},
+		},
+		"for a merged tag by name": {
+			func() ([]history.Entry, error) { return testDB.GetHistoryForTag(ctx, tags.Tag{Name: "food"}) },
+			[]historySummary{{history.TagDeleted, "", "food", "merged into meal"}},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			res, err := testData.get()
+			if err != nil {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if summary := summariseHistory(t, res, history.Actor{}); !reflect.DeepEqual(summary, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					summary,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBHistoryUndo(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/get_tags_no_tags.yml"})
+	defer teardown()
+
+	ctx := context.Background()
+	addedFiles, err := testDB.AddFiles(ctx, []files.File{{Path: "/path/to/foo", Hash: "foohash"}})
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	journaled(t, testDB, func(ctx context.Context) error {
+		addedTags, err := testDB.AddTags(ctx, []tags.Tag{{Name: "photo/cat"}})
+		if err != nil {
+			return err
+		}
+
+		_, err = testDB.AddLinks(ctx, []links.Link{{File: addedFiles[0].Id, Tag: addedTags[0].Id}})
+		return err
+	})
+
+	if _, err := testDB.Undo(ctx); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	if _, err := testDB.Redo(ctx); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	testMap := map[string]struct {
+		get    func() ([]history.Entry, error)
+		expect []historySummary
+	}{
+		"everything": {
+			func() ([]history.Entry, error) { return testDB.GetHistory(ctx) },
+			[]historySummary{
+				{history.FileAdded, "/path/to/foo", "", ""},
+				{history.TagAdded, "", "photo", "ancestor of photo/cat"},
+				{history.TagAdded, "", "photo/cat", ""},
+				{history.LinkAdded, "/path/to/foo", "photo/cat", ""},
+				{history.LinkRemoved, "/path/to/foo", "photo/cat", "undo"},
+				{history.TagDeleted, "", "photo/cat", "undo"},
+				{history.TagDeleted, "", "photo", "undo"},
+				{history.OperationUndone, "", "", ""},
+				{history.TagAdded, "", "photo", "redo"},
+				{history.TagAdded, "", "photo/cat", "redo"},
+				{history.LinkAdded, "/path/to/foo", "photo/cat", "redo"},
+				{history.OperationRedone, "", "", ""},
+			},
+		},
+		"for a file": {
+			func() ([]history.Entry, error) { return testDB.GetHistoryForFile(ctx, addedFiles[0]) },
+			[]historySummary{
+				{history.FileAdded, "/path/to/foo", "", ""},
+				{history.LinkAdded, "/path/to/foo", "photo/cat", ""},
+				{history.LinkRemoved, "/path/to/foo", "photo/cat", "undo"},
+				{history.LinkAdded, "/path/to/foo", "photo/cat", "redo"},
+			},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			res, err := testData.get()
+			if err != nil {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if summary := summariseHistory(t, res, history.Actor{}); !reflect.DeepEqual(summary, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					summary,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
@@ -0,0 +1,516 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/whatsfordinner/fstagger/internal/history"
+	"github.com/whatsfordinner/fstagger/internal/implications"
+	"github.com/whatsfordinner/fstagger/internal/links"
+
+	"github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// ImplicationMode decides how implication rules are applied to files, see
+// ADR-012.
+type ImplicationMode string
+
+const (
+	// ImplicationsMaterialized writes a link for every implied tag when a
+	// file is tagged, so implied tags show up everywhere a file's tags do.
+	ImplicationsMaterialized ImplicationMode = "materialized"
+	// ImplicationsComputed never writes implied links and instead expands
+	// searches to include the tags which imply the tags being searched for.
+	ImplicationsComputed ImplicationMode = "computed"
+)
+
+// ImplicationModes is every supported ImplicationMode.
+var ImplicationModes = []ImplicationMode{ImplicationsMaterialized, ImplicationsComputed}
+
+// ParseImplicationMode returns the ImplicationMode with the provided name or an
+// error if there isn't one.
+func ParseImplicationMode(mode string) (ImplicationMode, error) {
+	for _, m := range ImplicationModes {
+		if string(m) == mode {
+			return m, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown implication mode: %s", mode)
+}
+
+// implicationsSetting is the name of the setting holding the ImplicationMode
+// of the database.
+const implicationsSetting = "implications"
+
+// loadImplicationMode reads how implication rules are applied from the
+// database. A database which has never had it set uses
+// ImplicationsMaterialized, as does one migrated without a settings table.
+func (tagDB *TagDB) loadImplicationMode(ctx context.Context) error {
+	const (
+		availableString = "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'settings'"
+	)
+
+	var available bool
+	if err := tagDB.client.QueryRowContext(ctx, availableString).Scan(&available); err != nil {
+		return err
+	}
+
+	if !available {
+		return nil
+	}
+
+	mode, err := tagDB.GetImplicationMode(ctx)
+	if err != nil {
+		return err
+	}
+
+	tagDB.implications = mode
+	return nil
+}
+
+// GetImplicationMode returns how implication rules are applied to the
+// database.
+func (tagDB *TagDB) GetImplicationMode(ctx context.Context) (ImplicationMode, error) {
+	const (
+		searchString = "SELECT value FROM settings WHERE name = ?"
+	)
+
+	ctx, span := tracer.Start(ctx, "GetImplicationMode")
+	defer span.End()
+
+	var mode string
+	row := tagDB.client.QueryRowContext(ctx, searchString, implicationsSetting)
+	if err := row.Scan(&mode); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			span.SetStatus(codes.Ok, "")
+			return ImplicationsMaterialized, nil
+		}
+
+		span.SetStatus(codes.Error, err.Error())
+		return "", err
+	}
+
+	ret, err := ParseImplicationMode(mode)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return "", err
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+// SetImplicationMode changes how implication rules are applied to the
+// database. Switching to ImplicationsMaterialized writes the links implied by
+// every file's tags, and fails without changing anything if an exclusive group
+// rejects any of them. Switching to ImplicationsComputed leaves the links
+// which were already materialized.
+func (tagDB *TagDB) SetImplicationMode(ctx context.Context, mode ImplicationMode) error {
+	const (
+		upsertString = `INSERT INTO settings(name, value) VALUES(?, ?)
+			ON CONFLICT(name) DO UPDATE SET value = excluded.value`
+	)
+
+	ctx, span := tracer.Start(ctx, "SetImplicationMode")
+	defer span.End()
+
+	if _, err := ParseImplicationMode(string(mode)); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	tx, err := tagDB.client.BeginTx(ctx, nil)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	rollback := func(err error) error {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			err = errors.Join(err, rollbackErr)
+		}
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, upsertString, implicationsSetting, mode); err != nil {
+		return rollback(err)
+	}
+
+	previous := tagDB.implications
+	tagDB.implications = mode
+
+	if err := tagDB.materializeImplications(
+		ctx,
+		tx,
+		"SELECT fileid, tagid, 0 FROM livefiletags",
+	); err != nil {
+		tagDB.implications = previous
+		return rollback(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		tagDB.implications = previous
+		return rollback(err)
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return nil
+}
+
+// impliedString finds every tag implied by a set of starting links. The base
+// case is provided by the caller and must select a file ID, a tag ID and 0.
+// Implications are followed from a tag and from every tag above it in the
+// hierarchy, but only tags reached through an implication are selected so a
+// file's own tags and their ancestors aren't repeated. Each implied tag comes
+// with the ID of the tag whose rule implied it, the lowest if there's more
+// than one.
+const impliedString = `WITH RECURSIVE base(fileid, id, isimplied) AS (
+		%s
+	),
+	implied(fileid, id, isimplied, via) AS (
+		SELECT fileid, id, isimplied, NULL FROM base
+		UNION
+		SELECT p.fileid, t.parent, 0, NULL FROM tags t JOIN implied p ON t.id = p.id
+			WHERE t.parent IS NOT NULL
+		UNION
+		SELECT p.fileid, i.impliedid, 1, i.tagid FROM tagimplications i JOIN implied p ON i.tagid = p.id
+	)
+	SELECT fileid, id, MIN(via) AS via FROM implied WHERE isimplied = 1 GROUP BY fileid, id`
+
+// implyingString is a recursive step for descendantsString which matches every
+// tag that implies a matched tag. It's only needed when implications are
+// computed because materialized implications are already links.
+const implyingString = `UNION
+		SELECT i.tagid FROM tagimplications i JOIN matched m ON i.impliedid = m.id`
+
+// cycleString counts whether the second tag can be reached by following
+// implication rules from the first, which means a rule from the second tag to
+// the first would create a cycle.
+const cycleString = `WITH RECURSIVE reachable(id) AS (
+		SELECT ?
+		UNION
+		SELECT i.impliedid FROM tagimplications i JOIN reachable r ON i.tagid = r.id
+	)
+	SELECT COUNT(*) FROM reachable WHERE id = ?`
+
+// checkImplicationCycles returns an error if any implication rule from the tag
+// leads back to it. Rules were free of cycles before the tag gained new ones so
+// any cycle has to pass through it.
+func checkImplicationCycles(ctx context.Context, tx *sql.Tx, tagId int) error {
+	const (
+		searchString = "SELECT impliedid FROM tagimplications WHERE tagid = ?"
+	)
+
+	rows, err := tx.QueryContext(ctx, searchString, tagId)
+	if err != nil {
+		return err
+	}
+
+	implied := []int{}
+	for rows.Next() {
+		var impliedId int
+		if err := rows.Scan(&impliedId); err != nil {
+			rows.Close()
+			return err
+		}
+		implied = append(implied, impliedId)
+	}
+	rows.Close()
+
+	for _, impliedId := range implied {
+		var cycles int
+		row := tx.QueryRowContext(ctx, cycleString, impliedId, tagId)
+		if err := row.Scan(&cycles); err != nil {
+			return err
+		}
+
+		if cycles > 0 {
+			return fmt.Errorf(
+				"tag %s implying tag %s would create a cycle",
+				tagName(ctx, tx, tagId),
+				tagName(ctx, tx, impliedId),
+			)
+		}
+	}
+
+	return nil
+}
+
+// materializeImplications links files to every tag implied by the links
+// selected by base which they don't already have. Each link's source names the
+// tag whose rule implied it and each link makes room for itself in exclusive
+// groups like any other link that isn't made by hand, so an implied link which
+// a group rejects is an error. Every link is recorded in the history. It does
+// nothing unless implications are materialized.
+func (tagDB *TagDB) materializeImplications(ctx context.Context, tx *sql.Tx, base string, args ...any) error {
+	const (
+		searchString = `SELECT i.fileid, i.id,
+				'%s' || CASE WHEN t.namespace = '' THEN t.name ELSE t.namespace || ':' || t.name END
+			FROM (%s) i JOIN tags t ON t.id = i.via
+			WHERE NOT EXISTS (SELECT 1 FROM livefiletags ft WHERE ft.fileid = i.fileid AND ft.tagid = i.id)
+			ORDER BY i.fileid, i.id`
+		expiredString = "DELETE FROM filetags WHERE fileid = ? AND tagid = ?"
+		insertString  = `INSERT INTO filetags(fileid, tagid, source, created, updated)
+			VALUES(?, ?, ?, unixepoch(), unixepoch())`
+	)
+
+	if tagDB.implications != ImplicationsMaterialized {
+		return nil
+	}
+
+	rows, err := tx.QueryContext(
+		ctx,
+		fmt.Sprintf(searchString, links.SourceImplied, fmt.Sprintf(impliedString, base)),
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+
+	implied := []links.Link{}
+	for rows.Next() {
+		link := links.Link{}
+		if err := rows.Scan(&link.File, &link.Tag, &link.Source); err != nil {
+			rows.Close()
+			return err
+		}
+		implied = append(implied, link)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, link := range implied {
+		// the file doesn't have the tag so any row left for it has expired
+		if _, err := tx.ExecContext(ctx, expiredString, link.File, link.Tag); err != nil {
+			return err
+		}
+
+		if err := tagDB.enforceExclusiveGroups(ctx, tx, link); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, insertString, link.File, link.Tag, link.Source); err != nil {
+			return err
+		}
+
+		if err := tagDB.recordHistory(
+			ctx,
+			tx,
+			history.LinkAdded,
+			link.File,
+			link.Tag,
+			"implied by "+strings.TrimPrefix(link.Source, links.SourceImplied),
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AddImplications takes a slice of implication rules and adds them to the
+// datastore. A rule is rejected if it would create a cycle, including a tag
+// implying itself. When implications are materialized every file already
+// tagged with a rule's tag, or a tag below it, is given the implied tags too,
+// and a rule is rejected if an exclusive group rejects any of those links.
+func (tagDB *TagDB) AddImplications(ctx context.Context, newImplications []implications.Implication) ([]implications.Implication, error) {
+	const (
+		insertString = "INSERT INTO tagimplications(tagid, impliedid) VALUES(?, ?)"
+	)
+
+	ctx, span := tracer.Start(ctx, "AddImplications")
+	defer span.End()
+
+	addedImplications := []implications.Implication{}
+
+	tx, err := tagDB.client.BeginTx(ctx, nil)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return []implications.Implication{}, err
+	}
+	txErrors := &BatchError{}
+
+	addImplication := func(implication implications.Implication) error {
+		// the rule creates a cycle if the tag is already implied by the tag
+		// it's going to imply
+		var cycles int
+		row := tx.QueryRowContext(ctx, cycleString, implication.Implied, implication.Tag)
+		if err := row.Scan(&cycles); err != nil {
+			return err
+		}
+
+		if cycles > 0 {
+			return fmt.Errorf(
+				"tag %s implying tag %s would create a cycle",
+				tagName(ctx, tx, implication.Tag),
+				tagName(ctx, tx, implication.Implied),
+			)
+		}
+
+		if _, err := tx.ExecContext(ctx, insertString, implication.Tag, implication.Implied); err != nil {
+			if sqliteErr, ok := err.(sqlite3.Error); ok {
+				switch sqliteErr.ExtendedCode {
+				case sqlite3.ErrConstraintPrimaryKey:
+					err = fmt.Errorf(
+						"tag %s already implies tag %s",
+						tagName(ctx, tx, implication.Tag),
+						tagName(ctx, tx, implication.Implied),
+					)
+				case sqlite3.ErrConstraintForeignKey:
+					err = fmt.Errorf(
+						"tag does not exist with id: %d or %d",
+						implication.Tag,
+						implication.Implied,
+					)
+				}
+			}
+			return err
+		}
+
+		return tagDB.materializeImplications(
+			ctx,
+			tx,
+			"SELECT fileid, tagid, 0 FROM livefiletags",
+		)
+	}
+
+	for i, implication := range newImplications {
+		span.AddEvent(fmt.Sprintf(
+			"adding implication from tag ID %d to tag ID %d",
+			implication.Tag,
+			implication.Implied,
+		))
+
+		// a rule's implied links can be rejected by an exclusive group so each
+		// rule is added inside a savepoint which can be discarded along with
+		// its links
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT add_implication"); err != nil {
+			txErrors.add(i, err)
+			continue
+		}
+
+		err := addImplication(implication)
+		if err != nil {
+			if _, rollbackErr := tx.ExecContext(ctx, "ROLLBACK TO add_implication"); rollbackErr != nil {
+				err = errors.Join(err, rollbackErr)
+			}
+		}
+
+		if _, releaseErr := tx.ExecContext(ctx, "RELEASE add_implication"); releaseErr != nil {
+			err = errors.Join(err, releaseErr)
+		}
+
+		if err != nil {
+			txErrors.add(i, err)
+			continue
+		}
+
+		addedImplications = append(addedImplications, implication)
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			err = errors.Join(err, rollbackErr)
+		}
+		span.SetStatus(codes.Error, err.Error())
+		return []implications.Implication{}, err
+	}
+
+	if len(txErrors.Errors) > 0 {
+		span.SetStatus(codes.Error, "encountered DB errors during transaction")
+	} else {
+		span.SetStatus(codes.Ok, "")
+	}
+
+	return addedImplications, txErrors.errOrNil()
+}
+
+// DeleteImplications takes a slice of implication rules and removes them from
+// the datastore. Links which were materialized because of a rule are kept.
+func (tagDB *TagDB) DeleteImplications(ctx context.Context, deleteImplications []implications.Implication) error {
+	const (
+		deleteString = "DELETE FROM tagimplications WHERE tagid = ? AND impliedid = ?"
+	)
+
+	ctx, span := tracer.Start(ctx, "DeleteImplications")
+	defer span.End()
+
+	tx, err := tagDB.client.BeginTx(ctx, nil)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	txErrors := &BatchError{}
+
+	for i, implication := range deleteImplications {
+		res, err := tx.ExecContext(ctx, deleteString, implication.Tag, implication.Implied)
+		if err != nil {
+			txErrors.add(i, err)
+			continue
+		}
+
+		if deleted, err := res.RowsAffected(); err == nil && deleted == 0 {
+			txErrors.add(i, fmt.Errorf(
+				"tag %s doesn't imply tag %s",
+				tagName(ctx, tx, implication.Tag),
+				tagName(ctx, tx, implication.Implied),
+			))
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			err = errors.Join(err, rollbackErr)
+		}
+		span.SetStatus(codes.Error, "encountered error finalising transaction")
+		return err
+	}
+
+	if len(txErrors.Errors) > 0 {
+		span.SetStatus(codes.Error, "encountered DB errors during transaction")
+	} else {
+		span.SetStatus(codes.Ok, "")
+	}
+
+	return txErrors.errOrNil()
+}
+
+// GetImplications returns every implication rule ordered by tag ID and then
+// implied tag ID.
+func (tagDB *TagDB) GetImplications(ctx context.Context) ([]implications.Implication, error) {
+	const (
+		searchString = "SELECT tagid, impliedid FROM tagimplications ORDER BY tagid, impliedid"
+	)
+
+	ctx, span := tracer.Start(ctx, "GetImplications")
+	defer span.End()
+
+	rows, err := tagDB.client.QueryContext(ctx, searchString)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := []implications.Implication{}
+
+	for rows.Next() {
+		implication := implications.Implication{}
+		if err := rows.Scan(&implication.Tag, &implication.Implied); err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+		ret = append(ret, implication)
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
@@ -0,0 +1,575 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/whatsfordinner/fstagger/internal/files"
+	"github.com/whatsfordinner/fstagger/internal/implications"
+	"github.com/whatsfordinner/fstagger/internal/links"
+	"github.com/whatsfordinner/fstagger/internal/tags"
+)
+
+// tagNamesForFile returns the qualified names of every tag on a file in the
+// order GetTagsForFile returns them.
+func tagNamesForFile(t *testing.T, testDB *TagDB, fileId int) []string {
+	fileTags, err := testDB.GetTagsForFile(context.Background(), files.File{Id: fileId})
+	if err != nil {
+		t.Fatalf("Error retrieving tags: %s", err.Error())
+	}
+
+	ret := []string{}
+	for _, tag := range fileTags {
+		ret = append(ret, tag.String())
+	}
+
+	return ret
+}
+
+func TestParseImplicationMode(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr bool
+		input     string
+		expect    ImplicationMode
+	}{
+		"materialized": {false, "materialized", ImplicationsMaterialized},
+		"computed":     {false, "computed", ImplicationsComputed},
+		"unknown":      {true, "lazy", ""},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			res, err := ParseImplicationMode(testData.input)
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if res != testData.expect {
+				t.Fatalf("Expected %s but got %s", testData.expect, res)
+			}
+		})
+	}
+}
+
+func TestTagDBAddLinksImplications(t *testing.T) {
+	testMap := map[string]struct {
+		mode   ImplicationMode
+		input  []links.Link
+		expect []string
+	}{
+		"materialized rules are followed": {
+			ImplicationsMaterialized,
+			[]links.Link{{File: 2, Tag: 1}},
+			[]string{"finance", "invoice", "money", "tax"},
+		},
+		"materialized rules on ancestors are followed": {
+			ImplicationsMaterialized,
+			[]links.Link{{File: 2, Tag: 6}},
+			[]string{"finance", "money", "receipt/food"},
+		},
+		"computed rules aren't written": {
+			ImplicationsComputed,
+			[]links.Link{{File: 2, Tag: 1}},
+			[]string{"invoice"},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/implications.yml"})
+			defer teardown()
+			testDB.implications = testData.mode
+
+			if _, err := testDB.AddLinks(context.Background(), testData.input); err != nil {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			res := tagNamesForFile(t, testDB, 2)
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBAddImplications(t *testing.T) {
+	fixtureImplications := []implications.Implication{
+		{Tag: 1, Implied: 2},
+		{Tag: 1, Implied: 3},
+		{Tag: 2, Implied: 4},
+		{Tag: 5, Implied: 2},
+	}
+
+	testMap := map[string]struct {
+		shouldErr          bool
+		input              []implications.Implication
+		expect             []implications.Implication
+		expectImplications []implications.Implication
+	}{
+		"new rule": {
+			false,
+			[]implications.Implication{{Tag: 3, Implied: 4}},
+			[]implications.Implication{{Tag: 3, Implied: 4}},
+			[]implications.Implication{
+				{Tag: 1, Implied: 2},
+				{Tag: 1, Implied: 3},
+				{Tag: 2, Implied: 4},
+				{Tag: 3, Implied: 4},
+				{Tag: 5, Implied: 2},
+			},
+		},
+		"rule creating a cycle": {
+			true,
+			[]implications.Implication{{Tag: 4, Implied: 1}},
+			[]implications.Implication{},
+			fixtureImplications,
+		},
+		"tag implying itself": {
+			true,
+			[]implications.Implication{{Tag: 2, Implied: 2}},
+			[]implications.Implication{},
+			fixtureImplications,
+		},
+		"rule already exists": {
+			true,
+			[]implications.Implication{{Tag: 1, Implied: 2}},
+			[]implications.Implication{},
+			fixtureImplications,
+		},
+		"tag doesn't exist": {
+			true,
+			[]implications.Implication{{Tag: 1, Implied: 9}},
+			[]implications.Implication{},
+			fixtureImplications,
+		},
+		"some valid rules": {
+			true,
+			[]implications.Implication{{Tag: 4, Implied: 3}, {Tag: 4, Implied: 1}},
+			[]implications.Implication{{Tag: 4, Implied: 3}},
+			[]implications.Implication{
+				{Tag: 1, Implied: 2},
+				{Tag: 1, Implied: 3},
+				{Tag: 2, Implied: 4},
+				{Tag: 4, Implied: 3},
+				{Tag: 5, Implied: 2},
+			},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/implications.yml"})
+			defer teardown()
+
+			res, err := testDB.AddImplications(context.Background(), testData.input)
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+
+			dbImplications, err := testDB.GetImplications(context.Background())
+			if err != nil {
+				t.Fatalf("Error retrieving implications: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(dbImplications, testData.expectImplications) {
+				t.Fatalf(
+					"DB did not match expectation\nResult: %+v\nExpected: %+v",
+					dbImplications,
+					testData.expectImplications,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBAddImplicationsBackfill(t *testing.T) {
+	testMap := map[string]struct {
+		mode   ImplicationMode
+		expect map[int][]string
+	}{
+		"materialized": {
+			ImplicationsMaterialized,
+			map[int][]string{
+				1: {"finance", "invoice", "money", "tax"},
+				3: {"finance", "money", "receipt/food", "tax"},
+			},
+		},
+		"computed": {
+			ImplicationsComputed,
+			map[int][]string{
+				1: {"invoice"},
+				3: {"receipt/food"},
+			},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/implications.yml"})
+			defer teardown()
+			testDB.implications = testData.mode
+
+			if _, err := testDB.AddImplications(
+				context.Background(),
+				[]implications.Implication{{Tag: 6, Implied: 3}},
+			); err != nil {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			res := map[int][]string{
+				1: tagNamesForFile(t, testDB, 1),
+				3: tagNamesForFile(t, testDB, 3),
+			}
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBSetImplicationMode(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr  bool
+		input      []ImplicationMode
+		expectMode ImplicationMode
+		expect     []string
+	}{
+		"defaults to materialized": {
+			false,
+			[]ImplicationMode{},
+			ImplicationsMaterialized,
+			[]string{"invoice"},
+		},
+		"computed leaves links alone": {
+			false,
+			[]ImplicationMode{ImplicationsComputed},
+			ImplicationsComputed,
+			[]string{"invoice"},
+		},
+		"materialized writes implied links": {
+			false,
+			[]ImplicationMode{ImplicationsComputed, ImplicationsMaterialized},
+			ImplicationsMaterialized,
+			[]string{"finance", "invoice", "money", "tax"},
+		},
+		"unknown mode": {
+			true,
+			[]ImplicationMode{"lazy"},
+			ImplicationsMaterialized,
+			[]string{"invoice"},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/implications.yml"})
+			defer teardown()
+
+			var err error
+			for _, mode := range testData.input {
+				if err = testDB.SetImplicationMode(context.Background(), mode); err != nil {
+					break
+				}
+			}
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			mode, err := testDB.GetImplicationMode(context.Background())
+			if err != nil {
+				t.Fatalf("Error retrieving implication mode: %s", err.Error())
+			}
+
+			if mode != testData.expectMode {
+				t.Fatalf("Expected %s but got %s", testData.expectMode, mode)
+			}
+
+			res := tagNamesForFile(t, testDB, 1)
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBDeleteImplications(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr          bool
+		input              []implications.Implication
+		expectImplications []implications.Implication
+	}{
+		"existing rule": {
+			false,
+			[]implications.Implication{{Tag: 1, Implied: 3}},
+			[]implications.Implication{
+				{Tag: 1, Implied: 2},
+				{Tag: 2, Implied: 4},
+				{Tag: 5, Implied: 2},
+			},
+		},
+		"rule doesn't exist": {
+			true,
+			[]implications.Implication{{Tag: 3, Implied: 1}, {Tag: 5, Implied: 2}},
+			[]implications.Implication{
+				{Tag: 1, Implied: 2},
+				{Tag: 1, Implied: 3},
+				{Tag: 2, Implied: 4},
+			},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/implications.yml"})
+			defer teardown()
+
+			err := testDB.DeleteImplications(context.Background(), testData.input)
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			dbImplications, err := testDB.GetImplications(context.Background())
+			if err != nil {
+				t.Fatalf("Error retrieving implications: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(dbImplications, testData.expectImplications) {
+				t.Fatalf(
+					"DB did not match expectation\nResult: %+v\nExpected: %+v",
+					dbImplications,
+					testData.expectImplications,
+				)
+			}
+		})
+	}
+}
+
+// TestTagDBImplicationsErrors checks that errors name the tags involved rather
+// than their IDs.
+func TestTagDBImplicationsErrors(t *testing.T) {
+	testMap := map[string]struct {
+		do     func(testDB *TagDB) error
+		expect string
+	}{
+		"cycle": {
+			func(testDB *TagDB) error {
+				_, err := testDB.AddImplications(context.Background(), []implications.Implication{{Tag: 4, Implied: 1}})
+				return err
+			},
+			"tag money implying tag invoice would create a cycle",
+		},
+		"existing rule": {
+			func(testDB *TagDB) error {
+				_, err := testDB.AddImplications(context.Background(), []implications.Implication{{Tag: 1, Implied: 2}})
+				return err
+			},
+			"tag invoice already implies tag finance",
+		},
+		"missing rule": {
+			func(testDB *TagDB) error {
+				return testDB.DeleteImplications(context.Background(), []implications.Implication{{Tag: 2, Implied: 1}})
+			},
+			"tag finance doesn't imply tag invoice",
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/implications.yml"})
+			defer teardown()
+
+			if err := testData.do(testDB); err == nil || err.Error() != testData.expect {
+				t.Fatalf("Result did not match expectation\nResult: %v\nExpected: %s", err, testData.expect)
+			}
+		})
+	}
+}
+
+func TestTagDBGetFilesByTagsImplications(t *testing.T) {
+	foo := files.File{Id: 1, Path: "/path/to/foo", Hash: "foohash"}
+	baz := files.File{Id: 3, Path: "/path/to/baz", Hash: "bazhash"}
+
+	testMap := map[string]struct {
+		mode   ImplicationMode
+		input  []string
+		expect []files.File
+	}{
+		"computed rules are followed": {
+			ImplicationsComputed,
+			[]string{"money"},
+			[]files.File{baz, foo},
+		},
+		"computed rules with a hierarchy": {
+			ImplicationsComputed,
+			[]string{"finance", "receipt"},
+			[]files.File{baz},
+		},
+		"materialized rules only match links": {
+			ImplicationsMaterialized,
+			[]string{"money"},
+			[]files.File{},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/implications.yml"})
+			defer teardown()
+			testDB.implications = testData.mode
+
+			res, err := testDB.GetFilesByTags(context.Background(), testData.input)
+			if err != nil {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBMergeTagsImplications(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/implications.yml"})
+	defer teardown()
+
+	if _, err := testDB.MergeTags(
+		context.Background(),
+		[]tags.Tag{{Id: 2}},
+		tags.Tag{Id: 4},
+	); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	res, err := testDB.GetImplications(context.Background())
+	if err != nil {
+		t.Fatalf("Error retrieving implications: %s", err.Error())
+	}
+
+	expect := []implications.Implication{
+		{Tag: 1, Implied: 3},
+		{Tag: 1, Implied: 4},
+		{Tag: 5, Implied: 4},
+	}
+
+	if !reflect.DeepEqual(res, expect) {
+		t.Fatalf(
+			"Result did not match expectation\nResult: %+v\nExpected: %+v",
+			res,
+			expect,
+		)
+	}
+}
+
+func TestTagDBMergeTagsImplicationCycle(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/implications.yml"})
+	defer teardown()
+
+	// money is implied by finance which invoice implies, so folding it into
+	// invoice would have finance implying invoice
+	if _, err := testDB.MergeTags(
+		context.Background(),
+		[]tags.Tag{{Id: 4}},
+		tags.Tag{Id: 1},
+	); err == nil {
+		t.Fatal("Expected error but got no error")
+	}
+
+	res, err := testDB.GetImplications(context.Background())
+	if err != nil {
+		t.Fatalf("Error retrieving implications: %s", err.Error())
+	}
+
+	expect := []implications.Implication{
+		{Tag: 1, Implied: 2},
+		{Tag: 1, Implied: 3},
+		{Tag: 2, Implied: 4},
+		{Tag: 5, Implied: 2},
+	}
+
+	if !reflect.DeepEqual(res, expect) {
+		t.Fatalf(
+			"Result did not match expectation\nResult: %+v\nExpected: %+v",
+			res,
+			expect,
+		)
+	}
+}
+
+func TestTagDBImpliedLinkSources(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/provenance.yml"})
+	defer teardown()
+
+	if _, err := testDB.AddImplications(
+		context.Background(),
+		[]implications.Implication{{Tag: 3, Implied: 4}},
+	); err != nil {
+		t.Fatalf("Unable to add implication: %s", err.Error())
+	}
+
+	if _, err := testDB.AddLinks(context.Background(), []links.Link{{File: 2, Tag: 3}}); err != nil {
+		t.Fatalf("Unable to add link: %s", err.Error())
+	}
+
+	res, err := testDB.GetLinksBySource(context.Background(), links.SourceImplied+"*")
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	expect := []links.Link{
+		{File: 1, Tag: 4, Source: "implied:pet"},
+		{File: 2, Tag: 4, Source: "implied:pet"},
+	}
+	if !reflect.DeepEqual(res, expect) {
+		t.Fatalf(
+			"Result did not match expectation\nResult: %+v\nExpected: %+v",
+			res,
+			expect,
+		)
+	}
+}
@@ -0,0 +1,654 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/whatsfordinner/fstagger/internal/history"
+
+	"github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel/codes"
+)
+
+const (
+	operationColumns = `o.id, o.at, o.user, o.host, o.command,
+		(SELECT COUNT(*) FROM journal j WHERE j.operationid = o.id), o.state = 'undone'`
+)
+
+const (
+	// journalFunction is the SQL function the journal triggers call for the
+	// ID of the operation open on the TagDB the connection belongs to. It
+	// returns NULL while no operation is open.
+	journalFunction = "fstagger_operation"
+
+	// journalTriggerString creates the triggers which journal the changes to a
+	// table, given the table and the JSON images of its new and old rows. They
+	// are TEMP triggers so that they belong to the connection creating them.
+	journalTriggerString = `CREATE TEMP TRIGGER IF NOT EXISTS %[1]s_journal_insert AFTER INSERT ON main.%[1]s
+		WHEN ` + journalFunction + `() IS NOT NULL
+		BEGIN
+			INSERT INTO journal(operationid, tablename, before, after)
+			VALUES(` + journalFunction + `(), '%[1]s', NULL, %[2]s);
+		END;
+		CREATE TEMP TRIGGER IF NOT EXISTS %[1]s_journal_update AFTER UPDATE ON main.%[1]s
+		WHEN ` + journalFunction + `() IS NOT NULL
+		BEGIN
+			INSERT INTO journal(operationid, tablename, before, after)
+			VALUES(` + journalFunction + `(), '%[1]s', %[3]s, %[2]s);
+		END;
+		CREATE TEMP TRIGGER IF NOT EXISTS %[1]s_journal_delete AFTER DELETE ON main.%[1]s
+		WHEN ` + journalFunction + `() IS NOT NULL
+		BEGIN
+			INSERT INTO journal(operationid, tablename, before, after)
+			VALUES(` + journalFunction + `(), '%[1]s', %[3]s, NULL);
+		END;`
+)
+
+// journaledTables is every table whose changes are written to the journal,
+// along with the columns kept in the images of its rows. New columns need
+// adding here to be put back by Undo and Redo.
+var journaledTables = map[string][]string{
+	"files":           {"id", "path", "hash", "created", "updated"},
+	"tags":            {"id", "namespace", "name", "description", "parent", "created", "updated"},
+	"filetags":        {"fileid", "tagid", "value", "expires", "created", "updated", "source", "confidence"},
+	"tagaliases":      {"namespace", "name", "tagid"},
+	"tagimplications": {"tagid", "impliedid"},
+	"smarttags":       {"id", "namespace", "name", "query", "description"},
+	"taggroups":       {"id", "name", "description", "exclusive", "policy"},
+	"taggroupmembers": {"groupid", "tagid"},
+	"tagvaluetypes":   {"tagid", "type"},
+	"tagenumvalues":   {"tagid", "value", "position"},
+	"notes":           {"id", "fileid", "tagid", "text", "created", "updated"},
+	"settings":        {"name", "value"},
+}
+
+// journalTriggers returns the statements creating the journal triggers for
+// every journaled table in tables, in order of table name.
+func journalTriggers(tables map[string]bool) string {
+	names := []string{}
+	for table := range journaledTables {
+		if tables[table] {
+			names = append(names, table)
+		}
+	}
+	sort.Strings(names)
+
+	statements := []string{}
+	for _, table := range names {
+		image := func(row string) string {
+			pairs := []string{}
+			for _, column := range journaledTables[table] {
+				pairs = append(pairs, fmt.Sprintf("'%s', %s.%s", column, row, column))
+			}
+			return "json_object(" + strings.Join(pairs, ", ") + ")"
+		}
+
+		statements = append(statements, fmt.Sprintf(journalTriggerString, table, image("NEW"), image("OLD")))
+	}
+
+	return strings.Join(statements, "\n")
+}
+
+// connect prepares every connection the TagDB opens for journaling. The
+// journal triggers belong to the connection and find the open operation by
+// calling back into the TagDB, so each TagDB only journals its own changes
+// into its own operation however many commands are running against the same
+// database, and nothing else writing to the database is journaled. The
+// connection which migrates the database gets its triggers from initJournal
+// afterwards, so that migrations don't have to work around them.
+func (tagDB *TagDB) connect(conn *sqlite3.SQLiteConn) error {
+	const (
+		tablesString = "SELECT name FROM sqlite_master WHERE type = 'table'"
+	)
+
+	if err := conn.RegisterFunc(journalFunction, tagDB.journalOperation, false); err != nil {
+		return err
+	}
+
+	if !tagDB.migrated {
+		return nil
+	}
+
+	rows, err := conn.Query(tablesString, nil)
+	if err != nil {
+		return err
+	}
+
+	tables := map[string]bool{}
+	dest := make([]driver.Value, 1)
+	for {
+		if err := rows.Next(dest); err != nil {
+			if !errors.Is(err, io.EOF) {
+				rows.Close()
+				return err
+			}
+			break
+		}
+		if name, ok := dest[0].(string); ok {
+			tables[name] = true
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	_, err = conn.Exec(journalTriggers(tables), nil)
+	return err
+}
+
+// initJournal creates the journal triggers on the connection which migrated
+// the database and has every connection opened from then on create its own.
+func (tagDB *TagDB) initJournal(ctx context.Context) error {
+	const (
+		tablesString = "SELECT name FROM sqlite_master WHERE type = 'table'"
+	)
+
+	rows, err := tagDB.client.QueryContext(ctx, tablesString)
+	if err != nil {
+		return err
+	}
+
+	tables := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		tables[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if _, err := tagDB.client.ExecContext(ctx, journalTriggers(tables)); err != nil {
+		return err
+	}
+	tagDB.migrated = true
+
+	return nil
+}
+
+// journalOperation is called by the journal triggers for the ID of the open
+// operation, or nil while there isn't one.
+func (tagDB *TagDB) journalOperation() any {
+	if tagDB.operation == 0 {
+		return nil
+	}
+
+	return int64(tagDB.operation)
+}
+
+// BeginOperation starts journaling every change made through the TagDB as one
+// operation, recorded against its actor, until EndOperation is called. A
+// journaled change is recorded as the rows it wrote before and after it was
+// made so the operation can be undone and redone as a unit. Only changes made
+// through this TagDB are journaled into the operation, even while other
+// commands are running against the same database.
+func (tagDB *TagDB) BeginOperation(ctx context.Context) error {
+	const (
+		insertString = `INSERT INTO operations(at, user, host, command, state)
+			VALUES(unixepoch(), ?, ?, ?, 'open') RETURNING id`
+	)
+
+	ctx, span := tracer.Start(ctx, "BeginOperation")
+	defer span.End()
+
+	// an operation left open by a command that didn't finish is left as it is,
+	// since there's no telling it apart from one another command still has
+	// open, which means it can't be undone
+	var operationId int
+	row := tagDB.client.QueryRowContext(ctx, insertString, tagDB.actor.User, tagDB.actor.Host, tagDB.actor.Command)
+	if err := row.Scan(&operationId); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	tagDB.operation = operationId
+
+	span.SetStatus(codes.Ok, "")
+	return nil
+}
+
+// EndOperation stops journaling changes. An operation which changed nothing
+// is discarded. An operation which changed something can be undone and
+// discards every undone operation, since they can't be redone on top of it.
+func (tagDB *TagDB) EndOperation(ctx context.Context) error {
+	const (
+		discardString = `DELETE FROM operations
+			WHERE id = ? AND NOT EXISTS (SELECT 1 FROM journal WHERE operationid = ?)`
+		redoString = `DELETE FROM operations
+			WHERE state = 'undone' AND EXISTS (SELECT 1 FROM operations WHERE id = ?)`
+		doneString = "UPDATE operations SET state = 'done' WHERE id = ?"
+	)
+
+	ctx, span := tracer.Start(ctx, "EndOperation")
+	defer span.End()
+
+	if tagDB.operation == 0 {
+		span.SetStatus(codes.Ok, "")
+		return nil
+	}
+
+	tx, err := tagDB.client.BeginTx(ctx, nil)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	for _, step := range []struct {
+		statement string
+		args      []any
+	}{
+		{discardString, []any{tagDB.operation, tagDB.operation}},
+		{redoString, []any{tagDB.operation}},
+		{doneString, []any{tagDB.operation}},
+	} {
+		if _, err := tx.ExecContext(ctx, step.statement, step.args...); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				err = errors.Join(err, rollbackErr)
+			}
+			span.SetStatus(codes.Error, err.Error())
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	tagDB.operation = 0
+
+	span.SetStatus(codes.Ok, "")
+	return nil
+}
+
+// Undo reverses every change made by the most recent operation which hasn't
+// been undone and returns it. The operation is undone completely or not at
+// all and can be redone until a new operation changes something. It returns
+// ErrNothingToUndo if there's no operation to undo.
+func (tagDB *TagDB) Undo(ctx context.Context) (history.Operation, error) {
+	const (
+		searchString = "SELECT " + operationColumns + " FROM operations o WHERE o.state = 'done' ORDER BY o.id DESC LIMIT 1"
+	)
+
+	ctx, span := tracer.Start(ctx, "Undo")
+	defer span.End()
+
+	operation, err := tagDB.replay(ctx, searchString, true)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ErrNothingToUndo
+		}
+		span.SetStatus(codes.Error, err.Error())
+		return history.Operation{}, err
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return operation, nil
+}
+
+// Redo makes the changes of the most recently undone operation again and
+// returns it. It returns ErrNothingToRedo if there's no operation to redo.
+func (tagDB *TagDB) Redo(ctx context.Context) (history.Operation, error) {
+	const (
+		searchString = "SELECT " + operationColumns + " FROM operations o WHERE o.state = 'undone' ORDER BY o.id LIMIT 1"
+	)
+
+	ctx, span := tracer.Start(ctx, "Redo")
+	defer span.End()
+
+	operation, err := tagDB.replay(ctx, searchString, false)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ErrNothingToRedo
+		}
+		span.SetStatus(codes.Error, err.Error())
+		return history.Operation{}, err
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return operation, nil
+}
+
+// replay undoes or redoes the operation found by searchString in a single
+// transaction. Undoing puts back each row as it was before the operation,
+// newest change first, and redoing puts back each row as it was after the
+// operation, oldest change first. Replaying isn't journaled itself, but each
+// link and tag it changes is recorded in the history.
+func (tagDB *TagDB) replay(ctx context.Context, searchString string, undo bool) (history.Operation, error) {
+	const (
+		deferString   = "PRAGMA defer_foreign_keys = ON"
+		journalString = "SELECT tablename, before, after FROM journal WHERE operationid = ? ORDER BY id"
+		stateString   = "UPDATE operations SET state = ? WHERE id = ?"
+	)
+
+	// journaling is suspended until the operation has been replayed
+	defer func(operationId int) {
+		tagDB.operation = operationId
+	}(tagDB.operation)
+	tagDB.operation = 0
+
+	tx, err := tagDB.client.BeginTx(ctx, nil)
+	if err != nil {
+		return history.Operation{}, err
+	}
+
+	rollback := func(err error) (history.Operation, error) {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			err = errors.Join(err, rollbackErr)
+		}
+		return history.Operation{}, err
+	}
+
+	operation, err := scanOperation(tx.QueryRowContext(ctx, searchString))
+	if err != nil {
+		return rollback(err)
+	}
+
+	// rows are put back one at a time so a row can briefly refer to one
+	// which hasn't been put back yet
+	if _, err := tx.ExecContext(ctx, deferString); err != nil {
+		return rollback(err)
+	}
+
+	type change struct {
+		table  string
+		before sql.NullString
+		after  sql.NullString
+	}
+
+	rows, err := tx.QueryContext(ctx, journalString, operation.Id)
+	if err != nil {
+		return rollback(err)
+	}
+
+	changes := []change{}
+	for rows.Next() {
+		c := change{}
+		if err := rows.Scan(&c.table, &c.before, &c.after); err != nil {
+			rows.Close()
+			return rollback(err)
+		}
+		changes = append(changes, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return rollback(err)
+	}
+
+	state := history.OperationRedone
+	if undo {
+		state = history.OperationUndone
+		for i, j := 0, len(changes)-1; i < j; i, j = i+1, j-1 {
+			changes[i], changes[j] = changes[j], changes[i]
+		}
+	}
+
+	for _, c := range changes {
+		from, to := c.before, c.after
+		if undo {
+			from, to = c.after, c.before
+		}
+
+		// a row is recorded while it exists so its file and tag can be named
+		if !to.Valid {
+			if err := tagDB.recordReplayed(ctx, tx, state, c.table, from, to); err != nil {
+				return rollback(err)
+			}
+		}
+
+		if err := restoreRow(ctx, tx, c.table, from, to); err != nil {
+			return rollback(fmt.Errorf("unable to %s %s: %w", state, operation.Command, err))
+		}
+
+		if to.Valid {
+			if err := tagDB.recordReplayed(ctx, tx, state, c.table, from, to); err != nil {
+				return rollback(err)
+			}
+		}
+	}
+
+	newState := "done"
+	if undo {
+		newState = "undone"
+	}
+
+	if _, err := tx.ExecContext(ctx, stateString, newState, operation.Id); err != nil {
+		return rollback(err)
+	}
+
+	if err := tagDB.recordHistory(ctx, tx, state, 0, 0, operation.Command); err != nil {
+		return rollback(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return rollback(err)
+	}
+	operation.Undone = undo
+
+	return operation, nil
+}
+
+// recordReplayed appends an entry to the history for a link or a tag changed
+// from one image to another by undoing or redoing an operation. Changes to
+// other tables are only covered by the entry for the operation as a whole.
+func (tagDB *TagDB) recordReplayed(
+	ctx context.Context,
+	tx *sql.Tx,
+	state history.Action,
+	table string,
+	from sql.NullString,
+	to sql.NullString,
+) error {
+	const (
+		linkString = "SELECT json_extract(?, '$.fileid'), json_extract(?, '$.tagid')"
+		tagString  = "SELECT json_extract(?, '$.id')"
+	)
+
+	image := to
+	if !to.Valid {
+		image = from
+	}
+
+	var fileId, tagId int
+	var added, updated, removed history.Action
+	switch table {
+	case "filetags":
+		if err := tx.QueryRowContext(ctx, linkString, image.String, image.String).Scan(&fileId, &tagId); err != nil {
+			return err
+		}
+		added, updated, removed = history.LinkAdded, history.LinkUpdated, history.LinkRemoved
+	case "tags":
+		if err := tx.QueryRowContext(ctx, tagString, image.String).Scan(&tagId); err != nil {
+			return err
+		}
+		added, updated, removed = history.TagAdded, history.TagUpdated, history.TagDeleted
+	default:
+		return nil
+	}
+
+	action := updated
+	switch {
+	case !from.Valid:
+		action = added
+	case !to.Valid:
+		action = removed
+	}
+
+	return tagDB.recordHistory(ctx, tx, action, fileId, tagId, string(state))
+}
+
+// restoreRow changes a row in a journaled table from one image to another,
+// where the images are JSON objects of the row's columns. A missing from
+// image means the row is inserted and a missing to image means it's deleted.
+func restoreRow(ctx context.Context, tx *sql.Tx, table string, from sql.NullString, to sql.NullString) error {
+	if _, ok := journaledTables[table]; !ok {
+		return fmt.Errorf("table %s isn't journaled", table)
+	}
+
+	columns, keys, err := tableColumns(ctx, tx, table)
+	if err != nil {
+		return err
+	}
+
+	extract := func(column string) string {
+		return fmt.Sprintf("json_extract(?, '$.%s')", column)
+	}
+
+	keyConditions := []string{}
+	for _, key := range keys {
+		keyConditions = append(keyConditions, key+" IS "+extract(key))
+	}
+
+	switch {
+	case !from.Valid:
+		placeholders := []string{}
+		args := []any{}
+		for _, column := range columns {
+			placeholders = append(placeholders, extract(column))
+			args = append(args, to.String)
+		}
+
+		_, err = tx.ExecContext(
+			ctx,
+			fmt.Sprintf(
+				"INSERT INTO %s(%s) VALUES(%s)",
+				table,
+				strings.Join(columns, ", "),
+				strings.Join(placeholders, ", "),
+			),
+			args...,
+		)
+	case !to.Valid:
+		args := []any{}
+		for range keys {
+			args = append(args, from.String)
+		}
+
+		_, err = tx.ExecContext(
+			ctx,
+			fmt.Sprintf("DELETE FROM %s WHERE %s", table, strings.Join(keyConditions, " AND ")),
+			args...,
+		)
+	default:
+		assignments := []string{}
+		args := []any{}
+		for _, column := range columns {
+			assignments = append(assignments, column+" = "+extract(column))
+			args = append(args, to.String)
+		}
+		for range keys {
+			args = append(args, from.String)
+		}
+
+		_, err = tx.ExecContext(
+			ctx,
+			fmt.Sprintf(
+				"UPDATE %s SET %s WHERE %s",
+				table,
+				strings.Join(assignments, ", "),
+				strings.Join(keyConditions, " AND "),
+			),
+			args...,
+		)
+	}
+
+	return err
+}
+
+// tableColumns returns the columns of a table and the columns of its primary
+// key, in the order they're declared.
+func tableColumns(ctx context.Context, tx *sql.Tx, table string) ([]string, []string, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT name, pk FROM pragma_table_info(?) ORDER BY cid", table)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	columns := []string{}
+	keys := map[int]string{}
+	for rows.Next() {
+		var column string
+		var pk int
+		if err := rows.Scan(&column, &pk); err != nil {
+			return nil, nil, err
+		}
+		columns = append(columns, column)
+		if pk > 0 {
+			keys[pk] = column
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	orderedKeys := []string{}
+	for i := 1; i <= len(keys); i++ {
+		orderedKeys = append(orderedKeys, keys[i])
+	}
+
+	return columns, orderedKeys, nil
+}
+
+// GetOperations returns every operation which can be undone, newest first,
+// followed by every operation which can be redone, in the order they'd be
+// redone.
+func (tagDB *TagDB) GetOperations(ctx context.Context) ([]history.Operation, error) {
+	const (
+		searchString = "SELECT " + operationColumns + ` FROM operations o
+			WHERE o.state != 'open'
+			ORDER BY o.state, CASE WHEN o.state = 'done' THEN -o.id ELSE o.id END`
+	)
+
+	ctx, span := tracer.Start(ctx, "GetOperations")
+	defer span.End()
+
+	rows, err := tagDB.client.QueryContext(ctx, searchString)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := []history.Operation{}
+	for rows.Next() {
+		operation, err := scanOperation(rows)
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+		ret = append(ret, operation)
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+func scanOperation(row interface{ Scan(...any) error }) (history.Operation, error) {
+	operation := history.Operation{}
+	var at int64
+	if err := row.Scan(
+		&operation.Id,
+		&at,
+		&operation.User,
+		&operation.Host,
+		&operation.Command,
+		&operation.Changes,
+		&operation.Undone,
+	); err != nil {
+		return history.Operation{}, err
+	}
+	operation.Time = time.Unix(at, 0).UTC()
+
+	return operation, nil
+}
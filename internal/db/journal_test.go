@@ -0,0 +1,296 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/whatsfordinner/fstagger/internal/files"
+	"github.com/whatsfordinner/fstagger/internal/links"
+	"github.com/whatsfordinner/fstagger/internal/tags"
+	"github.com/whatsfordinner/fstagger/internal/values"
+)
+
+// journalState is what a test can see of the database after undoing or
+// redoing: every file's path and every tag on each of them with its value.
+func journalState(t *testing.T, testDB *TagDB) map[string][]string {
+	ctx := context.Background()
+
+	allFiles, err := testDB.GetFiles(ctx)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	ret := map[string][]string{}
+	for _, file := range allFiles {
+		fileLinks, err := testDB.GetLinksForFile(ctx, file)
+		if err != nil {
+			t.Fatalf("Expected no error but got: %s", err.Error())
+		}
+
+		ret[file.Path] = []string{}
+		for _, link := range fileLinks {
+			tag, err := testDB.GetTagById(ctx, link.Tag)
+			if err != nil {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			name := tag.String()
+			if link.Value != "" {
+				name += "=" + link.Value
+			}
+			ret[file.Path] = append(ret[file.Path], name)
+		}
+	}
+
+	return ret
+}
+
+// journaled runs a function as a single operation.
+func journaled(t *testing.T, testDB *TagDB, do func(ctx context.Context) error) {
+	ctx := context.Background()
+
+	if err := testDB.BeginOperation(ctx); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	if err := do(ctx); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	if err := testDB.EndOperation(ctx); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+}
+
+func TestTagDBUndoRedo(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/get_tags_no_tags.yml"})
+	defer teardown()
+
+	ctx := context.Background()
+	var addedFiles []files.File
+	var addedTags []tags.Tag
+
+	journaled(t, testDB, func(ctx context.Context) error {
+		var err error
+		addedFiles, err = testDB.AddFiles(ctx, []files.File{
+			{Path: "/path/to/foo", Hash: "foohash"},
+			{Path: "/path/to/bar", Hash: "barhash"},
+		})
+		if err != nil {
+			return err
+		}
+
+		addedTags, err = testDB.AddTags(ctx, []tags.Tag{{Name: "inbox"}, {Name: "rating"}})
+		if err != nil {
+			return err
+		}
+
+		if _, err := testDB.SetValueTypes(ctx, []values.Declaration{
+			{Tag: addedTags[1].Id, Type: values.Float},
+		}); err != nil {
+			return err
+		}
+
+		_, err = testDB.AddLinks(ctx, []links.Link{
+			{File: addedFiles[0].Id, Tag: addedTags[0].Id},
+			{File: addedFiles[1].Id, Tag: addedTags[0].Id},
+			{File: addedFiles[0].Id, Tag: addedTags[1].Id, Value: "4.5"},
+		})
+		return err
+	})
+
+	tagged := map[string][]string{
+		"/path/to/bar": {"inbox"},
+		"/path/to/foo": {"inbox", "rating=4.5"},
+	}
+
+	journaled(t, testDB, func(ctx context.Context) error {
+		return testDB.DeleteTags(ctx, []tags.Tag{addedTags[0]}, true)
+	})
+
+	deleted := map[string][]string{
+		"/path/to/bar": {},
+		"/path/to/foo": {"rating=4.5"},
+	}
+
+	steps := []struct {
+		name   string
+		do     func(ctx context.Context) error
+		expect map[string][]string
+	}{
+		{"undo deleting a tag", func(ctx context.Context) error { _, err := testDB.Undo(ctx); return err }, tagged},
+		{"undo tagging", func(ctx context.Context) error { _, err := testDB.Undo(ctx); return err }, map[string][]string{}},
+		{"redo tagging", func(ctx context.Context) error { _, err := testDB.Redo(ctx); return err }, tagged},
+		{"redo deleting a tag", func(ctx context.Context) error { _, err := testDB.Redo(ctx); return err }, deleted},
+	}
+
+	for _, step := range steps {
+		if err := step.do(ctx); err != nil {
+			t.Fatalf("%s: Expected no error but got: %s", step.name, err.Error())
+		}
+
+		if res := journalState(t, testDB); !reflect.DeepEqual(res, step.expect) {
+			t.Fatalf(
+				"%s: Result did not match expectation\nResult: %+v\nExpected: %+v",
+				step.name,
+				res,
+				step.expect,
+			)
+		}
+	}
+
+	if _, err := testDB.Redo(ctx); !errors.Is(err, ErrNothingToRedo) {
+		t.Fatalf("Expected %s but got: %v", ErrNothingToRedo, err)
+	}
+}
+
+func TestTagDBUndoNothing(t *testing.T) {
+	testMap := map[string]struct {
+		do     func(t *testing.T, testDB *TagDB)
+		expect error
+	}{
+		"nothing done": {
+			func(t *testing.T, testDB *TagDB) {},
+			ErrNothingToUndo,
+		},
+		"empty operation": {
+			func(t *testing.T, testDB *TagDB) {
+				journaled(t, testDB, func(ctx context.Context) error { return nil })
+			},
+			ErrNothingToUndo,
+		},
+		"change outside an operation": {
+			func(t *testing.T, testDB *TagDB) {
+				if _, err := testDB.AddTags(context.Background(), []tags.Tag{{Name: "inbox"}}); err != nil {
+					t.Fatalf("Expected no error but got: %s", err.Error())
+				}
+			},
+			ErrNothingToUndo,
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/get_tags_no_tags.yml"})
+			defer teardown()
+
+			testData.do(t, testDB)
+
+			if _, err := testDB.Undo(context.Background()); !errors.Is(err, testData.expect) {
+				t.Fatalf("Expected %s but got: %v", testData.expect, err)
+			}
+		})
+	}
+}
+
+func TestTagDBRedoDiverged(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/get_tags_no_tags.yml"})
+	defer teardown()
+
+	ctx := context.Background()
+
+	journaled(t, testDB, func(ctx context.Context) error {
+		_, err := testDB.AddTags(ctx, []tags.Tag{{Name: "inbox"}})
+		return err
+	})
+
+	if _, err := testDB.Undo(ctx); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	journaled(t, testDB, func(ctx context.Context) error {
+		_, err := testDB.AddTags(ctx, []tags.Tag{{Name: "done"}})
+		return err
+	})
+
+	if _, err := testDB.Redo(ctx); !errors.Is(err, ErrNothingToRedo) {
+		t.Fatalf("Expected %s but got: %v", ErrNothingToRedo, err)
+	}
+
+	operations, err := testDB.GetOperations(ctx)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	if len(operations) != 1 || operations[0].Changes == 0 {
+		t.Fatalf("Expected one operation with changes but got: %+v", operations)
+	}
+}
+
+// TestTagDBUndoConcurrent checks that two commands running against the same
+// database at once each journal their own changes into their own operation.
+func TestTagDBUndoConcurrent(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	first := New(WithConnectionString(path))
+	if err := first.Init(ctx); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+	defer first.Close(ctx)
+
+	second := New(WithConnectionString(path))
+	if err := second.Init(ctx); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+	defer second.Close(ctx)
+
+	for _, testDB := range []*TagDB{first, second} {
+		if err := testDB.BeginOperation(ctx); err != nil {
+			t.Fatalf("Expected no error but got: %s", err.Error())
+		}
+	}
+
+	for _, step := range []struct {
+		testDB *TagDB
+		name   string
+	}{
+		{first, "inbox"},
+		{second, "done"},
+		{first, "todo"},
+	} {
+		if _, err := step.testDB.AddTags(ctx, []tags.Tag{{Name: step.name}}); err != nil {
+			t.Fatalf("Expected no error but got: %s", err.Error())
+		}
+	}
+
+	if err := first.EndOperation(ctx); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	operations, err := first.GetOperations(ctx)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	if len(operations) != 1 || operations[0].Changes != 2 {
+		t.Fatalf("Expected one operation with 2 changes but got: %+v", operations)
+	}
+
+	if err := second.EndOperation(ctx); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	for _, expect := range [][]string{{"inbox", "todo"}, {}} {
+		if _, err := first.Undo(ctx); err != nil {
+			t.Fatalf("Expected no error but got: %s", err.Error())
+		}
+
+		allTags, err := first.GetTags(ctx)
+		if err != nil {
+			t.Fatalf("Expected no error but got: %s", err.Error())
+		}
+
+		res := []string{}
+		for _, tag := range allTags {
+			res = append(res, tag.String())
+		}
+
+		if !reflect.DeepEqual(res, expect) {
+			t.Fatalf("Result did not match expectation\nResult: %+v\nExpected: %+v", res, expect)
+		}
+	}
+}
@@ -2,10 +2,14 @@ package db
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
+	"sort"
+	"time"
 
 	"github.com/whatsfordinner/fstagger/internal/files"
+	"github.com/whatsfordinner/fstagger/internal/history"
 	"github.com/whatsfordinner/fstagger/internal/links"
 	"github.com/whatsfordinner/fstagger/internal/tags"
 
@@ -13,9 +17,36 @@ import (
 	"go.opentelemetry.io/otel/codes"
 )
 
+// AddLinks takes a slice of links and tags each file with each tag. A file
+// can only have one tag from an exclusive group, so adding a link either
+// removes the file's other tags from the group or is rejected with an error
+// wrapping ErrExclusiveGroup, depending on the group's policy. Each link is
+// added or not independently of the others.
+//
+// A link with a value must be to a tag which takes values and the value must
+// be valid for the tag's type. If the file already has the tag its value is
+// replaced.
+//
+// A link with an expiry time is hidden from every search once it has passed.
+// Adding a link with an expiry time to a file which already has the tag moves
+// the existing link's expiry time and adding a link the file had until it
+// expired replaces it.
+//
+// A link without a source is made by hand. Only a person or the link's own
+// source can change an existing link, so adding a link the file already has
+// from another source is an error wrapping ErrLinkExists unless it's made by
+// hand, in which case the link becomes a manual one. Likewise a link which
+// isn't made by hand can't replace a manual link in an exclusive group. A
+// link's confidence has to be between 0 and 1.
 func (tagDB *TagDB) AddLinks(ctx context.Context, newLinks []links.Link) ([]links.Link, error) {
 	const (
-		insertString = "INSERT INTO filetags(fileid, tagid) VALUES(?, ?) RETURNING fileid"
+		insertString = `INSERT INTO filetags(fileid, tagid, value, expires, source, confidence, created, updated)
+			VALUES(?, ?, ?, ?, ?, ?, unixepoch(), unixepoch()) RETURNING fileid`
+		expiredString    = "DELETE FROM filetags WHERE fileid = ? AND tagid = ? AND expires <= unixepoch()"
+		expiresString    = "UPDATE filetags SET expires = ?, updated = unixepoch() WHERE fileid = ? AND tagid = ?"
+		sourceString     = "SELECT source FROM filetags WHERE fileid = ? AND tagid = ?"
+		provenanceString = `UPDATE filetags SET source = ?, confidence = ?, updated = unixepoch()
+			WHERE fileid = ? AND tagid = ? AND (source != ? OR confidence IS NOT ?)`
 	)
 	ctx, span := tracer.Start(ctx, "AddLinks")
 	defer span.End()
@@ -27,34 +58,167 @@ func (tagDB *TagDB) AddLinks(ctx context.Context, newLinks []links.Link) ([]link
 		return []links.Link{}, err
 	}
 
-	txErrors := []error{}
+	txErrors := &BatchError{}
 
-	for _, newLink := range newLinks {
-		span.AddEvent(fmt.Sprintf("adding tag ID %d to file ID %d", newLink.Tag, newLink.File))
-		row := tx.QueryRowContext(ctx, insertString, newLink.File, newLink.Tag)
+	addLink := func(newLink links.Link) error {
+		newLink.Source = newLink.SourceOrManual()
+		if c := newLink.Confidence; c != nil && (*c < 0 || *c > 1) {
+			return fmt.Errorf("confidence must be between 0 and 1: %g", *c)
+		}
+
+		if _, err := tx.ExecContext(ctx, expiredString, newLink.File, newLink.Tag); err != nil {
+			return err
+		}
+
+		var existingSource string
+		err := tx.QueryRowContext(ctx, sourceString, newLink.File, newLink.Tag).Scan(&existingSource)
+		exists := err == nil
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		// re-running a rule or an import mustn't undo what a person decided or
+		// take over another source's links
+		if exists && existingSource != newLink.Source && newLink.Source != links.SourceManual {
+			return fmt.Errorf(
+				"%s already has tag %s from %s: %w",
+				filePath(ctx, tx, newLink.File),
+				tagName(ctx, tx, newLink.Tag),
+				existingSource,
+				ErrLinkExists,
+			)
+		}
+
+		value, updated, err := setLinkValue(ctx, tx, newLink)
+		if err != nil {
+			return err
+		}
+
+		if exists {
+			res, err := tx.ExecContext(
+				ctx,
+				provenanceString,
+				newLink.Source,
+				confidenceOrNull(newLink),
+				newLink.File,
+				newLink.Tag,
+				newLink.Source,
+				confidenceOrNull(newLink),
+			)
+			if err != nil {
+				return err
+			}
+
+			if changed, err := res.RowsAffected(); err != nil {
+				return err
+			} else if changed > 0 {
+				updated = true
+			}
+		}
+
+		if !newLink.Expires.IsZero() {
+			res, err := tx.ExecContext(ctx, expiresString, newLink.Expires.Unix(), newLink.File, newLink.Tag)
+			if err != nil {
+				return err
+			}
+
+			if extended, err := res.RowsAffected(); err != nil {
+				return err
+			} else if extended > 0 {
+				updated = true
+			}
+		}
+
+		if updated {
+			return tagDB.recordHistory(
+				ctx,
+				tx,
+				history.LinkUpdated,
+				newLink.File,
+				newLink.Tag,
+				history.Describe(newLink.Value, newLink.Expires),
+			)
+		}
+
+		if err := tagDB.enforceExclusiveGroups(ctx, tx, newLink); err != nil {
+			return err
+		}
+
+		row := tx.QueryRowContext(
+			ctx,
+			insertString,
+			newLink.File,
+			newLink.Tag,
+			value,
+			expiresUnix(newLink),
+			newLink.Source,
+			confidenceOrNull(newLink),
+		)
 		var fileId int64
-		err := row.Scan(&fileId)
+		err = row.Scan(&fileId)
 		if err != nil {
 			if sqliteErr, ok := err.(sqlite3.Error); ok {
-				if sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
-					collisionTag, err := tagDB.GetTagById(ctx, newLink.Tag)
-					if err != nil {
-					}
-					collisionFile, err := tagDB.GetFileById(ctx, newLink.File)
-					if err != nil {
-					}
-					collisionErr := fmt.Errorf(
-						"file at path %s already tagged with %s",
-						collisionFile.Path,
-						collisionTag.Name,
+				// the link's uniqueness comes from the table's primary key
+				if sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique ||
+					sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
+					return fmt.Errorf(
+						"%s already has tag %s: %w",
+						filePath(ctx, tx, newLink.File),
+						tagName(ctx, tx, newLink.Tag),
+						ErrLinkExists,
 					)
-					txErrors = append(txErrors, collisionErr)
-					continue
 				}
 			}
-			txErrors = append(txErrors, err)
+			return err
+		}
+
+		if err := tagDB.recordHistory(
+			ctx,
+			tx,
+			history.LinkAdded,
+			newLink.File,
+			newLink.Tag,
+			history.Describe(newLink.Value, newLink.Expires),
+		); err != nil {
+			return err
+		}
+
+		return tagDB.materializeImplications(
+			ctx,
+			tx,
+			"SELECT ?, ?, 0",
+			newLink.File,
+			newLink.Tag,
+		)
+	}
+
+	for i, newLink := range newLinks {
+		span.AddEvent(fmt.Sprintf("adding tag ID %d to file ID %d", newLink.Tag, newLink.File))
+
+		// making room in an exclusive group touches other links so each link
+		// is added inside a savepoint which can be discarded without losing
+		// the others
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT add_link"); err != nil {
+			txErrors.add(i, err)
 			continue
 		}
+
+		err := addLink(newLink)
+		if err != nil {
+			if _, rollbackErr := tx.ExecContext(ctx, "ROLLBACK TO add_link"); rollbackErr != nil {
+				err = errors.Join(err, rollbackErr)
+			}
+		}
+
+		if _, releaseErr := tx.ExecContext(ctx, "RELEASE add_link"); releaseErr != nil {
+			err = errors.Join(err, releaseErr)
+		}
+
+		if err != nil {
+			txErrors.add(i, err)
+			continue
+		}
+
 		addedLinks = append(addedLinks, newLink)
 	}
 	if err := tx.Commit(); err != nil {
@@ -65,13 +229,262 @@ func (tagDB *TagDB) AddLinks(ctx context.Context, newLinks []links.Link) ([]link
 	}
 
 	span.SetStatus(codes.Ok, "")
-	return addedLinks, errors.Join(txErrors...)
+	return addedLinks, txErrors.errOrNil()
+}
+
+// DeleteLinks takes a slice of links and removes each tag from its file. A link
+// the file doesn't have, including one which has expired, is an error wrapping
+// sql.ErrNoRows. Tags the removed tag implied stay on the file.
+func (tagDB *TagDB) DeleteLinks(ctx context.Context, deleteLinks []links.Link) error {
+	const (
+		deleteString = `DELETE FROM filetags WHERE fileid = ? AND tagid = ?
+			AND (expires IS NULL OR expires > unixepoch())`
+	)
+
+	ctx, span := tracer.Start(ctx, "DeleteLinks")
+	defer span.End()
+
+	tx, err := tagDB.client.BeginTx(ctx, nil)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	txErrors := &BatchError{}
+
+	for i, link := range deleteLinks {
+		span.AddEvent(fmt.Sprintf("removing tag ID %d from file ID %d", link.Tag, link.File))
+
+		res, err := tx.ExecContext(ctx, deleteString, link.File, link.Tag)
+		if err != nil {
+			txErrors.add(i, err)
+			continue
+		}
+
+		if deleted, err := res.RowsAffected(); err != nil {
+			txErrors.add(i, err)
+			continue
+		} else if deleted == 0 {
+			txErrors.add(i, fmt.Errorf("file ID %d doesn't have tag ID %d: %w", link.File, link.Tag, sql.ErrNoRows))
+			continue
+		}
+
+		if err := tagDB.recordHistory(ctx, tx, history.LinkRemoved, link.File, link.Tag, ""); err != nil {
+			txErrors.add(i, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			err = errors.Join(err, rollbackErr)
+		}
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	if len(txErrors.Errors) > 0 {
+		span.SetStatus(codes.Error, "encountered DB errors during transaction")
+	} else {
+		span.SetStatus(codes.Ok, "")
+	}
+
+	return txErrors.errOrNil()
+}
+
+// DeleteExpiredLinks removes every link whose expiry time has passed, records
+// each removal in the history and returns them ordered by file ID and then tag
+// ID.
+func (tagDB *TagDB) DeleteExpiredLinks(ctx context.Context) ([]links.Link, error) {
+	const (
+		nowString    = "SELECT unixepoch()"
+		deleteString = `DELETE FROM filetags WHERE expires <= ?
+			RETURNING ` + linkColumns
+	)
+
+	ctx, span := tracer.Start(ctx, "DeleteExpiredLinks")
+	defer span.End()
+
+	tx, err := tagDB.client.BeginTx(ctx, nil)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	rollback := func(err error) ([]links.Link, error) {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			err = errors.Join(err, rollbackErr)
+		}
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	// the same time is used throughout so a link expiring part way through
+	// isn't removed without being recorded
+	var now int64
+	if err := tx.QueryRowContext(ctx, nowString).Scan(&now); err != nil {
+		return rollback(err)
+	}
+
+	rows, err := tx.QueryContext(ctx, deleteString, now)
+	if err != nil {
+		return rollback(err)
+	}
+
+	ret, err := scanLinks(rows)
+	if err != nil {
+		return rollback(err)
+	}
+
+	// RETURNING doesn't support ORDER BY
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].File != ret[j].File {
+			return ret[i].File < ret[j].File
+		}
+		return ret[i].Tag < ret[j].Tag
+	})
+
+	for _, link := range ret {
+		if err := tagDB.recordHistory(ctx, tx, history.LinkRemoved, link.File, link.Tag, "expired"); err != nil {
+			return rollback(err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return rollback(err)
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
 }
 
+// GetLinks returns every link, including their values, ordered by file ID and
+// then tag ID.
+func (tagDB *TagDB) GetLinks(ctx context.Context) ([]links.Link, error) {
+	ctx, span := tracer.Start(ctx, "GetLinks")
+	defer span.End()
+
+	ret, err := getLinks(ctx, tagDB.client, "TRUE")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+// GetLinksForFile returns every link on a file, including their values,
+// ordered by tag ID. Only the file's ID is used for the search.
 func (tagDB *TagDB) GetLinksForFile(ctx context.Context, targetFile files.File) ([]links.Link, error) {
-	return nil, nil
+	ctx, span := tracer.Start(ctx, "GetLinksForFile")
+	defer span.End()
+
+	ret, err := getLinks(ctx, tagDB.client, "fileid = ?", targetFile.Id)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
 }
 
+// GetLinksForTag returns every link to a tag, including their values, ordered
+// by file ID. Only the tag's ID is used for the search.
 func (tagDB *TagDB) GetLinksForTag(ctx context.Context, targetTag tags.Tag) ([]links.Link, error) {
-	return nil, nil
+	ctx, span := tracer.Start(ctx, "GetLinksForTag")
+	defer span.End()
+
+	ret, err := getLinks(ctx, tagDB.client, "tagid = ?", targetTag.Id)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+// GetLinksBySource returns every link made by a source, ordered by file ID and
+// then tag ID. The source may contain * wildcards, so implied:* matches every
+// link made by an implication rule, and links.SourceManual matches the links
+// made by hand.
+func (tagDB *TagDB) GetLinksBySource(ctx context.Context, source string) ([]links.Link, error) {
+	ctx, span := tracer.Start(ctx, "GetLinksBySource")
+	defer span.End()
+
+	ret, err := getLinks(ctx, tagDB.client, globCondition("source", source), globPattern(source))
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+// linkColumns are the columns of filetags, or livefiletags, scanned by
+// scanLinks.
+const linkColumns = "fileid, tagid, COALESCE(CAST(value AS TEXT), ''), expires, source, confidence"
+
+// confidenceOrNull returns the link's confidence as it's stored.
+func confidenceOrNull(link links.Link) any {
+	if link.Confidence == nil {
+		return nil
+	}
+
+	return *link.Confidence
+}
+
+// expiresUnix returns the link's expiry time as it's stored.
+func expiresUnix(link links.Link) any {
+	if link.Expires.IsZero() {
+		return nil
+	}
+
+	return link.Expires.Unix()
+}
+
+// getLinks returns the links matching a condition which haven't expired.
+func getLinks(ctx context.Context, q querier, condition string, args ...any) ([]links.Link, error) {
+	const (
+		searchString = `SELECT ` + linkColumns + ` FROM livefiletags
+			WHERE %s
+			ORDER BY fileid, tagid`
+	)
+
+	rows, err := q.QueryContext(ctx, fmt.Sprintf(searchString, condition), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanLinks(rows)
+}
+
+func scanLinks(rows *sql.Rows) ([]links.Link, error) {
+	defer rows.Close()
+
+	ret := []links.Link{}
+	for rows.Next() {
+		link := links.Link{}
+		var expires sql.NullInt64
+		var confidence sql.NullFloat64
+		if err := rows.Scan(&link.File, &link.Tag, &link.Value, &expires, &link.Source, &confidence); err != nil {
+			return nil, err
+		}
+
+		if expires.Valid {
+			link.Expires = time.Unix(expires.Int64, 0).UTC()
+		}
+
+		if link.Source == links.SourceManual {
+			link.Source = ""
+		}
+		if confidence.Valid {
+			link.Confidence = new(confidence.Float64)
+		}
+
+		ret = append(ret, link)
+	}
+
+	return ret, rows.Err()
 }
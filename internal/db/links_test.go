@@ -4,8 +4,11 @@ import (
 	"context"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/whatsfordinner/fstagger/internal/files"
 	"github.com/whatsfordinner/fstagger/internal/links"
+	"github.com/whatsfordinner/fstagger/internal/tags"
 )
 
 func TestTagDBAddLinks(t *testing.T) {
@@ -114,3 +117,442 @@ func TestTagDBAddLinks(t *testing.T) {
 		})
 	}
 }
+
+var (
+	fixturePast   = time.Unix(946684800, 0).UTC()
+	fixtureFuture = time.Unix(4102444800, 0).UTC()
+)
+
+func TestTagDBExpiredLinksHidden(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/expiry.yml"})
+	defer teardown()
+
+	res, err := testDB.GetFilesByTags(context.Background(), []string{"inbox"})
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	expect := []string{"/path/to/bar", "/path/to/baz"}
+	if !reflect.DeepEqual(filePaths(res), expect) {
+		t.Fatalf(
+			"Result did not match expectation\nResult: %+v\nExpected: %+v",
+			filePaths(res),
+			expect,
+		)
+	}
+
+	if names := tagNamesForFile(t, testDB, 1); !reflect.DeepEqual(names, []string{"done"}) {
+		t.Fatalf("Expected only done on file ID 1 but got: %+v", names)
+	}
+
+	counts, err := testDB.GetTagFileCounts(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	expectCounts := map[int]int{1: 2, 2: 1}
+	if !reflect.DeepEqual(counts, expectCounts) {
+		t.Fatalf(
+			"Result did not match expectation\nResult: %+v\nExpected: %+v",
+			counts,
+			expectCounts,
+		)
+	}
+}
+
+func TestTagDBAddLinksExpiry(t *testing.T) {
+	later := fixtureFuture.Add(24 * time.Hour)
+
+	testMap := map[string]struct {
+		shouldErr  bool
+		input      links.Link
+		expectFile int
+		expect     []links.Link
+	}{
+		"replaces an expired link": {
+			false,
+			links.Link{File: 1, Tag: 1},
+			1,
+			[]links.Link{{File: 1, Tag: 1}, {File: 1, Tag: 2}},
+		},
+		"moves the expiry of an existing link": {
+			false,
+			links.Link{File: 2, Tag: 1, Expires: later},
+			2,
+			[]links.Link{{File: 2, Tag: 1, Expires: later}},
+		},
+		"new link with an expiry": {
+			false,
+			links.Link{File: 3, Tag: 2, Expires: later},
+			3,
+			[]links.Link{{File: 3, Tag: 1}, {File: 3, Tag: 2, Expires: later}},
+		},
+		"existing link without an expiry": {
+			true,
+			links.Link{File: 2, Tag: 1},
+			2,
+			[]links.Link{{File: 2, Tag: 1, Expires: fixtureFuture}},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/expiry.yml"})
+			defer teardown()
+
+			_, err := testDB.AddLinks(context.Background(), []links.Link{testData.input})
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			res, err := testDB.GetLinksForFile(context.Background(), files.File{Id: testData.expectFile})
+			if err != nil {
+				t.Fatalf("Error retrieving links: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBDeleteExpiredLinks(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/expiry.yml"})
+	defer teardown()
+
+	res, err := testDB.DeleteExpiredLinks(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	expect := []links.Link{{File: 1, Tag: 1, Expires: fixturePast}}
+	if !reflect.DeepEqual(res, expect) {
+		t.Fatalf(
+			"Result did not match expectation\nResult: %+v\nExpected: %+v",
+			res,
+			expect,
+		)
+	}
+
+	var remaining int
+	if err := testDB.client.QueryRow("SELECT COUNT(*) FROM filetags").Scan(&remaining); err != nil {
+		t.Fatalf("Error counting links: %s", err.Error())
+	}
+
+	if remaining != 3 {
+		t.Fatalf("Expected 3 links to remain but got %d", remaining)
+	}
+}
+
+// TestTagDBMergeTagsExpiredTarget checks that a file whose link to the target
+// tag has expired keeps its live link to the source tag as the target tag.
+func TestTagDBMergeTagsExpiredTarget(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/expiry.yml"})
+	defer teardown()
+
+	if _, err := testDB.MergeTags(context.Background(), []tags.Tag{{Id: 2}}, tags.Tag{Id: 1}); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	res, err := testDB.GetLinksForFile(context.Background(), files.File{Id: 1})
+	if err != nil {
+		t.Fatalf("Error retrieving links: %s", err.Error())
+	}
+
+	expect := []links.Link{{File: 1, Tag: 1}}
+	if !reflect.DeepEqual(res, expect) {
+		t.Fatalf(
+			"Result did not match expectation\nResult: %+v\nExpected: %+v",
+			res,
+			expect,
+		)
+	}
+}
+
+func TestTagDBDeleteLinks(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr bool
+		input     []links.Link
+		expect    map[int][]string
+	}{
+		"removing a tag": {
+			false,
+			[]links.Link{{File: 1, Tag: 2}},
+			map[int][]string{1: {}, 2: {"inbox"}, 3: {"inbox"}},
+		},
+		"removing a tag from many files": {
+			false,
+			[]links.Link{{File: 2, Tag: 1}, {File: 3, Tag: 1}},
+			map[int][]string{1: {"done"}, 2: {}, 3: {}},
+		},
+		"removing a tag the file doesn't have": {
+			true,
+			[]links.Link{{File: 2, Tag: 2}, {File: 3, Tag: 1}},
+			map[int][]string{1: {"done"}, 2: {"inbox"}, 3: {}},
+		},
+		"removing an expired tag": {
+			true,
+			[]links.Link{{File: 1, Tag: 1}},
+			map[int][]string{1: {"done"}, 2: {"inbox"}, 3: {"inbox"}},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/expiry.yml"})
+			defer teardown()
+
+			err := testDB.DeleteLinks(context.Background(), testData.input)
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			res := map[int][]string{}
+			for fileId := range testData.expect {
+				res[fileId] = tagNamesForFile(t, testDB, fileId)
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBAddLinksProvenance(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr bool
+		input     links.Link
+		expect    []links.Link
+	}{
+		"adding a link by hand": {
+			false,
+			links.Link{File: 2, Tag: 3},
+			[]links.Link{
+				{File: 2, Tag: 1, Source: links.SourceImport},
+				{File: 2, Tag: 3},
+			},
+		},
+		"adding a link with a source and confidence": {
+			false,
+			links.Link{File: 2, Tag: 4, Source: "rule:animals", Confidence: new(0.9)},
+			[]links.Link{
+				{File: 2, Tag: 1, Source: links.SourceImport},
+				{File: 2, Tag: 4, Source: "rule:animals", Confidence: new(0.9)},
+			},
+		},
+		"adding a link with no confidence at all": {
+			false,
+			links.Link{File: 2, Tag: 4, Source: "rule:animals", Confidence: new(0.0)},
+			[]links.Link{
+				{File: 2, Tag: 1, Source: links.SourceImport},
+				{File: 2, Tag: 4, Source: "rule:animals", Confidence: new(0.0)},
+			},
+		},
+		"re-adding a link from its own source": {
+			false,
+			links.Link{File: 1, Tag: 3, Source: "rule:pets", Confidence: new(0.9)},
+			[]links.Link{
+				{File: 1, Tag: 2},
+				{File: 1, Tag: 3, Source: "rule:pets", Confidence: new(0.9)},
+			},
+		},
+		"re-adding a link from another source": {
+			true,
+			links.Link{File: 1, Tag: 3, Source: links.SourceImport},
+			[]links.Link{
+				{File: 1, Tag: 2},
+				{File: 1, Tag: 3, Source: "rule:pets", Confidence: new(0.6)},
+			},
+		},
+		"re-adding a manual link from a source": {
+			true,
+			links.Link{File: 1, Tag: 2, Source: "rule:pets"},
+			[]links.Link{
+				{File: 1, Tag: 2},
+				{File: 1, Tag: 3, Source: "rule:pets", Confidence: new(0.6)},
+			},
+		},
+		"taking over a link by hand": {
+			false,
+			links.Link{File: 1, Tag: 3},
+			[]links.Link{
+				{File: 1, Tag: 2},
+				{File: 1, Tag: 3},
+			},
+		},
+		"replacing a link from a source in an exclusive group by hand": {
+			false,
+			links.Link{File: 2, Tag: 2},
+			[]links.Link{
+				{File: 2, Tag: 2},
+			},
+		},
+		"replacing a manual link in an exclusive group from a source": {
+			true,
+			links.Link{File: 1, Tag: 1, Source: "rule:pets"},
+			[]links.Link{
+				{File: 1, Tag: 2},
+				{File: 1, Tag: 3, Source: "rule:pets", Confidence: new(0.6)},
+			},
+		},
+		"adding a link with too much confidence": {
+			true,
+			links.Link{File: 2, Tag: 3, Confidence: new(1.5)},
+			[]links.Link{
+				{File: 2, Tag: 1, Source: links.SourceImport},
+			},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/provenance.yml"})
+			defer teardown()
+
+			_, err := testDB.AddLinks(context.Background(), []links.Link{testData.input})
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			res, err := testDB.GetLinksForFile(context.Background(), files.File{Id: testData.input.File})
+			if err != nil {
+				t.Fatalf("Unable to retrieve links: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBGetLinksBySource(t *testing.T) {
+	testMap := map[string]struct {
+		input  string
+		expect []links.Link
+	}{
+		"a source": {
+			"rule:pets",
+			[]links.Link{
+				{File: 1, Tag: 3, Source: "rule:pets", Confidence: new(0.6)},
+			},
+		},
+		"a wildcard": {
+			"*",
+			[]links.Link{
+				{File: 1, Tag: 2},
+				{File: 1, Tag: 3, Source: "rule:pets", Confidence: new(0.6)},
+				{File: 2, Tag: 1, Source: links.SourceImport},
+			},
+		},
+		"links made by hand": {
+			links.SourceManual,
+			[]links.Link{
+				{File: 1, Tag: 2},
+			},
+		},
+		"a source without links": {
+			"rule:*cars",
+			[]links.Link{},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/provenance.yml"})
+			defer teardown()
+
+			res, err := testDB.GetLinksBySource(context.Background(), testData.input)
+			if err != nil {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBMergeTagsProvenance(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/provenance.yml"})
+	defer teardown()
+
+	if _, err := testDB.MergeTags(
+		context.Background(),
+		[]tags.Tag{{Id: 3}},
+		tags.Tag{Id: 4},
+	); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	res, err := testDB.GetLinksBySource(context.Background(), "rule:*")
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	expect := []links.Link{{File: 1, Tag: 4, Source: "rule:pets", Confidence: new(0.6)}}
+	if !reflect.DeepEqual(res, expect) {
+		t.Fatalf(
+			"Result did not match expectation\nResult: %+v\nExpected: %+v",
+			res,
+			expect,
+		)
+	}
+}
+
+func TestTagDBGetLinks(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/expiry.yml"})
+	defer teardown()
+
+	res, err := testDB.GetLinks(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	expect := []links.Link{
+		{File: 1, Tag: 2},
+		{File: 2, Tag: 1, Expires: fixtureFuture},
+		{File: 3, Tag: 1},
+	}
+	if !reflect.DeepEqual(res, expect) {
+		t.Fatalf(
+			"Result did not match expectation\nResult: %+v\nExpected: %+v",
+			res,
+			expect,
+		)
+	}
+}
@@ -0,0 +1,73 @@
+-- +goose Up
+-- filetags is rebuilt around the tags table so that dropping the old tags table
+-- doesn't cascade and delete every link
+CREATE TABLE filetags_backup AS SELECT fileid, tagid FROM filetags;
+DROP TABLE filetags;
+
+CREATE TABLE tags_namespaced(
+	id INTEGER PRIMARY KEY,
+	namespace TEXT NOT NULL DEFAULT '',
+	name TEXT NOT NULL,
+	description TEXT,
+	UNIQUE(namespace, name)
+);
+
+-- existing tags shaped like namespace:value are split on the first colon
+INSERT INTO tags_namespaced(id, namespace, name, description)
+SELECT
+	id,
+	CASE WHEN instr(name, ':') > 1 AND instr(name, ':') < length(name)
+		THEN substr(name, 1, instr(name, ':') - 1)
+		ELSE ''
+	END,
+	CASE WHEN instr(name, ':') > 1 AND instr(name, ':') < length(name)
+		THEN substr(name, instr(name, ':') + 1)
+		ELSE name
+	END,
+	description
+FROM tags;
+
+DROP TABLE tags;
+ALTER TABLE tags_namespaced RENAME TO tags;
+
+CREATE TABLE filetags(
+	fileid INTEGER NOT NULL,
+	tagid INTEGER NOT NULL,
+	FOREIGN KEY(fileid) REFERENCES files(id) ON DELETE CASCADE,
+	FOREIGN KEY(tagid) REFERENCES tags(id) ON DELETE CASCADE
+	PRIMARY KEY(fileid, tagid)
+);
+
+INSERT INTO filetags(fileid, tagid) SELECT fileid, tagid FROM filetags_backup;
+DROP TABLE filetags_backup;
+
+-- +goose Down
+CREATE TABLE filetags_backup AS SELECT fileid, tagid FROM filetags;
+DROP TABLE filetags;
+
+CREATE TABLE tags_flat(
+	id INTEGER PRIMARY KEY,
+	name TEXT UNIQUE NOT NULL,
+	description TEXT
+);
+
+INSERT INTO tags_flat(id, name, description)
+SELECT
+	id,
+	CASE WHEN namespace = '' THEN name ELSE namespace || ':' || name END,
+	description
+FROM tags;
+
+DROP TABLE tags;
+ALTER TABLE tags_flat RENAME TO tags;
+
+CREATE TABLE filetags(
+	fileid INTEGER NOT NULL,
+	tagid INTEGER NOT NULL,
+	FOREIGN KEY(fileid) REFERENCES files(id) ON DELETE CASCADE,
+	FOREIGN KEY(tagid) REFERENCES tags(id) ON DELETE CASCADE
+	PRIMARY KEY(fileid, tagid)
+);
+
+INSERT INTO filetags(fileid, tagid) SELECT fileid, tagid FROM filetags_backup;
+DROP TABLE filetags_backup;
@@ -0,0 +1,66 @@
+-- +goose Up
+ALTER TABLE tags ADD COLUMN parent INTEGER REFERENCES tags(id) ON DELETE SET NULL;
+CREATE INDEX tags_parent ON tags(parent);
+
+-- every ancestor of an existing hierarchical tag, e.g. food and food/dessert
+-- for food/dessert/pie, is created if it doesn't already exist
+INSERT OR IGNORE INTO tags(namespace, name, description)
+WITH RECURSIVE hierarchy(namespace, path, rest) AS (
+	SELECT
+		namespace,
+		substr(name, 1, instr(name, '/') - 1),
+		substr(name, instr(name, '/') + 1)
+	FROM tags
+	WHERE instr(name, '/') > 0
+		AND name NOT LIKE '/%'
+		AND name NOT LIKE '%/'
+		AND instr(name, '//') = 0
+	UNION
+	SELECT
+		namespace,
+		path || '/' || substr(rest, 1, instr(rest, '/') - 1),
+		substr(rest, instr(rest, '/') + 1)
+	FROM hierarchy
+	WHERE instr(rest, '/') > 0
+)
+SELECT DISTINCT namespace, path, '' FROM hierarchy;
+
+-- rtrim(name, replace(name, '/', '')) strips everything after the last slash
+UPDATE tags SET parent = (
+	SELECT p.id FROM tags p
+	WHERE p.namespace = tags.namespace
+		AND p.name || '/' = rtrim(tags.name, replace(tags.name, '/', ''))
+)
+WHERE instr(name, '/') > 0
+	AND name NOT LIKE '/%'
+	AND name NOT LIKE '%/'
+	AND instr(name, '//') = 0;
+
+-- +goose Down
+CREATE TABLE filetags_backup AS SELECT fileid, tagid FROM filetags;
+DROP TABLE filetags;
+
+CREATE TABLE tags_flat(
+	id INTEGER PRIMARY KEY,
+	namespace TEXT NOT NULL DEFAULT '',
+	name TEXT NOT NULL,
+	description TEXT,
+	UNIQUE(namespace, name)
+);
+
+INSERT INTO tags_flat(id, namespace, name, description)
+SELECT id, namespace, name, description FROM tags;
+
+DROP TABLE tags;
+ALTER TABLE tags_flat RENAME TO tags;
+
+CREATE TABLE filetags(
+	fileid INTEGER NOT NULL,
+	tagid INTEGER NOT NULL,
+	FOREIGN KEY(fileid) REFERENCES files(id) ON DELETE CASCADE,
+	FOREIGN KEY(tagid) REFERENCES tags(id) ON DELETE CASCADE
+	PRIMARY KEY(fileid, tagid)
+);
+
+INSERT INTO filetags(fileid, tagid) SELECT fileid, tagid FROM filetags_backup;
+DROP TABLE filetags_backup;
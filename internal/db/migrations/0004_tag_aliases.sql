@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS tagaliases(
+	namespace TEXT NOT NULL DEFAULT '',
+	name TEXT NOT NULL,
+	tagid INTEGER NOT NULL,
+	FOREIGN KEY(tagid) REFERENCES tags(id) ON DELETE CASCADE,
+	PRIMARY KEY(namespace, name)
+);
+
+CREATE INDEX tagaliases_tagid ON tagaliases(tagid);
+
+-- +goose Down
+DROP TABLE tagaliases;
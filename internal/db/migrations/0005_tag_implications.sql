@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS tagimplications(
+	tagid INTEGER NOT NULL,
+	impliedid INTEGER NOT NULL,
+	FOREIGN KEY(tagid) REFERENCES tags(id) ON DELETE CASCADE,
+	FOREIGN KEY(impliedid) REFERENCES tags(id) ON DELETE CASCADE,
+	PRIMARY KEY(tagid, impliedid)
+);
+
+CREATE INDEX tagimplications_impliedid ON tagimplications(impliedid);
+
+-- +goose Down
+DROP TABLE tagimplications;
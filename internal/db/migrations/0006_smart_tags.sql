@@ -0,0 +1,12 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS smarttags(
+	id INTEGER PRIMARY KEY,
+	namespace TEXT NOT NULL DEFAULT '',
+	name TEXT NOT NULL,
+	query TEXT NOT NULL,
+	description TEXT,
+	UNIQUE(namespace, name)
+);
+
+-- +goose Down
+DROP TABLE smarttags;
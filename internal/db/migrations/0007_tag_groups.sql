@@ -0,0 +1,22 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS taggroups(
+	id INTEGER PRIMARY KEY,
+	name TEXT NOT NULL UNIQUE,
+	description TEXT,
+	exclusive INTEGER NOT NULL DEFAULT 0,
+	policy TEXT NOT NULL DEFAULT 'reject' CHECK(policy IN ('reject', 'replace'))
+);
+
+CREATE TABLE IF NOT EXISTS taggroupmembers(
+	groupid INTEGER NOT NULL,
+	tagid INTEGER NOT NULL,
+	FOREIGN KEY(groupid) REFERENCES taggroups(id) ON DELETE CASCADE,
+	FOREIGN KEY(tagid) REFERENCES tags(id) ON DELETE CASCADE,
+	PRIMARY KEY(groupid, tagid)
+);
+
+CREATE INDEX taggroupmembers_tagid ON taggroupmembers(tagid);
+
+-- +goose Down
+DROP TABLE taggroupmembers;
+DROP TABLE taggroups;
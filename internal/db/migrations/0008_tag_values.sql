@@ -0,0 +1,21 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS tagvaluetypes(
+	tagid INTEGER PRIMARY KEY,
+	type TEXT NOT NULL CHECK(type IN ('int', 'float', 'date', 'enum', 'string')),
+	FOREIGN KEY(tagid) REFERENCES tags(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS tagenumvalues(
+	tagid INTEGER NOT NULL,
+	value TEXT NOT NULL,
+	position INTEGER NOT NULL,
+	FOREIGN KEY(tagid) REFERENCES tagvaluetypes(tagid) ON DELETE CASCADE,
+	PRIMARY KEY(tagid, value)
+);
+
+ALTER TABLE filetags ADD COLUMN value;
+
+-- +goose Down
+ALTER TABLE filetags DROP COLUMN value;
+DROP TABLE tagenumvalues;
+DROP TABLE tagvaluetypes;
@@ -0,0 +1,13 @@
+-- +goose Up
+ALTER TABLE filetags ADD COLUMN expires INTEGER;
+
+CREATE INDEX filetags_expires ON filetags(expires) WHERE expires IS NOT NULL;
+
+CREATE VIEW IF NOT EXISTS livefiletags AS
+	SELECT fileid, tagid, value, expires FROM filetags
+	WHERE expires IS NULL OR expires > unixepoch();
+
+-- +goose Down
+DROP VIEW livefiletags;
+DROP INDEX filetags_expires;
+ALTER TABLE filetags DROP COLUMN expires;
@@ -0,0 +1,24 @@
+-- +goose Up
+ALTER TABLE files ADD COLUMN created INTEGER;
+ALTER TABLE files ADD COLUMN updated INTEGER;
+ALTER TABLE tags ADD COLUMN created INTEGER;
+ALTER TABLE tags ADD COLUMN updated INTEGER;
+ALTER TABLE filetags ADD COLUMN created INTEGER;
+ALTER TABLE filetags ADD COLUMN updated INTEGER;
+CREATE INDEX filetags_created ON filetags(created);
+DROP VIEW livefiletags;
+CREATE VIEW livefiletags AS
+	SELECT fileid, tagid, value, expires, created, updated FROM filetags
+	WHERE expires IS NULL OR expires > unixepoch();
+-- +goose Down
+DROP VIEW livefiletags;
+DROP INDEX filetags_created;
+ALTER TABLE filetags DROP COLUMN updated;
+ALTER TABLE filetags DROP COLUMN created;
+ALTER TABLE tags DROP COLUMN updated;
+ALTER TABLE tags DROP COLUMN created;
+ALTER TABLE files DROP COLUMN updated;
+ALTER TABLE files DROP COLUMN created;
+CREATE VIEW livefiletags AS
+	SELECT fileid, tagid, value, expires FROM filetags
+	WHERE expires IS NULL OR expires > unixepoch();
@@ -0,0 +1,32 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS history(
+	id INTEGER PRIMARY KEY,
+	at INTEGER NOT NULL,
+	user TEXT NOT NULL,
+	host TEXT NOT NULL,
+	command TEXT NOT NULL,
+	action TEXT NOT NULL,
+	fileid INTEGER,
+	path TEXT,
+	tagid INTEGER,
+	tag TEXT,
+	detail TEXT NOT NULL
+);
+CREATE INDEX history_fileid ON history(fileid);
+CREATE INDEX history_tagid ON history(tagid);
+-- +goose StatementBegin
+CREATE TRIGGER history_no_update BEFORE UPDATE ON history
+BEGIN
+	SELECT RAISE(ABORT, 'history is append-only');
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER history_no_delete BEFORE DELETE ON history
+BEGIN
+	SELECT RAISE(ABORT, 'history is append-only');
+END;
+-- +goose StatementEnd
+-- +goose Down
+DROP TRIGGER history_no_delete;
+DROP TRIGGER history_no_update;
+DROP TABLE history;
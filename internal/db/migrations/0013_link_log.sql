@@ -0,0 +1,81 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS linklog(
+	id INTEGER PRIMARY KEY,
+	at INTEGER NOT NULL,
+	fileid INTEGER NOT NULL,
+	tagid INTEGER NOT NULL,
+	value,
+	expires INTEGER,
+	removed INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX linklog_link ON linklog(fileid, tagid, id);
+
+CREATE TABLE IF NOT EXISTS linksnapshots(
+	id INTEGER PRIMARY KEY,
+	at INTEGER NOT NULL,
+	logid INTEGER NOT NULL
+);
+
+CREATE INDEX linksnapshots_at ON linksnapshots(at);
+
+CREATE TABLE IF NOT EXISTS linksnapshotrows(
+	snapshotid INTEGER NOT NULL,
+	fileid INTEGER NOT NULL,
+	tagid INTEGER NOT NULL,
+	value,
+	expires INTEGER,
+	PRIMARY KEY(snapshotid, fileid, tagid),
+	FOREIGN KEY(snapshotid) REFERENCES linksnapshots(id) ON DELETE CASCADE
+) WITHOUT ROWID;
+
+-- links made before the log existed are logged from when they were made, or
+-- from the beginning of time if that wasn't recorded
+INSERT INTO linklog(at, fileid, tagid, value, expires)
+SELECT COALESCE(created, 0), fileid, tagid, value, expires FROM filetags
+ORDER BY COALESCE(created, 0), fileid, tagid;
+
+-- +goose StatementBegin
+CREATE TRIGGER filetags_linklog_insert AFTER INSERT ON filetags
+BEGIN
+	INSERT INTO linklog(at, fileid, tagid, value, expires)
+	VALUES(unixepoch(), NEW.fileid, NEW.tagid, NEW.value, NEW.expires);
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER filetags_linklog_update AFTER UPDATE ON filetags
+WHEN OLD.fileid != NEW.fileid OR OLD.tagid != NEW.tagid
+	OR OLD.value IS NOT NEW.value OR OLD.expires IS NOT NEW.expires
+BEGIN
+	INSERT INTO linklog(at, fileid, tagid, removed)
+	SELECT unixepoch(), OLD.fileid, OLD.tagid, 1
+	WHERE OLD.fileid != NEW.fileid OR OLD.tagid != NEW.tagid;
+	INSERT INTO linklog(at, fileid, tagid, value, expires)
+	VALUES(unixepoch(), NEW.fileid, NEW.tagid, NEW.value, NEW.expires);
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER filetags_linklog_delete AFTER DELETE ON filetags
+BEGIN
+	INSERT INTO linklog(at, fileid, tagid, removed)
+	VALUES(unixepoch(), OLD.fileid, OLD.tagid, 1);
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER linklog_snapshot AFTER INSERT ON linklog
+WHEN NEW.id >= COALESCE((SELECT MAX(logid) FROM linksnapshots), 0) + 1000
+BEGIN
+	INSERT INTO linksnapshots(at, logid) VALUES(NEW.at, NEW.id);
+	INSERT INTO linksnapshotrows(snapshotid, fileid, tagid, value, expires)
+	SELECT (SELECT MAX(id) FROM linksnapshots), fileid, tagid, value, expires FROM filetags
+	WHERE expires IS NULL OR expires > NEW.at;
+END;
+-- +goose StatementEnd
+-- +goose Down
+DROP TRIGGER linklog_snapshot;
+DROP TRIGGER filetags_linklog_delete;
+DROP TRIGGER filetags_linklog_update;
+DROP TRIGGER filetags_linklog_insert;
+DROP TABLE linksnapshotrows;
+DROP TABLE linksnapshots;
+DROP TABLE linklog;
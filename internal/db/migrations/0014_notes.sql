@@ -0,0 +1,45 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS notes(
+	id INTEGER PRIMARY KEY,
+	fileid INTEGER NOT NULL,
+	tagid INTEGER,
+	text TEXT NOT NULL,
+	created INTEGER,
+	updated INTEGER,
+	FOREIGN KEY(fileid) REFERENCES files(id) ON DELETE CASCADE,
+	FOREIGN KEY(fileid, tagid) REFERENCES filetags(fileid, tagid) ON DELETE CASCADE
+);
+
+-- a file has one note of its own and one for each of its links
+CREATE UNIQUE INDEX notes_link ON notes(fileid, COALESCE(tagid, 0));
+CREATE INDEX notes_tagid ON notes(tagid) WHERE tagid IS NOT NULL;
+
+-- +goose StatementBegin
+CREATE TRIGGER notes_journal_insert AFTER INSERT ON notes
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'notes', NULL, json_object('id', NEW.id, 'fileid', NEW.fileid, 'tagid', NEW.tagid, 'text', NEW.text, 'created', NEW.created, 'updated', NEW.updated) FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER notes_journal_update AFTER UPDATE ON notes
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'notes', json_object('id', OLD.id, 'fileid', OLD.fileid, 'tagid', OLD.tagid, 'text', OLD.text, 'created', OLD.created, 'updated', OLD.updated), json_object('id', NEW.id, 'fileid', NEW.fileid, 'tagid', NEW.tagid, 'text', NEW.text, 'created', NEW.created, 'updated', NEW.updated) FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER notes_journal_delete AFTER DELETE ON notes
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'notes', json_object('id', OLD.id, 'fileid', OLD.fileid, 'tagid', OLD.tagid, 'text', OLD.text, 'created', OLD.created, 'updated', OLD.updated), NULL FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose Down
+DROP TRIGGER notes_journal_delete;
+DROP TRIGGER notes_journal_update;
+DROP TRIGGER notes_journal_insert;
+DROP TABLE notes;
@@ -0,0 +1,13 @@
+-- +goose Up
+-- the text extracted from files which have opted in to content search, along
+-- with the hash the file had when it was extracted
+CREATE TABLE IF NOT EXISTS filecontents(
+	fileid INTEGER PRIMARY KEY,
+	hash TEXT NOT NULL,
+	text TEXT NOT NULL,
+	extracted INTEGER NOT NULL,
+	FOREIGN KEY(fileid) REFERENCES files(id) ON DELETE CASCADE
+);
+
+-- +goose Down
+DROP TABLE filecontents;
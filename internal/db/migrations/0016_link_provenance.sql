@@ -0,0 +1,85 @@
+-- +goose Up
+-- existing links become manual, including ones materialized by implication
+-- rules, since there's no telling those apart from links a person added
+ALTER TABLE filetags ADD COLUMN source TEXT NOT NULL DEFAULT 'manual';
+ALTER TABLE filetags ADD COLUMN confidence REAL;
+CREATE INDEX filetags_source ON filetags(source);
+DROP VIEW livefiletags;
+CREATE VIEW livefiletags AS
+	SELECT fileid, tagid, value, expires, created, updated, source, confidence FROM filetags
+	WHERE expires IS NULL OR expires > unixepoch();
+
+-- links journaled before they had a source were all made by hand or by
+-- implication rules, which there's no telling apart, so they can be undone
+-- and redone as manual links
+UPDATE journal SET before = json_set(before, '$.source', 'manual', '$.confidence', NULL)
+WHERE tablename = 'filetags' AND before IS NOT NULL;
+UPDATE journal SET after = json_set(after, '$.source', 'manual', '$.confidence', NULL)
+WHERE tablename = 'filetags' AND after IS NOT NULL;
+
+DROP TRIGGER filetags_journal_insert;
+DROP TRIGGER filetags_journal_update;
+DROP TRIGGER filetags_journal_delete;
+-- +goose StatementBegin
+CREATE TRIGGER filetags_journal_insert AFTER INSERT ON filetags
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'filetags', NULL, json_object('fileid', NEW.fileid, 'tagid', NEW.tagid, 'value', NEW.value, 'expires', NEW.expires, 'created', NEW.created, 'updated', NEW.updated, 'source', NEW.source, 'confidence', NEW.confidence) FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER filetags_journal_update AFTER UPDATE ON filetags
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'filetags', json_object('fileid', OLD.fileid, 'tagid', OLD.tagid, 'value', OLD.value, 'expires', OLD.expires, 'created', OLD.created, 'updated', OLD.updated, 'source', OLD.source, 'confidence', OLD.confidence), json_object('fileid', NEW.fileid, 'tagid', NEW.tagid, 'value', NEW.value, 'expires', NEW.expires, 'created', NEW.created, 'updated', NEW.updated, 'source', NEW.source, 'confidence', NEW.confidence) FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER filetags_journal_delete AFTER DELETE ON filetags
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'filetags', json_object('fileid', OLD.fileid, 'tagid', OLD.tagid, 'value', OLD.value, 'expires', OLD.expires, 'created', OLD.created, 'updated', OLD.updated, 'source', OLD.source, 'confidence', OLD.confidence), NULL FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose Down
+DROP TRIGGER filetags_journal_insert;
+DROP TRIGGER filetags_journal_update;
+DROP TRIGGER filetags_journal_delete;
+DROP VIEW livefiletags;
+DROP INDEX filetags_source;
+ALTER TABLE filetags DROP COLUMN confidence;
+ALTER TABLE filetags DROP COLUMN source;
+UPDATE journal SET before = json_remove(before, '$.source', '$.confidence')
+WHERE tablename = 'filetags' AND before IS NOT NULL;
+UPDATE journal SET after = json_remove(after, '$.source', '$.confidence')
+WHERE tablename = 'filetags' AND after IS NOT NULL;
+CREATE VIEW livefiletags AS
+	SELECT fileid, tagid, value, expires, created, updated FROM filetags
+	WHERE expires IS NULL OR expires > unixepoch();
+-- +goose StatementBegin
+CREATE TRIGGER filetags_journal_insert AFTER INSERT ON filetags
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'filetags', NULL, json_object('fileid', NEW.fileid, 'tagid', NEW.tagid, 'value', NEW.value, 'expires', NEW.expires, 'created', NEW.created, 'updated', NEW.updated) FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER filetags_journal_update AFTER UPDATE ON filetags
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'filetags', json_object('fileid', OLD.fileid, 'tagid', OLD.tagid, 'value', OLD.value, 'expires', OLD.expires, 'created', OLD.created, 'updated', OLD.updated), json_object('fileid', NEW.fileid, 'tagid', NEW.tagid, 'value', NEW.value, 'expires', NEW.expires, 'created', NEW.created, 'updated', NEW.updated) FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER filetags_journal_delete AFTER DELETE ON filetags
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'filetags', json_object('fileid', OLD.fileid, 'tagid', OLD.tagid, 'value', OLD.value, 'expires', OLD.expires, 'created', OLD.created, 'updated', OLD.updated), NULL FROM journalstate;
+END;
+-- +goose StatementEnd
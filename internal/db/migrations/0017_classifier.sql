@@ -0,0 +1,33 @@
+-- +goose Up
+-- the counts behind the model "fstagger suggest --model" predicts tags with,
+-- which is replaced as a whole every time it's trained
+CREATE TABLE IF NOT EXISTS classifier(
+	id INTEGER PRIMARY KEY CHECK(id = 1),
+	documents INTEGER NOT NULL,
+	trained INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS classifiertags(
+	tagid INTEGER PRIMARY KEY,
+	documents INTEGER NOT NULL,
+	FOREIGN KEY(tagid) REFERENCES tags(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS classifierfeatures(
+	feature TEXT PRIMARY KEY,
+	documents INTEGER NOT NULL
+) WITHOUT ROWID;
+
+CREATE TABLE IF NOT EXISTS classifiercounts(
+	tagid INTEGER NOT NULL,
+	feature TEXT NOT NULL,
+	documents INTEGER NOT NULL,
+	PRIMARY KEY(tagid, feature),
+	FOREIGN KEY(tagid) REFERENCES classifiertags(tagid) ON DELETE CASCADE
+) WITHOUT ROWID;
+
+-- +goose Down
+DROP TABLE classifiercounts;
+DROP TABLE classifierfeatures;
+DROP TABLE classifiertags;
+DROP TABLE classifier;
@@ -0,0 +1,306 @@
+-- +goose Up
+-- the journal triggers are created by each of fstagger's connections so that
+-- they journal into the operation its own TagDB has open, see ADR-020
+DROP TRIGGER main.files_journal_insert;
+DROP TRIGGER main.files_journal_update;
+DROP TRIGGER main.files_journal_delete;
+DROP TRIGGER main.tags_journal_insert;
+DROP TRIGGER main.tags_journal_update;
+DROP TRIGGER main.tags_journal_delete;
+DROP TRIGGER main.tagaliases_journal_insert;
+DROP TRIGGER main.tagaliases_journal_update;
+DROP TRIGGER main.tagaliases_journal_delete;
+DROP TRIGGER main.tagimplications_journal_insert;
+DROP TRIGGER main.tagimplications_journal_update;
+DROP TRIGGER main.tagimplications_journal_delete;
+DROP TRIGGER main.smarttags_journal_insert;
+DROP TRIGGER main.smarttags_journal_update;
+DROP TRIGGER main.smarttags_journal_delete;
+DROP TRIGGER main.taggroups_journal_insert;
+DROP TRIGGER main.taggroups_journal_update;
+DROP TRIGGER main.taggroups_journal_delete;
+DROP TRIGGER main.taggroupmembers_journal_insert;
+DROP TRIGGER main.taggroupmembers_journal_update;
+DROP TRIGGER main.taggroupmembers_journal_delete;
+DROP TRIGGER main.tagvaluetypes_journal_insert;
+DROP TRIGGER main.tagvaluetypes_journal_update;
+DROP TRIGGER main.tagvaluetypes_journal_delete;
+DROP TRIGGER main.tagenumvalues_journal_insert;
+DROP TRIGGER main.tagenumvalues_journal_update;
+DROP TRIGGER main.tagenumvalues_journal_delete;
+DROP TRIGGER main.notes_journal_insert;
+DROP TRIGGER main.notes_journal_update;
+DROP TRIGGER main.notes_journal_delete;
+DROP TRIGGER main.filetags_journal_insert;
+DROP TRIGGER main.filetags_journal_update;
+DROP TRIGGER main.filetags_journal_delete;
+DROP TABLE journalstate;
+
+-- +goose Down
+CREATE TABLE IF NOT EXISTS journalstate(
+	operationid INTEGER NOT NULL REFERENCES operations(id) ON DELETE CASCADE
+);
+-- +goose StatementBegin
+CREATE TRIGGER files_journal_insert AFTER INSERT ON files
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'files', NULL, json_object('id', NEW.id, 'path', NEW.path, 'hash', NEW.hash, 'created', NEW.created, 'updated', NEW.updated) FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER files_journal_update AFTER UPDATE ON files
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'files', json_object('id', OLD.id, 'path', OLD.path, 'hash', OLD.hash, 'created', OLD.created, 'updated', OLD.updated), json_object('id', NEW.id, 'path', NEW.path, 'hash', NEW.hash, 'created', NEW.created, 'updated', NEW.updated) FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER files_journal_delete AFTER DELETE ON files
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'files', json_object('id', OLD.id, 'path', OLD.path, 'hash', OLD.hash, 'created', OLD.created, 'updated', OLD.updated), NULL FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER tags_journal_insert AFTER INSERT ON tags
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'tags', NULL, json_object('id', NEW.id, 'namespace', NEW.namespace, 'name', NEW.name, 'description', NEW.description, 'parent', NEW.parent, 'created', NEW.created, 'updated', NEW.updated) FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER tags_journal_update AFTER UPDATE ON tags
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'tags', json_object('id', OLD.id, 'namespace', OLD.namespace, 'name', OLD.name, 'description', OLD.description, 'parent', OLD.parent, 'created', OLD.created, 'updated', OLD.updated), json_object('id', NEW.id, 'namespace', NEW.namespace, 'name', NEW.name, 'description', NEW.description, 'parent', NEW.parent, 'created', NEW.created, 'updated', NEW.updated) FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER tags_journal_delete AFTER DELETE ON tags
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'tags', json_object('id', OLD.id, 'namespace', OLD.namespace, 'name', OLD.name, 'description', OLD.description, 'parent', OLD.parent, 'created', OLD.created, 'updated', OLD.updated), NULL FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER tagaliases_journal_insert AFTER INSERT ON tagaliases
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'tagaliases', NULL, json_object('namespace', NEW.namespace, 'name', NEW.name, 'tagid', NEW.tagid) FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER tagaliases_journal_update AFTER UPDATE ON tagaliases
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'tagaliases', json_object('namespace', OLD.namespace, 'name', OLD.name, 'tagid', OLD.tagid), json_object('namespace', NEW.namespace, 'name', NEW.name, 'tagid', NEW.tagid) FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER tagaliases_journal_delete AFTER DELETE ON tagaliases
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'tagaliases', json_object('namespace', OLD.namespace, 'name', OLD.name, 'tagid', OLD.tagid), NULL FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER tagimplications_journal_insert AFTER INSERT ON tagimplications
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'tagimplications', NULL, json_object('tagid', NEW.tagid, 'impliedid', NEW.impliedid) FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER tagimplications_journal_update AFTER UPDATE ON tagimplications
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'tagimplications', json_object('tagid', OLD.tagid, 'impliedid', OLD.impliedid), json_object('tagid', NEW.tagid, 'impliedid', NEW.impliedid) FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER tagimplications_journal_delete AFTER DELETE ON tagimplications
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'tagimplications', json_object('tagid', OLD.tagid, 'impliedid', OLD.impliedid), NULL FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER smarttags_journal_insert AFTER INSERT ON smarttags
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'smarttags', NULL, json_object('id', NEW.id, 'namespace', NEW.namespace, 'name', NEW.name, 'query', NEW.query, 'description', NEW.description) FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER smarttags_journal_update AFTER UPDATE ON smarttags
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'smarttags', json_object('id', OLD.id, 'namespace', OLD.namespace, 'name', OLD.name, 'query', OLD.query, 'description', OLD.description), json_object('id', NEW.id, 'namespace', NEW.namespace, 'name', NEW.name, 'query', NEW.query, 'description', NEW.description) FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER smarttags_journal_delete AFTER DELETE ON smarttags
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'smarttags', json_object('id', OLD.id, 'namespace', OLD.namespace, 'name', OLD.name, 'query', OLD.query, 'description', OLD.description), NULL FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER taggroups_journal_insert AFTER INSERT ON taggroups
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'taggroups', NULL, json_object('id', NEW.id, 'name', NEW.name, 'description', NEW.description, 'exclusive', NEW.exclusive, 'policy', NEW.policy) FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER taggroups_journal_update AFTER UPDATE ON taggroups
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'taggroups', json_object('id', OLD.id, 'name', OLD.name, 'description', OLD.description, 'exclusive', OLD.exclusive, 'policy', OLD.policy), json_object('id', NEW.id, 'name', NEW.name, 'description', NEW.description, 'exclusive', NEW.exclusive, 'policy', NEW.policy) FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER taggroups_journal_delete AFTER DELETE ON taggroups
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'taggroups', json_object('id', OLD.id, 'name', OLD.name, 'description', OLD.description, 'exclusive', OLD.exclusive, 'policy', OLD.policy), NULL FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER taggroupmembers_journal_insert AFTER INSERT ON taggroupmembers
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'taggroupmembers', NULL, json_object('groupid', NEW.groupid, 'tagid', NEW.tagid) FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER taggroupmembers_journal_update AFTER UPDATE ON taggroupmembers
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'taggroupmembers', json_object('groupid', OLD.groupid, 'tagid', OLD.tagid), json_object('groupid', NEW.groupid, 'tagid', NEW.tagid) FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER taggroupmembers_journal_delete AFTER DELETE ON taggroupmembers
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'taggroupmembers', json_object('groupid', OLD.groupid, 'tagid', OLD.tagid), NULL FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER tagvaluetypes_journal_insert AFTER INSERT ON tagvaluetypes
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'tagvaluetypes', NULL, json_object('tagid', NEW.tagid, 'type', NEW.type) FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER tagvaluetypes_journal_update AFTER UPDATE ON tagvaluetypes
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'tagvaluetypes', json_object('tagid', OLD.tagid, 'type', OLD.type), json_object('tagid', NEW.tagid, 'type', NEW.type) FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER tagvaluetypes_journal_delete AFTER DELETE ON tagvaluetypes
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'tagvaluetypes', json_object('tagid', OLD.tagid, 'type', OLD.type), NULL FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER tagenumvalues_journal_insert AFTER INSERT ON tagenumvalues
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'tagenumvalues', NULL, json_object('tagid', NEW.tagid, 'value', NEW.value, 'position', NEW.position) FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER tagenumvalues_journal_update AFTER UPDATE ON tagenumvalues
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'tagenumvalues', json_object('tagid', OLD.tagid, 'value', OLD.value, 'position', OLD.position), json_object('tagid', NEW.tagid, 'value', NEW.value, 'position', NEW.position) FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER tagenumvalues_journal_delete AFTER DELETE ON tagenumvalues
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'tagenumvalues', json_object('tagid', OLD.tagid, 'value', OLD.value, 'position', OLD.position), NULL FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER notes_journal_insert AFTER INSERT ON notes
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'notes', NULL, json_object('id', NEW.id, 'fileid', NEW.fileid, 'tagid', NEW.tagid, 'text', NEW.text, 'created', NEW.created, 'updated', NEW.updated) FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER notes_journal_update AFTER UPDATE ON notes
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'notes', json_object('id', OLD.id, 'fileid', OLD.fileid, 'tagid', OLD.tagid, 'text', OLD.text, 'created', OLD.created, 'updated', OLD.updated), json_object('id', NEW.id, 'fileid', NEW.fileid, 'tagid', NEW.tagid, 'text', NEW.text, 'created', NEW.created, 'updated', NEW.updated) FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER notes_journal_delete AFTER DELETE ON notes
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'notes', json_object('id', OLD.id, 'fileid', OLD.fileid, 'tagid', OLD.tagid, 'text', OLD.text, 'created', OLD.created, 'updated', OLD.updated), NULL FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER filetags_journal_insert AFTER INSERT ON filetags
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'filetags', NULL, json_object('fileid', NEW.fileid, 'tagid', NEW.tagid, 'value', NEW.value, 'expires', NEW.expires, 'created', NEW.created, 'updated', NEW.updated, 'source', NEW.source, 'confidence', NEW.confidence) FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER filetags_journal_update AFTER UPDATE ON filetags
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'filetags', json_object('fileid', OLD.fileid, 'tagid', OLD.tagid, 'value', OLD.value, 'expires', OLD.expires, 'created', OLD.created, 'updated', OLD.updated, 'source', OLD.source, 'confidence', OLD.confidence), json_object('fileid', NEW.fileid, 'tagid', NEW.tagid, 'value', NEW.value, 'expires', NEW.expires, 'created', NEW.created, 'updated', NEW.updated, 'source', NEW.source, 'confidence', NEW.confidence) FROM journalstate;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER filetags_journal_delete AFTER DELETE ON filetags
+WHEN EXISTS (SELECT 1 FROM journalstate)
+BEGIN
+	INSERT INTO journal(operationid, tablename, before, after)
+	SELECT operationid, 'filetags', json_object('fileid', OLD.fileid, 'tagid', OLD.tagid, 'value', OLD.value, 'expires', OLD.expires, 'created', OLD.created, 'updated', OLD.updated, 'source', OLD.source, 'confidence', OLD.confidence), NULL FROM journalstate;
+END;
+-- +goose StatementEnd
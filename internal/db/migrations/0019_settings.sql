@@ -0,0 +1,10 @@
+-- +goose Up
+-- settings which belong to the database rather than to whoever is running a
+-- command, like how implication rules are applied
+CREATE TABLE IF NOT EXISTS settings(
+	name TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+) WITHOUT ROWID;
+
+-- +goose Down
+DROP TABLE settings;
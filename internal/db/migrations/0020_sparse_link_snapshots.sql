@@ -0,0 +1,41 @@
+-- +goose Up
+-- snapshots record how many links they hold and the next one isn't taken until
+-- at least that many more changes have been logged, so the copies made of the
+-- links never add up to more than about twice the size of the log
+ALTER TABLE linksnapshots ADD COLUMN links INTEGER NOT NULL DEFAULT 0;
+
+UPDATE linksnapshots
+SET links = (SELECT COUNT(*) FROM linksnapshotrows WHERE snapshotid = linksnapshots.id);
+
+DROP TRIGGER linklog_snapshot;
+
+-- +goose StatementBegin
+CREATE TRIGGER linklog_snapshot AFTER INSERT ON linklog
+WHEN NEW.id >= COALESCE(
+	(SELECT logid + MAX(links, 1000) FROM linksnapshots ORDER BY id DESC LIMIT 1),
+	1000
+)
+BEGIN
+	INSERT INTO linksnapshots(at, logid, links) VALUES(NEW.at, NEW.id, 0);
+	INSERT INTO linksnapshotrows(snapshotid, fileid, tagid, value, expires)
+	SELECT (SELECT MAX(id) FROM linksnapshots), fileid, tagid, value, expires FROM filetags
+	WHERE expires IS NULL OR expires > NEW.at;
+	UPDATE linksnapshots SET links = changes() WHERE id = (SELECT MAX(id) FROM linksnapshots);
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TRIGGER linklog_snapshot;
+
+-- +goose StatementBegin
+CREATE TRIGGER linklog_snapshot AFTER INSERT ON linklog
+WHEN NEW.id >= COALESCE((SELECT MAX(logid) FROM linksnapshots), 0) + 1000
+BEGIN
+	INSERT INTO linksnapshots(at, logid) VALUES(NEW.at, NEW.id);
+	INSERT INTO linksnapshotrows(snapshotid, fileid, tagid, value, expires)
+	SELECT (SELECT MAX(id) FROM linksnapshots), fileid, tagid, value, expires FROM filetags
+	WHERE expires IS NULL OR expires > NEW.at;
+END;
+-- +goose StatementEnd
+
+ALTER TABLE linksnapshots DROP COLUMN links;
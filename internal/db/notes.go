@@ -0,0 +1,251 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/whatsfordinner/fstagger/internal/files"
+	"github.com/whatsfordinner/fstagger/internal/history"
+	"github.com/whatsfordinner/fstagger/internal/notes"
+
+	"go.opentelemetry.io/otel/codes"
+)
+
+// SetNotes takes a slice of notes and writes each one, replacing the note the
+// file or link already has if there is one. A note about a link needs the file
+// to have the tag and a note about a file needs the file to be tracked, and
+// it's an error wrapping sql.ErrNoRows otherwise. A note can't be blank. Each
+// note is written or not independently of the others.
+func (tagDB *TagDB) SetNotes(ctx context.Context, newNotes []notes.Note) ([]notes.Note, error) {
+	const (
+		fileString   = "SELECT 1 FROM files WHERE id = ?"
+		linkString   = "SELECT 1 FROM livefiletags WHERE fileid = ? AND tagid = ?"
+		updateString = "UPDATE notes SET text = ?, updated = unixepoch() WHERE fileid = ? AND tagid IS ?"
+		insertString = `INSERT INTO notes(fileid, tagid, text, created, updated)
+			VALUES(?, ?, ?, unixepoch(), unixepoch())`
+	)
+
+	ctx, span := tracer.Start(ctx, "SetNotes")
+	defer span.End()
+
+	tx, err := tagDB.client.BeginTx(ctx, nil)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return []notes.Note{}, err
+	}
+	txErrors := &BatchError{}
+
+	setNote := func(note notes.Note) error {
+		if strings.TrimSpace(note.Text) == "" {
+			return errors.New("note can't be blank")
+		}
+
+		var exists int
+		if note.Tag == 0 {
+			if err := tx.QueryRowContext(ctx, fileString, note.File).Scan(&exists); err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					return fmt.Errorf("file does not exist with id: %d: %w", note.File, err)
+				}
+				return err
+			}
+		} else {
+			if err := tx.QueryRowContext(ctx, linkString, note.File, note.Tag).Scan(&exists); err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					return fmt.Errorf(
+						"%s doesn't have tag %s: %w",
+						filePath(ctx, tx, note.File),
+						tagName(ctx, tx, note.Tag),
+						err,
+					)
+				}
+				return err
+			}
+		}
+
+		res, err := tx.ExecContext(ctx, updateString, note.Text, note.File, noteTag(note))
+		if err != nil {
+			return err
+		}
+
+		if updated, err := res.RowsAffected(); err != nil {
+			return err
+		} else if updated == 0 {
+			if _, err := tx.ExecContext(ctx, insertString, note.File, noteTag(note), note.Text); err != nil {
+				return err
+			}
+		}
+
+		return tagDB.recordHistory(ctx, tx, history.NoteSet, note.File, note.Tag, note.Text)
+	}
+
+	setNotes := []notes.Note{}
+	for i, note := range newNotes {
+		span.AddEvent(fmt.Sprintf("setting note on file ID %d and tag ID %d", note.File, note.Tag))
+
+		if err := setNote(note); err != nil {
+			txErrors.add(i, err)
+			continue
+		}
+
+		setNotes = append(setNotes, note)
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			err = errors.Join(err, rollbackErr)
+		}
+		span.SetStatus(codes.Error, err.Error())
+		return []notes.Note{}, err
+	}
+
+	if len(txErrors.Errors) > 0 {
+		span.SetStatus(codes.Error, "encountered DB errors during transaction")
+	} else {
+		span.SetStatus(codes.Ok, "")
+	}
+
+	return setNotes, txErrors.errOrNil()
+}
+
+// DeleteNotes takes a slice of notes and removes the note from each file or
+// link. Only the file and tag IDs are used. A file or link without a note is
+// an error wrapping sql.ErrNoRows.
+func (tagDB *TagDB) DeleteNotes(ctx context.Context, deleteNotes []notes.Note) error {
+	const (
+		deleteString = "DELETE FROM notes WHERE fileid = ? AND tagid IS ?"
+	)
+
+	ctx, span := tracer.Start(ctx, "DeleteNotes")
+	defer span.End()
+
+	tx, err := tagDB.client.BeginTx(ctx, nil)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	txErrors := &BatchError{}
+
+	for i, note := range deleteNotes {
+		span.AddEvent(fmt.Sprintf("removing note from file ID %d and tag ID %d", note.File, note.Tag))
+
+		res, err := tx.ExecContext(ctx, deleteString, note.File, noteTag(note))
+		if err != nil {
+			txErrors.add(i, err)
+			continue
+		}
+
+		if deleted, err := res.RowsAffected(); err != nil {
+			txErrors.add(i, err)
+			continue
+		} else if deleted == 0 {
+			about := filePath(ctx, tx, note.File)
+			if note.Tag != 0 {
+				about += " and tag " + tagName(ctx, tx, note.Tag)
+			}
+			txErrors.add(i, fmt.Errorf("no note on %s: %w", about, sql.ErrNoRows))
+			continue
+		}
+
+		if err := tagDB.recordHistory(ctx, tx, history.NoteRemoved, note.File, note.Tag, ""); err != nil {
+			txErrors.add(i, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			err = errors.Join(err, rollbackErr)
+		}
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	if len(txErrors.Errors) > 0 {
+		span.SetStatus(codes.Error, "encountered DB errors during transaction")
+	} else {
+		span.SetStatus(codes.Ok, "")
+	}
+
+	return txErrors.errOrNil()
+}
+
+// GetNotesForFile returns the note about a file followed by the notes about
+// its links, ordered by tag ID. Only the file's ID is used for the search.
+func (tagDB *TagDB) GetNotesForFile(ctx context.Context, file files.File) ([]notes.Note, error) {
+	ctx, span := tracer.Start(ctx, "GetNotesForFile")
+	defer span.End()
+
+	ret, err := getNotes(ctx, tagDB.client, "n.fileid = ?", file.Id)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+// SearchNotes returns every note containing search, ignoring case, ordered
+// by the path of its file and then by tag ID with the note about the file
+// first. An empty search returns every note.
+func (tagDB *TagDB) SearchNotes(ctx context.Context, search string) ([]notes.Note, error) {
+	ctx, span := tracer.Start(ctx, "SearchNotes")
+	defer span.End()
+
+	ret, err := getNotes(ctx, tagDB.client, `n.text LIKE ? ESCAPE '\'`, containsPattern(search))
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+// noteTag returns the tag a note is about as it's stored.
+func noteTag(note notes.Note) any {
+	if note.Tag == 0 {
+		return nil
+	}
+
+	return note.Tag
+}
+
+// containsPattern builds a LIKE pattern, escaped with \, which matches text
+// containing search.
+func containsPattern(search string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(search)
+	return "%" + escaped + "%"
+}
+
+// getNotes returns the notes matching a condition on the notes table, aliased
+// as n. Notes about links which have expired are left out.
+func getNotes(ctx context.Context, q querier, condition string, args ...any) ([]notes.Note, error) {
+	const (
+		searchString = `SELECT n.fileid, COALESCE(n.tagid, 0), n.text FROM notes n
+			JOIN files f ON f.id = n.fileid
+			WHERE %s AND (n.tagid IS NULL OR EXISTS (
+				SELECT 1 FROM livefiletags ft WHERE ft.fileid = n.fileid AND ft.tagid = n.tagid
+			))
+			ORDER BY f.path, COALESCE(n.tagid, 0)`
+	)
+
+	rows, err := q.QueryContext(ctx, fmt.Sprintf(searchString, condition), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := []notes.Note{}
+	for rows.Next() {
+		note := notes.Note{}
+		if err := rows.Scan(&note.File, &note.Tag, &note.Text); err != nil {
+			return nil, err
+		}
+		ret = append(ret, note)
+	}
+
+	return ret, rows.Err()
+}
@@ -0,0 +1,274 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/whatsfordinner/fstagger/internal/files"
+	"github.com/whatsfordinner/fstagger/internal/links"
+	"github.com/whatsfordinner/fstagger/internal/notes"
+	"github.com/whatsfordinner/fstagger/internal/tags"
+)
+
+func TestTagDBSetNotes(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr bool
+		input     []notes.Note
+		expect    []notes.Note
+	}{
+		"adding a note to a link": {
+			false,
+			[]notes.Note{{File: 1, Tag: 2, Text: "signed 2025-03-01"}},
+			[]notes.Note{
+				{File: 1, Text: "Final cut approved by client"},
+				{File: 1, Tag: 1, Text: "tagged because of clause 4"},
+				{File: 1, Tag: 2, Text: "signed 2025-03-01"},
+			},
+		},
+		"replacing notes": {
+			false,
+			[]notes.Note{{File: 1, Text: "recut"}, {File: 1, Tag: 1, Text: "clause 5"}},
+			[]notes.Note{{File: 1, Text: "recut"}, {File: 1, Tag: 1, Text: "clause 5"}},
+		},
+		"adding a note to a link that doesn't exist": {
+			true,
+			[]notes.Note{{File: 1, Tag: 3, Text: "nope"}, {File: 1, Tag: 2, Text: "signed"}},
+			[]notes.Note{
+				{File: 1, Text: "Final cut approved by client"},
+				{File: 1, Tag: 1, Text: "tagged because of clause 4"},
+				{File: 1, Tag: 2, Text: "signed"},
+			},
+		},
+		"adding a note to a file that doesn't exist": {
+			true,
+			[]notes.Note{{File: 3, Text: "nope"}},
+			[]notes.Note{
+				{File: 1, Text: "Final cut approved by client"},
+				{File: 1, Tag: 1, Text: "tagged because of clause 4"},
+			},
+		},
+		"adding a blank note": {
+			true,
+			[]notes.Note{{File: 1, Text: "  "}},
+			[]notes.Note{
+				{File: 1, Text: "Final cut approved by client"},
+				{File: 1, Tag: 1, Text: "tagged because of clause 4"},
+			},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/notes.yml"})
+			defer teardown()
+
+			_, err := testDB.SetNotes(context.Background(), testData.input)
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			res, err := testDB.GetNotesForFile(context.Background(), files.File{Id: 1})
+			if err != nil {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBDeleteNotes(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr bool
+		input     []notes.Note
+		expect    []notes.Note
+	}{
+		"removing a file's note": {
+			false,
+			[]notes.Note{{File: 1}},
+			[]notes.Note{{File: 1, Tag: 1, Text: "tagged because of clause 4"}},
+		},
+		"removing a link's note": {
+			false,
+			[]notes.Note{{File: 1, Tag: 1}},
+			[]notes.Note{{File: 1, Text: "Final cut approved by client"}},
+		},
+		"removing a note that doesn't exist": {
+			true,
+			[]notes.Note{{File: 1, Tag: 2}, {File: 1}},
+			[]notes.Note{{File: 1, Tag: 1, Text: "tagged because of clause 4"}},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/notes.yml"})
+			defer teardown()
+
+			err := testDB.DeleteNotes(context.Background(), testData.input)
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			res, err := testDB.GetNotesForFile(context.Background(), files.File{Id: 1})
+			if err != nil {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBSearchNotes(t *testing.T) {
+	testMap := map[string]struct {
+		input  string
+		expect []notes.Note
+	}{
+		"everything": {
+			"",
+			[]notes.Note{
+				{File: 1, Text: "Final cut approved by client"},
+				{File: 1, Tag: 1, Text: "tagged because of clause 4"},
+			},
+		},
+		"ignoring case": {
+			"CLIENT",
+			[]notes.Note{{File: 1, Text: "Final cut approved by client"}},
+		},
+		"nothing matching":       {"indemnity", []notes.Note{}},
+		"wildcards are literal":  {"clause_4%", []notes.Note{}},
+		"notes on expired links": {"expired", []notes.Note{}},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/notes.yml"})
+			defer teardown()
+
+			res, err := testDB.SearchNotes(context.Background(), testData.input)
+			if err != nil {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+// TestTagDBNotesFollowLinks checks that a link's note goes when the link is
+// removed and moves when its tag is merged into another.
+func TestTagDBNotesFollowLinks(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/notes.yml"})
+	defer teardown()
+
+	ctx := context.Background()
+	if _, err := testDB.MergeTags(ctx, []tags.Tag{{Id: 1}}, tags.Tag{Id: 2}); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	res, err := testDB.SearchNotes(ctx, "")
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	expect := []notes.Note{
+		{File: 1, Text: "Final cut approved by client"},
+		{File: 1, Tag: 2, Text: "tagged because of clause 4"},
+	}
+	if !reflect.DeepEqual(res, expect) {
+		t.Fatalf("Result did not match expectation\nResult: %+v\nExpected: %+v", res, expect)
+	}
+
+	if err := testDB.DeleteLinks(ctx, []links.Link{{File: 1, Tag: 2}}); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	res, err = testDB.SearchNotes(ctx, "")
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	expect = []notes.Note{{File: 1, Text: "Final cut approved by client"}}
+	if !reflect.DeepEqual(res, expect) {
+		t.Fatalf("Result did not match expectation\nResult: %+v\nExpected: %+v", res, expect)
+	}
+}
+
+// TestTagDBMergeTagsJoinsNotes checks that a file with notes on both of the
+// merged links keeps the text of both.
+func TestTagDBMergeTagsJoinsNotes(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/notes.yml"})
+	defer teardown()
+
+	ctx := context.Background()
+	if _, err := testDB.SetNotes(ctx, []notes.Note{{File: 1, Tag: 2, Text: "signed 2025-03-01"}}); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	if _, err := testDB.MergeTags(ctx, []tags.Tag{{Id: 1}}, tags.Tag{Id: 2}); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	res, err := testDB.GetNotesForFile(ctx, files.File{Id: 1})
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	expect := []notes.Note{
+		{File: 1, Text: "Final cut approved by client"},
+		{File: 1, Tag: 2, Text: "signed 2025-03-01\n\ntagged because of clause 4"},
+	}
+	if !reflect.DeepEqual(res, expect) {
+		t.Fatalf("Result did not match expectation\nResult: %+v\nExpected: %+v", res, expect)
+	}
+}
+
+// TestTagDBNotesErrors checks that errors name the file and tag involved
+// rather than their IDs.
+func TestTagDBNotesErrors(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/notes.yml"})
+	defer teardown()
+
+	ctx := context.Background()
+	_, err := testDB.SetNotes(ctx, []notes.Note{{File: 1, Tag: 3, Text: "nope"}})
+	expect := "/path/to/foo doesn't have tag inbox: sql: no rows in result set"
+	if err == nil || err.Error() != expect {
+		t.Fatalf("Result did not match expectation\nResult: %v\nExpected: %s", err, expect)
+	}
+
+	err = testDB.DeleteNotes(ctx, []notes.Note{{File: 2}})
+	expect = "no note on /path/to/bar: sql: no rows in result set"
+	if err == nil || err.Error() != expect {
+		t.Fatalf("Result did not match expectation\nResult: %v\nExpected: %s", err, expect)
+	}
+}
@@ -0,0 +1,260 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/whatsfordinner/fstagger/internal/files"
+	"github.com/whatsfordinner/fstagger/internal/query"
+	"github.com/whatsfordinner/fstagger/internal/smarttags"
+	"github.com/whatsfordinner/fstagger/internal/tags"
+	"github.com/whatsfordinner/fstagger/internal/values"
+
+	"go.opentelemetry.io/otel/codes"
+)
+
+// queryCompiler turns a query expression into a condition on the files table,
+// aliased as f. Smart tags are expanded into their own queries as they're
+// found so it has to know every smart tag up front, and comparisons are
+// checked against the declared type of their tag so it has to know those too.
+// Links are matched against the links table, which is livefiletags unless the
+// query is about the past.
+type queryCompiler struct {
+	links        string
+	smartTags    map[string]smarttags.SmartTag
+	declarations map[string]values.Declaration
+	implying     string
+	visiting     map[string]bool
+}
+
+// newQueryCompiler loads every smart tag so their queries can be expanded and
+// the value type of every tag, by name and by alias, so comparisons can be
+// checked.
+func (tagDB *TagDB) newQueryCompiler(ctx context.Context, q querier) (*queryCompiler, error) {
+	allSmartTags, err := getSmartTags(ctx, q, "SELECT "+smartTagColumns+" FROM smarttags")
+	if err != nil {
+		return nil, err
+	}
+
+	compiler := &queryCompiler{
+		links:        "livefiletags",
+		smartTags:    map[string]smarttags.SmartTag{},
+		declarations: map[string]values.Declaration{},
+		visiting:     map[string]bool{},
+	}
+
+	for _, smartTag := range allSmartTags {
+		compiler.smartTags[smartTag.String()] = smartTag
+	}
+
+	if err := compiler.loadDeclarations(ctx, q); err != nil {
+		return nil, err
+	}
+
+	if tagDB.implications == ImplicationsComputed {
+		compiler.implying = implyingString
+	}
+
+	return compiler, nil
+}
+
+func (c *queryCompiler) loadDeclarations(ctx context.Context, q querier) error {
+	const (
+		searchString = `SELECT t.namespace, t.name, v.tagid
+			FROM tagvaluetypes v JOIN tags t ON t.id = v.tagid
+			UNION ALL
+			SELECT a.namespace, a.name, v.tagid
+			FROM tagvaluetypes v JOIN tagaliases a ON a.tagid = v.tagid`
+	)
+
+	rows, err := q.QueryContext(ctx, searchString)
+	if err != nil {
+		return err
+	}
+
+	names := map[string]int{}
+	for rows.Next() {
+		tag := tags.Tag{}
+		var tagId int
+		if err := rows.Scan(&tag.Namespace, &tag.Name, &tagId); err != nil {
+			rows.Close()
+			return err
+		}
+		names[tag.String()] = tagId
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for name, tagId := range names {
+		declaration, _, err := getValueType(ctx, q, tagId)
+		if err != nil {
+			return err
+		}
+		c.declarations[name] = declaration
+	}
+
+	return nil
+}
+
+// compile returns the SQL condition for an expression and its arguments. It
+// returns an error if a smart tag's query can't be parsed, expanding smart
+// tags would go around in a circle or a comparison is on a tag which doesn't
+// take values or with a value which isn't valid for the tag.
+func (c *queryCompiler) compile(expr query.Expr) (string, []any, error) {
+	const (
+		termString = `EXISTS (SELECT 1 FROM %s ft
+			WHERE ft.fileid = f.id AND ft.tagid IN (%s))`
+	)
+
+	switch e := expr.(type) {
+	case query.Term:
+		if smartTag, ok := c.smartTags[e.Tag]; ok {
+			return c.compileSmartTag(smartTag)
+		}
+
+		condition, conditionArgs := tagCondition(e.Tag)
+		args := append(append([]any{}, conditionArgs...), conditionArgs...)
+		return fmt.Sprintf(termString, c.links, fmt.Sprintf(descendantsString, condition, c.implying)), args, nil
+	case query.Compare:
+		return c.compileCompare(e)
+	case query.Not:
+		condition, args, err := c.compile(e.Expr)
+		if err != nil {
+			return "", nil, err
+		}
+		return "NOT " + condition, args, nil
+	case query.And:
+		return c.compileAll(e.Exprs, " AND ")
+	case query.Or:
+		return c.compileAll(e.Exprs, " OR ")
+	}
+
+	return "", nil, fmt.Errorf("unsupported query expression: %s", expr)
+}
+
+func (c *queryCompiler) compileAll(exprs []query.Expr, operator string) (string, []any, error) {
+	conditions := []string{}
+	args := []any{}
+	for _, expr := range exprs {
+		condition, conditionArgs, err := c.compile(expr)
+		if err != nil {
+			return "", nil, err
+		}
+		conditions = append(conditions, condition)
+		args = append(args, conditionArgs...)
+	}
+
+	return "(" + strings.Join(conditions, operator) + ")", args, nil
+}
+
+// compileCompare matches the value on the compared tag only, not on its
+// descendants or the tags it implies. Enums are compared by the position of
+// their options so they sort the way they were declared.
+func (c *queryCompiler) compileCompare(compare query.Compare) (string, []any, error) {
+	const (
+		compareString = `EXISTS (SELECT 1 FROM %s ft
+			WHERE ft.fileid = f.id AND ft.tagid = ? AND %s %s ?)`
+		positionString = `(SELECT e.position FROM tagenumvalues e
+			WHERE e.tagid = ft.tagid AND e.value = ft.value)`
+	)
+
+	if !slices.Contains(query.Operators, compare.Op) {
+		return "", nil, fmt.Errorf("unsupported operator: %s", compare.Op)
+	}
+
+	declaration, ok := c.declarations[tags.Parse(compare.Tag).String()]
+	if !ok {
+		return "", nil, fmt.Errorf("tag %s doesn't take values", compare.Tag)
+	}
+
+	value, err := declaration.Parse(compare.Value)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid comparison %s: %w", compare, err)
+	}
+
+	if declaration.Type == values.Enum {
+		position := slices.Index(declaration.Options, compare.Value)
+		return fmt.Sprintf(compareString, c.links, positionString, compare.Op), []any{declaration.Tag, position}, nil
+	}
+
+	return fmt.Sprintf(compareString, c.links, "ft.value", compare.Op), []any{declaration.Tag, value}, nil
+}
+
+func (c *queryCompiler) compileSmartTag(smartTag smarttags.SmartTag) (string, []any, error) {
+	name := smartTag.String()
+	if c.visiting[name] {
+		return "", nil, fmt.Errorf("smart tag %s refers to itself", name)
+	}
+
+	expr, err := query.Parse(smartTag.Query)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid query for smart tag %s: %w", name, err)
+	}
+
+	c.visiting[name] = true
+	defer delete(c.visiting, name)
+
+	return c.compile(expr)
+}
+
+// GetFilesByQuery returns every file which matches a query expression, ordered
+// by path. Each term matches the same files as a single tag does in
+// GetFilesByTags, or the files matching a smart tag's query if the term is the
+// name of a smart tag. A comparison matches files whose value for the tag
+// compares true.
+func (tagDB *TagDB) GetFilesByQuery(ctx context.Context, expr query.Expr) ([]files.File, error) {
+	ctx, span := tracer.Start(ctx, "GetFilesByQuery")
+	defer span.End()
+
+	compiler, err := tagDB.newQueryCompiler(ctx, tagDB.client)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	ret, err := getFilesByQuery(ctx, tagDB.client, compiler, expr)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+// getFilesByQuery returns every file which matches a query expression compiled
+// by compiler, ordered by path.
+func getFilesByQuery(ctx context.Context, q querier, compiler *queryCompiler, expr query.Expr) ([]files.File, error) {
+	const (
+		searchString = `SELECT f.id, f.path, f.hash FROM files f
+			WHERE %s
+			ORDER BY f.path`
+	)
+
+	condition, args, err := compiler.compile(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.QueryContext(ctx, fmt.Sprintf(searchString, condition), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := []files.File{}
+
+	for rows.Next() {
+		file := files.File{}
+		if err := rows.Scan(&file.Id, &file.Path, &file.Hash); err != nil {
+			return nil, err
+		}
+		ret = append(ret, file)
+	}
+
+	return ret, rows.Err()
+}
@@ -0,0 +1,535 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/whatsfordinner/fstagger/internal/files"
+	"github.com/whatsfordinner/fstagger/internal/query"
+	"github.com/whatsfordinner/fstagger/internal/smarttags"
+	"github.com/whatsfordinner/fstagger/internal/tags"
+
+	"github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel/codes"
+)
+
+const smartTagColumns = "id, namespace, name, COALESCE(description, ''), query"
+
+// AddSmartTags takes a slice of smart tags and adds them to the datastore. It
+// ignores any IDs in the input slice and the output slice is the added smart
+// tags with their IDs. A smart tag is rejected if its query can't be parsed,
+// if its name is already used by a tag, an alias or another smart tag or if
+// its query refers back to itself through other smart tags.
+func (tagDB *TagDB) AddSmartTags(ctx context.Context, newSmartTags []smarttags.SmartTag) ([]smarttags.SmartTag, error) {
+	const (
+		insertString = "INSERT INTO smarttags(namespace, name, query, description) VALUES(?, ?, ?, ?) RETURNING id"
+	)
+
+	ctx, span := tracer.Start(ctx, "AddSmartTags")
+	defer span.End()
+
+	addedSmartTags := []smarttags.SmartTag{}
+
+	tx, err := tagDB.client.BeginTx(ctx, nil)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return []smarttags.SmartTag{}, err
+	}
+	txErrors := &BatchError{}
+
+	for i, smartTag := range newSmartTags {
+		span.AddEvent(fmt.Sprintf("adding smart tag: %s", smartTag))
+
+		err := tagDB.writeSmartTag(ctx, tx, smartTag, func() error {
+			row := tx.QueryRowContext(
+				ctx,
+				insertString,
+				smartTag.Namespace,
+				smartTag.Name,
+				smartTag.Query,
+				smartTag.Description,
+			)
+			if err := row.Scan(&smartTag.Id); err != nil {
+				if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.Code == sqlite3.ErrConstraint {
+					return fmt.Errorf("smart tag already exists: %s", smartTag)
+				}
+				return err
+			}
+
+			return nil
+		})
+		if err != nil {
+			txErrors.add(i, err)
+			continue
+		}
+
+		addedSmartTags = append(addedSmartTags, smartTag)
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			err = errors.Join(err, rollbackErr)
+		}
+		span.SetStatus(codes.Error, err.Error())
+		return []smarttags.SmartTag{}, err
+	}
+
+	if len(txErrors.Errors) > 0 {
+		span.SetStatus(codes.Error, "encountered DB errors during transaction")
+	} else {
+		span.SetStatus(codes.Ok, "")
+	}
+
+	return addedSmartTags, txErrors.errOrNil()
+}
+
+// UpdateSmartTags takes a slice of smart tags and updates the smart tags with
+// matching IDs. The same checks as AddSmartTags are applied to the updated
+// smart tags, including that no smart tag's query ends up referring back to
+// itself, and a smart tag used by another smart tag can't be renamed.
+func (tagDB *TagDB) UpdateSmartTags(ctx context.Context, updateSmartTags []smarttags.SmartTag) ([]smarttags.SmartTag, error) {
+	const (
+		updateString = "UPDATE smarttags SET namespace = ?, name = ?, query = ?, description = ? WHERE id = ?"
+	)
+
+	ctx, span := tracer.Start(ctx, "UpdateSmartTags")
+	defer span.End()
+
+	updatedSmartTags := []smarttags.SmartTag{}
+
+	tx, err := tagDB.client.BeginTx(ctx, nil)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return []smarttags.SmartTag{}, err
+	}
+	txErrors := &BatchError{}
+
+	for i, smartTag := range updateSmartTags {
+		span.AddEvent(fmt.Sprintf("updating smart tag ID %d", smartTag.Id))
+
+		// renaming a smart tag which another smart tag uses would silently
+		// change what the other one matches
+		existing, err := getSmartTags(ctx, tx, "SELECT "+smartTagColumns+" FROM smarttags WHERE id = ?", smartTag.Id)
+		if err != nil {
+			txErrors.add(i, err)
+			continue
+		}
+
+		if len(existing) > 0 && existing[0].String() != smartTag.String() {
+			if user, err := smartTagUser(ctx, tx, existing[0]); err != nil {
+				txErrors.add(i, err)
+				continue
+			} else if user != "" {
+				txErrors.add(i, fmt.Errorf("can't rename smart tag %s because it's used by smart tag %s", existing[0], user))
+				continue
+			}
+		}
+
+		err = tagDB.writeSmartTag(ctx, tx, smartTag, func() error {
+			res, err := tx.ExecContext(
+				ctx,
+				updateString,
+				smartTag.Namespace,
+				smartTag.Name,
+				smartTag.Query,
+				smartTag.Description,
+				smartTag.Id,
+			)
+			if err != nil {
+				if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.Code == sqlite3.ErrConstraint {
+					return fmt.Errorf("smart tag already exists: %s", smartTag)
+				}
+				return err
+			}
+
+			if updated, err := res.RowsAffected(); err == nil && updated == 0 {
+				return fmt.Errorf("smart tag does not exist with id: %d", smartTag.Id)
+			}
+
+			return nil
+		})
+		if err != nil {
+			txErrors.add(i, err)
+			continue
+		}
+
+		updatedSmartTags = append(updatedSmartTags, smartTag)
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			err = errors.Join(err, rollbackErr)
+		}
+		span.SetStatus(codes.Error, err.Error())
+		return []smarttags.SmartTag{}, err
+	}
+
+	if len(txErrors.Errors) > 0 {
+		span.SetStatus(codes.Error, "encountered DB errors during transaction")
+	} else {
+		span.SetStatus(codes.Ok, "")
+	}
+
+	return updatedSmartTags, txErrors.errOrNil()
+}
+
+// writeSmartTag checks a smart tag can be written, runs write inside a
+// savepoint and then checks every smart tag can still be expanded. The
+// savepoint is discarded if anything fails so the rest of the batch is kept.
+func (tagDB *TagDB) writeSmartTag(ctx context.Context, tx *sql.Tx, smartTag smarttags.SmartTag, write func() error) error {
+	const (
+		tagString   = "SELECT COUNT(*) FROM tags WHERE namespace = ? AND name = ?"
+		aliasString = "SELECT COUNT(*) FROM tagaliases WHERE namespace = ? AND name = ?"
+	)
+
+	if smartTag.Name == "" {
+		return errors.New("smart tag must have a name")
+	}
+
+	if _, err := query.Parse(smartTag.Query); err != nil {
+		return fmt.Errorf("invalid query for smart tag %s: %w", smartTag, err)
+	}
+
+	for _, checkString := range []string{tagString, aliasString} {
+		var existing int
+		row := tx.QueryRowContext(ctx, checkString, smartTag.Namespace, smartTag.Name)
+		if err := row.Scan(&existing); err != nil {
+			return err
+		}
+
+		if existing > 0 {
+			return fmt.Errorf("can't create smart tag %s because a tag or alias already has that name", smartTag)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT write_smart_tag"); err != nil {
+		return err
+	}
+
+	err := write()
+	if err == nil {
+		err = tagDB.checkSmartTags(ctx, tx)
+	}
+
+	if err != nil {
+		if _, rollbackErr := tx.ExecContext(ctx, "ROLLBACK TO write_smart_tag"); rollbackErr != nil {
+			err = errors.Join(err, rollbackErr)
+		}
+	}
+
+	if _, releaseErr := tx.ExecContext(ctx, "RELEASE write_smart_tag"); releaseErr != nil {
+		err = errors.Join(err, releaseErr)
+	}
+
+	return err
+}
+
+// checkSmartTags expands every smart tag and returns the first error, which
+// is how cycles between smart tags are found.
+func (tagDB *TagDB) checkSmartTags(ctx context.Context, q querier) error {
+	compiler, err := tagDB.newQueryCompiler(ctx, q)
+	if err != nil {
+		return err
+	}
+
+	for _, smartTag := range compiler.smartTags {
+		if _, _, err := compiler.compileSmartTag(smartTag); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteSmartTags takes a slice of smart tags and removes them from the
+// datastore. A smart tag used in the query of another smart tag isn't deleted
+// because the other smart tag would silently change meaning.
+func (tagDB *TagDB) DeleteSmartTags(ctx context.Context, deleteSmartTags []smarttags.SmartTag) error {
+	const (
+		deleteString = "DELETE FROM smarttags WHERE id = ?"
+	)
+
+	ctx, span := tracer.Start(ctx, "DeleteSmartTags")
+	defer span.End()
+
+	tx, err := tagDB.client.BeginTx(ctx, nil)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	txErrors := &BatchError{}
+
+	for i, smartTag := range deleteSmartTags {
+		existing, err := getSmartTags(ctx, tx, "SELECT "+smartTagColumns+" FROM smarttags WHERE id = ?", smartTag.Id)
+		if err != nil {
+			txErrors.add(i, err)
+			continue
+		}
+
+		if len(existing) == 0 {
+			txErrors.add(i, fmt.Errorf("smart tag does not exist with id: %d", smartTag.Id))
+			continue
+		}
+
+		if user, err := smartTagUser(ctx, tx, existing[0]); err != nil {
+			txErrors.add(i, err)
+			continue
+		} else if user != "" {
+			txErrors.add(i, fmt.Errorf("smart tag %s is used by smart tag %s", existing[0], user))
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, deleteString, smartTag.Id); err != nil {
+			txErrors.add(i, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			err = errors.Join(err, rollbackErr)
+		}
+		span.SetStatus(codes.Error, "encountered error finalising transaction")
+		return err
+	}
+
+	if len(txErrors.Errors) > 0 {
+		span.SetStatus(codes.Error, "encountered DB errors during transaction")
+	} else {
+		span.SetStatus(codes.Ok, "")
+	}
+
+	return txErrors.errOrNil()
+}
+
+// smartTagUser returns the name of a smart tag whose query uses the provided
+// smart tag or an empty string if none do.
+func smartTagUser(ctx context.Context, q querier, smartTag smarttags.SmartTag) (string, error) {
+	others, err := getSmartTags(ctx, q, "SELECT "+smartTagColumns+" FROM smarttags WHERE id != ?", smartTag.Id)
+	if err != nil {
+		return "", err
+	}
+
+	for _, other := range others {
+		expr, err := query.Parse(other.Query)
+		if err != nil {
+			return "", err
+		}
+
+		if slices.Contains(query.Terms(expr), smartTag.String()) {
+			return other.String(), nil
+		}
+	}
+
+	return "", nil
+}
+
+// GetSmartTags returns every smart tag ordered by namespace and name.
+func (tagDB *TagDB) GetSmartTags(ctx context.Context) ([]smarttags.SmartTag, error) {
+	ctx, span := tracer.Start(ctx, "GetSmartTags")
+	defer span.End()
+
+	ret, err := getSmartTags(ctx, tagDB.client, "SELECT "+smartTagColumns+" FROM smarttags ORDER BY namespace, name")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+// GetSmartTagsByName returns every smart tag whose name, qualified with its
+// namespace, matches a search string with % as a wildcard the same as
+// GetTagsByName.
+func (tagDB *TagDB) GetSmartTagsByName(ctx context.Context, search string) ([]smarttags.SmartTag, error) {
+	const (
+		searchString = "SELECT " + smartTagColumns + ` FROM smarttags
+			WHERE CASE WHEN namespace = '' THEN name ELSE namespace || ':' || name END LIKE ?
+			ORDER BY namespace, name`
+	)
+
+	ctx, span := tracer.Start(ctx, "GetSmartTagsByName")
+	defer span.End()
+
+	ret, err := getSmartTags(ctx, tagDB.client, searchString, search)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+// GetSmartTagByName returns the smart tag whose name, qualified with its
+// namespace, exactly matches the input or an error wrapping sql.ErrNoRows if
+// there isn't one.
+func (tagDB *TagDB) GetSmartTagByName(ctx context.Context, search string) (smarttags.SmartTag, error) {
+	const (
+		searchString = "SELECT " + smartTagColumns + " FROM smarttags WHERE namespace = ? AND name = ?"
+	)
+
+	ctx, span := tracer.Start(ctx, "GetSmartTagByName")
+	defer span.End()
+
+	parsed := tags.Parse(search)
+	ret, err := getSmartTags(ctx, tagDB.client, searchString, parsed.Namespace, parsed.Name)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return smarttags.SmartTag{}, err
+	}
+
+	if len(ret) == 0 {
+		span.SetStatus(codes.Error, "smart tag not found")
+		return smarttags.SmartTag{}, fmt.Errorf("smart tag does not exist with name: %s: %w", search, sql.ErrNoRows)
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret[0], nil
+}
+
+// GetSmartTagFileCounts returns the number of files matching each smart tag,
+// keyed by smart tag ID. Smart tags which don't match any files aren't
+// included.
+func (tagDB *TagDB) GetSmartTagFileCounts(ctx context.Context) (map[int]int, error) {
+	const (
+		countString = "SELECT COUNT(*) FROM files f WHERE %s"
+	)
+
+	ctx, span := tracer.Start(ctx, "GetSmartTagFileCounts")
+	defer span.End()
+
+	compiler, err := tagDB.newQueryCompiler(ctx, tagDB.client)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	ret := map[int]int{}
+
+	for _, smartTag := range compiler.smartTags {
+		condition, args, err := compiler.compileSmartTag(smartTag)
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+
+		var count int
+		row := tagDB.client.QueryRowContext(ctx, fmt.Sprintf(countString, condition), args...)
+		if err := row.Scan(&count); err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+
+		if count > 0 {
+			ret[smartTag.Id] = count
+		}
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+// GetSmartTagsForFile returns every smart tag the provided file matches,
+// ordered by namespace and name. Only the file's ID is used for the search.
+func (tagDB *TagDB) GetSmartTagsForFile(ctx context.Context, file files.File) ([]smarttags.SmartTag, error) {
+	ctx, span := tracer.Start(ctx, "GetSmartTagsForFile")
+	defer span.End()
+
+	compiler, err := tagDB.newQueryCompiler(ctx, tagDB.client)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	ret, err := getSmartTagsForFile(ctx, tagDB.client, compiler, file)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+// getSmartTagsForFile returns every smart tag whose query, compiled by
+// compiler, the provided file matches, ordered by namespace and name.
+func getSmartTagsForFile(ctx context.Context, q querier, compiler *queryCompiler, file files.File) ([]smarttags.SmartTag, error) {
+	const (
+		matchString = "SELECT COUNT(*) FROM files f WHERE f.id = ? AND %s"
+	)
+
+	allSmartTags, err := getSmartTags(ctx, q, "SELECT "+smartTagColumns+" FROM smarttags ORDER BY namespace, name")
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []smarttags.SmartTag{}
+
+	for _, smartTag := range allSmartTags {
+		condition, args, err := compiler.compileSmartTag(smartTag)
+		if err != nil {
+			return nil, err
+		}
+
+		var matches int
+		row := q.QueryRowContext(
+			ctx,
+			fmt.Sprintf(matchString, condition),
+			append([]any{file.Id}, args...)...,
+		)
+		if err := row.Scan(&matches); err != nil {
+			return nil, err
+		}
+
+		if matches > 0 {
+			ret = append(ret, smartTag)
+		}
+	}
+
+	return ret, nil
+}
+
+// isSmartTag reports whether a smart tag has the provided tag's namespace and
+// name.
+func isSmartTag(ctx context.Context, q querier, tag tags.Tag) (bool, error) {
+	const (
+		searchString = "SELECT COUNT(*) FROM smarttags WHERE namespace = ? AND name = ?"
+	)
+
+	var count int
+	if err := q.QueryRowContext(ctx, searchString, tag.Namespace, tag.Name).Scan(&count); err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func getSmartTags(ctx context.Context, q querier, searchString string, args ...any) ([]smarttags.SmartTag, error) {
+	rows, err := q.QueryContext(ctx, searchString, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := []smarttags.SmartTag{}
+
+	for rows.Next() {
+		smartTag := smarttags.SmartTag{}
+		if err := rows.Scan(
+			&smartTag.Id,
+			&smartTag.Namespace,
+			&smartTag.Name,
+			&smartTag.Description,
+			&smartTag.Query,
+		); err != nil {
+			return nil, err
+		}
+		ret = append(ret, smartTag)
+	}
+
+	return ret, rows.Err()
+}
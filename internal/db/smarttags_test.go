@@ -0,0 +1,381 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/whatsfordinner/fstagger/internal/files"
+	"github.com/whatsfordinner/fstagger/internal/query"
+	"github.com/whatsfordinner/fstagger/internal/smarttags"
+	"github.com/whatsfordinner/fstagger/internal/tags"
+)
+
+var fixtureSmartTags = []smarttags.SmartTag{
+	{Id: 1, Namespace: "", Name: "todo-photos", Description: "photos to look at", Query: "photos and not reviewed"},
+	{Id: 3, Namespace: "", Name: "unreviewed", Description: "anything not looked at", Query: "not reviewed"},
+	{Id: 2, Namespace: "smart", Name: "cleanup", Description: "photos to throw away", Query: `todo-photos and "to delete"`},
+}
+
+// filePaths returns the path of every file in the order they're provided.
+func filePaths(input []files.File) []string {
+	ret := []string{}
+	for _, file := range input {
+		ret = append(ret, file.Path)
+	}
+
+	return ret
+}
+
+func TestTagDBGetFilesByQuery(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr bool
+		input     string
+		expect    []string
+	}{
+		"single tag": {
+			false,
+			"photos",
+			[]string{"/path/to/bar", "/path/to/baz", "/path/to/foo"},
+		},
+		"and": {
+			false,
+			"photos and reviewed",
+			[]string{"/path/to/foo"},
+		},
+		"or": {
+			false,
+			`reviewed or "to delete"`,
+			[]string{"/path/to/baz", "/path/to/foo"},
+		},
+		"not": {
+			false,
+			"not photos",
+			[]string{"/path/to/qux"},
+		},
+		"grouping": {
+			false,
+			`photos and not (reviewed or "to delete")`,
+			[]string{"/path/to/bar"},
+		},
+		"smart tag": {
+			false,
+			"todo-photos",
+			[]string{"/path/to/bar", "/path/to/baz"},
+		},
+		"nested smart tag": {
+			false,
+			"smart:cleanup",
+			[]string{"/path/to/baz"},
+		},
+		"negated smart tag": {
+			false,
+			"not todo-photos",
+			[]string{"/path/to/foo", "/path/to/qux"},
+		},
+		"smart tag combined with a tag": {
+			false,
+			"unreviewed and photos/raw",
+			[]string{"/path/to/bar"},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/smarttags.yml"})
+			defer teardown()
+
+			expr, err := query.Parse(testData.input)
+			if err != nil {
+				t.Fatalf("Unable to parse query: %s", err.Error())
+			}
+
+			res, err := testDB.GetFilesByQuery(context.Background(), expr)
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(filePaths(res), testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					filePaths(res),
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBAddSmartTags(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr bool
+		input     []smarttags.SmartTag
+		expect    []smarttags.SmartTag
+	}{
+		"new smart tag": {
+			false,
+			[]smarttags.SmartTag{{Name: "raw-todo", Query: "todo-photos and photos/raw"}},
+			[]smarttags.SmartTag{{Id: 4, Name: "raw-todo", Query: "todo-photos and photos/raw"}},
+		},
+		"invalid query": {
+			true,
+			[]smarttags.SmartTag{{Name: "broken", Query: "photos and"}},
+			[]smarttags.SmartTag{},
+		},
+		"name of a tag": {
+			true,
+			[]smarttags.SmartTag{{Name: "photos", Query: "reviewed"}},
+			[]smarttags.SmartTag{},
+		},
+		"name of a smart tag": {
+			true,
+			[]smarttags.SmartTag{{Name: "unreviewed", Query: "photos"}},
+			[]smarttags.SmartTag{},
+		},
+		"refers to itself": {
+			true,
+			[]smarttags.SmartTag{{Name: "loop", Query: "photos or loop"}},
+			[]smarttags.SmartTag{},
+		},
+		"some valid smart tags": {
+			true,
+			[]smarttags.SmartTag{
+				{Name: "loop", Query: "loop"},
+				{Namespace: "smart", Name: "raw", Query: "photos/raw"},
+			},
+			[]smarttags.SmartTag{{Id: 4, Namespace: "smart", Name: "raw", Query: "photos/raw"}},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/smarttags.yml"})
+			defer teardown()
+
+			res, err := testDB.AddSmartTags(context.Background(), testData.input)
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+
+			allSmartTags, err := testDB.GetSmartTags(context.Background())
+			if err != nil {
+				t.Fatalf("Error retrieving smart tags: %s", err.Error())
+			}
+
+			if len(allSmartTags) != len(fixtureSmartTags)+len(testData.expect) {
+				t.Fatalf(
+					"Expected %d smart tags but got %d",
+					len(fixtureSmartTags)+len(testData.expect),
+					len(allSmartTags),
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBUpdateSmartTags(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr bool
+		input     smarttags.SmartTag
+		expect    []smarttags.SmartTag
+	}{
+		"new query": {
+			false,
+			smarttags.SmartTag{Id: 3, Name: "unreviewed", Query: "photos and not reviewed"},
+			[]smarttags.SmartTag{fixtureSmartTags[0], {Id: 3, Name: "unreviewed", Query: "photos and not reviewed"}, fixtureSmartTags[2]},
+		},
+		"rename unused smart tag": {
+			false,
+			smarttags.SmartTag{Id: 3, Name: "unseen", Query: "not reviewed"},
+			[]smarttags.SmartTag{fixtureSmartTags[0], {Id: 3, Name: "unseen", Query: "not reviewed"}, fixtureSmartTags[2]},
+		},
+		"rename used smart tag": {
+			true,
+			smarttags.SmartTag{Id: 1, Name: "photo-todo", Query: "photos and not reviewed"},
+			fixtureSmartTags,
+		},
+		"creates a cycle": {
+			true,
+			smarttags.SmartTag{Id: 1, Name: "todo-photos", Query: "smart:cleanup"},
+			fixtureSmartTags,
+		},
+		"smart tag doesn't exist": {
+			true,
+			smarttags.SmartTag{Id: 10, Name: "missing", Query: "photos"},
+			fixtureSmartTags,
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/smarttags.yml"})
+			defer teardown()
+
+			_, err := testDB.UpdateSmartTags(context.Background(), []smarttags.SmartTag{testData.input})
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			res, err := testDB.GetSmartTags(context.Background())
+			if err != nil {
+				t.Fatalf("Error retrieving smart tags: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBDeleteSmartTags(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr bool
+		input     []smarttags.SmartTag
+		expect    []smarttags.SmartTag
+	}{
+		"unused smart tag": {
+			false,
+			[]smarttags.SmartTag{{Id: 2}},
+			[]smarttags.SmartTag{fixtureSmartTags[0], fixtureSmartTags[1]},
+		},
+		"smart tag used by another": {
+			true,
+			[]smarttags.SmartTag{{Id: 1}},
+			fixtureSmartTags,
+		},
+		"smart tag doesn't exist": {
+			true,
+			[]smarttags.SmartTag{{Id: 10}},
+			fixtureSmartTags,
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/smarttags.yml"})
+			defer teardown()
+
+			err := testDB.DeleteSmartTags(context.Background(), testData.input)
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			res, err := testDB.GetSmartTags(context.Background())
+			if err != nil {
+				t.Fatalf("Error retrieving smart tags: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBGetSmartTagsForFile(t *testing.T) {
+	testMap := map[string]struct {
+		input  int
+		expect []string
+	}{
+		"reviewed photo":    {1, []string{}},
+		"unreviewed photo":  {2, []string{"todo-photos", "unreviewed"}},
+		"photo to delete":   {3, []string{"todo-photos", "unreviewed", "smart:cleanup"}},
+		"file with no tags": {4, []string{"unreviewed"}},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/smarttags.yml"})
+			defer teardown()
+
+			res, err := testDB.GetSmartTagsForFile(context.Background(), files.File{Id: testData.input})
+			if err != nil {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			names := []string{}
+			for _, smartTag := range res {
+				names = append(names, smartTag.String())
+			}
+
+			if !reflect.DeepEqual(names, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					names,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBGetSmartTagFileCounts(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/smarttags.yml"})
+	defer teardown()
+
+	expect := map[int]int{1: 2, 2: 1, 3: 3}
+
+	res, err := testDB.GetSmartTagFileCounts(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	if !reflect.DeepEqual(res, expect) {
+		t.Fatalf(
+			"Result did not match expectation\nResult: %+v\nExpected: %+v",
+			res,
+			expect,
+		)
+	}
+}
+
+func TestTagDBAddTagsSmartTagName(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/smarttags.yml"})
+	defer teardown()
+
+	_, err := testDB.AddTags(context.Background(), []tags.Tag{{Name: "todo-photos"}})
+	if err == nil {
+		t.Fatal("Expected error but got no error")
+	}
+
+	if _, err := testDB.GetTagByName(context.Background(), "todo-photos"); err == nil {
+		t.Fatal("Expected tag not to be added")
+	}
+}
@@ -5,7 +5,12 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/whatsfordinner/fstagger/internal/files"
+	"github.com/whatsfordinner/fstagger/internal/history"
+	"github.com/whatsfordinner/fstagger/internal/links"
 	"github.com/whatsfordinner/fstagger/internal/tags"
 
 	"github.com/mattn/go-sqlite3"
@@ -14,12 +19,15 @@ import (
 
 // AddTags takes a slice of tags, tries adding them to the datastore and returns a slice of
 // tags. It ignores any IDs in the input slice. The output slice is the same tags with
-// the IDs assigned to them in the datastore. If a tag with the same name is supplied
-// multiple times it will only be added once.
+// the IDs assigned to them in the datastore. If a tag with the same namespace and name is
+// supplied multiple times it will only be added once. A tag whose name is an alias is
+// replaced by the tag the alias points at rather than being added and a tag can't share
+// its name with a smart tag.
 func (tagDB *TagDB) AddTags(ctx context.Context, newTags []tags.Tag) ([]tags.Tag, error) {
 	const (
-		insertString = "INSERT INTO tags(name, description) VALUES(?, ?) RETURNING id"
-		searchString = "SELECT id, name, description FROM tags WHERE name = ?"
+		insertString = `INSERT INTO tags(namespace, name, description, parent, created, updated)
+			VALUES(?, ?, ?, NULLIF(?, 0), unixepoch(), unixepoch()) RETURNING id`
+		searchString = "SELECT id, namespace, name, description, COALESCE(parent, 0) FROM tags WHERE namespace = ? AND name = ?"
 	)
 
 	ctx, span := tracer.Start(ctx, "AddTags")
@@ -31,12 +39,18 @@ func (tagDB *TagDB) AddTags(ctx context.Context, newTags []tags.Tag) ([]tags.Tag
 	if err != nil {
 		return []tags.Tag{}, err
 	}
-	txErrors := []error{}
+	txErrors := &BatchError{}
+
+	for i, tag := range newTags {
+		tag, isAlias, err := resolveAlias(ctx, tx, tag)
+		if err != nil {
+			txErrors.add(i, err)
+			continue
+		}
 
-	for _, tag := range newTags {
 		tagAlreadyProcessed := false
 		for _, addedTag := range returnTags {
-			if tag.Name == addedTag.Name {
+			if tag.Namespace == addedTag.Namespace && tag.Name == addedTag.Name {
 				tagAlreadyProcessed = true
 				continue
 			}
@@ -46,39 +60,72 @@ func (tagDB *TagDB) AddTags(ctx context.Context, newTags []tags.Tag) ([]tags.Tag
 			continue
 		}
 
-		span.AddEvent(fmt.Sprintf("adding new tag: %s", tag.Name))
-		row := tx.QueryRowContext(ctx, insertString, tag.Name, tag.Description)
+		if isAlias {
+			span.AddEvent(fmt.Sprintf("resolved alias to existing tag: %s", tag))
+			returnTags = append(returnTags, tag)
+			continue
+		}
+
+		if smart, err := isSmartTag(ctx, tx, tag); err != nil {
+			txErrors.add(i, err)
+			continue
+		} else if smart {
+			txErrors.add(i, fmt.Errorf("can't add tag %s because a smart tag has that name", tag))
+			continue
+		}
+
+		span.AddEvent(fmt.Sprintf("adding new tag: %s", tag))
+		parentId, err := tagDB.ensureAncestors(ctx, tx, tag)
+		if err != nil {
+			txErrors.add(i, err)
+			continue
+		}
+
+		row := tx.QueryRowContext(ctx, insertString, tag.Namespace, tag.Name, tag.Description, parentId)
 		var tagId int64
-		err := row.Scan(&tagId)
+		err = row.Scan(&tagId)
 		if err != nil {
 			span.SetStatus(codes.Error, err.Error())
 			if sqliteErr, ok := err.(sqlite3.Error); ok {
 				switch sqliteErr.Code {
 				case sqlite3.ErrConstraint:
-					span.AddEvent(fmt.Sprintf("tag already exists: %s", tag.Name))
+					span.AddEvent(fmt.Sprintf("tag already exists: %s", tag))
 					searchRow := tx.QueryRowContext(
 						ctx,
 						searchString,
+						tag.Namespace,
 						tag.Name,
 					)
 					err := searchRow.Scan(
 						&tag.Id,
+						&tag.Namespace,
 						&tag.Name,
 						&tag.Description,
+						&tag.Parent,
 					)
 					if err != nil {
-						txErrors = append(txErrors, err)
+						txErrors.add(i, err)
 						continue
 					}
 				default:
-					txErrors = append(txErrors, sqliteErr)
+					txErrors.add(i, sqliteErr)
 				}
 			} else {
-				txErrors = append(txErrors, err)
+				txErrors.add(i, err)
 				continue
 			}
 		} else {
 			tag.Id = int(tagId)
+			tag.Parent = parentId
+			if err := adoptChildren(ctx, tx, tag); err != nil {
+				txErrors.add(i, err)
+				continue
+			}
+
+			if err := tagDB.recordHistory(ctx, tx, history.TagAdded, 0, tag.Id, ""); err != nil {
+				txErrors.add(i, err)
+				continue
+			}
 		}
 
 		returnTags = append(returnTags, tag)
@@ -92,21 +139,21 @@ func (tagDB *TagDB) AddTags(ctx context.Context, newTags []tags.Tag) ([]tags.Tag
 		return []tags.Tag{}, err
 	}
 
-	if len(txErrors) > 0 {
+	if len(txErrors.Errors) > 0 {
 		span.SetStatus(codes.Error, "encountered DB errors during transaction")
 	} else {
 		span.SetStatus(codes.Ok, "")
 	}
 
-	return returnTags, errors.Join(txErrors...)
+	return returnTags, txErrors.errOrNil()
 }
 
-// DeleteTags takes a slice of tags and removes them from the database.
-func (tagDB *TagDB) DeleteTags(ctx context.Context, deleteTags []tags.Tag) error {
-	const (
-		deleteString = "DELETE FROM tags WHERE id = ?"
-	)
-
+// DeleteTags takes a slice of tags and removes them from the database, along
+// with every link to them. Unless force is set a tag which is still attached
+// to any file isn't deleted and its error wraps ErrTagInUse, which is checked
+// in the same transaction as the deletion so a link added in between can't be
+// lost.
+func (tagDB *TagDB) DeleteTags(ctx context.Context, deleteTags []tags.Tag, force bool) error {
 	ctx, span := tracer.Start(ctx, "DeleteTags")
 	defer span.End()
 
@@ -114,12 +161,29 @@ func (tagDB *TagDB) DeleteTags(ctx context.Context, deleteTags []tags.Tag) error
 	if err != nil {
 		return err
 	}
-	txErrors := []error{}
+	txErrors := &BatchError{}
+
+	for i, tag := range deleteTags {
+		// the history is written before the tag goes so each tag is deleted
+		// inside a savepoint which keeps its history and deletion together
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT delete_tag"); err != nil {
+			txErrors.add(i, err)
+			continue
+		}
 
-	for _, tag := range deleteTags {
-		_, err := tx.ExecContext(ctx, deleteString, tag.Id)
+		err := tagDB.deleteTag(ctx, tx, tag.Id, force)
 		if err != nil {
-			txErrors = append(txErrors, err)
+			if _, rollbackErr := tx.ExecContext(ctx, "ROLLBACK TO delete_tag"); rollbackErr != nil {
+				err = errors.Join(err, rollbackErr)
+			}
+		}
+
+		if _, releaseErr := tx.ExecContext(ctx, "RELEASE delete_tag"); releaseErr != nil {
+			err = errors.Join(err, releaseErr)
+		}
+
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			txErrors.add(i, err)
 		}
 	}
 
@@ -131,22 +195,71 @@ func (tagDB *TagDB) DeleteTags(ctx context.Context, deleteTags []tags.Tag) error
 		return err
 	}
 
-	if len(txErrors) > 0 {
+	if len(txErrors.Errors) > 0 {
 		span.SetStatus(codes.Error, "encountered DB errors during transaction")
 	} else {
 		span.SetStatus(codes.Ok, "")
 	}
 
-	return errors.Join(txErrors...)
+	return txErrors.errOrNil()
+}
+
+// deleteTag records a tag's deletion and the links it takes with it in the
+// history and then deletes it. It returns sql.ErrNoRows if there's no tag to
+// delete, in which case the history it wrote has to be rolled back, and an
+// error wrapping ErrTagInUse if the tag is attached to files and force isn't
+// set.
+func (tagDB *TagDB) deleteTag(ctx context.Context, tx *sql.Tx, tagId int, force bool) error {
+	const (
+		usageString = `SELECT t.namespace, t.name, COUNT(ft.fileid) FROM tags t
+			LEFT JOIN livefiletags ft ON ft.tagid = t.id
+			WHERE t.id = ? GROUP BY t.id`
+		deleteString = "DELETE FROM tags WHERE id = ?"
+	)
+
+	if !force {
+		tag := tags.Tag{Id: tagId}
+		var files int
+		row := tx.QueryRowContext(ctx, usageString, tagId)
+		if err := row.Scan(&tag.Namespace, &tag.Name, &files); err != nil {
+			return err
+		}
+
+		if files > 0 {
+			return fmt.Errorf("tag %s is attached to %d files: %w", tag, files, ErrTagInUse)
+		}
+	}
+
+	if err := tagDB.recordTagRemoved(ctx, tx, tagId, "tag deleted"); err != nil {
+		return err
+	}
+
+	if err := tagDB.recordHistory(ctx, tx, history.TagDeleted, 0, tagId, ""); err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, deleteString, tagId)
+	if err != nil {
+		return err
+	}
+
+	if deleted, err := res.RowsAffected(); err != nil {
+		return err
+	} else if deleted == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
 }
 
 // UpdateTags takes a slice of tags and updates the tags with matching IDs. If a
 // tag with a provided ID doesn't exist then it will update what it can and
-// return an error.
+// return an error. Renaming a tag moves every tag below it in the hierarchy
+// along with it and the parent of an updated tag is always taken from its
+// name rather than the input.
 func (tagDB *TagDB) UpdateTags(ctx context.Context, updateTags []tags.Tag) ([]tags.Tag, error) {
 	const (
-		updateString = "UPDATE tags SET name = ?, description = ? WHERE id = ?"
-		searchString = "SELECT id FROM tags WHERE id = ?"
+		searchString = "SELECT id, namespace, name, description, COALESCE(parent, 0) FROM tags WHERE id = ?"
 	)
 
 	ctx, span := tracer.Start(ctx, "UpdateTags")
@@ -159,28 +272,50 @@ func (tagDB *TagDB) UpdateTags(ctx context.Context, updateTags []tags.Tag) ([]ta
 		span.SetStatus(codes.Error, err.Error())
 		return []tags.Tag{}, err
 	}
-	txErrors := []error{}
+	txErrors := &BatchError{}
 
-	for _, tag := range updateTags {
+	for i, tag := range updateTags {
+		existing := tags.Tag{}
 		row := tx.QueryRowContext(ctx, searchString, tag.Id)
-		var tagId int64
-		err := row.Scan(&tagId)
+		err := row.Scan(
+			&existing.Id,
+			&existing.Namespace,
+			&existing.Name,
+			&existing.Description,
+			&existing.Parent,
+		)
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
-				span.AddEvent(fmt.Sprintf("tag doesn't exist in db: %s", tag.Name))
+				span.AddEvent(fmt.Sprintf("tag doesn't exist in db: %s", tag))
 			}
-			txErrors = append(txErrors, err)
+			txErrors.add(i, err)
 			continue
 		}
 
-		if _, err = tx.ExecContext(
-			ctx,
-			updateString,
-			tag.Name,
-			tag.Description,
-			tag.Id,
-		); err != nil {
-			txErrors = append(txErrors, err)
+		// a rename touches several rows so each tag is updated inside a
+		// savepoint which can be discarded without losing the others
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT update_tag"); err != nil {
+			txErrors.add(i, err)
+			continue
+		}
+
+		tag.Parent, err = tagDB.updateTag(ctx, tx, existing, tag)
+		if err == nil {
+			err = tagDB.recordHistory(ctx, tx, history.TagUpdated, 0, tag.Id, describeTagUpdate(existing, tag))
+		}
+		if err != nil {
+			span.AddEvent(fmt.Sprintf("unable to update tag: %s", tag))
+			if _, rollbackErr := tx.ExecContext(ctx, "ROLLBACK TO update_tag"); rollbackErr != nil {
+				err = errors.Join(err, rollbackErr)
+			}
+		}
+
+		if _, releaseErr := tx.ExecContext(ctx, "RELEASE update_tag"); releaseErr != nil {
+			err = errors.Join(err, releaseErr)
+		}
+
+		if err != nil {
+			txErrors.add(i, err)
 			continue
 		}
 
@@ -195,13 +330,102 @@ func (tagDB *TagDB) UpdateTags(ctx context.Context, updateTags []tags.Tag) ([]ta
 		return []tags.Tag{}, err
 	}
 
-	if len(txErrors) > 0 {
+	if len(txErrors.Errors) > 0 {
 		span.SetStatus(codes.Error, "")
 	} else {
 		span.SetStatus(codes.Ok, "")
 	}
 
-	return updatedTags, errors.Join(txErrors...)
+	return updatedTags, txErrors.errOrNil()
+}
+
+// updateTag writes the changes to a single tag and returns the ID of its
+// parent. If the tag has been renamed then its new ancestors are created, the
+// tags below it are moved and any orphans below its new name are adopted. A
+// tag renamed to one of its aliases takes the alias's name and its old name
+// becomes the alias.
+func (tagDB *TagDB) updateTag(ctx context.Context, tx *sql.Tx, existing tags.Tag, tag tags.Tag) (int, error) {
+	const (
+		updateString = `UPDATE tags SET namespace = ?, name = ?, description = ?, parent = NULLIF(?, 0), updated = unixepoch()
+			WHERE id = ?`
+		swapString = "UPDATE tagaliases SET namespace = ?, name = ? WHERE namespace = ? AND name = ?"
+	)
+
+	if existing.Namespace == tag.Namespace && existing.Name == tag.Name {
+		_, err := tx.ExecContext(
+			ctx,
+			updateString,
+			tag.Namespace,
+			tag.Name,
+			tag.Description,
+			existing.Parent,
+			tag.Id,
+		)
+
+		return existing.Parent, err
+	}
+
+	// renaming a tag to one of its own aliases swaps the two names so the old
+	// name keeps finding the tag
+	if resolved, isAlias, err := resolveAlias(ctx, tx, tag); err != nil {
+		return 0, err
+	} else if isAlias && resolved.Id != tag.Id {
+		return 0, fmt.Errorf("can't rename tag %s to %s which is an alias of tag %s", existing, tag, resolved)
+	} else if isAlias {
+		if _, err := tx.ExecContext(
+			ctx,
+			swapString,
+			existing.Namespace,
+			existing.Name,
+			tag.Namespace,
+			tag.Name,
+		); err != nil {
+			return 0, err
+		}
+	}
+
+	if smart, err := isSmartTag(ctx, tx, tag); err != nil {
+		return 0, err
+	} else if smart {
+		return 0, fmt.Errorf("can't rename tag %s to %s which is a smart tag", existing, tag)
+	}
+
+	parentId, err := tagDB.ensureAncestors(ctx, tx, tag)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.ExecContext(
+		ctx,
+		updateString,
+		tag.Namespace,
+		tag.Name,
+		tag.Description,
+		parentId,
+		tag.Id,
+	); err != nil {
+		return 0, err
+	}
+
+	if err := tagDB.moveDescendants(ctx, tx, existing, tag, false); err != nil {
+		return 0, err
+	}
+
+	return parentId, adoptChildren(ctx, tx, tag)
+}
+
+// describeTagUpdate summarises what changed about a tag for the history.
+func describeTagUpdate(existing tags.Tag, tag tags.Tag) string {
+	changes := []string{}
+	if existing.String() != tag.String() {
+		changes = append(changes, "renamed from "+existing.String())
+	}
+
+	if existing.Description != tag.Description {
+		changes = append(changes, fmt.Sprintf("description changed from %q to %q", existing.Description, tag.Description))
+	}
+
+	return strings.Join(changes, ", ")
 }
 
 // GetTags returns a slice of all tags being tracked. There's no pagination on
@@ -209,7 +433,7 @@ func (tagDB *TagDB) UpdateTags(ctx context.Context, updateTags []tags.Tag) ([]ta
 // someone's local collection.
 func (tagDB *TagDB) GetTags(ctx context.Context) ([]tags.Tag, error) {
 	const (
-		searchString = "SELECT id, name, description FROM tags"
+		searchString = "SELECT id, namespace, name, description, COALESCE(parent, 0) FROM tags"
 	)
 
 	ctx, span := tracer.Start(ctx, "GetTags")
@@ -233,8 +457,10 @@ func (tagDB *TagDB) GetTags(ctx context.Context) ([]tags.Tag, error) {
 		tag := tags.Tag{}
 		if err := rows.Scan(
 			&tag.Id,
+			&tag.Namespace,
 			&tag.Name,
 			&tag.Description,
+			&tag.Parent,
 		); err != nil {
 			span.SetStatus(codes.Error, err.Error())
 			return nil, err
@@ -250,7 +476,7 @@ func (tagDB *TagDB) GetTags(ctx context.Context) ([]tags.Tag, error) {
 // that ID
 func (tagDB *TagDB) GetTagById(ctx context.Context, search int) (tags.Tag, error) {
 	const (
-		searchString = "SELECT id, name, description FROM tags WHERE id = ?"
+		searchString = "SELECT id, namespace, name, description, COALESCE(parent, 0) FROM tags WHERE id = ?"
 	)
 
 	ctx, span := tracer.Start(ctx, "GetTagById")
@@ -259,7 +485,7 @@ func (tagDB *TagDB) GetTagById(ctx context.Context, search int) (tags.Tag, error
 	row := tagDB.client.QueryRowContext(ctx, searchString, search)
 	ret := tags.Tag{}
 
-	if err := row.Scan(&ret.Id, &ret.Name, &ret.Description); err != nil {
+	if err := row.Scan(&ret.Id, &ret.Namespace, &ret.Name, &ret.Description, &ret.Parent); err != nil {
 		if err == sql.ErrNoRows {
 			span.SetStatus(codes.Error, "tag not found")
 			return ret, errors.New(fmt.Sprintf("tag does not exist with id: %d", search))
@@ -275,10 +501,12 @@ func (tagDB *TagDB) GetTagById(ctx context.Context, search int) (tags.Tag, error
 
 // GetTagsByName returns a list of all tags which match a provided search string. Right now
 // it only supports a % wildcard but that could be upgraded to use something more
-// substantial later.
+// substantial later. The search is matched against the tag's name qualified with its
+// namespace, e.g. project:% matches every tag in the project namespace.
 func (tagDB *TagDB) GetTagsByName(ctx context.Context, search string) ([]tags.Tag, error) {
 	const (
-		searchString = "SELECT id, name, description FROM tags WHERE name LIKE ?"
+		searchString = `SELECT id, namespace, name, description, COALESCE(parent, 0) FROM tags
+			WHERE CASE WHEN namespace = '' THEN name ELSE namespace || ':' || name END LIKE ?`
 	)
 
 	ctx, span := tracer.Start(ctx, "GetTagsByName")
@@ -301,8 +529,10 @@ func (tagDB *TagDB) GetTagsByName(ctx context.Context, search string) ([]tags.Ta
 		tag := tags.Tag{}
 		if err := rows.Scan(
 			&tag.Id,
+			&tag.Namespace,
 			&tag.Name,
 			&tag.Description,
+			&tag.Parent,
 		); err != nil {
 			span.SetStatus(codes.Error, err.Error())
 			return nil, err
@@ -313,3 +543,453 @@ func (tagDB *TagDB) GetTagsByName(ctx context.Context, search string) ([]tags.Ta
 	span.SetStatus(codes.Ok, "")
 	return ret, nil
 }
+
+// GetTagsForFile returns every tag attached to the provided file, ordered by
+// name. Only the file's ID is used for the search.
+func (tagDB *TagDB) GetTagsForFile(ctx context.Context, file files.File) ([]tags.Tag, error) {
+	const (
+		searchString = `SELECT t.id, t.namespace, t.name, t.description, COALESCE(t.parent, 0) FROM tags t
+			JOIN livefiletags ft ON ft.tagid = t.id
+			WHERE ft.fileid = ?
+			ORDER BY t.namespace, t.name`
+	)
+
+	ctx, span := tracer.Start(ctx, "GetTagsForFile")
+	defer span.End()
+
+	rows, err := tagDB.client.QueryContext(ctx, searchString, file.Id)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := []tags.Tag{}
+
+	for rows.Next() {
+		tag := tags.Tag{}
+		if err := rows.Scan(
+			&tag.Id,
+			&tag.Namespace,
+			&tag.Name,
+			&tag.Description,
+			&tag.Parent,
+		); err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+		ret = append(ret, tag)
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+// GetTagByName returns the tag whose name exactly matches the input or an error
+// wrapping sql.ErrNoRows if there isn't one. The input is a name qualified with
+// its namespace, as parsed by tags.Parse, or an alias in which case the tag the
+// alias points at is returned. Unlike GetTagsByName there are no wildcards.
+func (tagDB *TagDB) GetTagByName(ctx context.Context, search string) (tags.Tag, error) {
+	const (
+		searchString = "SELECT id, namespace, name, description, COALESCE(parent, 0) FROM tags WHERE namespace = ? AND name = ?"
+	)
+
+	ctx, span := tracer.Start(ctx, "GetTagByName")
+	defer span.End()
+
+	parsed := tags.Parse(search)
+	row := tagDB.client.QueryRowContext(ctx, searchString, parsed.Namespace, parsed.Name)
+	ret := tags.Tag{}
+
+	if err := row.Scan(&ret.Id, &ret.Namespace, &ret.Name, &ret.Description, &ret.Parent); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			resolved, isAlias, aliasErr := resolveAlias(ctx, tagDB.client, parsed)
+			if aliasErr != nil {
+				span.SetStatus(codes.Error, aliasErr.Error())
+				return ret, aliasErr
+			}
+
+			if isAlias {
+				span.SetStatus(codes.Ok, "")
+				return resolved, nil
+			}
+
+			span.SetStatus(codes.Error, "tag not found")
+			return ret, fmt.Errorf("tag does not exist with name: %s: %w", search, err)
+		}
+
+		span.SetStatus(codes.Error, err.Error())
+		return ret, err
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+// GetTagFileCounts returns the number of files each tag is attached to, keyed
+// by tag ID. Tags which aren't attached to any files aren't included.
+func (tagDB *TagDB) GetTagFileCounts(ctx context.Context) (map[int]int, error) {
+	const (
+		searchString = "SELECT tagid, COUNT(fileid) FROM livefiletags GROUP BY tagid"
+	)
+
+	ctx, span := tracer.Start(ctx, "GetTagFileCounts")
+	defer span.End()
+
+	rows, err := tagDB.client.QueryContext(ctx, searchString)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := map[int]int{}
+
+	for rows.Next() {
+		var tagId, count int
+		if err := rows.Scan(&tagId, &count); err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+		ret[tagId] = count
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+// GetTagActivity returns the last time each tag was created, changed or
+// attached to a file, keyed by tag ID. Tags with no recorded activity, because
+// they were last touched before timestamps were recorded, aren't included.
+func (tagDB *TagDB) GetTagActivity(ctx context.Context) (map[int]time.Time, error) {
+	const (
+		searchString = `SELECT t.id, MAX(COALESCE(t.updated, 0), COALESCE(MAX(ft.updated), 0)) AS active
+			FROM tags t LEFT JOIN livefiletags ft ON ft.tagid = t.id
+			GROUP BY t.id
+			HAVING active > 0`
+	)
+
+	ctx, span := tracer.Start(ctx, "GetTagActivity")
+	defer span.End()
+
+	rows, err := tagDB.client.QueryContext(ctx, searchString)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := map[int]time.Time{}
+
+	for rows.Next() {
+		var tagId int
+		var active int64
+		if err := rows.Scan(&tagId, &active); err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+		ret[tagId] = time.Unix(active, 0).UTC()
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+// GetTagTreeFileCounts returns the number of distinct files attached to each
+// tag or any tag below it in the hierarchy, keyed by tag ID. Tags which have no
+// files anywhere below them aren't included.
+func (tagDB *TagDB) GetTagTreeFileCounts(ctx context.Context) (map[int]int, error) {
+	const (
+		searchString = `WITH RECURSIVE subtree(root, id) AS (
+				SELECT id, id FROM tags
+				UNION
+				SELECT s.root, c.id FROM tags c JOIN subtree s ON c.parent = s.id
+			)
+			SELECT s.root, COUNT(DISTINCT ft.fileid) FROM subtree s
+			JOIN livefiletags ft ON ft.tagid = s.id
+			GROUP BY s.root`
+	)
+
+	ctx, span := tracer.Start(ctx, "GetTagTreeFileCounts")
+	defer span.End()
+
+	rows, err := tagDB.client.QueryContext(ctx, searchString)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := map[int]int{}
+
+	for rows.Next() {
+		var tagId, count int
+		if err := rows.Scan(&tagId, &count); err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+		ret[tagId] = count
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+// MergeTags folds every source tag into the target tag. Files with a source tag
+// are given the target tag instead, without duplicating links for files that
+// already have both, and the source tags are then deleted. Unlike the other
+// batch operations the merge happens in a single transaction which is rolled
+// back if any part of it fails so a merge is never partially applied.
+func (tagDB *TagDB) MergeTags(ctx context.Context, sources []tags.Tag, target tags.Tag) (tags.Tag, error) {
+	const (
+		searchString = "SELECT id, namespace, name, description, COALESCE(parent, 0) FROM tags WHERE id = ?"
+	)
+
+	ctx, span := tracer.Start(ctx, "MergeTags")
+	defer span.End()
+
+	tx, err := tagDB.client.BeginTx(ctx, nil)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return tags.Tag{}, err
+	}
+
+	rollback := func(err error) (tags.Tag, error) {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			err = errors.Join(err, rollbackErr)
+		}
+		span.SetStatus(codes.Error, err.Error())
+		return tags.Tag{}, err
+	}
+
+	row := tx.QueryRowContext(ctx, searchString, target.Id)
+	if err := row.Scan(&target.Id, &target.Namespace, &target.Name, &target.Description, &target.Parent); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = fmt.Errorf("tag does not exist with id: %d: %w", target.Id, err)
+		}
+		return rollback(err)
+	}
+
+	for _, source := range sources {
+		if source.Id == target.Id {
+			return rollback(fmt.Errorf("can't merge tag %s into itself", target))
+		}
+
+		span.AddEvent(fmt.Sprintf("merging tag ID %d into tag ID %d", source.Id, target.Id))
+		row := tx.QueryRowContext(ctx, searchString, source.Id)
+		if err := row.Scan(&source.Id, &source.Namespace, &source.Name, &source.Description, &source.Parent); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				err = fmt.Errorf("tag does not exist with id: %d: %w", source.Id, err)
+			}
+			return rollback(err)
+		}
+
+		if err := tagDB.moveDescendants(ctx, tx, source, target, true); err != nil {
+			return rollback(err)
+		}
+
+		if err := tagDB.foldTag(ctx, tx, source.Id, target.Id); err != nil {
+			return rollback(err)
+		}
+	}
+
+	if err := tagDB.materializeImplications(
+		ctx,
+		tx,
+		"SELECT fileid, tagid, 0 FROM livefiletags WHERE tagid = ?",
+		target.Id,
+	); err != nil {
+		return rollback(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return rollback(err)
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return target, nil
+}
+
+// foldTag gives every file tagged with the source tag the target tag instead,
+// points the source tag's link notes, aliases, implication rules and group
+// memberships at the target tag and deletes the source tag. A file which had
+// both tags keeps the value and source of the target tag's link, and if it
+// had notes on both links the source tag's note is added to the end of the
+// target tag's. An expired link to the target tag is replaced by a live link
+// to the source tag and expired links to the source tag are dropped. The
+// source tag's deletion, each of its links and each link the target tag gains
+// are recorded in the history. Links keep the source and confidence they had.
+// Values are converted to the target tag's type and it fails if any can't be,
+// or if the target tag's rules would then imply each other in a cycle. Every
+// file left with the target tag is then checked against the target tag's
+// exclusive groups, which now include the source tag's, as if it had just been
+// tagged, so each group's policy either removes the file's other tags from the
+// group or fails the fold.
+func (tagDB *TagDB) foldTag(ctx context.Context, tx *sql.Tx, sourceId int, targetId int) error {
+	const (
+		expiredString = `DELETE FROM filetags WHERE tagid = ? AND expires <= unixepoch()
+			AND fileid IN (SELECT fileid FROM livefiletags WHERE tagid = ?)`
+		relinkString = `INSERT OR IGNORE INTO filetags(fileid, tagid, value, expires, source, confidence, created, updated)
+			SELECT fileid, ?, value, expires, source, confidence, created, unixepoch() FROM livefiletags WHERE tagid = ?`
+		joinString = `UPDATE notes SET text = notes.text || char(10) || char(10) || s.text, updated = unixepoch()
+			FROM notes s WHERE notes.tagid = ? AND s.tagid = ? AND s.fileid = notes.fileid`
+		notesString   = "UPDATE OR IGNORE notes SET tagid = ? WHERE tagid = ?"
+		aliasString   = "UPDATE tagaliases SET tagid = ? WHERE tagid = ?"
+		impliesString = "UPDATE OR IGNORE tagimplications SET tagid = ? WHERE tagid = ?"
+		impliedString = "UPDATE OR IGNORE tagimplications SET impliedid = ? WHERE impliedid = ?"
+		groupString   = "UPDATE OR IGNORE taggroupmembers SET tagid = ? WHERE tagid = ?"
+		loopString    = "DELETE FROM tagimplications WHERE tagid = impliedid"
+		deleteString  = "DELETE FROM tags WHERE id = ?"
+		linksString   = "SELECT fileid, source FROM livefiletags WHERE tagid = ? ORDER BY fileid"
+		movedString   = `SELECT fileid FROM livefiletags WHERE tagid = ?
+			AND fileid NOT IN (SELECT fileid FROM livefiletags WHERE tagid = ?) ORDER BY fileid`
+	)
+
+	// an expired link to the target tag would otherwise stand in for a live
+	// link to the source tag and the file would lose the tag
+	if _, err := tx.ExecContext(ctx, expiredString, targetId, sourceId); err != nil {
+		return err
+	}
+
+	sourceName, targetName := tagName(ctx, tx, sourceId), tagName(ctx, tx, targetId)
+	if err := tagDB.recordHistory(ctx, tx, history.TagDeleted, 0, sourceId, "merged into "+targetName); err != nil {
+		return err
+	}
+
+	if err := tagDB.recordTagRemoved(ctx, tx, sourceId, "merged into "+targetName); err != nil {
+		return err
+	}
+
+	movedRows, err := tx.QueryContext(ctx, movedString, sourceId, targetId)
+	if err != nil {
+		return err
+	}
+
+	moved := []int{}
+	for movedRows.Next() {
+		var fileId int
+		if err := movedRows.Scan(&fileId); err != nil {
+			movedRows.Close()
+			return err
+		}
+		moved = append(moved, fileId)
+	}
+	movedRows.Close()
+	if err := movedRows.Err(); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, joinString, targetId, sourceId); err != nil {
+		return err
+	}
+
+	for _, statement := range []string{relinkString, notesString, aliasString, impliesString, impliedString, groupString} {
+		if _, err := tx.ExecContext(ctx, statement, targetId, sourceId); err != nil {
+			return err
+		}
+	}
+
+	for _, fileId := range moved {
+		if err := tagDB.recordHistory(ctx, tx, history.LinkAdded, fileId, targetId, "merged from "+sourceName); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, loopString); err != nil {
+		return err
+	}
+
+	if err := convertLinkValues(ctx, tx, targetId); err != nil {
+		return fmt.Errorf("can't merge tag %s into tag %s: %w", sourceName, targetName, err)
+	}
+
+	if err := checkImplicationCycles(ctx, tx, targetId); err != nil {
+		return fmt.Errorf("can't merge tag %s into tag %s: %w", sourceName, targetName, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, deleteString, sourceId); err != nil {
+		return err
+	}
+
+	// the source tag is only checked once it's gone, since a file with both
+	// tags would otherwise clash with itself in a group they shared
+	rows, err := tx.QueryContext(ctx, linksString, targetId)
+	if err != nil {
+		return err
+	}
+
+	folded := []links.Link{}
+	for rows.Next() {
+		link := links.Link{Tag: targetId}
+		if err := rows.Scan(&link.File, &link.Source); err != nil {
+			rows.Close()
+			return err
+		}
+		folded = append(folded, link)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, link := range folded {
+		if err := tagDB.enforceExclusiveGroups(ctx, tx, link); err != nil {
+			return fmt.Errorf("can't merge tag %s into tag %s: %w", sourceName, targetName, err)
+		}
+	}
+
+	return nil
+}
+
+// GetTagsByNamespace returns every tag in the provided namespace, ordered by
+// name. An empty namespace returns the tags which don't have one.
+func (tagDB *TagDB) GetTagsByNamespace(ctx context.Context, namespace string) ([]tags.Tag, error) {
+	const (
+		searchString = "SELECT id, namespace, name, description, COALESCE(parent, 0) FROM tags WHERE namespace = ? ORDER BY name"
+	)
+
+	ctx, span := tracer.Start(ctx, "GetTagsByNamespace")
+	defer span.End()
+
+	rows, err := tagDB.client.QueryContext(ctx, searchString, namespace)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := []tags.Tag{}
+
+	for rows.Next() {
+		tag := tags.Tag{}
+		if err := rows.Scan(
+			&tag.Id,
+			&tag.Namespace,
+			&tag.Name,
+			&tag.Description,
+			&tag.Parent,
+		); err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+		ret = append(ret, tag)
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+// tagName returns the name of a tag qualified with its namespace for use in
+// errors, or a description of its ID if the tag can't be found.
+func tagName(ctx context.Context, q querier, tagId int) string {
+	const (
+		searchString = "SELECT namespace, name FROM tags WHERE id = ?"
+	)
+
+	tag := tags.Tag{}
+	if err := q.QueryRowContext(ctx, searchString, tagId).Scan(&tag.Namespace, &tag.Name); err != nil {
+		return fmt.Sprintf("tag ID %d", tagId)
+	}
+
+	return tag.String()
+}
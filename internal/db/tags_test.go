@@ -2,9 +2,13 @@ package db
 
 import (
 	"context"
+	"errors"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/whatsfordinner/fstagger/internal/files"
+	"github.com/whatsfordinner/fstagger/internal/links"
 	"github.com/whatsfordinner/fstagger/internal/tags"
 )
 
@@ -174,10 +178,12 @@ func TestTagDBAddTags(t *testing.T) {
 func TestTagDBDeleteTags(t *testing.T) {
 	testMap := map[string]struct {
 		shouldErr bool
+		force     bool
 		input     []tags.Tag
 		expect    []tags.Tag
 	}{
 		"delete nothing": {
+			false,
 			false,
 			[]tags.Tag{},
 			[]tags.Tag{
@@ -199,6 +205,7 @@ func TestTagDBDeleteTags(t *testing.T) {
 			},
 		},
 		"delete something that doesn't exist": {
+			false,
 			false,
 			[]tags.Tag{
 				{
@@ -227,6 +234,7 @@ func TestTagDBDeleteTags(t *testing.T) {
 		},
 		"delete one thing": {
 			false,
+			true,
 			[]tags.Tag{
 				{
 					Id:          2,
@@ -249,6 +257,7 @@ func TestTagDBDeleteTags(t *testing.T) {
 		},
 		"delete many things": {
 			false,
+			true,
 			[]tags.Tag{
 				{
 					Id:          1,
@@ -268,6 +277,34 @@ func TestTagDBDeleteTags(t *testing.T) {
 			},
 			[]tags.Tag{},
 		},
+		"delete something in use without force": {
+			true,
+			false,
+			[]tags.Tag{
+				{
+					Id:          1,
+					Name:        "foo",
+					Description: "a foo",
+				},
+				{
+					Id:          2,
+					Name:        "bar",
+					Description: "a bar",
+				},
+			},
+			[]tags.Tag{
+				{
+					Id:          2,
+					Name:        "bar",
+					Description: "a bar",
+				},
+				{
+					Id:          3,
+					Name:        "baz",
+					Description: "a baz",
+				},
+			},
+		},
 	}
 
 	for testName, testData := range testMap {
@@ -275,7 +312,7 @@ func TestTagDBDeleteTags(t *testing.T) {
 			testDB, teardown := setupDB(t, []string{"fixtures/delete_tags.yml"})
 			defer teardown()
 
-			err := testDB.DeleteTags(context.Background(), testData.input)
+			err := testDB.DeleteTags(context.Background(), testData.input, testData.force)
 
 			if err == nil && testData.shouldErr {
 				t.Fatal("Expected error but got no error")
@@ -285,6 +322,10 @@ func TestTagDBDeleteTags(t *testing.T) {
 				t.Fatalf("Expected no error but got: %s", err.Error())
 			}
 
+			if testData.shouldErr && !errors.Is(err, ErrTagInUse) {
+				t.Fatalf("Expected a tag in use error but got: %s", err.Error())
+			}
+
 			res, err := testDB.GetTags(context.Background())
 			if err != nil {
 				t.Fatalf("Error retrieving remaining tags: %s", err.Error())
@@ -714,3 +755,450 @@ func TestTagDBGetTagsByName(t *testing.T) {
 		})
 	}
 }
+
+func TestTagDBGetTagsForFile(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr bool
+		input     files.File
+		expect    []tags.Tag
+	}{
+		"file with tags": {
+			false,
+			files.File{Id: 1},
+			[]tags.Tag{
+				{
+					Id:          2,
+					Name:        "dessert",
+					Description: "sweet things",
+				},
+				{
+					Id:          1,
+					Name:        "food",
+					Description: "things to eat",
+				},
+			},
+		},
+		"file with no tags": {
+			false,
+			files.File{Id: 3},
+			[]tags.Tag{},
+		},
+		"file that doesn't exist": {
+			false,
+			files.File{Id: 4},
+			[]tags.Tag{},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/get_files_by_tags.yml"})
+			defer teardown()
+
+			res, err := testDB.GetTagsForFile(context.Background(), testData.input)
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBGetTagByName(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr bool
+		input     string
+		expect    tags.Tag
+	}{
+		"tag exists": {
+			false,
+			"food",
+			tags.Tag{
+				Id:          1,
+				Name:        "food",
+				Description: "things to eat",
+			},
+		},
+		"tag doesn't exist": {
+			true,
+			"qux",
+			tags.Tag{},
+		},
+		"wildcards aren't expanded": {
+			true,
+			"foo%",
+			tags.Tag{},
+		},
+		"namespace isn't part of the name": {
+			true,
+			"food:things",
+			tags.Tag{},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/get_files_by_tags.yml"})
+			defer teardown()
+
+			res, err := testDB.GetTagByName(context.Background(), testData.input)
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBGetTagFileCounts(t *testing.T) {
+	testMap := map[string]struct {
+		fixtures []string
+		expect   map[int]int
+	}{
+		"no links": {
+			[]string{"fixtures/get_tags_many_tags.yml"},
+			map[int]int{},
+		},
+		"some links": {
+			[]string{"fixtures/get_files_by_tags.yml"},
+			map[int]int{
+				1: 2,
+				2: 1,
+			},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, testData.fixtures)
+			defer teardown()
+
+			res, err := testDB.GetTagFileCounts(context.Background())
+			if err != nil {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBGetTagActivity(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/timestamps.yml"})
+	defer teardown()
+
+	res, err := testDB.GetTagActivity(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	expect := map[int]time.Time{
+		1: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		2: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	if !reflect.DeepEqual(res, expect) {
+		t.Fatalf(
+			"Result did not match expectation\nResult: %+v\nExpected: %+v",
+			res,
+			expect,
+		)
+	}
+}
+
+// TestTagDBTimestampsRecorded checks that files, tags and links written through
+// the DAO are timestamped so they can be found by when they happened.
+func TestTagDBTimestampsRecorded(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/get_tags_no_tags.yml"})
+	defer teardown()
+
+	ctx := context.Background()
+	before := time.Now().Truncate(time.Second)
+
+	addedFiles, err := testDB.AddFiles(ctx, []files.File{{Path: "/path/to/foo", Hash: "foohash"}})
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	addedTags, err := testDB.AddTags(ctx, []tags.Tag{{Name: "inbox"}})
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	if _, err := testDB.AddLinks(ctx, []links.Link{{File: addedFiles[0].Id, Tag: addedTags[0].Id}}); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	tagged, err := testDB.GetFilesTaggedSince(ctx, before)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	if !reflect.DeepEqual(filePaths(tagged), []string{"/path/to/foo"}) {
+		t.Fatalf("Expected /path/to/foo to be tagged since %s but got: %+v", before, filePaths(tagged))
+	}
+
+	activity, err := testDB.GetTagActivity(ctx)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	if activity[addedTags[0].Id].Before(before) {
+		t.Fatalf("Expected activity at or after %s but got: %s", before, activity[addedTags[0].Id])
+	}
+}
+
+func TestTagDBMergeTags(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr    bool
+		inputSources []tags.Tag
+		inputTarget  tags.Tag
+		expect       tags.Tag
+		expectCounts map[int]int
+	}{
+		"no sources": {
+			false,
+			[]tags.Tag{},
+			tags.Tag{Id: 2},
+			tags.Tag{
+				Id:          2,
+				Name:        "photos",
+				Description: "some photos",
+			},
+			map[int]int{1: 2, 2: 1, 3: 1},
+		},
+		"one source with a shared file": {
+			false,
+			[]tags.Tag{{Id: 1}},
+			tags.Tag{Id: 2},
+			tags.Tag{
+				Id:          2,
+				Name:        "photos",
+				Description: "some photos",
+			},
+			map[int]int{2: 2, 3: 1},
+		},
+		"many sources": {
+			false,
+			[]tags.Tag{{Id: 1}, {Id: 3}},
+			tags.Tag{Id: 2},
+			tags.Tag{
+				Id:          2,
+				Name:        "photos",
+				Description: "some photos",
+			},
+			map[int]int{2: 3},
+		},
+		"source doesn't exist": {
+			true,
+			[]tags.Tag{{Id: 1}, {Id: 4}},
+			tags.Tag{Id: 2},
+			tags.Tag{},
+			map[int]int{1: 2, 2: 1, 3: 1},
+		},
+		"target doesn't exist": {
+			true,
+			[]tags.Tag{{Id: 1}},
+			tags.Tag{Id: 4},
+			tags.Tag{},
+			map[int]int{1: 2, 2: 1, 3: 1},
+		},
+		"merging into itself": {
+			true,
+			[]tags.Tag{{Id: 1}, {Id: 2}},
+			tags.Tag{Id: 2},
+			tags.Tag{},
+			map[int]int{1: 2, 2: 1, 3: 1},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/merge_tags.yml"})
+			defer teardown()
+
+			res, err := testDB.MergeTags(
+				context.Background(),
+				testData.inputSources,
+				testData.inputTarget,
+			)
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+
+			counts, err := testDB.GetTagFileCounts(context.Background())
+			if err != nil {
+				t.Fatalf("Error retrieving tag counts: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(counts, testData.expectCounts) {
+				t.Fatalf(
+					"DB did not match expectation\nResult: %+v\nExpected: %+v",
+					counts,
+					testData.expectCounts,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBGetTagsByNamespace(t *testing.T) {
+	testMap := map[string]struct {
+		input  string
+		expect []tags.Tag
+	}{
+		"namespace with tags": {
+			"project",
+			[]tags.Tag{
+				{
+					Id:          1,
+					Namespace:   "project",
+					Name:        "apollo",
+					Description: "the apollo project",
+				},
+				{
+					Id:          2,
+					Namespace:   "project",
+					Name:        "gemini",
+					Description: "the gemini project",
+				},
+			},
+		},
+		"no namespace": {
+			"",
+			[]tags.Tag{
+				{
+					Id:          4,
+					Name:        "acme",
+					Description: "not a client",
+				},
+			},
+		},
+		"namespace doesn't exist": {
+			"year",
+			[]tags.Tag{},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/namespaces.yml"})
+			defer teardown()
+
+			res, err := testDB.GetTagsByNamespace(context.Background(), testData.input)
+			if err != nil {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBAddTagsNamespaced(t *testing.T) {
+	testMap := map[string]struct {
+		input  []tags.Tag
+		expect []tags.Tag
+	}{
+		"same name in a different namespace": {
+			[]tags.Tag{
+				{
+					Namespace: "project",
+					Name:      "acme",
+				},
+			},
+			[]tags.Tag{
+				{
+					Id:        5,
+					Namespace: "project",
+					Name:      "acme",
+				},
+			},
+		},
+		"existing namespaced tag": {
+			[]tags.Tag{
+				{
+					Namespace: "client",
+					Name:      "acme",
+				},
+			},
+			[]tags.Tag{
+				{
+					Id:          3,
+					Namespace:   "client",
+					Name:        "acme",
+					Description: "acme corp",
+				},
+			},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/namespaces.yml"})
+			defer teardown()
+
+			res, err := testDB.AddTags(context.Background(), testData.input)
+			if err != nil {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
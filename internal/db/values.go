@@ -0,0 +1,356 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/whatsfordinner/fstagger/internal/links"
+	"github.com/whatsfordinner/fstagger/internal/values"
+
+	"github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// SetValueTypes takes a slice of declarations and makes each tag take values
+// of the declared type, replacing any type it already had. Values already on
+// files are converted to the new type and the declaration is rejected if any
+// of them aren't valid for it. An enum must have at least one option.
+func (tagDB *TagDB) SetValueTypes(ctx context.Context, declarations []values.Declaration) ([]values.Declaration, error) {
+	const (
+		upsertString = `INSERT INTO tagvaluetypes(tagid, type) VALUES(?, ?)
+			ON CONFLICT(tagid) DO UPDATE SET type = excluded.type`
+		clearString  = "DELETE FROM tagenumvalues WHERE tagid = ?"
+		optionString = "INSERT INTO tagenumvalues(tagid, value, position) VALUES(?, ?, ?)"
+	)
+
+	ctx, span := tracer.Start(ctx, "SetValueTypes")
+	defer span.End()
+
+	setDeclarations := []values.Declaration{}
+
+	tx, err := tagDB.client.BeginTx(ctx, nil)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return []values.Declaration{}, err
+	}
+	txErrors := &BatchError{}
+
+	setValueType := func(declaration values.Declaration) error {
+		if _, err := values.ParseType(string(declaration.Type)); err != nil {
+			return err
+		}
+
+		if declaration.Type == values.Enum && len(declaration.Options) == 0 {
+			return fmt.Errorf("enum for tag %s must have at least one option", tagName(ctx, tx, declaration.Tag))
+		}
+
+		if declaration.Type != values.Enum && len(declaration.Options) > 0 {
+			return fmt.Errorf("only enums have options but tag %s is %s", tagName(ctx, tx, declaration.Tag), declaration.Type)
+		}
+
+		if _, err := tx.ExecContext(ctx, upsertString, declaration.Tag, declaration.Type); err != nil {
+			if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.ExtendedCode == sqlite3.ErrConstraintForeignKey {
+				return fmt.Errorf("tag does not exist with id: %d", declaration.Tag)
+			}
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, clearString, declaration.Tag); err != nil {
+			return err
+		}
+
+		for position, option := range declaration.Options {
+			if _, err := tx.ExecContext(ctx, optionString, declaration.Tag, option, position); err != nil {
+				if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
+					return fmt.Errorf("enum option %s is listed more than once", option)
+				}
+				return err
+			}
+		}
+
+		return convertLinkValues(ctx, tx, declaration.Tag)
+	}
+
+	for i, declaration := range declarations {
+		span.AddEvent(fmt.Sprintf("setting value type of tag ID %d to %s", declaration.Tag, declaration.Type))
+
+		// converting existing values touches several rows so each declaration
+		// is set inside a savepoint which can be discarded without losing the
+		// others
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT set_value_type"); err != nil {
+			txErrors.add(i, err)
+			continue
+		}
+
+		err := setValueType(declaration)
+		if err != nil {
+			if _, rollbackErr := tx.ExecContext(ctx, "ROLLBACK TO set_value_type"); rollbackErr != nil {
+				err = errors.Join(err, rollbackErr)
+			}
+		}
+
+		if _, releaseErr := tx.ExecContext(ctx, "RELEASE set_value_type"); releaseErr != nil {
+			err = errors.Join(err, releaseErr)
+		}
+
+		if err != nil {
+			txErrors.add(i, err)
+			continue
+		}
+
+		setDeclarations = append(setDeclarations, declaration)
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			err = errors.Join(err, rollbackErr)
+		}
+		span.SetStatus(codes.Error, err.Error())
+		return []values.Declaration{}, err
+	}
+
+	if len(txErrors.Errors) > 0 {
+		span.SetStatus(codes.Error, "encountered DB errors during transaction")
+	} else {
+		span.SetStatus(codes.Ok, "")
+	}
+
+	return setDeclarations, txErrors.errOrNil()
+}
+
+// DeleteValueTypes takes a slice of declarations and stops each tag taking
+// values. Only the tag ID of each declaration is used. The values already on
+// files are removed but the files keep the tag.
+func (tagDB *TagDB) DeleteValueTypes(ctx context.Context, declarations []values.Declaration) error {
+	const (
+		deleteString = "DELETE FROM tagvaluetypes WHERE tagid = ?"
+		clearString  = "UPDATE filetags SET value = NULL, updated = unixepoch() WHERE tagid = ?"
+	)
+
+	ctx, span := tracer.Start(ctx, "DeleteValueTypes")
+	defer span.End()
+
+	tx, err := tagDB.client.BeginTx(ctx, nil)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	txErrors := &BatchError{}
+
+	for i, declaration := range declarations {
+		res, err := tx.ExecContext(ctx, deleteString, declaration.Tag)
+		if err != nil {
+			txErrors.add(i, err)
+			continue
+		}
+
+		if deleted, err := res.RowsAffected(); err == nil && deleted == 0 {
+			txErrors.add(i, fmt.Errorf("tag %s doesn't take values", tagName(ctx, tx, declaration.Tag)))
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, clearString, declaration.Tag); err != nil {
+			txErrors.add(i, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			err = errors.Join(err, rollbackErr)
+		}
+		span.SetStatus(codes.Error, "encountered error finalising transaction")
+		return err
+	}
+
+	if len(txErrors.Errors) > 0 {
+		span.SetStatus(codes.Error, "encountered DB errors during transaction")
+	} else {
+		span.SetStatus(codes.Ok, "")
+	}
+
+	return txErrors.errOrNil()
+}
+
+// GetValueTypes returns the declaration of every tag which takes values,
+// ordered by tag ID.
+func (tagDB *TagDB) GetValueTypes(ctx context.Context) ([]values.Declaration, error) {
+	const (
+		searchString = "SELECT tagid, type FROM tagvaluetypes ORDER BY tagid"
+	)
+
+	ctx, span := tracer.Start(ctx, "GetValueTypes")
+	defer span.End()
+
+	rows, err := tagDB.client.QueryContext(ctx, searchString)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	ret := []values.Declaration{}
+	for rows.Next() {
+		declaration := values.Declaration{Options: []string{}}
+		if err := rows.Scan(&declaration.Tag, &declaration.Type); err != nil {
+			rows.Close()
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+		ret = append(ret, declaration)
+	}
+	rows.Close()
+
+	for i := range ret {
+		if ret[i].Options, err = enumOptions(ctx, tagDB.client, ret[i].Tag); err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return ret, nil
+}
+
+// getValueType returns the declaration for a tag and true if it takes values.
+func getValueType(ctx context.Context, q querier, tagId int) (values.Declaration, bool, error) {
+	const (
+		searchString = "SELECT tagid, type FROM tagvaluetypes WHERE tagid = ?"
+	)
+
+	declaration := values.Declaration{}
+	err := q.QueryRowContext(ctx, searchString, tagId).Scan(&declaration.Tag, &declaration.Type)
+	if errors.Is(err, sql.ErrNoRows) {
+		return declaration, false, nil
+	}
+	if err != nil {
+		return declaration, false, err
+	}
+
+	declaration.Options, err = enumOptions(ctx, q, tagId)
+
+	return declaration, true, err
+}
+
+func enumOptions(ctx context.Context, q querier, tagId int) ([]string, error) {
+	const (
+		searchString = "SELECT value FROM tagenumvalues WHERE tagid = ? ORDER BY position"
+	)
+
+	rows, err := q.QueryContext(ctx, searchString, tagId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := []string{}
+	for rows.Next() {
+		var option string
+		if err := rows.Scan(&option); err != nil {
+			return nil, err
+		}
+		ret = append(ret, option)
+	}
+
+	return ret, rows.Err()
+}
+
+// convertLinkValues converts the values on every link to a tag to the tag's
+// declared type. It returns an error if any value isn't valid for the type or
+// the tag doesn't take values at all.
+func convertLinkValues(ctx context.Context, tx *sql.Tx, tagId int) error {
+	const (
+		valuesString = "SELECT fileid, CAST(value AS TEXT) FROM filetags WHERE tagid = ? AND value IS NOT NULL"
+		updateString = "UPDATE filetags SET value = ?, updated = unixepoch() WHERE fileid = ? AND tagid = ?"
+	)
+
+	type fileValue struct {
+		file  int
+		value string
+	}
+
+	rows, err := tx.QueryContext(ctx, valuesString, tagId)
+	if err != nil {
+		return err
+	}
+
+	existing := []fileValue{}
+	for rows.Next() {
+		v := fileValue{}
+		if err := rows.Scan(&v.file, &v.value); err != nil {
+			rows.Close()
+			return err
+		}
+		existing = append(existing, v)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(existing) == 0 {
+		return nil
+	}
+
+	declaration, ok, err := getValueType(ctx, tx, tagId)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf(
+			"%s has a value but tag %s doesn't take values",
+			filePath(ctx, tx, existing[0].file),
+			tagName(ctx, tx, tagId),
+		)
+	}
+
+	for _, v := range existing {
+		converted, err := declaration.Parse(v.value)
+		if err != nil {
+			return fmt.Errorf("%s has a value that can't be converted: %w", filePath(ctx, tx, v.file), err)
+		}
+
+		if _, err := tx.ExecContext(ctx, updateString, converted, v.file, tagId); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setLinkValue checks a link's value against its tag's declaration and writes
+// it to the link if the file already has the tag. It returns the value in the
+// form it's stored and whether an existing link was updated.
+func setLinkValue(ctx context.Context, tx *sql.Tx, link links.Link) (any, bool, error) {
+	const (
+		updateString = "UPDATE filetags SET value = ?, updated = unixepoch() WHERE fileid = ? AND tagid = ?"
+	)
+
+	if link.Value == "" {
+		return nil, false, nil
+	}
+
+	declaration, ok, err := getValueType(ctx, tx, link.Tag)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !ok {
+		return nil, false, fmt.Errorf("tag %s doesn't take values", tagName(ctx, tx, link.Tag))
+	}
+
+	value, err := declaration.Parse(link.Value)
+	if err != nil {
+		return nil, false, err
+	}
+
+	res, err := tx.ExecContext(ctx, updateString, value, link.File, link.Tag)
+	if err != nil {
+		return nil, false, err
+	}
+
+	updated, err := res.RowsAffected()
+
+	return value, updated > 0, err
+}
@@ -0,0 +1,394 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/whatsfordinner/fstagger/internal/files"
+	"github.com/whatsfordinner/fstagger/internal/links"
+	"github.com/whatsfordinner/fstagger/internal/query"
+	"github.com/whatsfordinner/fstagger/internal/tags"
+	"github.com/whatsfordinner/fstagger/internal/values"
+)
+
+var fixtureValueTypes = []values.Declaration{
+	{Tag: 1, Type: values.Int, Options: []string{}},
+	{Tag: 2, Type: values.Date, Options: []string{}},
+	{Tag: 3, Type: values.Enum, Options: []string{"draft", "review", "final"}},
+	{Tag: 5, Type: values.Float, Options: []string{}},
+}
+
+func TestTagDBGetFilesByQueryValues(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr bool
+		input     string
+		expect    []string
+	}{
+		"greater or equal": {
+			false,
+			"rating>=4",
+			[]string{"/path/to/baz", "/path/to/foo"},
+		},
+		"combined comparisons": {
+			false,
+			"rating>=4 and due<2025-07-01",
+			[]string{"/path/to/foo"},
+		},
+		"not equal only matches files with the tag": {
+			false,
+			"rating!=3",
+			[]string{"/path/to/baz", "/path/to/foo"},
+		},
+		"negated comparison": {
+			false,
+			"not rating=3",
+			[]string{"/path/to/baz", "/path/to/foo", "/path/to/qux"},
+		},
+		"enums compare in declared order": {
+			false,
+			"stage>=review",
+			[]string{"/path/to/baz", "/path/to/foo"},
+		},
+		"alias": {
+			false,
+			"score=3",
+			[]string{"/path/to/bar"},
+		},
+		"comparison with a tag": {
+			false,
+			"reviewed and rating<5",
+			[]string{"/path/to/baz"},
+		},
+		"tag doesn't take values": {
+			true,
+			"reviewed>1",
+			[]string{},
+		},
+		"invalid value": {
+			true,
+			"rating>high",
+			[]string{},
+		},
+		"unknown enum option": {
+			true,
+			"stage=published",
+			[]string{},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/values.yml"})
+			defer teardown()
+
+			expr, err := query.Parse(testData.input)
+			if err != nil {
+				t.Fatalf("Unable to parse query: %s", err.Error())
+			}
+
+			res, err := testDB.GetFilesByQuery(context.Background(), expr)
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(filePaths(res), testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					filePaths(res),
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBAddLinksValues(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr  bool
+		input      links.Link
+		expectFile int
+		expect     []links.Link
+	}{
+		"new link with a value": {
+			false,
+			links.Link{File: 4, Tag: 1, Value: "2"},
+			4,
+			[]links.Link{{File: 4, Tag: 1, Value: "2"}, {File: 4, Tag: 4}},
+		},
+		"replaces an existing value": {
+			false,
+			links.Link{File: 3, Tag: 1, Value: "1"},
+			3,
+			[]links.Link{{File: 3, Tag: 1, Value: "1"}, {File: 3, Tag: 3, Value: "review"}, {File: 3, Tag: 4}},
+		},
+		"float value": {
+			false,
+			links.Link{File: 4, Tag: 5, Value: "1.5"},
+			4,
+			[]links.Link{{File: 4, Tag: 4}, {File: 4, Tag: 5, Value: "1.5"}},
+		},
+		"invalid value": {
+			true,
+			links.Link{File: 4, Tag: 2, Value: "tomorrow"},
+			4,
+			[]links.Link{{File: 4, Tag: 4}},
+		},
+		"tag doesn't take values": {
+			true,
+			links.Link{File: 3, Tag: 4, Value: "yes"},
+			3,
+			[]links.Link{{File: 3, Tag: 1, Value: "4"}, {File: 3, Tag: 3, Value: "review"}, {File: 3, Tag: 4}},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/values.yml"})
+			defer teardown()
+
+			_, err := testDB.AddLinks(context.Background(), []links.Link{testData.input})
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			res, err := testDB.GetLinksForFile(context.Background(), files.File{Id: testData.expectFile})
+			if err != nil {
+				t.Fatalf("Error retrieving links: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBMergeTagsValues(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr  bool
+		source     int
+		target     int
+		expectFile int
+		expect     []links.Link
+	}{
+		"values are converted to the target's type": {
+			false,
+			1,
+			5,
+			3,
+			[]links.Link{{File: 3, Tag: 3, Value: "review"}, {File: 3, Tag: 4}, {File: 3, Tag: 5, Value: "4.0"}},
+		},
+		"the target keeps its own values": {
+			false,
+			4,
+			1,
+			3,
+			[]links.Link{{File: 3, Tag: 1, Value: "4"}, {File: 3, Tag: 3, Value: "review"}},
+		},
+		"a target which doesn't take values": {
+			true,
+			1,
+			4,
+			3,
+			[]links.Link{{File: 3, Tag: 1, Value: "4"}, {File: 3, Tag: 3, Value: "review"}, {File: 3, Tag: 4}},
+		},
+		"values which can't be converted": {
+			true,
+			3,
+			5,
+			3,
+			[]links.Link{{File: 3, Tag: 1, Value: "4"}, {File: 3, Tag: 3, Value: "review"}, {File: 3, Tag: 4}},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/values.yml"})
+			defer teardown()
+
+			_, err := testDB.MergeTags(
+				context.Background(),
+				[]tags.Tag{{Id: testData.source}},
+				tags.Tag{Id: testData.target},
+			)
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			res, err := testDB.GetLinksForFile(context.Background(), files.File{Id: testData.expectFile})
+			if err != nil {
+				t.Fatalf("Error retrieving links: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBSetValueTypes(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr   bool
+		input       values.Declaration
+		expect      []values.Declaration
+		expectLinks []links.Link
+	}{
+		"new value type": {
+			false,
+			values.Declaration{Tag: 4, Type: values.String},
+			[]values.Declaration{fixtureValueTypes[0], fixtureValueTypes[1], fixtureValueTypes[2], {Tag: 4, Type: values.String, Options: []string{}}, fixtureValueTypes[3]},
+			[]links.Link{{File: 1, Tag: 1, Value: "5"}, {File: 2, Tag: 1, Value: "3"}, {File: 3, Tag: 1, Value: "4"}},
+		},
+		"existing values are converted": {
+			false,
+			values.Declaration{Tag: 1, Type: values.Float},
+			[]values.Declaration{{Tag: 1, Type: values.Float, Options: []string{}}, fixtureValueTypes[1], fixtureValueTypes[2], fixtureValueTypes[3]},
+			[]links.Link{{File: 1, Tag: 1, Value: "5.0"}, {File: 2, Tag: 1, Value: "3.0"}, {File: 3, Tag: 1, Value: "4.0"}},
+		},
+		"existing values are options of the enum": {
+			false,
+			values.Declaration{Tag: 1, Type: values.Enum, Options: []string{"3", "4", "5"}},
+			[]values.Declaration{{Tag: 1, Type: values.Enum, Options: []string{"3", "4", "5"}}, fixtureValueTypes[1], fixtureValueTypes[2], fixtureValueTypes[3]},
+			[]links.Link{{File: 1, Tag: 1, Value: "5"}, {File: 2, Tag: 1, Value: "3"}, {File: 3, Tag: 1, Value: "4"}},
+		},
+		"existing values can't be converted": {
+			true,
+			values.Declaration{Tag: 1, Type: values.Date},
+			fixtureValueTypes,
+			[]links.Link{{File: 1, Tag: 1, Value: "5"}, {File: 2, Tag: 1, Value: "3"}, {File: 3, Tag: 1, Value: "4"}},
+		},
+		"enum without options": {
+			true,
+			values.Declaration{Tag: 4, Type: values.Enum},
+			fixtureValueTypes,
+			[]links.Link{{File: 1, Tag: 1, Value: "5"}, {File: 2, Tag: 1, Value: "3"}, {File: 3, Tag: 1, Value: "4"}},
+		},
+		"repeated enum option": {
+			true,
+			values.Declaration{Tag: 4, Type: values.Enum, Options: []string{"yes", "yes"}},
+			fixtureValueTypes,
+			[]links.Link{{File: 1, Tag: 1, Value: "5"}, {File: 2, Tag: 1, Value: "3"}, {File: 3, Tag: 1, Value: "4"}},
+		},
+		"unknown type": {
+			true,
+			values.Declaration{Tag: 4, Type: "bool"},
+			fixtureValueTypes,
+			[]links.Link{{File: 1, Tag: 1, Value: "5"}, {File: 2, Tag: 1, Value: "3"}, {File: 3, Tag: 1, Value: "4"}},
+		},
+		"tag doesn't exist": {
+			true,
+			values.Declaration{Tag: 10, Type: values.Int},
+			fixtureValueTypes,
+			[]links.Link{{File: 1, Tag: 1, Value: "5"}, {File: 2, Tag: 1, Value: "3"}, {File: 3, Tag: 1, Value: "4"}},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB, teardown := setupDB(t, []string{"fixtures/values.yml"})
+			defer teardown()
+
+			_, err := testDB.SetValueTypes(context.Background(), []values.Declaration{testData.input})
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			res, err := testDB.GetValueTypes(context.Background())
+			if err != nil {
+				t.Fatalf("Error retrieving value types: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+
+			resLinks, err := getLinks(context.Background(), testDB.client, "tagid = ?", 1)
+			if err != nil {
+				t.Fatalf("Error retrieving links: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(resLinks, testData.expectLinks) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					resLinks,
+					testData.expectLinks,
+				)
+			}
+		})
+	}
+}
+
+func TestTagDBDeleteValueTypes(t *testing.T) {
+	testDB, teardown := setupDB(t, []string{"fixtures/values.yml"})
+	defer teardown()
+
+	if err := testDB.DeleteValueTypes(context.Background(), []values.Declaration{{Tag: 3}}); err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	res, err := testDB.GetValueTypes(context.Background())
+	if err != nil {
+		t.Fatalf("Error retrieving value types: %s", err.Error())
+	}
+
+	expect := []values.Declaration{fixtureValueTypes[0], fixtureValueTypes[1], fixtureValueTypes[3]}
+	if !reflect.DeepEqual(res, expect) {
+		t.Fatalf(
+			"Result did not match expectation\nResult: %+v\nExpected: %+v",
+			res,
+			expect,
+		)
+	}
+
+	resLinks, err := getLinks(context.Background(), testDB.client, "tagid = ?", 3)
+	if err != nil {
+		t.Fatalf("Error retrieving links: %s", err.Error())
+	}
+
+	expectLinks := []links.Link{{File: 1, Tag: 3}, {File: 2, Tag: 3}, {File: 3, Tag: 3}}
+	if !reflect.DeepEqual(resLinks, expectLinks) {
+		t.Fatalf(
+			"Result did not match expectation\nResult: %+v\nExpected: %+v",
+			resLinks,
+			expectLinks,
+		)
+	}
+
+	if err := testDB.DeleteValueTypes(context.Background(), []values.Declaration{{Tag: 4}}); err == nil {
+		t.Fatal("Expected error but got no error")
+	}
+}
@@ -1,7 +1,56 @@
 package files
 
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
 type File struct {
-	Id   int
-	Path string
-	Hash string
+	Id   int    `json:"id"`
+	Path string `json:"path"`
+	Hash string `json:"hash"`
+}
+
+// String returns the file's path, which is how files are shown to users.
+func (f File) String() string {
+	return f.Path
+}
+
+// FromPath builds a File for the file on disk at the provided path. The path
+// is made absolute and the file's contents are hashed with MD5 so the result
+// can be tracked as described in ADR-007. The returned File has no ID.
+func FromPath(path string) (File, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return File{}, err
+	}
+
+	f, err := os.Open(absPath)
+	if err != nil {
+		return File{}, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return File{}, err
+	}
+
+	if info.IsDir() {
+		return File{}, fmt.Errorf("%s is a directory", absPath)
+	}
+
+	hash := md5.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return File{}, err
+	}
+
+	return File{
+		Path: absPath,
+		Hash: hex.EncodeToString(hash.Sum(nil)),
+	}, nil
 }
@@ -0,0 +1,52 @@
+package groups
+
+import "fmt"
+
+// Policy decides what happens when a file is given a tag from an exclusive
+// group while it already has another tag from that group.
+type Policy string
+
+const (
+	// PolicyReject refuses the new link and leaves the file's tags alone.
+	PolicyReject Policy = "reject"
+	// PolicyReplace removes the file's other tags from the group so the new
+	// link is the only one left.
+	PolicyReplace Policy = "replace"
+)
+
+// Policies is every supported Policy.
+var Policies = []Policy{PolicyReject, PolicyReplace}
+
+// ParsePolicy returns the Policy with the provided name or an error if there
+// isn't one.
+func ParsePolicy(policy string) (Policy, error) {
+	for _, p := range Policies {
+		if string(p) == policy {
+			return p, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown group policy: %s", policy)
+}
+
+// Group is a named set of tags, like the states status:todo, status:doing and
+// status:done. When a group is Exclusive a file may only have one of its tags
+// at a time and Policy decides what happens when a second one is added.
+type Group struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Exclusive   bool   `json:"exclusive"`
+	Policy      Policy `json:"policy"`
+}
+
+func (g Group) String() string {
+	return g.Name
+}
+
+// Member is the membership of a tag in a group. A tag can belong to any
+// number of groups.
+type Member struct {
+	Group int `json:"group"`
+	Tag   int `json:"tag"`
+}
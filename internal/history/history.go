@@ -0,0 +1,136 @@
+// Package history describes the append-only record of changes made to a
+// TagDB, so it's possible to tell who added or removed a tag and when on a
+// database shared between people or machines.
+package history
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+)
+
+// Action is the kind of change an Entry records.
+type Action string
+
+const (
+	// FileAdded is a file starting to be tracked.
+	FileAdded Action = "add-file"
+	// FileChanged is a tracked file's contents changing, found by a scan.
+	FileChanged Action = "change-file"
+	// TagAdded is a new tag being created.
+	TagAdded Action = "add-tag"
+	// TagUpdated is a tag being renamed or having its description changed.
+	TagUpdated Action = "update-tag"
+	// TagDeleted is a tag being deleted.
+	TagDeleted Action = "delete-tag"
+	// LinkAdded is a file being given a tag.
+	LinkAdded Action = "add-link"
+	// LinkUpdated is a file's existing tag being given a new value or expiry.
+	LinkUpdated Action = "update-link"
+	// LinkRemoved is a file losing a tag, because the tag was deleted or was
+	// replaced by another tag from an exclusive group.
+	LinkRemoved Action = "remove-link"
+	// NoteSet is a note being written about a file or a link, or rewritten.
+	NoteSet Action = "set-note"
+	// NoteRemoved is a note about a file or a link being removed.
+	NoteRemoved Action = "remove-note"
+	// OperationUndone is an operation's changes being reversed.
+	OperationUndone Action = "undo"
+	// OperationRedone is an undone operation's changes being made again.
+	OperationRedone Action = "redo"
+)
+
+// Actor is who made a change: the user, the machine they were on and the
+// command they ran.
+type Actor struct {
+	User    string `json:"user"`
+	Host    string `json:"host"`
+	Command string `json:"command"`
+}
+
+// CurrentActor describes the user running this process. Anything that can't
+// be worked out is left empty.
+func CurrentActor() Actor {
+	actor := Actor{Command: strings.Join(os.Args, " ")}
+
+	if current, err := user.Current(); err == nil {
+		actor.User = current.Username
+	}
+
+	if host, err := os.Hostname(); err == nil {
+		actor.Host = host
+	}
+
+	return actor
+}
+
+func (a Actor) String() string {
+	if a.Host == "" {
+		return a.User
+	}
+
+	return a.User + "@" + a.Host
+}
+
+// Entry is a single change. File and Tag are the IDs of the file and tag the
+// change was made to, or 0 if it wasn't made to one, and Path and TagName are
+// what they were called at the time so an entry still makes sense once they've
+// been deleted.
+type Entry struct {
+	Id   int       `json:"id"`
+	Time time.Time `json:"time"`
+	Actor
+	Action  Action `json:"action"`
+	File    int    `json:"file,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Tag     int    `json:"tag,omitempty"`
+	TagName string `json:"tag_name,omitempty"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+func (e Entry) String() string {
+	fields := []string{e.Time.Local().Format(time.RFC3339), e.Actor.String(), string(e.Action)}
+	for _, field := range []string{e.Path, e.TagName, e.Detail} {
+		if field != "" {
+			fields = append(fields, field)
+		}
+	}
+
+	return strings.Join(fields, "\t")
+}
+
+// Operation is every change made by one command, which is undone and redone
+// as a unit. Changes is the number of rows the command wrote and Undone is
+// whether it has been undone and can be redone.
+type Operation struct {
+	Id   int       `json:"id"`
+	Time time.Time `json:"time"`
+	Actor
+	Changes int  `json:"changes"`
+	Undone  bool `json:"undone"`
+}
+
+func (o Operation) String() string {
+	ret := fmt.Sprintf("%s\t%s\t%s", o.Time.Local().Format(time.RFC3339), o.Actor, o.Command)
+	if o.Undone {
+		ret += "\tundone"
+	}
+
+	return ret
+}
+
+// Describe summarises a link's value and expiry for the detail of an entry.
+func Describe(value string, expires time.Time) string {
+	details := []string{}
+	if value != "" {
+		details = append(details, fmt.Sprintf("value %s", value))
+	}
+
+	if !expires.IsZero() {
+		details = append(details, fmt.Sprintf("expires %s", expires.UTC().Format(time.RFC3339)))
+	}
+
+	return strings.Join(details, ", ")
+}
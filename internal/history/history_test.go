@@ -0,0 +1,45 @@
+package history
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDescribe(t *testing.T) {
+	expires := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	testMap := map[string]struct {
+		value   string
+		expires time.Time
+		expect  string
+	}{
+		"nothing":            {"", time.Time{}, ""},
+		"value":              {"4", time.Time{}, "value 4"},
+		"expiry":             {"", expires, "expires 2025-06-01T12:00:00Z"},
+		"value and expiry":   {"4", expires, "value 4, expires 2025-06-01T12:00:00Z"},
+		"expiry in any zone": {"", expires.In(time.FixedZone("AEST", 10*60*60)), "expires 2025-06-01T12:00:00Z"},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			if res := Describe(testData.value, testData.expires); res != testData.expect {
+				t.Fatalf("Expected %q but got %q", testData.expect, res)
+			}
+		})
+	}
+}
+
+func TestEntryString(t *testing.T) {
+	entry := Entry{
+		Time:    time.Date(2025, 6, 1, 12, 0, 0, 0, time.Local),
+		Actor:   Actor{User: "alice", Host: "laptop"},
+		Action:  LinkAdded,
+		Path:    "/path/to/foo",
+		TagName: "inbox",
+	}
+
+	expect := "2025-06-01T12:00:00" + entry.Time.Format("Z07:00") + "\talice@laptop\tadd-link\t/path/to/foo\tinbox"
+	if res := entry.String(); res != expect {
+		t.Fatalf("Expected %q but got %q", expect, res)
+	}
+}
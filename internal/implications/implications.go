@@ -0,0 +1,8 @@
+package implications
+
+// Implication is a rule that any file tagged with Tag is also tagged with
+// Implied. Rules chain, so if a implies b and b implies c then a implies c.
+type Implication struct {
+	Tag     int `json:"tag"`
+	Implied int `json:"implied"`
+}
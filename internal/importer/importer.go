@@ -0,0 +1,382 @@
+// Package importer bulk-applies tag assignments generated by other tools. Input
+// is line oriented and every line is either tab separated:
+//
+//	/path/to/file<TAB>tag1,tag2
+//
+// or an NDJSON object:
+//
+//	{"path": "/path/to/file", "tags": ["tag1", "tag2"]}
+//
+// A tag may carry a value for tags which take values, like rating=4. An NDJSON
+// object can also say which tool made its links and how sure it was:
+//
+//	{"path": "/path/to/file", "tags": ["cat"], "source": "rule:pets", "confidence": 0.8}
+//
+// Links from lines which don't name a source come from the importer's source,
+// which is links.SourceImport unless it's set with WithSource.
+//
+// Blank lines and lines starting with # are skipped.
+package importer
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/whatsfordinner/fstagger/internal/db"
+	"github.com/whatsfordinner/fstagger/internal/files"
+	"github.com/whatsfordinner/fstagger/internal/links"
+	"github.com/whatsfordinner/fstagger/internal/tags"
+	"github.com/whatsfordinner/fstagger/internal/values"
+)
+
+const (
+	DefaultBatchSize = 1000
+	maxLineLength    = 1024 * 1024
+)
+
+// Record is a single parsed line of input.
+type Record struct {
+	Line       int      `json:"-"`
+	Path       string   `json:"path"`
+	Tags       []string `json:"tags"`
+	Source     string   `json:"source"`
+	Confidence *float64 `json:"confidence"`
+}
+
+// Result reports the outcome of importing a single line of input. Err is nil
+// if every tag on the line was applied to the file.
+type Result struct {
+	Line       int
+	Path       string
+	Tags       []string
+	Source     string
+	Confidence *float64
+	Err        error
+}
+
+// Parse turns a single line of input into a Record. It doesn't touch the
+// filesystem or the database so the path is returned as provided, apart from
+// surrounding whitespace being trimmed like the tags' so a \r left by CRLF line
+// endings or a space before the tab isn't taken as part of it.
+func Parse(line string) (Record, error) {
+	record := Record{}
+	trimmed := strings.TrimSpace(line)
+
+	if strings.HasPrefix(trimmed, "{") {
+		if err := json.Unmarshal([]byte(trimmed), &record); err != nil {
+			return Record{}, fmt.Errorf("invalid JSON: %w", err)
+		}
+	} else {
+		path, tagList, found := strings.Cut(line, "\t")
+		if !found {
+			return Record{}, errors.New("expected a path and a tag list separated by a tab")
+		}
+		record.Path = path
+		record.Tags = strings.Split(tagList, ",")
+	}
+
+	cleanTags := []string{}
+	for _, tag := range record.Tags {
+		tag = strings.TrimSpace(tag)
+		if tag != "" {
+			cleanTags = append(cleanTags, tag)
+		}
+	}
+	record.Tags = cleanTags
+	record.Path = strings.TrimSpace(record.Path)
+
+	if record.Path == "" {
+		return Record{}, errors.New("no path provided")
+	}
+
+	if len(record.Tags) == 0 {
+		return Record{}, errors.New("no tags provided")
+	}
+
+	if c := record.Confidence; c != nil && (*c < 0 || *c > 1) {
+		return Record{}, fmt.Errorf("confidence must be between 0 and 1: %g", *c)
+	}
+
+	return record, nil
+}
+
+// Importer reads tag assignments and applies them to a TagDB in batches.
+type Importer struct {
+	tagDB     *db.TagDB
+	batchSize int
+	source    string
+}
+
+func New(tagDB *db.TagDB, options ...func(*Importer)) *Importer {
+	importer := &Importer{
+		tagDB:     tagDB,
+		batchSize: DefaultBatchSize,
+		source:    links.SourceImport,
+	}
+	for _, o := range options {
+		o(importer)
+	}
+
+	return importer
+}
+
+// WithBatchSize sets how many lines are collected before they're written to
+// the DB. Each batch is written with one call to each of AddFiles, AddTags and
+// AddLinks.
+func WithBatchSize(batchSize int) func(*Importer) {
+	return func(i *Importer) {
+		if batchSize > 0 {
+			i.batchSize = batchSize
+		}
+	}
+}
+
+// WithSource sets the source of links from lines which don't name their own.
+// It defaults to links.SourceImport.
+func WithSource(source string) func(*Importer) {
+	return func(i *Importer) {
+		if source != "" {
+			i.source = source
+		}
+	}
+}
+
+// Import reads every line from r and calls report once for each line that
+// isn't blank or a comment, in input order. It only returns an error if the
+// input itself can't be read; problems with individual lines are passed to
+// report instead.
+func (importer *Importer) Import(ctx context.Context, r io.Reader, report func(Result)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineLength)
+
+	batch := []Result{}
+	lineNumber := 0
+
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		record, err := Parse(line)
+		batch = append(batch, Result{
+			Line:       lineNumber,
+			Path:       record.Path,
+			Tags:       record.Tags,
+			Source:     record.Source,
+			Confidence: record.Confidence,
+			Err:        err,
+		})
+
+		if len(batch) >= importer.batchSize {
+			for _, result := range importer.apply(ctx, batch) {
+				report(result)
+			}
+			batch = []Result{}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	for _, result := range importer.apply(ctx, batch) {
+		report(result)
+	}
+
+	return nil
+}
+
+// apply writes a batch of parsed lines to the DB. Lines which already have an
+// error are passed through untouched.
+func (importer *Importer) apply(ctx context.Context, batch []Result) []Result {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	knownFiles := map[string]files.File{}
+	newFiles := []files.File{}
+
+	for i := range batch {
+		if batch[i].Err != nil {
+			continue
+		}
+
+		absPath, err := filepath.Abs(batch[i].Path)
+		if err != nil {
+			batch[i].Err = err
+			continue
+		}
+		batch[i].Path = absPath
+
+		if _, ok := knownFiles[absPath]; ok {
+			continue
+		}
+
+		file, err := importer.tagDB.GetFileByPath(ctx, absPath)
+		if err == nil {
+			knownFiles[absPath] = file
+			continue
+		}
+
+		if !errors.Is(err, sql.ErrNoRows) {
+			batch[i].Err = err
+			continue
+		}
+
+		file, err = files.FromPath(absPath)
+		if err != nil {
+			batch[i].Err = err
+			continue
+		}
+		knownFiles[absPath] = file
+		newFiles = append(newFiles, file)
+	}
+
+	addedFiles, err := importer.tagDB.AddFiles(ctx, newFiles)
+	fileErrors := batchErrorsByKey(err, len(newFiles), func(i int) string {
+		return newFiles[i].Path
+	})
+	for _, file := range addedFiles {
+		knownFiles[file.Path] = file
+	}
+
+	tagNames := []string{}
+	seenTags := map[string]bool{}
+	for i := range batch {
+		if batch[i].Err != nil {
+			continue
+		}
+
+		if err, ok := fileErrors[batch[i].Path]; ok {
+			batch[i].Err = err
+			continue
+		}
+
+		for _, tag := range batch[i].Tags {
+			name, _, _ := values.Split(tag)
+			if !seenTags[name] {
+				seenTags[name] = true
+				tagNames = append(tagNames, name)
+			}
+		}
+	}
+
+	newTags := []tags.Tag{}
+	for _, name := range tagNames {
+		newTags = append(newTags, tags.Parse(name))
+	}
+
+	// aliases are resolved up front so every name on a line can be matched
+	// to the canonical tag that AddTags returns for it
+	newTags, err = importer.tagDB.ResolveAliases(ctx, newTags)
+	if err != nil {
+		return failAll(batch, err)
+	}
+	canonicalNames := map[string]string{}
+	for i, name := range tagNames {
+		canonicalNames[name] = newTags[i].String()
+	}
+
+	addedTags, err := importer.tagDB.AddTags(ctx, newTags)
+	tagErrors := batchErrorsByKey(err, len(newTags), func(i int) string {
+		return tagNames[i]
+	})
+	tagIds := map[string]int{}
+	for _, tag := range addedTags {
+		tagIds[tag.String()] = tag.Id
+	}
+
+	newLinks := []links.Link{}
+	linkOwners := []int{}
+	for i := range batch {
+		if batch[i].Err != nil {
+			continue
+		}
+
+		for _, tag := range batch[i].Tags {
+			name, value, _ := values.Split(tag)
+			if err, ok := tagErrors[name]; ok {
+				batch[i].Err = errors.Join(batch[i].Err, err)
+				continue
+			}
+
+			source := batch[i].Source
+			if source == "" {
+				source = importer.source
+			}
+
+			newLinks = append(newLinks, links.Link{
+				File:       knownFiles[batch[i].Path].Id,
+				Tag:        tagIds[canonicalNames[name]],
+				Value:      value,
+				Source:     source,
+				Confidence: batch[i].Confidence,
+			})
+			linkOwners = append(linkOwners, i)
+		}
+	}
+
+	_, err = importer.tagDB.AddLinks(ctx, newLinks)
+	if err != nil {
+		batchErr := &db.BatchError{}
+		if !errors.As(err, &batchErr) {
+			return failAll(batch, err)
+		}
+
+		for i, linkErr := range batchErr.Errors {
+			// re-importing a line that's already been applied isn't a failure
+			// and neither is a link the file already has from somewhere else
+			if errors.Is(linkErr, db.ErrLinkExists) {
+				continue
+			}
+			owner := linkOwners[i]
+			batch[owner].Err = errors.Join(batch[owner].Err, linkErr)
+		}
+	}
+
+	return batch
+}
+
+// batchErrorsByKey converts the index-keyed errors of a BatchError into errors
+// keyed by something meaningful to a line of input, like a path or tag name.
+// An error which isn't a BatchError applies to every element of the batch.
+func batchErrorsByKey(err error, size int, key func(int) string) map[string]error {
+	ret := map[string]error{}
+	if err == nil {
+		return ret
+	}
+
+	batchErr := &db.BatchError{}
+	if !errors.As(err, &batchErr) {
+		for i := 0; i < size; i++ {
+			ret[key(i)] = err
+		}
+		return ret
+	}
+
+	for i, indexErr := range batchErr.Errors {
+		ret[key(i)] = indexErr
+	}
+
+	return ret
+}
+
+func failAll(batch []Result, err error) []Result {
+	for i := range batch {
+		if batch[i].Err == nil {
+			batch[i].Err = err
+		}
+	}
+
+	return batch
+}
@@ -0,0 +1,416 @@
+package importer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/whatsfordinner/fstagger/internal/aliases"
+	"github.com/whatsfordinner/fstagger/internal/db"
+	"github.com/whatsfordinner/fstagger/internal/files"
+	"github.com/whatsfordinner/fstagger/internal/links"
+	"github.com/whatsfordinner/fstagger/internal/tags"
+	"github.com/whatsfordinner/fstagger/internal/values"
+)
+
+func TestParse(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr bool
+		input     string
+		expect    Record
+	}{
+		"tsv with one tag": {
+			false,
+			"/path/to/foo\tfoo",
+			Record{
+				Path: "/path/to/foo",
+				Tags: []string{"foo"},
+			},
+		},
+		"tsv with many tags": {
+			false,
+			"/path/to/foo\tfoo, bar,,baz",
+			Record{
+				Path: "/path/to/foo",
+				Tags: []string{"foo", "bar", "baz"},
+			},
+		},
+		"tsv with crlf line ending": {
+			false,
+			"/path/to/foo\tfoo,bar\r\n",
+			Record{
+				Path: "/path/to/foo",
+				Tags: []string{"foo", "bar"},
+			},
+		},
+		"tsv with whitespace around the path": {
+			false,
+			" /path/to/foo \tfoo",
+			Record{
+				Path: "/path/to/foo",
+				Tags: []string{"foo"},
+			},
+		},
+		"tsv with only whitespace for a path": {
+			true,
+			" \tfoo",
+			Record{},
+		},
+		"tsv with no tab": {
+			true,
+			"/path/to/foo foo",
+			Record{},
+		},
+		"tsv with no tags": {
+			true,
+			"/path/to/foo\t",
+			Record{},
+		},
+		"ndjson": {
+			false,
+			`{"path": "/path/to/foo", "tags": ["foo", "bar"]}`,
+			Record{
+				Path: "/path/to/foo",
+				Tags: []string{"foo", "bar"},
+			},
+		},
+		"ndjson with source": {
+			false,
+			`{"path": "/path/to/foo", "tags": ["cat"], "source": "rule:pets", "confidence": 0.8}`,
+			Record{
+				Path:       "/path/to/foo",
+				Tags:       []string{"cat"},
+				Source:     "rule:pets",
+				Confidence: new(0.8),
+			},
+		},
+		"ndjson with no confidence at all": {
+			false,
+			`{"path": "/path/to/foo", "tags": ["cat"], "source": "rule:pets", "confidence": 0}`,
+			Record{
+				Path:       "/path/to/foo",
+				Tags:       []string{"cat"},
+				Source:     "rule:pets",
+				Confidence: new(0.0),
+			},
+		},
+		"ndjson with invalid confidence": {
+			true,
+			`{"path": "/path/to/foo", "tags": ["cat"], "confidence": 80}`,
+			Record{},
+		},
+		"ndjson with whitespace around the path": {
+			false,
+			`{"path": "/path/to/foo\r", "tags": ["foo"]}`,
+			Record{
+				Path: "/path/to/foo",
+				Tags: []string{"foo"},
+			},
+		},
+		"ndjson with no path": {
+			true,
+			`{"tags": ["foo"]}`,
+			Record{},
+		},
+		"invalid ndjson": {
+			true,
+			`{"path": "/path/to/foo"`,
+			Record{},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			res, err := Parse(testData.input)
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestImporterImport(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"foo", "bar", "baz"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o644); err != nil {
+			t.Fatalf("Unable to create test file: %s", err.Error())
+		}
+	}
+
+	testMap := map[string]struct {
+		input  string
+		expect map[int]bool
+	}{
+		"all lines succeed": {
+			"foo\ta,b\n{\"path\": \"bar\", \"tags\": [\"a\"]}\n",
+			map[int]bool{1: true, 2: true},
+		},
+		"comments and blank lines are skipped": {
+			"# header\n\nfoo\ta\n",
+			map[int]bool{3: true},
+		},
+		"missing file fails only its line": {
+			"foo\ta\nmissing\ta\nbar\tb\n",
+			map[int]bool{1: true, 2: false, 3: true},
+		},
+		"malformed line fails only its line": {
+			"foo\ta\nnot a record\n",
+			map[int]bool{1: true, 2: false},
+		},
+		"repeating a line isn't an error": {
+			"foo\ta\nfoo\ta\n",
+			map[int]bool{1: true, 2: true},
+		},
+		"lines span multiple batches": {
+			"foo\ta\nbar\ta\nbaz\ta\nfoo\tb\n",
+			map[int]bool{1: true, 2: true, 3: true, 4: true},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			testDB := db.New(db.WithConnectionString(filepath.Join(t.TempDir(), "test.db")))
+			if err := testDB.Init(context.Background()); err != nil {
+				t.Fatalf("Unable to init test DB: %s", err.Error())
+			}
+			defer testDB.Close(context.Background())
+
+			input := strings.NewReader(strings.NewReplacer(
+				"foo\t", filepath.Join(dir, "foo")+"\t",
+				"bar\t", filepath.Join(dir, "bar")+"\t",
+				"baz\t", filepath.Join(dir, "baz")+"\t",
+				"missing\t", filepath.Join(dir, "missing")+"\t",
+				`"bar"`, `"`+filepath.Join(dir, "bar")+`"`,
+			).Replace(testData.input))
+
+			res := map[int]bool{}
+			err := New(testDB, WithBatchSize(2)).Import(
+				context.Background(),
+				input,
+				func(result Result) {
+					res[result.Line] = result.Err == nil
+				},
+			)
+
+			if err != nil {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestImporterImportAliases(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "foo")
+	if err := os.WriteFile(path, []byte("foo"), 0o644); err != nil {
+		t.Fatalf("Unable to create test file: %s", err.Error())
+	}
+
+	testDB := db.New(db.WithConnectionString(filepath.Join(t.TempDir(), "test.db")))
+	if err := testDB.Init(context.Background()); err != nil {
+		t.Fatalf("Unable to init test DB: %s", err.Error())
+	}
+	defer testDB.Close(context.Background())
+
+	image, err := testDB.AddTags(context.Background(), []tags.Tag{{Name: "image"}})
+	if err != nil {
+		t.Fatalf("Unable to add tag: %s", err.Error())
+	}
+
+	if _, err := testDB.AddAliases(
+		context.Background(),
+		[]aliases.Alias{{Name: "img", Tag: image[0].Id}},
+	); err != nil {
+		t.Fatalf("Unable to add alias: %s", err.Error())
+	}
+
+	err = New(testDB).Import(
+		context.Background(),
+		strings.NewReader(path+"\timg,image\n"),
+		func(result Result) {
+			if result.Err != nil {
+				t.Fatalf("Expected no error but got: %s", result.Err.Error())
+			}
+		},
+	)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	file, err := testDB.GetFileByPath(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Unable to retrieve file: %s", err.Error())
+	}
+
+	res, err := testDB.GetTagsForFile(context.Background(), file)
+	if err != nil {
+		t.Fatalf("Unable to retrieve tags: %s", err.Error())
+	}
+
+	if !reflect.DeepEqual(res, image) {
+		t.Fatalf(
+			"Result did not match expectation\nResult: %+v\nExpected: %+v",
+			res,
+			image,
+		)
+	}
+}
+
+func TestImporterImportValues(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "foo")
+	if err := os.WriteFile(path, []byte("foo"), 0o644); err != nil {
+		t.Fatalf("Unable to create test file: %s", err.Error())
+	}
+
+	testDB := db.New(db.WithConnectionString(filepath.Join(t.TempDir(), "test.db")))
+	if err := testDB.Init(context.Background()); err != nil {
+		t.Fatalf("Unable to init test DB: %s", err.Error())
+	}
+	defer testDB.Close(context.Background())
+
+	rating, err := testDB.AddTags(context.Background(), []tags.Tag{{Name: "rating"}})
+	if err != nil {
+		t.Fatalf("Unable to add tag: %s", err.Error())
+	}
+
+	if _, err := testDB.SetValueTypes(
+		context.Background(),
+		[]values.Declaration{{Tag: rating[0].Id, Type: values.Int}},
+	); err != nil {
+		t.Fatalf("Unable to set value type: %s", err.Error())
+	}
+
+	results := []Result{}
+	err = New(testDB).Import(
+		context.Background(),
+		strings.NewReader(path+"\trating=4,draft\n"+path+"\trating=high\n"),
+		func(result Result) {
+			results = append(results, result)
+		},
+	)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	if len(results) != 2 || results[0].Err != nil || results[1].Err == nil {
+		t.Fatalf("Expected only the second line to fail but got: %+v", results)
+	}
+
+	file, err := testDB.GetFileByPath(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Unable to retrieve file: %s", err.Error())
+	}
+
+	res, err := testDB.GetLinksForFile(context.Background(), file)
+	if err != nil {
+		t.Fatalf("Unable to retrieve links: %s", err.Error())
+	}
+
+	expect := []links.Link{
+		{File: file.Id, Tag: rating[0].Id, Value: "4", Source: links.SourceImport},
+		{File: file.Id, Tag: rating[0].Id + 1, Source: links.SourceImport},
+	}
+	if !reflect.DeepEqual(res, expect) {
+		t.Fatalf(
+			"Result did not match expectation\nResult: %+v\nExpected: %+v",
+			res,
+			expect,
+		)
+	}
+}
+
+func TestImporterImportSources(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "foo")
+	if err := os.WriteFile(path, []byte("foo"), 0o644); err != nil {
+		t.Fatalf("Unable to create test file: %s", err.Error())
+	}
+
+	testDB := db.New(db.WithConnectionString(filepath.Join(t.TempDir(), "test.db")))
+	if err := testDB.Init(context.Background()); err != nil {
+		t.Fatalf("Unable to init test DB: %s", err.Error())
+	}
+	defer testDB.Close(context.Background())
+
+	addedFiles, err := testDB.AddFiles(context.Background(), []files.File{{Path: path, Hash: "foo"}})
+	if err != nil {
+		t.Fatalf("Unable to add file: %s", err.Error())
+	}
+
+	addedTags, err := testDB.AddTags(context.Background(), []tags.Tag{{Name: "cat"}, {Name: "dog"}, {Name: "pet"}})
+	if err != nil {
+		t.Fatalf("Unable to add tags: %s", err.Error())
+	}
+
+	// a person already decided the file is a dog
+	if _, err := testDB.AddLinks(
+		context.Background(),
+		[]links.Link{{File: addedFiles[0].Id, Tag: addedTags[1].Id}},
+	); err != nil {
+		t.Fatalf("Unable to add link: %s", err.Error())
+	}
+
+	results := []Result{}
+	err = New(testDB, WithSource("rule:pets")).Import(
+		context.Background(),
+		strings.NewReader(
+			`{"path": "`+path+`", "tags": ["cat", "dog"], "source": "classifier", "confidence": 0.8}`+"\n"+
+				path+"\tpet\n",
+		),
+		func(result Result) {
+			results = append(results, result)
+		},
+	)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err.Error())
+	}
+
+	for _, result := range results {
+		if result.Err != nil {
+			t.Fatalf("Expected no error but got: %s", result.Err.Error())
+		}
+	}
+
+	res, err := testDB.GetLinksForFile(context.Background(), addedFiles[0])
+	if err != nil {
+		t.Fatalf("Unable to retrieve links: %s", err.Error())
+	}
+
+	expect := []links.Link{
+		{File: addedFiles[0].Id, Tag: addedTags[0].Id, Source: "classifier", Confidence: new(0.8)},
+		{File: addedFiles[0].Id, Tag: addedTags[1].Id},
+		{File: addedFiles[0].Id, Tag: addedTags[2].Id, Source: "rule:pets"},
+	}
+	if !reflect.DeepEqual(res, expect) {
+		t.Fatalf(
+			"Result did not match expectation\nResult: %+v\nExpected: %+v",
+			res,
+			expect,
+		)
+	}
+}
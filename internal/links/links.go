@@ -1,6 +1,95 @@
 package links
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// SourceManual is the source of links made by a person. It's the source
+	// of a link which doesn't say.
+	SourceManual = "manual"
+	// SourceImport is the source of links made by "fstagger tag import"
+	// unless the import names another one.
+	SourceImport = "import"
+	// SourceImplied is the start of the source of links made by an
+	// implication rule, which is followed by the name of the implying tag.
+	SourceImplied = "implied:"
+)
+
+// Link tags a file with a tag. Value is the link's value, as written by a
+// user, if the tag takes one and is empty otherwise. A link with an Expires
+// time is hidden once that time has passed and is removed by the next garbage
+// collection, while a link with the zero time never expires.
+//
+// Source is what made the link: SourceImport, an implication rule or anything
+// else a tool names itself, like rule:camera. It's empty for a link made by a
+// person, which is stored as SourceManual. Confidence is how sure the source
+// was, between 0 and 1, and is nil if it didn't say.
 type Link struct {
-	File int
-	Tag  int
+	File       int       `json:"file"`
+	Tag        int       `json:"tag"`
+	Value      string    `json:"value,omitempty"`
+	Expires    time.Time `json:"expires,omitzero"`
+	Source     string    `json:"source,omitempty"`
+	Confidence *float64  `json:"confidence,omitempty"`
+}
+
+// SourceOrManual returns the link's source, or SourceManual if it doesn't
+// have one.
+func (l Link) SourceOrManual() string {
+	if l.Source == "" {
+		return SourceManual
+	}
+
+	return l.Source
+}
+
+// ParseConfidence parses a confidence between 0 and 1, either as a fraction
+// like 0.85 or a percentage like 85%.
+func ParseConfidence(confidence string) (float64, error) {
+	number, percent := strings.CutSuffix(confidence, "%")
+
+	ret, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid confidence: %s", confidence)
+	}
+
+	if percent {
+		ret /= 100
+	}
+
+	if ret <= 0 || ret > 1 {
+		return 0, fmt.Errorf("confidence must be above 0 and at most 1: %s", confidence)
+	}
+
+	return ret, nil
+}
+
+// ParseTTL parses how long a link should live for. It accepts anything
+// time.ParseDuration does as well as whole days and weeks, like 7d or 2w.
+func ParseTTL(ttl string) (time.Duration, error) {
+	units := map[string]time.Duration{
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+	}
+
+	for suffix, unit := range units {
+		if count, found := strings.CutSuffix(ttl, suffix); found {
+			n, err := strconv.Atoi(count)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid ttl: %s", ttl)
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+
+	d, err := time.ParseDuration(ttl)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid ttl: %s", ttl)
+	}
+
+	return d, nil
 }
@@ -0,0 +1,76 @@
+package links
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTTL(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr bool
+		input     string
+		expect    time.Duration
+	}{
+		"days":        {false, "7d", 7 * 24 * time.Hour},
+		"weeks":       {false, "2w", 14 * 24 * time.Hour},
+		"go duration": {false, "1h30m", 90 * time.Minute},
+		"no unit":     {true, "7", 0},
+		"zero":        {true, "0d", 0},
+		"negative":    {true, "-1h", 0},
+		"fraction":    {true, "1.5d", 0},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			res, err := ParseTTL(testData.input)
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if res != testData.expect {
+				t.Fatalf("Expected %s but got %s", testData.expect, res)
+			}
+		})
+	}
+}
+
+func TestParseConfidence(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr bool
+		input     string
+		expect    float64
+	}{
+		"fraction":          {false, "0.85", 0.85},
+		"one":               {false, "1", 1},
+		"percentage":        {false, "85%", 0.85},
+		"zero":              {true, "0", 0},
+		"above one":         {true, "1.5", 0},
+		"above 100%":        {true, "150%", 0},
+		"negative":          {true, "-0.5", 0},
+		"not a number":      {true, "high", 0},
+		"percent sign only": {true, "%", 0},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			res, err := ParseConfidence(testData.input)
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if res != testData.expect {
+				t.Fatalf("Result did not match expectation\nResult: %+v\nExpected: %+v", res, testData.expect)
+			}
+		})
+	}
+}
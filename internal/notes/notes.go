@@ -0,0 +1,10 @@
+package notes
+
+// Note is free text written about a file, like "final cut approved by client".
+// A note with a Tag is about the file's link to that tag instead, like why the
+// file was given the tag, and goes when the link does.
+type Note struct {
+	File int    `json:"file"`
+	Tag  int    `json:"tag,omitempty"`
+	Text string `json:"text"`
+}
@@ -0,0 +1,277 @@
+// Package output renders the results of fstagger commands in the format chosen
+// with the global --output flag. Records are structs and the same struct is
+// used for every format: JSON encodes it using its json tags, CSV uses those
+// tags as the header and the text formats use its String method if it has one.
+package output
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+type Format string
+
+const (
+	Text     Format = "text"
+	JSON     Format = "json"
+	NDJSON   Format = "ndjson"
+	CSV      Format = "csv"
+	NUL      Format = "nul"
+	Template Format = "template"
+
+	templatePrefix = string(Template) + "="
+)
+
+// Formats lists every format accepted by New, for use in help text.
+var Formats = []Format{Text, JSON, NDJSON, CSV, NUL, Template}
+
+// Renderer writes records to an io.Writer as they're produced. Close must be
+// called once every record has been rendered because some formats, like JSON,
+// can't be completed until then.
+type Renderer struct {
+	w        io.Writer
+	format   Format
+	template *template.Template
+	csv      *csv.Writer
+	records  int
+}
+
+// New creates a Renderer for the provided format. The template format takes
+// its template after an equals sign, e.g. "template={{.Path}}", and is executed
+// once for every record.
+func New(w io.Writer, format string) (*Renderer, error) {
+	renderer := &Renderer{
+		w:      w,
+		format: Format(format),
+	}
+
+	if strings.HasPrefix(format, templatePrefix) {
+		tmpl, err := template.New("output").Parse(strings.TrimPrefix(format, templatePrefix))
+		if err != nil {
+			return nil, fmt.Errorf("invalid output template: %w", err)
+		}
+		renderer.format = Template
+		renderer.template = tmpl
+	}
+
+	switch renderer.format {
+	case Text, JSON, NDJSON, NUL:
+	case CSV:
+		renderer.csv = csv.NewWriter(w)
+	case Template:
+		if renderer.template == nil {
+			return nil, errors.New("template output requires a template, e.g. template={{.Path}}")
+		}
+	default:
+		return nil, fmt.Errorf("unknown output format: %s", format)
+	}
+
+	return renderer, nil
+}
+
+// Render writes a single record.
+func (r *Renderer) Render(record any) error {
+	defer func() { r.records++ }()
+
+	switch r.format {
+	case JSON:
+		prefix := ",\n"
+		if r.records == 0 {
+			prefix = "[\n"
+		}
+		encoded, err := json.MarshalIndent(record, "  ", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(r.w, "%s  %s", prefix, encoded)
+		return err
+	case NDJSON:
+		return json.NewEncoder(r.w).Encode(record)
+	case CSV:
+		if r.records == 0 {
+			if err := r.csv.Write(header(record)); err != nil {
+				return err
+			}
+		}
+		return r.csv.Write(row(record))
+	case NUL:
+		_, err := fmt.Fprintf(r.w, "%s\x00", text(record))
+		return err
+	case Template:
+		if err := r.template.Execute(r.w, record); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintln(r.w)
+		return err
+	default:
+		_, err := fmt.Fprintln(r.w, text(record))
+		return err
+	}
+}
+
+// RenderAll writes every element of a slice of records.
+func RenderAll[T any](r *Renderer, records []T) error {
+	for _, record := range records {
+		if err := r.Render(record); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close finishes the output.
+func (r *Renderer) Close() error {
+	switch r.format {
+	case JSON:
+		if r.records == 0 {
+			_, err := fmt.Fprintln(r.w, "[]")
+			return err
+		}
+		_, err := fmt.Fprintln(r.w, "\n]")
+		return err
+	case CSV:
+		r.csv.Flush()
+		return r.csv.Error()
+	}
+
+	return nil
+}
+
+// text is the single line representation of a record. Records that don't
+// implement fmt.Stringer are written as their CSV row separated by tabs.
+func text(record any) string {
+	if stringer, ok := record.(fmt.Stringer); ok {
+		return stringer.String()
+	}
+
+	return strings.Join(row(record), "\t")
+}
+
+func header(record any) []string {
+	ret := []string{}
+	for _, f := range fields(reflect.ValueOf(record)) {
+		ret = append(ret, f.name)
+	}
+
+	return ret
+}
+
+func row(record any) []string {
+	ret := []string{}
+	for _, f := range fields(reflect.ValueOf(record)) {
+		ret = append(ret, format(f.value))
+	}
+
+	return ret
+}
+
+type field struct {
+	name  string
+	value reflect.Value
+}
+
+// fields flattens a struct into its exported fields, honouring json tags for
+// names and omission and promoting the fields of embedded structs the same way
+// encoding/json does. Anything that isn't a struct is a single field.
+func fields(v reflect.Value) []field {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return []field{}
+		}
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Struct || v.Type() == reflect.TypeOf(time.Time{}) {
+		return []field{{name: "value", value: v}}
+	}
+
+	ret := []field{}
+	for i := 0; i < v.NumField(); i++ {
+		structField := v.Type().Field(i)
+		// embedded structs with unexported types still promote their fields
+		if !structField.IsExported() && !structField.Anonymous {
+			continue
+		}
+
+		name, _, _ := strings.Cut(structField.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+
+		fieldValue := v.Field(i)
+		if structField.Anonymous && name == "" {
+			embedded := fieldValue
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				ret = append(ret, fields(embedded)...)
+				continue
+			}
+		}
+
+		if name == "" {
+			name = structField.Name
+		}
+		ret = append(ret, field{name: name, value: fieldValue})
+	}
+
+	return ret
+}
+
+func format(v reflect.Value) string {
+	if !v.IsValid() {
+		return ""
+	}
+
+	if v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return ""
+		}
+		return format(v.Elem())
+	}
+
+	if v.CanInterface() {
+		switch value := v.Interface().(type) {
+		case time.Time:
+			if value.IsZero() {
+				return ""
+			}
+			return value.Format(time.RFC3339)
+		case fmt.Stringer:
+			return value.String()
+		case error:
+			return value.Error()
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64)
+	case reflect.Slice, reflect.Array:
+		elements := []string{}
+		for i := 0; i < v.Len(); i++ {
+			elements = append(elements, format(v.Index(i)))
+		}
+		return strings.Join(elements, ",")
+	}
+
+	return fmt.Sprint(v.Interface())
+}
@@ -0,0 +1,145 @@
+package output
+
+import (
+	"bytes"
+	"testing"
+)
+
+type testRecord struct {
+	Id   int      `json:"id"`
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+	Note string   `json:"-"`
+}
+
+type testStringer struct {
+	testRecord
+	Count int `json:"count"`
+}
+
+func (t testStringer) String() string {
+	return t.Name
+}
+
+func TestRendererRender(t *testing.T) {
+	records := []testRecord{
+		{Id: 1, Name: "foo", Tags: []string{"a", "b"}, Note: "hidden"},
+		{Id: 2, Name: "bar, baz", Tags: []string{}},
+	}
+
+	testMap := map[string]struct {
+		shouldErr bool
+		format    string
+		input     []any
+		expect    string
+	}{
+		"text without a String method": {
+			false,
+			"text",
+			[]any{records[0], records[1]},
+			"1\tfoo\ta,b\n2\tbar, baz\t\n",
+		},
+		"text with a String method": {
+			false,
+			"text",
+			[]any{testStringer{records[0], 3}},
+			"foo\n",
+		},
+		"json": {
+			false,
+			"json",
+			[]any{records[0], records[1]},
+			"[\n  {\n    \"id\": 1,\n    \"name\": \"foo\",\n    \"tags\": [\n      \"a\",\n      \"b\"\n    ]\n  },\n" +
+				"  {\n    \"id\": 2,\n    \"name\": \"bar, baz\",\n    \"tags\": []\n  }\n]\n",
+		},
+		"json with no records": {
+			false,
+			"json",
+			[]any{},
+			"[]\n",
+		},
+		"ndjson": {
+			false,
+			"ndjson",
+			[]any{records[0], records[1]},
+			"{\"id\":1,\"name\":\"foo\",\"tags\":[\"a\",\"b\"]}\n{\"id\":2,\"name\":\"bar, baz\",\"tags\":[]}\n",
+		},
+		"csv": {
+			false,
+			"csv",
+			[]any{records[0], records[1]},
+			"id,name,tags\n1,foo,\"a,b\"\n2,\"bar, baz\",\n",
+		},
+		"csv flattens embedded structs": {
+			false,
+			"csv",
+			[]any{testStringer{records[0], 3}},
+			"id,name,tags,count\n1,foo,\"a,b\",3\n",
+		},
+		"nul": {
+			false,
+			"nul",
+			[]any{testStringer{records[0], 3}, testStringer{records[1], 0}},
+			"foo\x00bar, baz\x00",
+		},
+		"template": {
+			false,
+			"template={{.Name}}={{.Id}}",
+			[]any{records[0], records[1]},
+			"foo=1\nbar, baz=2\n",
+		},
+		"template with no template": {
+			true,
+			"template",
+			[]any{},
+			"",
+		},
+		"invalid template": {
+			true,
+			"template={{.Name",
+			[]any{},
+			"",
+		},
+		"unknown format": {
+			true,
+			"yaml",
+			[]any{},
+			"",
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			res := &bytes.Buffer{}
+			renderer, err := New(res, testData.format)
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if err != nil {
+				return
+			}
+
+			if err := RenderAll(renderer, testData.input); err != nil {
+				t.Fatalf("Error rendering records: %s", err.Error())
+			}
+
+			if err := renderer.Close(); err != nil {
+				t.Fatalf("Error closing renderer: %s", err.Error())
+			}
+
+			if res.String() != testData.expect {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %q\nExpected: %q",
+					res.String(),
+					testData.expect,
+				)
+			}
+		})
+	}
+}
@@ -0,0 +1,356 @@
+// Package policy checks tagging conventions, like "every file under /projects
+// has exactly one client: tag" or "no file is both draft and published",
+// against the files in a TagDB. Policies are written in a YAML file:
+//
+//	policies:
+//	  - name: one-client
+//	    description: every project file belongs to exactly one client
+//	    under: /projects
+//	    count:
+//	      tags: client:*
+//	      min: 1
+//	      max: 1
+//	  - name: draft-or-published
+//	    forbid: draft and published
+//
+// Each policy applies to every file unless it's narrowed to the files under a
+// directory with under, or to the files matching a query with where. It then
+// makes exactly one rule about those files:
+//
+//   - require: every file matches a query
+//   - forbid: no file matches a query
+//   - count: every file has between min and max tags matching a pattern
+//
+// Queries use the same syntax as searching for files. Count patterns are tag
+// names qualified with their namespace where * matches anything, the same as
+// a single search term.
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/whatsfordinner/fstagger/internal/files"
+	"github.com/whatsfordinner/fstagger/internal/query"
+	"github.com/whatsfordinner/fstagger/internal/tags"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Count requires the number of a file's tags which match Tags to be at least
+// Min and at most Max. Either bound may be left out but not both.
+type Count struct {
+	Tags string `yaml:"tags" json:"tags"`
+	Min  *int   `yaml:"min" json:"min,omitempty"`
+	Max  *int   `yaml:"max" json:"max,omitempty"`
+}
+
+func (c Count) String() string {
+	switch {
+	case c.Min != nil && c.Max != nil && *c.Min == *c.Max:
+		return fmt.Sprintf("exactly %d %s", *c.Min, c.Tags)
+	case c.Min != nil && c.Max != nil:
+		return fmt.Sprintf("between %d and %d %s", *c.Min, *c.Max, c.Tags)
+	case c.Min != nil:
+		return fmt.Sprintf("at least %d %s", *c.Min, c.Tags)
+	}
+
+	return fmt.Sprintf("at most %d %s", *c.Max, c.Tags)
+}
+
+// Policy is a single named rule about the files it applies to.
+type Policy struct {
+	Name        string `yaml:"name" json:"name"`
+	Description string `yaml:"description" json:"description,omitempty"`
+	Under       string `yaml:"under" json:"under,omitempty"`
+	Where       string `yaml:"where" json:"where,omitempty"`
+	Require     string `yaml:"require" json:"require,omitempty"`
+	Forbid      string `yaml:"forbid" json:"forbid,omitempty"`
+	Count       *Count `yaml:"count" json:"count,omitempty"`
+}
+
+// File is the contents of a policy file.
+type File struct {
+	Policies []Policy `yaml:"policies" json:"policies"`
+}
+
+// Violation is a file which breaks a policy.
+type Violation struct {
+	Policy  string `json:"policy"`
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s\t%s\t%s", v.Path, v.Policy, v.Message)
+}
+
+// Source is where policies find the files they check.
+type Source interface {
+	GetFiles(ctx context.Context) ([]files.File, error)
+	GetFilesByQuery(ctx context.Context, expr query.Expr) ([]files.File, error)
+	GetTagsForFile(ctx context.Context, file files.File) ([]tags.Tag, error)
+}
+
+// Load reads and validates the policy file at path. A relative under is
+// relative to the directory the policy file is in.
+func Load(path string) (File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return File{}, err
+	}
+	defer f.Close()
+
+	policyFile, err := Parse(f)
+	if err != nil {
+		return File{}, fmt.Errorf("invalid policy file %s: %w", path, err)
+	}
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return File{}, err
+	}
+
+	for i, p := range policyFile.Policies {
+		if p.Under != "" && !filepath.IsAbs(p.Under) {
+			policyFile.Policies[i].Under = filepath.Join(filepath.Dir(absPath), p.Under)
+		}
+	}
+
+	return policyFile, nil
+}
+
+// Parse reads a policy file and checks every policy is valid. Unknown fields
+// are an error so a misspelt rule isn't silently ignored.
+func Parse(r io.Reader) (File, error) {
+	policyFile := File{}
+
+	decoder := yaml.NewDecoder(r)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&policyFile); err != nil && !errors.Is(err, io.EOF) {
+		return File{}, err
+	}
+
+	seen := map[string]bool{}
+	for _, p := range policyFile.Policies {
+		if err := p.validate(); err != nil {
+			return File{}, err
+		}
+
+		if seen[p.Name] {
+			return File{}, fmt.Errorf("policy %s is defined more than once", p.Name)
+		}
+		seen[p.Name] = true
+	}
+
+	return policyFile, nil
+}
+
+func (p Policy) validate() error {
+	if p.Name == "" {
+		return errors.New("policy has no name")
+	}
+
+	rules := 0
+	for _, q := range []string{p.Where, p.Require, p.Forbid} {
+		if q == "" {
+			continue
+		}
+
+		if _, err := query.Parse(q); err != nil {
+			return fmt.Errorf("policy %s has an invalid query %q: %w", p.Name, q, err)
+		}
+	}
+
+	if p.Require != "" {
+		rules++
+	}
+
+	if p.Forbid != "" {
+		rules++
+	}
+
+	if p.Count != nil {
+		rules++
+
+		if p.Count.Tags == "" {
+			return fmt.Errorf("policy %s doesn't say which tags to count", p.Name)
+		}
+
+		if p.Count.Min == nil && p.Count.Max == nil {
+			return fmt.Errorf("policy %s needs a min or a max count", p.Name)
+		}
+
+		if p.Count.Min != nil && p.Count.Max != nil && *p.Count.Min > *p.Count.Max {
+			return fmt.Errorf("policy %s has a min count above its max", p.Name)
+		}
+	}
+
+	if rules != 1 {
+		return fmt.Errorf("policy %s needs exactly one of require, forbid or count", p.Name)
+	}
+
+	return nil
+}
+
+// Check returns every violation of every policy in the file, grouped by policy
+// in the order they're defined and ordered by path within each policy.
+func (f File) Check(ctx context.Context, src Source) ([]Violation, error) {
+	ret := []Violation{}
+	for _, p := range f.Policies {
+		violations, err := p.Check(ctx, src)
+		if err != nil {
+			return nil, fmt.Errorf("unable to check policy %s: %w", p.Name, err)
+		}
+		ret = append(ret, violations...)
+	}
+
+	return ret, nil
+}
+
+// Check returns a violation for every file the policy applies to which breaks
+// its rule, ordered by path.
+func (p Policy) Check(ctx context.Context, src Source) ([]Violation, error) {
+	scope, err := p.scope(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []Violation{}
+	violation := func(file files.File, message string) {
+		ret = append(ret, Violation{Policy: p.Name, Path: file.Path, Message: message})
+	}
+
+	switch {
+	case p.Require != "":
+		matching, err := queryIds(ctx, src, p.Require)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range scope {
+			if !matching[file.Id] {
+				violation(file, "doesn't match "+p.Require)
+			}
+		}
+	case p.Forbid != "":
+		matching, err := queryIds(ctx, src, p.Forbid)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range scope {
+			if matching[file.Id] {
+				violation(file, "matches "+p.Forbid)
+			}
+		}
+	case p.Count != nil:
+		for _, file := range scope {
+			fileTags, err := src.GetTagsForFile(ctx, file)
+			if err != nil {
+				return nil, err
+			}
+
+			count := 0
+			for _, tag := range fileTags {
+				if match(p.Count.Tags, tag) {
+					count++
+				}
+			}
+
+			if (p.Count.Min != nil && count < *p.Count.Min) || (p.Count.Max != nil && count > *p.Count.Max) {
+				violation(file, fmt.Sprintf("has %d %s, expected %s", count, p.Count.Tags, p.Count))
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+// scope returns the files the policy applies to, ordered by path.
+func (p Policy) scope(ctx context.Context, src Source) ([]files.File, error) {
+	var candidates []files.File
+	var err error
+
+	if p.Where != "" {
+		expr, parseErr := query.Parse(p.Where)
+		if parseErr != nil {
+			return nil, parseErr
+		}
+		candidates, err = src.GetFilesByQuery(ctx, expr)
+	} else {
+		candidates, err = src.GetFiles(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if p.Under == "" {
+		return candidates, nil
+	}
+
+	dir := filepath.Clean(p.Under)
+	ret := []files.File{}
+	for _, file := range candidates {
+		if file.Path == dir || strings.HasPrefix(file.Path, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator)) {
+			ret = append(ret, file)
+		}
+	}
+
+	return ret, nil
+}
+
+func queryIds(ctx context.Context, src Source, q string) (map[int]bool, error) {
+	expr, err := query.Parse(q)
+	if err != nil {
+		return nil, err
+	}
+
+	matching, err := src.GetFilesByQuery(ctx, expr)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := map[int]bool{}
+	for _, file := range matching {
+		ret[file.Id] = true
+	}
+
+	return ret, nil
+}
+
+// match reports whether a tag matches a pattern. The pattern is parsed like a
+// tag name and * in either its namespace or its name matches anything,
+// including nothing.
+func match(pattern string, tag tags.Tag) bool {
+	parsed := tags.Parse(pattern)
+
+	return globMatch(parsed.Namespace, tag.Namespace) && globMatch(parsed.Name, tag.Name)
+}
+
+func globMatch(pattern string, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
@@ -0,0 +1,233 @@
+package policy
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/whatsfordinner/fstagger/internal/files"
+	"github.com/whatsfordinner/fstagger/internal/query"
+	"github.com/whatsfordinner/fstagger/internal/tags"
+)
+
+// testSource is a Source where a query is a single tag or tags joined by and.
+type testSource struct {
+	files []files.File
+	tags  map[int][]string
+}
+
+func (s testSource) GetFiles(ctx context.Context) ([]files.File, error) {
+	return s.files, nil
+}
+
+func (s testSource) GetFilesByQuery(ctx context.Context, expr query.Expr) ([]files.File, error) {
+	ret := []files.File{}
+	for _, file := range s.files {
+		matches := true
+		for _, term := range query.Terms(expr) {
+			if !strings.Contains(","+strings.Join(s.tags[file.Id], ",")+",", ","+term+",") {
+				matches = false
+			}
+		}
+
+		if matches {
+			ret = append(ret, file)
+		}
+	}
+
+	return ret, nil
+}
+
+func (s testSource) GetTagsForFile(ctx context.Context, file files.File) ([]tags.Tag, error) {
+	ret := []tags.Tag{}
+	for _, name := range s.tags[file.Id] {
+		ret = append(ret, tags.Parse(name))
+	}
+
+	return ret, nil
+}
+
+var source = testSource{
+	files: []files.File{
+		{Id: 1, Path: "/notes/todo"},
+		{Id: 2, Path: "/projects/apollo/plan"},
+		{Id: 3, Path: "/projects/gemini/plan"},
+		{Id: 4, Path: "/projects/mercury/plan"},
+		{Id: 5, Path: "/projectsarchive/plan"},
+	},
+	tags: map[int][]string{
+		1: {"draft", "published"},
+		2: {"client:acme", "draft"},
+		3: {"client:acme", "client:initech", "published"},
+		4: {"draft", "published"},
+		5: {"published"},
+	},
+}
+
+func TestParse(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr bool
+		input     string
+		expect    int
+	}{
+		"valid policies": {
+			false,
+			`policies:
+  - name: one-client
+    under: /projects
+    count: {tags: "client:*", min: 1, max: 1}
+  - name: draft-or-published
+    forbid: draft and published
+  - name: published-reviewed
+    where: published
+    require: reviewed`,
+			3,
+		},
+		"empty file": {
+			false,
+			"",
+			0,
+		},
+		"no name": {
+			true,
+			`policies: [{forbid: draft}]`,
+			0,
+		},
+		"no rule": {
+			true,
+			`policies: [{name: empty, under: /projects}]`,
+			0,
+		},
+		"two rules": {
+			true,
+			`policies: [{name: both, forbid: draft, require: published}]`,
+			0,
+		},
+		"invalid query": {
+			true,
+			`policies: [{name: broken, forbid: "draft and"}]`,
+			0,
+		},
+		"count without bounds": {
+			true,
+			`policies: [{name: clients, count: {tags: "client:*"}}]`,
+			0,
+		},
+		"min above max": {
+			true,
+			`policies: [{name: clients, count: {tags: "client:*", min: 2, max: 1}}]`,
+			0,
+		},
+		"duplicate name": {
+			true,
+			`policies: [{name: a, forbid: draft}, {name: a, forbid: published}]`,
+			0,
+		},
+		"unknown field": {
+			true,
+			`policies: [{name: a, forbidden: draft}]`,
+			0,
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			res, err := Parse(strings.NewReader(testData.input))
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if len(res.Policies) != testData.expect {
+				t.Fatalf("Expected %d policies but got %d", testData.expect, len(res.Policies))
+			}
+		})
+	}
+}
+
+func TestPolicyCheck(t *testing.T) {
+	one := 1
+
+	testMap := map[string]struct {
+		input  Policy
+		expect []Violation
+	}{
+		"forbid": {
+			Policy{Name: "draft-or-published", Forbid: "draft and published"},
+			[]Violation{
+				{"draft-or-published", "/notes/todo", "matches draft and published"},
+				{"draft-or-published", "/projects/mercury/plan", "matches draft and published"},
+			},
+		},
+		"require within a query": {
+			Policy{Name: "drafts-have-clients", Where: "draft", Require: "client:acme"},
+			[]Violation{
+				{"drafts-have-clients", "/notes/todo", "doesn't match client:acme"},
+				{"drafts-have-clients", "/projects/mercury/plan", "doesn't match client:acme"},
+			},
+		},
+		"count under a directory": {
+			Policy{Name: "one-client", Under: "/projects", Count: &Count{Tags: "client:*", Min: &one, Max: &one}},
+			[]Violation{
+				{"one-client", "/projects/gemini/plan", "has 2 client:*, expected exactly 1 client:*"},
+				{"one-client", "/projects/mercury/plan", "has 0 client:*, expected exactly 1 client:*"},
+			},
+		},
+		"count with one bound": {
+			Policy{Name: "few-clients", Count: &Count{Tags: "client:*", Max: &one}},
+			[]Violation{
+				{"few-clients", "/projects/gemini/plan", "has 2 client:*, expected at most 1 client:*"},
+			},
+		},
+		"no violations": {
+			Policy{Name: "published", Under: "/projectsarchive", Require: "published"},
+			[]Violation{},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			res, err := testData.input.Check(context.Background(), source)
+			if err != nil {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	testMap := map[string]struct {
+		pattern string
+		tag     tags.Tag
+		expect  bool
+	}{
+		"exact":                    {"draft", tags.Tag{Name: "draft"}, true},
+		"namespace wildcard":       {"client:*", tags.Tag{Namespace: "client", Name: "acme"}, true},
+		"other namespace":          {"client:*", tags.Tag{Namespace: "project", Name: "acme"}, false},
+		"no namespace":             {"*", tags.Tag{Namespace: "client", Name: "acme"}, false},
+		"any namespace":            {"*:acme", tags.Tag{Name: "acme"}, true},
+		"wildcard inside the name": {"photos/*/raw", tags.Tag{Name: "photos/2024/raw"}, true},
+		"wildcard doesn't match":   {"photos/*/raw", tags.Tag{Name: "photos/2024/edited"}, false},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			if res := match(testData.pattern, testData.tag); res != testData.expect {
+				t.Fatalf("Expected %t but got %t", testData.expect, res)
+			}
+		})
+	}
+}
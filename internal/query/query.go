@@ -0,0 +1,378 @@
+// Package query parses the expressions used to search for files by tag, like
+//
+//	photos and not (reviewed or "to delete")
+//
+// Terms are tag names as typed by users and may be combined with and, or and
+// not, which are case insensitive, and grouped with parentheses. Terms written
+// next to each other without an operator are joined with and, so "food dessert"
+// is the same as "food and dessert". A term containing spaces, parentheses or
+// a keyword has to be wrapped in double quotes, and \" and \\ escape a quote or
+// a backslash inside quotes. and binds tighter than or, and not tighter than
+// both.
+//
+// A term followed by a comparison operator and a value, like rating>=4 or
+// due<2025-07-01, compares the value the tag has on each file instead. The
+// operators are =, !=, <, <=, > and >=, and the value may be quoted too, like
+// title="a long title".
+package query
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Expr is a parsed query expression.
+type Expr interface {
+	// String returns the expression in a form Parse accepts, fully
+	// parenthesised so it can be embedded in another expression.
+	String() string
+}
+
+// Term matches files with a tag, written the way a user would type it.
+type Term struct {
+	Tag string
+}
+
+// Operators are the comparison operators a Compare can use, longest first so
+// they can be matched in order.
+var Operators = []string{">=", "<=", "!=", "=", "<", ">"}
+
+// Compare matches files whose value for a tag compares to Value with Op, one
+// of Operators. Files without the tag never match.
+type Compare struct {
+	Tag   string
+	Op    string
+	Value string
+}
+
+// Not matches files the expression doesn't match.
+type Not struct {
+	Expr Expr
+}
+
+// And matches files every expression matches.
+type And struct {
+	Exprs []Expr
+}
+
+// Or matches files any expression matches.
+type Or struct {
+	Exprs []Expr
+}
+
+func (t Term) String() string {
+	return quote(t.Tag)
+}
+
+func (c Compare) String() string {
+	return quote(c.Tag) + c.Op + quote(c.Value)
+}
+
+func (n Not) String() string {
+	return "not " + n.Expr.String()
+}
+
+func (a And) String() string {
+	return join(a.Exprs, " and ")
+}
+
+func (o Or) String() string {
+	return join(o.Exprs, " or ")
+}
+
+func join(exprs []Expr, separator string) string {
+	parts := []string{}
+	for _, expr := range exprs {
+		parts = append(parts, expr.String())
+	}
+
+	return "(" + strings.Join(parts, separator) + ")"
+}
+
+// quote wraps a tag in double quotes if it wouldn't be parsed back as the same
+// single term otherwise.
+func quote(tag string) string {
+	if tag != "" && !isKeyword(tag) && !strings.ContainsFunc(tag, isDelimiter) {
+		return tag
+	}
+
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(tag)
+	return `"` + escaped + `"`
+}
+
+// Terms returns the tag of every term in the expression in the order they
+// appear.
+func Terms(expr Expr) []string {
+	switch e := expr.(type) {
+	case Term:
+		return []string{e.Tag}
+	case Compare:
+		return []string{e.Tag}
+	case Not:
+		return Terms(e.Expr)
+	case And:
+		return termsOf(e.Exprs)
+	case Or:
+		return termsOf(e.Exprs)
+	}
+
+	return []string{}
+}
+
+func termsOf(exprs []Expr) []string {
+	ret := []string{}
+	for _, expr := range exprs {
+		ret = append(ret, Terms(expr)...)
+	}
+
+	return ret
+}
+
+type tokenKind int
+
+const (
+	wordToken tokenKind = iota
+	quotedToken
+	openToken
+	closeToken
+	operatorToken
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+}
+
+func isSpecial(r rune) bool {
+	return unicode.IsSpace(r) || r == '(' || r == ')' || r == '"'
+}
+
+func isOperator(r rune) bool {
+	return r == '<' || r == '>' || r == '=' || r == '!'
+}
+
+// isDelimiter reports whether a rune ends an unquoted word.
+func isDelimiter(r rune) bool {
+	return isSpecial(r) || isOperator(r)
+}
+
+func isKeyword(word string) bool {
+	switch strings.ToLower(word) {
+	case "and", "or", "not":
+		return true
+	}
+
+	return false
+}
+
+func tokenize(input string) ([]token, error) {
+	tokens := []token{}
+	runes := []rune(input)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{openToken, "("})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{closeToken, ")"})
+			i++
+		case r == '"':
+			value := strings.Builder{}
+			i++
+			for {
+				if i >= len(runes) {
+					return nil, errors.New("unterminated quoted term")
+				}
+				if runes[i] == '"' {
+					i++
+					break
+				}
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				value.WriteRune(runes[i])
+				i++
+			}
+			tokens = append(tokens, token{quotedToken, value.String()})
+		case isOperator(r):
+			operator := ""
+			for _, o := range Operators {
+				if strings.HasPrefix(string(runes[i:]), o) {
+					operator = o
+					break
+				}
+			}
+			if operator == "" {
+				return nil, fmt.Errorf("unknown operator at %q", string(runes[i:]))
+			}
+			tokens = append(tokens, token{operatorToken, operator})
+			i += len([]rune(operator))
+		default:
+			start := i
+			for i < len(runes) && !isDelimiter(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{wordToken, string(runes[start:i])})
+		}
+	}
+
+	return tokens, nil
+}
+
+// Parse turns a query into an expression. An empty query is an error.
+func Parse(input string) (Expr, error) {
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) == 0 {
+		return nil, errors.New("empty query")
+	}
+
+	p := &parser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos].value)
+	}
+
+	return expr, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// keyword reports whether the next token is the provided keyword.
+func (p *parser) keyword(keyword string) bool {
+	if p.pos >= len(p.tokens) {
+		return false
+	}
+
+	next := p.tokens[p.pos]
+	return next.kind == wordToken && strings.EqualFold(next.value, keyword)
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	exprs := []Expr{}
+	for {
+		expr, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+
+		if !p.keyword("or") {
+			break
+		}
+		p.pos++
+	}
+
+	if len(exprs) == 1 {
+		return exprs[0], nil
+	}
+
+	return Or{exprs}, nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	exprs := []Expr{}
+	for {
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+
+		if p.keyword("and") {
+			p.pos++
+			continue
+		}
+
+		// anything that can start another operand is an implicit and
+		if p.pos >= len(p.tokens) || p.tokens[p.pos].kind == closeToken || p.keyword("or") {
+			break
+		}
+	}
+
+	if len(exprs) == 1 {
+		return exprs[0], nil
+	}
+
+	return And{exprs}, nil
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, errors.New("unexpected end of query")
+	}
+
+	next := p.tokens[p.pos]
+	switch {
+	case p.keyword("not"):
+		p.pos++
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return Not{expr}, nil
+	case next.kind == openToken:
+		p.pos++
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != closeToken {
+			return nil, errors.New("missing closing parenthesis")
+		}
+		p.pos++
+		return expr, nil
+	case next.kind == quotedToken:
+		if next.value == "" {
+			return nil, errors.New("empty quoted term")
+		}
+		p.pos++
+		return p.parseCompare(next.value)
+	case next.kind == wordToken && !isKeyword(next.value):
+		p.pos++
+		return p.parseCompare(next.value)
+	}
+
+	return nil, fmt.Errorf("unexpected %q", next.value)
+}
+
+// parseCompare returns a comparison if the term is followed by an operator and
+// the plain term otherwise.
+func (p *parser) parseCompare(tag string) (Expr, error) {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != operatorToken {
+		return Term{tag}, nil
+	}
+
+	operator := p.tokens[p.pos].value
+	p.pos++
+
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("missing value after %s%s", tag, operator)
+	}
+
+	value := p.tokens[p.pos]
+	switch {
+	case value.kind == quotedToken,
+		value.kind == wordToken && !isKeyword(value.value):
+		p.pos++
+		return Compare{tag, operator, value.value}, nil
+	}
+
+	return nil, fmt.Errorf("unexpected %q after %s%s", value.value, tag, operator)
+}
@@ -0,0 +1,210 @@
+package query
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr bool
+		input     string
+		expect    Expr
+	}{
+		"single term": {
+			false,
+			"photos",
+			Term{"photos"},
+		},
+		"namespaced and hierarchical terms": {
+			false,
+			"project:apollo food/dessert",
+			And{[]Expr{Term{"project:apollo"}, Term{"food/dessert"}}},
+		},
+		"implicit and": {
+			false,
+			"food dessert pies",
+			And{[]Expr{Term{"food"}, Term{"dessert"}, Term{"pies"}}},
+		},
+		"and binds tighter than or": {
+			false,
+			"a or b and c",
+			Or{[]Expr{Term{"a"}, And{[]Expr{Term{"b"}, Term{"c"}}}}},
+		},
+		"not binds tighter than and": {
+			false,
+			"photos and not reviewed",
+			And{[]Expr{Term{"photos"}, Not{Term{"reviewed"}}}},
+		},
+		"parentheses": {
+			false,
+			"(a or b) and c",
+			And{[]Expr{Or{[]Expr{Term{"a"}, Term{"b"}}}, Term{"c"}}},
+		},
+		"keywords are case insensitive": {
+			false,
+			"a OR NOT b",
+			Or{[]Expr{Term{"a"}, Not{Term{"b"}}}},
+		},
+		"quoted terms": {
+			false,
+			`"to delete" or "and" or "say \"hi\""`,
+			Or{[]Expr{Term{"to delete"}, Term{"and"}, Term{`say "hi"`}}},
+		},
+		"wildcards": {
+			false,
+			"project:*",
+			Term{"project:*"},
+		},
+		"comparisons": {
+			false,
+			"rating>=4 and due<2025-07-01",
+			And{[]Expr{Compare{"rating", ">=", "4"}, Compare{"due", "<", "2025-07-01"}}},
+		},
+		"comparison with spaces and quotes": {
+			false,
+			`not "my rating" != 3 title="a b"`,
+			And{[]Expr{Not{Compare{"my rating", "!=", "3"}}, Compare{"title", "=", "a b"}}},
+		},
+		"quoted operators are part of the term": {
+			false,
+			`"a>b"`,
+			Term{"a>b"},
+		},
+		"missing value": {
+			true,
+			"rating>=",
+			nil,
+		},
+		"unknown operator": {
+			true,
+			"rating!4",
+			nil,
+		},
+		"empty query": {
+			true,
+			"  ",
+			nil,
+		},
+		"dangling operator": {
+			true,
+			"a and",
+			nil,
+		},
+		"missing closing parenthesis": {
+			true,
+			"(a or b",
+			nil,
+		},
+		"unexpected closing parenthesis": {
+			true,
+			"a or b)",
+			nil,
+		},
+		"unterminated quote": {
+			true,
+			`"to delete`,
+			nil,
+		},
+		"empty quoted term": {
+			true,
+			`""`,
+			nil,
+		},
+		"keyword as a term": {
+			true,
+			"or",
+			nil,
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			res, err := Parse(testData.input)
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestExprString(t *testing.T) {
+	testMap := map[string]struct {
+		input  string
+		expect string
+	}{
+		"single term": {
+			"photos",
+			"photos",
+		},
+		"nested expression": {
+			"photos not reviewed or video",
+			"((photos and not reviewed) or video)",
+		},
+		"terms needing quotes": {
+			`"to delete" "or" "a\\b"`,
+			`("to delete" and "or" and a\b)`,
+		},
+		"comparisons": {
+			`rating >= 4 "a=b"="x y"`,
+			`(rating>=4 and "a=b"="x y")`,
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			expr, err := Parse(testData.input)
+			if err != nil {
+				t.Fatalf("Unable to parse query: %s", err.Error())
+			}
+
+			res := expr.String()
+			if res != testData.expect {
+				t.Fatalf("Expected %q but got %q", testData.expect, res)
+			}
+
+			reparsed, err := Parse(res)
+			if err != nil {
+				t.Fatalf("Unable to parse output: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(reparsed, expr) {
+				t.Fatalf(
+					"Output didn't parse to the same expression\nResult: %+v\nExpected: %+v",
+					reparsed,
+					expr,
+				)
+			}
+		})
+	}
+}
+
+func TestTerms(t *testing.T) {
+	expr, err := Parse("a and not (b or c>1) d")
+	if err != nil {
+		t.Fatalf("Unable to parse query: %s", err.Error())
+	}
+
+	res := Terms(expr)
+	expect := []string{"a", "b", "c", "d"}
+	if !reflect.DeepEqual(res, expect) {
+		t.Fatalf(
+			"Result did not match expectation\nResult: %+v\nExpected: %+v",
+			res,
+			expect,
+		)
+	}
+}
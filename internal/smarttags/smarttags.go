@@ -0,0 +1,21 @@
+package smarttags
+
+import "github.com/whatsfordinner/fstagger/internal/tags"
+
+// SmartTag is a virtual tag whose files are whichever files match Query, as
+// parsed by query.Parse, at the time it's used. Smart tags can't be attached
+// to files directly but can be searched for like any other tag, including
+// from the query of another smart tag.
+type SmartTag struct {
+	Id          int    `json:"id"`
+	Namespace   string `json:"namespace"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Query       string `json:"query"`
+}
+
+// String returns the smart tag's name qualified with its namespace the same
+// way as a tag.
+func (s SmartTag) String() string {
+	return tags.Tag{Namespace: s.Namespace, Name: s.Name}.String()
+}
@@ -0,0 +1,271 @@
+// Package suggest proposes tags for a file from what its path already says, so
+// ~/clients/acme/2024/invoice-042.pdf suggests tags like acme, 2024 and
+// invoices.
+//
+// The path is split into tokens: runs of up to three words from the same
+// directory or file name, where words are split at punctuation, at changes
+// from lower to upper case and between letters and digits. Tokens and tag
+// names are compared by their cluster.Key, so case, accents and word endings
+// don't matter, and match exactly, as a prefix of each other or within a few
+// edits. Words in the file's name say more about it than words in the
+// directories above it, so matches are weighted by where in the path they are.
+package suggest
+
+import (
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/whatsfordinner/fstagger/internal/cluster"
+	"github.com/whatsfordinner/fstagger/internal/tags"
+)
+
+const (
+	DefaultMaxDistance = 2
+	// maxWords is the most words in a token, enough for tag names like
+	// tax-return-draft.
+	maxWords = 3
+	// minFuzzyLength is the shortest key that's compared by edit distance.
+	minFuzzyLength = 4
+	// minPrefixLength is the shortest key that's allowed to match as a prefix
+	// of another key.
+	minPrefixLength = 5
+	// extensionWeight is the weight of the file's extension, which says what
+	// kind of file it is but not what it's about.
+	extensionWeight = 0.6
+	// minWeight is the weight of directories far enough up the path that they
+	// barely say anything about the file.
+	minWeight = 0.3
+)
+
+// Match is how a tag's name matched a token.
+type Match string
+
+const (
+	MatchExact  Match = "exact"
+	MatchPrefix Match = "prefix"
+	MatchFuzzy  Match = "fuzzy"
+)
+
+// quality is how much each kind of match is worth before it's weighted and,
+// for fuzzy matches, before each edit is taken off.
+var quality = map[Match]float64{
+	MatchExact:  1,
+	MatchPrefix: 0.8,
+	MatchFuzzy:  0.8,
+}
+
+// Token is a run of words from a path along with how much it says about the
+// file, from 1 for words in the file's name down to minWeight for directories
+// far above it.
+type Token struct {
+	Text   string  `json:"text"`
+	Weight float64 `json:"weight"`
+	key    string
+}
+
+// Candidate is a name a tag can be suggested by, which is the tag's own name
+// or one of its aliases. Files is how many files the tag is attached to and
+// breaks ties between equally good matches.
+type Candidate struct {
+	Name  string
+	Tag   tags.Tag
+	Files int
+}
+
+// Suggestion is a tag suggested for a file, with a score between 0 and 1, the
+// token its name matched and how it matched.
+type Suggestion struct {
+	Tag   tags.Tag `json:"tag"`
+	Score float64  `json:"score"`
+	Token string   `json:"token"`
+	Match Match    `json:"match"`
+	files int
+}
+
+// Key returns the token's text the way it's compared with tag names.
+func (t Token) Key() string {
+	return t.key
+}
+
+// Tokens splits a path into tokens, starting with the file's name.
+func Tokens(path string) []Token {
+	components := strings.FieldsFunc(filepath.ToSlash(path), func(r rune) bool {
+		return r == '/'
+	})
+	if len(components) == 0 {
+		return []Token{}
+	}
+
+	ret := []Token{}
+	name := components[len(components)-1]
+	extension := filepath.Ext(name)
+	ret = append(ret, componentTokens(strings.TrimSuffix(name, extension), 1)...)
+
+	for i := len(components) - 2; i >= 0; i-- {
+		distance := len(components) - 1 - i
+		weight := math.Max(minWeight, 1-0.1*float64(distance))
+		ret = append(ret, componentTokens(components[i], weight)...)
+	}
+
+	if extension != "" {
+		ret = append(ret, componentTokens(extension, extensionWeight)...)
+	}
+
+	return ret
+}
+
+// componentTokens returns every run of up to maxWords words in a directory or
+// file name.
+func componentTokens(component string, weight float64) []Token {
+	componentWords := words(component)
+
+	ret := []Token{}
+	for start := range componentWords {
+		for end := start + 1; end <= len(componentWords) && end-start <= maxWords; end++ {
+			text := strings.Join(componentWords[start:end], " ")
+			key := cluster.Key(text)
+			if len([]rune(key)) < 2 {
+				continue
+			}
+			ret = append(ret, Token{Text: text, Weight: weight, key: key})
+		}
+	}
+
+	return ret
+}
+
+// words splits a directory or file name into words at anything which isn't a
+// letter or digit, at a change from lower to upper case and between letters
+// and digits, so TaxReturn2024 is tax, return and 2024.
+func words(s string) []string {
+	ret := []string{}
+	current := []rune{}
+	var previous rune
+
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsNumber(r) {
+			if len(current) > 0 {
+				ret = append(ret, string(current))
+			}
+			current = current[:0]
+			previous = 0
+			continue
+		}
+
+		if len(current) > 0 &&
+			((unicode.IsLower(previous) && unicode.IsUpper(r)) ||
+				unicode.IsLetter(previous) != unicode.IsLetter(r)) {
+			ret = append(ret, string(current))
+			current = current[:0]
+		}
+
+		current = append(current, r)
+		previous = r
+	}
+
+	if len(current) > 0 {
+		ret = append(ret, string(current))
+	}
+
+	return ret
+}
+
+// Suggest returns a suggestion for every candidate whose name matches a token
+// from path, best first, with only the best match for each tag. maxDistance is
+// the most edits a fuzzy match can have and is lower for short names.
+func Suggest(path string, candidates []Candidate, maxDistance int) []Suggestion {
+	tokens := Tokens(path)
+
+	best := map[int]Suggestion{}
+	for _, candidate := range candidates {
+		key := cluster.Key(candidate.Name)
+		if key == "" {
+			continue
+		}
+
+		for _, token := range tokens {
+			match, edits, ok := compare(key, token.key, maxDistance)
+			if !ok {
+				continue
+			}
+
+			score := (quality[match] - 0.2*float64(edits)) * token.Weight
+			if existing, ok := best[candidate.Tag.Id]; ok && existing.Score >= score {
+				continue
+			}
+
+			best[candidate.Tag.Id] = Suggestion{
+				Tag:   candidate.Tag,
+				Score: math.Round(score*100) / 100,
+				Token: token.Text,
+				Match: match,
+				files: candidate.Files,
+			}
+		}
+	}
+
+	ret := []Suggestion{}
+	for _, suggestion := range best {
+		ret = append(ret, suggestion)
+	}
+
+	Sort(ret)
+	return ret
+}
+
+// Sort orders suggestions best first, breaking ties by how many files their
+// tags are attached to and then by name.
+func Sort(suggestions []Suggestion) {
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Score != suggestions[j].Score {
+			return suggestions[i].Score > suggestions[j].Score
+		}
+		if suggestions[i].files != suggestions[j].files {
+			return suggestions[i].files > suggestions[j].files
+		}
+		return suggestions[i].Tag.String() < suggestions[j].Tag.String()
+	})
+}
+
+// compare reports whether a tag name's key matches a token's key, how, and
+// with how many edits.
+func compare(name string, token string, maxDistance int) (Match, int, bool) {
+	if name == token {
+		return MatchExact, 0, true
+	}
+
+	// numbers are either the same or unrelated, 2023 isn't a typo of 2024
+	if !hasLetter(name) || !hasLetter(token) {
+		return "", 0, false
+	}
+
+	shorter, longer := name, token
+	if len([]rune(shorter)) > len([]rune(longer)) {
+		shorter, longer = longer, shorter
+	}
+	length := len([]rune(shorter))
+
+	if length >= minPrefixLength && strings.HasPrefix(longer, shorter) {
+		return MatchPrefix, 0, true
+	}
+
+	if length < minFuzzyLength {
+		return "", 0, false
+	}
+
+	// short names are a couple of edits from lots of words so they're only
+	// allowed one
+	allowed := min(maxDistance, max(1, length/4))
+	if edits := cluster.Distance(name, token); edits <= allowed {
+		return MatchFuzzy, edits, true
+	}
+
+	return "", 0, false
+}
+
+func hasLetter(s string) bool {
+	return strings.IndexFunc(s, unicode.IsLetter) != -1
+}
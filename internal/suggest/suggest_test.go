@@ -0,0 +1,119 @@
+package suggest
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/whatsfordinner/fstagger/internal/tags"
+)
+
+func TestWords(t *testing.T) {
+	testMap := map[string]struct {
+		input  string
+		expect []string
+	}{
+		"punctuation":       {"invoice-042", []string{"invoice", "042"}},
+		"camel case":        {"TaxReturn2024", []string{"Tax", "Return", "2024"}},
+		"upper case":        {"README", []string{"README"}},
+		"digits and letter": {"IMG_0001b", []string{"IMG", "0001", "b"}},
+		"nothing":           {"--", []string{}},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			res := words(testData.input)
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestTokens(t *testing.T) {
+	res := []string{}
+	weights := map[string]float64{}
+	for _, token := range Tokens("clients/acme/2024/tax-return.pdf") {
+		res = append(res, token.Text)
+		weights[token.Text] = token.Weight
+	}
+
+	expect := []string{"tax", "tax return", "return", "2024", "acme", "clients", "pdf"}
+	if !reflect.DeepEqual(res, expect) {
+		t.Fatalf("Result did not match expectation\nResult: %+v\nExpected: %+v", res, expect)
+	}
+
+	if weights["tax"] != 1 || weights["2024"] != 0.9 || weights["clients"] != 0.7 || weights["pdf"] != extensionWeight {
+		t.Fatalf("Tokens weren't weighted by where they are in the path: %+v", weights)
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	acme := tags.Tag{Id: 1, Namespace: "client", Name: "acme"}
+	invoices := tags.Tag{Id: 2, Name: "invoices"}
+	year := tags.Tag{Id: 3, Name: "2024"}
+	otherYear := tags.Tag{Id: 4, Name: "2023"}
+	recipes := tags.Tag{Id: 5, Name: "recipes"}
+	photograph := tags.Tag{Id: 6, Name: "photograph"}
+	receipt := tags.Tag{Id: 7, Name: "receipt"}
+	documents := tags.Tag{Id: 8, Name: "documents"}
+
+	candidates := []Candidate{
+		{Name: "acme", Tag: acme, Files: 3},
+		{Name: "invoices", Tag: invoices, Files: 10},
+		{Name: "2024", Tag: year, Files: 5},
+		{Name: "2023", Tag: otherYear, Files: 5},
+		{Name: "recipes", Tag: recipes, Files: 1},
+		{Name: "photograph", Tag: photograph, Files: 1},
+		{Name: "receipt", Tag: receipt, Files: 2},
+		{Name: "docs", Tag: documents, Files: 4},
+	}
+
+	testMap := map[string]struct {
+		input  string
+		expect []Suggestion
+	}{
+		"exact matches are weighted by where they are": {
+			"clients/acme/2024/invoice-042.pdf",
+			[]Suggestion{
+				{Tag: invoices, Score: 1, Token: "invoice", Match: MatchExact, files: 10},
+				{Tag: year, Score: 0.9, Token: "2024", Match: MatchExact, files: 5},
+				{Tag: acme, Score: 0.8, Token: "acme", Match: MatchExact, files: 3},
+			},
+		},
+		"prefix and fuzzy matches": {
+			"photos/receit-scan.jpg",
+			[]Suggestion{
+				{Tag: receipt, Score: 0.6, Token: "receit", Match: MatchFuzzy, files: 2},
+				{Tag: photograph, Score: 0.72, Token: "photos", Match: MatchPrefix, files: 1},
+			},
+		},
+		"aliases": {
+			"docs/letter.txt",
+			[]Suggestion{
+				{Tag: documents, Score: 0.9, Token: "docs", Match: MatchExact, files: 4},
+			},
+		},
+		"nothing matches": {
+			"misc/a.txt",
+			[]Suggestion{},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			res := Suggest(testData.input, candidates, DefaultMaxDistance)
+			Sort(testData.expect)
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
@@ -1,7 +1,94 @@
 package tags
 
+import "strings"
+
+const (
+	// NamespaceSeparator splits a tag's namespace from its value, e.g.
+	// project:apollo is the tag apollo in the project namespace.
+	NamespaceSeparator = ":"
+	// HierarchySeparator splits a tag's name into the path of tags above it,
+	// e.g. food/dessert/pie is a child of food/dessert which is a child of food.
+	HierarchySeparator = "/"
+)
+
 type Tag struct {
-	Id          int
-	Name        string
-	Description string
+	Id          int    `json:"id"`
+	Namespace   string `json:"namespace"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Parent is the ID of the tag one level up the hierarchy or 0 if the tag
+	// is at the top. It's maintained by the datastore based on the name.
+	Parent int `json:"parent"`
+}
+
+// Match is a tag found by searching for what it means rather than by its
+// name, along with a snippet of the text it was found in where the words that
+// matched are wrapped in square brackets.
+type Match struct {
+	Tag
+	Snippet string `json:"snippet"`
+}
+
+func (m Match) String() string {
+	return m.Tag.String() + "\t" + m.Snippet
+}
+
+// Parse builds a Tag from a name as a user would type it. Anything before the
+// first colon is the namespace and everything after it is the tag's name. A
+// name with no colon, or with nothing either side of the first colon, has no
+// namespace.
+func Parse(qualifiedName string) Tag {
+	namespace, name, found := strings.Cut(qualifiedName, NamespaceSeparator)
+	if !found || namespace == "" || name == "" {
+		return Tag{Name: qualifiedName}
+	}
+
+	return Tag{
+		Namespace: namespace,
+		Name:      name,
+	}
+}
+
+// String returns the tag's name qualified with its namespace, which is how
+// tags are shown to users.
+func (t Tag) String() string {
+	if t.Namespace == "" {
+		return t.Name
+	}
+
+	return t.Namespace + NamespaceSeparator + t.Name
+}
+
+// Ancestors returns the names of every tag above this one in the hierarchy,
+// starting at the top. Tags are in the same namespace as their ancestors. A
+// name with an empty segment, like food//pie, isn't treated as a hierarchy.
+func (t Tag) Ancestors() []string {
+	segments := strings.Split(t.Name, HierarchySeparator)
+	if len(segments) < 2 {
+		return []string{}
+	}
+
+	for _, segment := range segments {
+		if segment == "" {
+			return []string{}
+		}
+	}
+
+	ret := []string{}
+	for i := 1; i < len(segments); i++ {
+		ret = append(ret, strings.Join(segments[:i], HierarchySeparator))
+	}
+
+	return ret
+}
+
+// Leaf returns the last segment of the tag's name, e.g. pie for
+// food/dessert/pie.
+func (t Tag) Leaf() string {
+	ancestors := t.Ancestors()
+	if len(ancestors) == 0 {
+		return t.Name
+	}
+
+	return strings.TrimPrefix(t.Name, ancestors[len(ancestors)-1]+HierarchySeparator)
 }
@@ -0,0 +1,122 @@
+package tags
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	testMap := map[string]struct {
+		input  string
+		expect Tag
+	}{
+		"no namespace": {
+			"apollo",
+			Tag{Name: "apollo"},
+		},
+		"namespace": {
+			"project:apollo",
+			Tag{Namespace: "project", Name: "apollo"},
+		},
+		"only the first colon separates": {
+			"time:12:30",
+			Tag{Namespace: "time", Name: "12:30"},
+		},
+		"leading colon": {
+			":apollo",
+			Tag{Name: ":apollo"},
+		},
+		"trailing colon": {
+			"project:",
+			Tag{Name: "project:"},
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			res := Parse(testData.input)
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+
+			if res.String() != testData.input {
+				t.Fatalf(
+					"String did not round trip\nResult: %s\nExpected: %s",
+					res.String(),
+					testData.input,
+				)
+			}
+		})
+	}
+}
+
+func TestTagAncestors(t *testing.T) {
+	testMap := map[string]struct {
+		input        Tag
+		expect       []string
+		expectedLeaf string
+	}{
+		"no hierarchy": {
+			Tag{Name: "food"},
+			[]string{},
+			"food",
+		},
+		"one level": {
+			Tag{Name: "food/dessert"},
+			[]string{"food"},
+			"dessert",
+		},
+		"many levels": {
+			Tag{Name: "food/dessert/pie"},
+			[]string{"food", "food/dessert"},
+			"pie",
+		},
+		"namespaced": {
+			Tag{Namespace: "project", Name: "apollo/design"},
+			[]string{"apollo"},
+			"design",
+		},
+		"empty segment": {
+			Tag{Name: "food//pie"},
+			[]string{},
+			"food//pie",
+		},
+		"leading separator": {
+			Tag{Name: "/food"},
+			[]string{},
+			"/food",
+		},
+		"trailing separator": {
+			Tag{Name: "food/"},
+			[]string{},
+			"food/",
+		},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			res := testData.input.Ancestors()
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %+v\nExpected: %+v",
+					res,
+					testData.expect,
+				)
+			}
+
+			if testData.input.Leaf() != testData.expectedLeaf {
+				t.Fatalf(
+					"Leaf did not match expectation\nResult: %s\nExpected: %s",
+					testData.input.Leaf(),
+					testData.expectedLeaf,
+				)
+			}
+		})
+	}
+}
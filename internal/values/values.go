@@ -0,0 +1,91 @@
+// Package values describes the typed values a tag can carry on a file, like
+// rating=4 or due=2025-06-01. A tag only takes values once a type has been
+// declared for it and every value is checked against that type.
+package values
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Type is the kind of value a tag takes.
+type Type string
+
+const (
+	Int    Type = "int"
+	Float  Type = "float"
+	Date   Type = "date"
+	Enum   Type = "enum"
+	String Type = "string"
+
+	// DateLayout is the only accepted format for dates. It sorts the same way
+	// as the dates it represents so dates can be compared as text.
+	DateLayout = "2006-01-02"
+)
+
+// Types is every supported Type.
+var Types = []Type{Int, Float, Date, Enum, String}
+
+// ParseType returns the Type with the provided name or an error if there isn't
+// one.
+func ParseType(name string) (Type, error) {
+	for _, t := range Types {
+		if string(t) == name {
+			return t, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown value type: %s", name)
+}
+
+// Declaration is the type of value a tag takes. Options lists the allowed
+// values of an enum, in order, and is empty for every other type.
+type Declaration struct {
+	Tag     int      `json:"tag"`
+	Type    Type     `json:"type"`
+	Options []string `json:"options"`
+}
+
+// Parse checks a value as written by a user against the declaration and
+// returns it in the form it's stored: an int64 for ints, a float64 for floats
+// and a string for everything else. Dates are normalised to DateLayout.
+func (d Declaration) Parse(value string) (any, error) {
+	switch d.Type {
+	case Int:
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid int value: %s", value)
+		}
+		return parsed, nil
+	case Float:
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid float value: %s", value)
+		}
+		return parsed, nil
+	case Date:
+		parsed, err := time.Parse(DateLayout, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date value, expected YYYY-MM-DD: %s", value)
+		}
+		return parsed.Format(DateLayout), nil
+	case Enum:
+		if !slices.Contains(d.Options, value) {
+			return nil, fmt.Errorf("invalid enum value %s, expected one of: %s", value, strings.Join(d.Options, ", "))
+		}
+		return value, nil
+	case String:
+		return value, nil
+	}
+
+	return nil, fmt.Errorf("unknown value type: %s", d.Type)
+}
+
+// Split separates a tag written with a value, like rating=4, into the tag's
+// name and the value. It reports false if there's no value.
+func Split(term string) (string, string, bool) {
+	return strings.Cut(term, "=")
+}
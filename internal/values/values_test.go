@@ -0,0 +1,81 @@
+package values
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDeclarationParse(t *testing.T) {
+	testMap := map[string]struct {
+		shouldErr   bool
+		declaration Declaration
+		input       string
+		expect      any
+	}{
+		"int":                  {false, Declaration{Type: Int}, "4", int64(4)},
+		"invalid int":          {true, Declaration{Type: Int}, "4.5", nil},
+		"float":                {false, Declaration{Type: Float}, "4.5", 4.5},
+		"invalid float":        {true, Declaration{Type: Float}, "four", nil},
+		"date":                 {false, Declaration{Type: Date}, "2025-06-01", "2025-06-01"},
+		"invalid date":         {true, Declaration{Type: Date}, "01/06/2025", nil},
+		"enum":                 {false, Declaration{Type: Enum, Options: []string{"low", "high"}}, "high", "high"},
+		"enum value not found": {true, Declaration{Type: Enum, Options: []string{"low", "high"}}, "medium", nil},
+		"string":               {false, Declaration{Type: String}, "anything at all", "anything at all"},
+		"unknown type":         {true, Declaration{Type: "colour"}, "red", nil},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			res, err := testData.declaration.Parse(testData.input)
+
+			if err == nil && testData.shouldErr {
+				t.Fatal("Expected error but got no error")
+			}
+
+			if err != nil && !testData.shouldErr {
+				t.Fatalf("Expected no error but got: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(res, testData.expect) {
+				t.Fatalf(
+					"Result did not match expectation\nResult: %#v\nExpected: %#v",
+					res,
+					testData.expect,
+				)
+			}
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+	testMap := map[string]struct {
+		input       string
+		expectName  string
+		expectValue string
+		expectFound bool
+	}{
+		"no value":          {"rating", "rating", "", false},
+		"value":             {"rating=4", "rating", "4", true},
+		"namespaced value":  {"book:pages=320", "book:pages", "320", true},
+		"value with equals": {"formula=a=b", "formula", "a=b", true},
+		"empty value":       {"rating=", "rating", "", true},
+	}
+
+	for testName, testData := range testMap {
+		t.Run(testName, func(t *testing.T) {
+			name, value, found := Split(testData.input)
+
+			if name != testData.expectName || value != testData.expectValue || found != testData.expectFound {
+				t.Fatalf(
+					"Expected %s, %s, %t but got %s, %s, %t",
+					testData.expectName,
+					testData.expectValue,
+					testData.expectFound,
+					name,
+					value,
+					found,
+				)
+			}
+		})
+	}
+}
@@ -1,7 +1,13 @@
 package main
 
-import "github.com/whatsfordinner/fstagger/cmd"
+import (
+	"os"
+
+	"github.com/whatsfordinner/fstagger/cmd"
+)
 
 func main() {
-	cmd.Execute()
+	if err := cmd.Execute(); err != nil {
+		os.Exit(1)
+	}
 }